@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/31z4/surge/pkg/awsconfig"
+	"github.com/31z4/surge/pkg/downloader"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/uploader"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+var benchCommand = &command{
+	name:    "bench",
+	summary: "Measure the hashing and transfer throughput",
+	description: "Measure the throughput of the tree hashing and of the upload and download\n" +
+		"pipelines against a local backend discarding the data, with the part size and\n" +
+		"jobs options. It helps tuning the options and catching performance regressions.",
+	hidden: true,
+}
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	benchCommand.setup = setupBench
+}
+
+func setupBench(flags *flag.FlagSet) func(args []string) error {
+	size := sizeValue(64 << 20)
+	flags.Var(&size, "size", "the `size` of the archive transferred by the pipeline benchmarks")
+
+	return func(args []string) error {
+		if len(args) != 0 {
+			return newUsageError(benchCommand, "expected no arguments, got %d argument(s)", len(args))
+		}
+
+		for _, n := range []int64{1 << 20, 16 << 20, 64 << 20} {
+			data := make([]byte, n)
+			printBench(os.Stdout, "tree hash "+utils.FormatSize(n), testing.Benchmark(func(b *testing.B) {
+				b.SetBytes(n)
+				for i := 0; i < b.N; i++ {
+					utils.TreeHashBytes(data)
+				}
+			}))
+		}
+
+		dir, err := ioutil.TempDir("", "surge-bench")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		fileName := filepath.Join(dir, "archive")
+		if err := ioutil.WriteFile(fileName, make([]byte, size), 0600); err != nil {
+			return err
+		}
+
+		backend := newBenchBackend(int64(globals.partSize.sizeValue), int64(size))
+		server := httptest.NewServer(backend)
+		defer server.Close()
+
+		config := defaults.Config()
+		config.Region = "us-east-1"
+		config.Credentials = aws.NewStaticCredentialsProvider("bench", "bench", "")
+		config.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+		config.HTTPClient = awsconfig.NewHTTPClient(globals.jobs)
+		service := glacier.New(config)
+
+		var benchErr error
+		printBench(os.Stdout, "upload", testing.Benchmark(func(b *testing.B) {
+			b.SetBytes(int64(size))
+			client := uploader.NewClient(service)
+			input := &uploader.Input{
+				AccountId: "-",
+				VaultName: "bench",
+				FileName:  fileName,
+				PartSize:  int64(globals.partSize.sizeValue),
+				MaxMemory: int64(globals.maxMemory),
+				DropCache: globals.dropCache,
+				Logger:    events.Discard,
+			}
+			for i := 0; i < b.N && benchErr == nil; i++ {
+				_, benchErr = client.Upload(input, globals.jobs)
+			}
+		}))
+		if benchErr != nil {
+			return benchErr
+		}
+
+		printBench(os.Stdout, "download", testing.Benchmark(func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N && benchErr == nil; i++ {
+				_, benchErr = downloader.New(service, &downloader.Input{
+					AccountId: "-",
+					VaultName: "bench",
+					FileName:  filepath.Join(dir, "download"),
+					Overwrite: true,
+					JobId:     "bench",
+					PartSize:  int64(globals.partSize.sizeValue),
+					MaxMemory: int64(globals.maxMemory),
+					DropCache: globals.dropCache,
+					Logger:    events.Discard,
+				}).Download(globals.jobs)
+			}
+		}))
+
+		return benchErr
+	}
+}
+
+func printBench(w io.Writer, name string, r testing.BenchmarkResult) {
+	fmt.Fprintf(w, "%-20s %12s  %v/op  %d iteration(s)\n", name,
+		utils.FormatRate(r.Bytes*int64(r.N), r.T.Seconds()), time.Duration(r.NsPerOp()).Round(time.Microsecond), r.N)
+}
+
+// benchBackend is a Glacier endpoint discarding the uploaded data and
+// serving zeros as the archive of any retrieval job.
+type benchBackend struct {
+	partSize int64
+	size     int64
+	treeHash string
+}
+
+func newBenchBackend(partSize, size int64) *benchBackend {
+	return &benchBackend{
+		partSize: partSize,
+		size:     size,
+		treeHash: *utils.TreeHashBytes(make([]byte, size)),
+	}
+}
+
+func (b *benchBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(ioutil.Discard, r.Body)
+	w.Header().Set("x-amzn-RequestId", "bench")
+
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/multipart-uploads"):
+		w.Header().Set("x-amz-multipart-upload-id", "bench")
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/multipart-uploads/bench"):
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"MultipartUploadId": "bench",
+			"PartSizeInBytes":   b.partSize,
+			"Parts":             []interface{}{},
+		})
+	case r.Method == http.MethodPut:
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/multipart-uploads/bench"):
+		w.Header().Set("x-amz-archive-id", "bench")
+		w.Header().Set("x-amz-sha256-tree-hash", r.Header.Get("x-amz-sha256-tree-hash"))
+		w.Header().Set("Location", path)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/archives"):
+		w.Header().Set("x-amz-archive-id", "bench")
+		w.Header().Set("x-amz-sha256-tree-hash", r.Header.Get("x-amz-sha256-tree-hash"))
+		w.Header().Set("Location", path+"/bench")
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/jobs"):
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"JobList": []interface{}{map[string]interface{}{
+				"JobId":              "bench",
+				"Action":             "ArchiveRetrieval",
+				"ArchiveId":          "bench",
+				"StatusCode":         "Succeeded",
+				"ArchiveSizeInBytes": b.size,
+				"SHA256TreeHash":     b.treeHash,
+			}},
+		})
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/jobs/bench"):
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"JobId":              "bench",
+			"Action":             "ArchiveRetrieval",
+			"ArchiveId":          "bench",
+			"StatusCode":         "Succeeded",
+			"ArchiveSizeInBytes": b.size,
+			"SHA256TreeHash":     b.treeHash,
+		})
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/jobs/bench/output"):
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", start, end))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(make([]byte, end-start+1))
+	default:
+		http.Error(w, "unexpected request", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// command is a surge subcommand.
+type command struct {
+	name        string
+	args        string // the positional arguments shown in the usage line
+	summary     string // the one line description shown in the command list
+	description string
+
+	// setup registers the command options and returns the function running
+	// the command with the remaining positional arguments.
+	setup func(flags *flag.FlagSet) func(args []string) error
+
+	// A hidden command is not listed in the usage.
+	hidden bool
+
+	// The commands grouped by this one, which has no setup of its own.
+	subcommands []*command
+	parent      *command
+}
+
+// commands lists the available commands in the order they are shown in the usage.
+var commands = []*command{
+	abortCommand,
+	benchCommand,
+	deleteArchiveCommand,
+	deleteVaultCommand,
+	downloadCommand,
+	gcCommand,
+	iamPolicyCommand,
+	inventoryCommand,
+	jobsCommand,
+	pruneCommand,
+	restoreCommand,
+	uploadCommand,
+	versionCommand,
+	waitCommand,
+}
+
+func init() {
+	for _, c := range commands {
+		for _, sub := range c.subcommands {
+			sub.parent = c
+		}
+	}
+}
+
+// fullName returns the name of the command prefixed with the names of its groups.
+func (c *command) fullName() string {
+	if c.parent != nil {
+		return c.parent.fullName() + " " + c.name
+	}
+	return c.name
+}
+
+// findCommand returns the command with the name or nil if there is none.
+func findCommand(list []*command, name string) *command {
+	for _, c := range list {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// lookupCommand finds the command named by the leading arguments, descending
+// into the command groups, and returns it with the rest of the arguments.
+func lookupCommand(args []string) (*command, []string, error) {
+	c := findCommand(commands, args[0])
+	if c == nil {
+		return nil, nil, &usageError{err: fmt.Errorf("unknown command %q", args[0])}
+	}
+	args = args[1:]
+
+	for c.subcommands != nil {
+		if len(args) == 0 {
+			return nil, nil, &usageError{command: c, err: errors.New("no command given")}
+		}
+
+		sub := findCommand(c.subcommands, args[0])
+		if sub == nil {
+			return nil, nil, &usageError{command: c, err: fmt.Errorf("unknown command %q", c.fullName()+" "+args[0])}
+		}
+		c, args = sub, args[1:]
+	}
+
+	return c, args, nil
+}
+
+// usageError is an invalid command line.
+type usageError struct {
+	command *command // nil for the top level usage
+	err     error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+// newUsageError creates a usage error of the command.
+func newUsageError(c *command, format string, a ...interface{}) error {
+	return &usageError{command: c, err: fmt.Errorf(format, a...)}
+}
+
+// parseCommand parses the command line following the command name. The global
+// options are accepted there as well, and options may follow the positional
+// arguments.
+func parseCommand(c *command, global *flag.FlagSet, arguments []string) (func(args []string) error, []string, error) {
+	flags := flag.NewFlagSet(c.fullName(), flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+
+	run := c.setup(flags)
+	global.VisitAll(func(f *flag.Flag) {
+		flags.Var(f.Value, f.Name, f.Usage)
+		flags.Lookup(f.Name).DefValue = f.DefValue
+	})
+
+	args, err := parseInterspersed(flags, arguments)
+	if err == flag.ErrHelp {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, &usageError{command: c, err: err}
+	}
+	globals.recordGiven(global, flags)
+
+	return run, args, nil
+}
+
+// parseInterspersed parses the options mixed with the positional arguments and
+// returns the latter. Everything after "--" is treated as positional.
+func parseInterspersed(flags *flag.FlagSet, arguments []string) ([]string, error) {
+	var args []string
+
+	for {
+		if err := flags.Parse(arguments); err != nil {
+			return nil, err
+		}
+
+		rest := flags.Args()
+		if parsed := len(arguments) - len(rest); parsed > 0 && arguments[parsed-1] == "--" {
+			return append(args, rest...), nil
+		}
+
+		if len(rest) == 0 {
+			return args, nil
+		}
+
+		args = append(args, rest[0])
+		arguments = rest[1:]
+	}
+}
+
+// printUsage writes the top level usage.
+func printUsage(w io.Writer, global *flag.FlagSet) {
+	const usage = "Usage: surge [options] <command> [options] ARGS\n\n" +
+		"Amazon Glacier multipart download and upload\n\n" +
+		"Options:\n"
+
+	fmt.Fprint(w, usage)
+	printDefaults(w, global)
+
+	fmt.Fprint(w, "\nCommands:\n")
+	printCommands(w, commands)
+
+	fmt.Fprint(w, "\nOptions may be given either before or after the command.\n"+
+		"Run 'surge help <command>' for the options of a command.\n")
+}
+
+// printCommandUsage writes the usage of the command, listing both its own and
+// the global options.
+func printCommandUsage(w io.Writer, c *command, global *flag.FlagSet) {
+	if c.subcommands != nil {
+		fmt.Fprintf(w, "Usage: surge %s <command> [options] ARGS\n\n%s\n\nCommands:\n", c.fullName(), c.description)
+		printCommands(w, c.subcommands)
+		return
+	}
+
+	fmt.Fprintf(w, "Usage: surge %s\n\n%s\n", strings.TrimSpace(c.fullName()+" [options] "+c.args), c.description)
+
+	// Register the options on a separate set, so that the values already
+	// parsed are not reset to the defaults.
+	flags := flag.NewFlagSet(c.fullName(), flag.ContinueOnError)
+	c.setup(flags)
+
+	if hasFlags(flags) {
+		fmt.Fprint(w, "\nOptions:\n")
+		printDefaults(w, flags)
+	}
+
+	fmt.Fprint(w, "\nGlobal options:\n")
+	printDefaults(w, global)
+}
+
+// printCommands writes the names and the summaries of the commands,
+// listing the commands of a group by their full names.
+func printCommands(w io.Writer, list []*command) {
+	for _, c := range list {
+		if c.hidden {
+			continue
+		}
+		if c.subcommands != nil {
+			printCommands(w, c.subcommands)
+			continue
+		}
+		fmt.Fprintf(w, "  %-16s %s\n", c.fullName(), c.summary)
+	}
+}
+
+// printDefaults writes the default values of all options in the set to w.
+func printDefaults(w io.Writer, flags *flag.FlagSet) {
+	flags.SetOutput(w)
+	flags.PrintDefaults()
+	flags.SetOutput(ioutil.Discard)
+}
+
+func hasFlags(flags *flag.FlagSet) bool {
+	found := false
+	flags.VisitAll(func(*flag.Flag) {
+		found = true
+	})
+	return found
+}
+
+// newGlobalFlagSet creates the set of the options accepted by every command.
+func newGlobalFlagSet() *flag.FlagSet {
+	global := flag.NewFlagSet("surge", flag.ContinueOnError)
+	global.SetOutput(ioutil.Discard)
+	globals.register(global)
+	return global
+}
+
+// runCommandLine parses the command line arguments and runs the command.
+// It returns the error to exit with.
+func runCommandLine(global *flag.FlagSet, arguments []string) error {
+	if err := global.Parse(arguments); err == flag.ErrHelp {
+		printUsage(os.Stdout, global)
+		return nil
+	} else if err != nil {
+		return &usageError{err: err}
+	}
+
+	globals.recordGiven(global, global)
+
+	args := global.Args()
+	if len(args) == 0 {
+		return &usageError{err: errors.New("no command given")}
+	}
+
+	if args[0] == "help" {
+		return help(global, args[1:])
+	}
+
+	invocation = commandLine{args: arguments, command: len(arguments) - len(args)}
+
+	c, args, err := lookupCommand(args)
+	if err != nil {
+		return err
+	}
+
+	run, args, err := parseCommand(c, global, args)
+	if err == flag.ErrHelp {
+		printCommandUsage(os.Stdout, c, global)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := globals.startProfiling(); err != nil {
+		return err
+	}
+
+	return run(args)
+}
+
+// help prints the usage of the command given in args or the top level usage.
+func help(global *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		printUsage(os.Stdout, global)
+		return nil
+	}
+
+	c, args, err := lookupCommand(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return &usageError{err: errors.New("help accepts a single command")}
+	}
+
+	printCommandUsage(os.Stdout, c, global)
+	return nil
+}
+
+// printUsageError writes the error followed by the relevant usage to w.
+func printUsageError(w io.Writer, err *usageError, global *flag.FlagSet) {
+	fmt.Fprintf(w, "surge: %s\n\n", err)
+	if err.command != nil {
+		printCommandUsage(w, err.command, global)
+	} else {
+		printUsage(w, global)
+	}
+}
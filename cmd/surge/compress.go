@@ -0,0 +1,72 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+// checkSparse warns about the file if it is sparse, since the zeros of its
+// holes are uploaded and stored in full, and reports whether it is sparse.
+// The file is then compressed if compress is set.
+func checkSparse(fileName string, compress bool) bool {
+	// A file failing to open fails the upload once it is opened.
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return false
+	}
+	allocated, sparse := utils.Sparse(info)
+	if !sparse {
+		return false
+	}
+
+	message := fmt.Sprintf("%s is sparse or compressed by the filesystem, it allocates %s of its %s on disk",
+		fileName, utils.FormatSize(allocated), utils.FormatSize(info.Size()))
+	if compress {
+		message += ", uploading it compressed with gzip"
+	} else {
+		message += " but is uploaded in full, pass -compress-sparse to upload it compressed with gzip"
+	}
+	logger.Log(&events.Event{
+		Type:      events.Warning,
+		Message:   message,
+		Operation: uploadCommand.name,
+		Bytes:     info.Size(),
+	})
+
+	return true
+}
+
+// startCompression compresses the file with gzip in the background and
+// returns the compressed stream. A failure to read the file fails the stream,
+// so that the compressed part of the file is not taken for a complete archive.
+// Closing the stream stops the compression.
+func startCompression(fileName string) (io.ReadCloser, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer file.Close()
+
+		// The holes of a sparse file are runs of zeros, which compress well
+		// even at the fastest level.
+		compressor, _ := gzip.NewWriterLevel(writer, gzip.BestSpeed)
+		compressor.Name = filepath.Base(fileName)
+
+		_, err := io.Copy(compressor, file)
+		if err == nil {
+			err = compressor.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return reader, nil
+}
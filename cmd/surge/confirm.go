@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// confirm asks the user to confirm the destructive operation described by
+// the prompt. It succeeds without asking if -yes is given, and fails if the
+// standard input is not a terminal to ask on.
+func confirm(prompt string) error {
+	if globals.yes {
+		return nil
+	}
+
+	if !isTerminal(os.Stdin) {
+		return withCode(exitUsage, fmt.Errorf("%s: confirmation required, pass -yes to proceed", prompt))
+	}
+
+	fmt.Fprintf(os.Stderr, "%s? [y/N] ", prompt)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return errors.New("not confirmed")
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("not confirmed")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+var (
+	abortCommand = &command{
+		name:        "abort",
+		args:        "VAULT|REMOTE: UPLOAD_ID",
+		summary:     "Abort a multipart upload",
+		description: "Abort the multipart upload, the uploaded parts are deleted",
+	}
+
+	deleteArchiveCommand = &command{
+		name:        "delete-archive",
+		args:        "VAULT|REMOTE: ARCHIVE_ID",
+		summary:     "Delete an archive from the vault",
+		description: "Delete the archive from the Amazon Glacier vault, it cannot be recovered",
+	}
+
+	deleteVaultCommand = &command{
+		name:        "delete-vault",
+		args:        "VAULT|REMOTE:",
+		summary:     "Delete an empty vault",
+		description: "Delete the Amazon Glacier vault, it must contain no archives as of the last inventory",
+	}
+)
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	abortCommand.setup = setupAbort
+	deleteArchiveCommand.setup = setupDeleteArchive
+	deleteVaultCommand.setup = setupDeleteVault
+}
+
+func setupAbort(flags *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		if len(args) != 2 {
+			return newUsageError(abortCommand, "expected VAULT and UPLOAD_ID arguments, got %d argument(s)", len(args))
+		}
+		target, uploadId := args[0], args[1]
+
+		service, vaultName, err := globals.start(abortCommand.name, target, "")
+		if err != nil {
+			return err
+		}
+
+		parts, err := service.ListPartsRequest(&glacier.ListPartsInput{
+			AccountId: &globals.accountId,
+			UploadId:  &uploadId,
+			VaultName: &vaultName,
+		}).Send()
+		if err != nil {
+			return err
+		}
+
+		count := fmt.Sprint(len(parts.Parts))
+		if parts.Marker != nil {
+			count = "more than " + count
+		}
+
+		prompt := fmt.Sprintf("abort upload %s of %q to vault %s created at %s, deleting %s uploaded part(s)",
+			uploadId, aws.StringValue(parts.ArchiveDescription), vaultName,
+			aws.StringValue(parts.CreationDate), count)
+		if err := confirm(prompt); err != nil {
+			return err
+		}
+
+		_, err = service.AbortMultipartUploadRequest(&glacier.AbortMultipartUploadInput{
+			AccountId: &globals.accountId,
+			UploadId:  &uploadId,
+			VaultName: &vaultName,
+		}).Send()
+		if err != nil {
+			return err
+		}
+
+		logger.Log(&events.Event{
+			Type:      events.Completed,
+			Message:   fmt.Sprint("upload ", uploadId, " aborted"),
+			Operation: abortCommand.name,
+			UploadId:  uploadId,
+		})
+
+		return nil
+	}
+}
+
+func setupDeleteArchive(flags *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		if len(args) != 2 {
+			return newUsageError(deleteArchiveCommand, "expected VAULT and ARCHIVE_ID arguments, got %d argument(s)", len(args))
+		}
+		target, archiveId := args[0], args[1]
+
+		service, vaultName, err := globals.start(deleteArchiveCommand.name, target, "")
+		if err != nil {
+			return err
+		}
+
+		prompt := fmt.Sprintf("permanently delete archive %s from vault %s", archiveId, vaultName)
+		if err := confirm(prompt); err != nil {
+			return err
+		}
+
+		_, err = service.DeleteArchiveRequest(&glacier.DeleteArchiveInput{
+			AccountId: &globals.accountId,
+			ArchiveId: &archiveId,
+			VaultName: &vaultName,
+		}).Send()
+		if err != nil {
+			return err
+		}
+
+		logger.Log(&events.Event{
+			Type:      events.Completed,
+			Message:   fmt.Sprint("archive ", archiveId, " deleted"),
+			Operation: deleteArchiveCommand.name,
+			ArchiveId: archiveId,
+		})
+
+		return forgetArchive(service.Region, vaultName, archiveId)
+	}
+}
+
+// forgetArchive removes the deleted archive from the local catalog.
+func forgetArchive(region, vaultName, archiveId string) error {
+	c, err := globals.loadCatalog()
+	if err != nil {
+		return err
+	}
+
+	if !c.Remove(region, vaultName, archiveId) {
+		return nil
+	}
+
+	return globals.saveCatalog(c)
+}
+
+func setupDeleteVault(flags *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		if len(args) != 1 {
+			return newUsageError(deleteVaultCommand, "expected VAULT argument, got %d argument(s)", len(args))
+		}
+
+		service, vaultName, err := globals.start(deleteVaultCommand.name, args[0], "")
+		if err != nil {
+			return err
+		}
+
+		vault, err := service.DescribeVaultRequest(&glacier.DescribeVaultInput{
+			AccountId: &globals.accountId,
+			VaultName: &vaultName,
+		}).Send()
+		if err != nil {
+			return err
+		}
+
+		prompt := fmt.Sprintf("permanently delete vault %s created at %s, holding %d archive(s) of %s as of the inventory at %s",
+			vaultName, aws.StringValue(vault.CreationDate), aws.Int64Value(vault.NumberOfArchives),
+			utils.FormatSize(aws.Int64Value(vault.SizeInBytes)), aws.StringValue(vault.LastInventoryDate))
+		if err := confirm(prompt); err != nil {
+			return err
+		}
+
+		_, err = service.DeleteVaultRequest(&glacier.DeleteVaultInput{
+			AccountId: &globals.accountId,
+			VaultName: &vaultName,
+		}).Send()
+		if err != nil {
+			return err
+		}
+
+		logger.Log(&events.Event{
+			Type:      events.Completed,
+			Message:   fmt.Sprint("vault ", vaultName, " deleted"),
+			Operation: deleteVaultCommand.name,
+		})
+
+		return nil
+	}
+}
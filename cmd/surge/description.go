@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+// maxDescriptionLength is the longest archive description Glacier accepts.
+const maxDescriptionLength = 1024
+
+// descriptionData are the fields of a -description template, e.g.
+// {{.Hostname}}:{{.Path}}@{{.Date}}.
+type descriptionData struct {
+	Hostname string
+	User     string
+	Path     string // the absolute path of the file, or FILE as given if it is not a local file
+	Name     string // the base name of Path
+	Date     string // the UTC date of the upload, e.g. 2018-05-05
+	Time     string // the UTC time of the upload, e.g. 2018-05-05T19:01:56Z
+	Now      time.Time
+}
+
+// newDescriptionData returns the fields describing the upload of the file
+// at the time. A stream or an S3 object is named by FILE as given.
+func newDescriptionData(fileName string, local bool, now time.Time) *descriptionData {
+	path := fileName
+	if local {
+		if abs, err := filepath.Abs(fileName); err == nil {
+			path = abs
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	var username string
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	now = now.UTC()
+	return &descriptionData{
+		Hostname: hostname,
+		User:     username,
+		Path:     path,
+		Name:     filepath.Base(path),
+		Date:     now.Format("2006-01-02"),
+		Time:     now.Format(time.RFC3339),
+		Now:      now,
+	}
+}
+
+// expandDescription expands the description template with the data. The
+// expanded description must be valid for Glacier: at most 1024 printable
+// ASCII characters.
+func expandDescription(text string, data *descriptionData) (string, error) {
+	tmpl, err := template.New("description").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid -description template: %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("invalid -description template: %v", err)
+	}
+	description := b.String()
+
+	if len(description) > maxDescriptionLength {
+		return "", fmt.Errorf("the description %q is longer than %d characters", description, maxDescriptionLength)
+	}
+	for _, c := range description {
+		if c < 0x20 || c > 0x7e {
+			return "", fmt.Errorf("the description %q contains %q, only printable ASCII characters are allowed", description, c)
+		}
+	}
+
+	return description, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandDescription(t *testing.T) {
+	data := &descriptionData{
+		Hostname: "host",
+		User:     "user",
+		Path:     "/home/user/photos.tar",
+		Name:     "photos.tar",
+		Date:     "2018-05-05",
+		Time:     "2018-05-05T19:01:56Z",
+		Now:      time.Date(2018, 5, 5, 19, 1, 56, 0, time.UTC),
+	}
+
+	cases := []struct {
+		name        string
+		text        string
+		description string
+		err         string
+	}{
+		{"plain", "photos 2018", "photos 2018", ""},
+		{"empty", "", "", ""},
+		{"fields", "{{.Hostname}}:{{.Path}}@{{.Date}}", "host:/home/user/photos.tar@2018-05-05", ""},
+		{"format", `{{.User}}/{{.Name}} {{.Now.Format "2006-01"}}`, "user/photos.tar 2018-05", ""},
+		{"unknown field", "{{.Vault}}", "", "invalid -description template"},
+		{"syntax", "{{.Hostname", "", "invalid -description template"},
+		{"not printable", "photos\t2018", "", "only printable ASCII characters are allowed"},
+		{"too long", strings.Repeat("a", maxDescriptionLength+1), "", "is longer than 1024 characters"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			description, err := expandDescription(c.text, data)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("got %v, want an error containing %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if description != c.description {
+				t.Fatalf("got %#v, want %#v", description, c.description)
+			}
+		})
+	}
+}
+
+func TestNewDescriptionData(t *testing.T) {
+	now := time.Date(2018, 5, 5, 21, 1, 56, 0, time.FixedZone("CEST", 2*60*60))
+
+	data := newDescriptionData("s3://bucket/photos.tar", false, now)
+	if data.Path != "s3://bucket/photos.tar" || data.Name != "photos.tar" {
+		t.Fatalf("got %#v, %#v, want the file name as given", data.Path, data.Name)
+	}
+	if data.Date != "2018-05-05" || data.Time != "2018-05-05T19:01:56Z" {
+		t.Fatalf("got %#v, %#v, want the UTC date and time", data.Date, data.Time)
+	}
+
+	data = newDescriptionData("photos.tar", true, now)
+	if !filepath.IsAbs(data.Path) || data.Name != "photos.tar" {
+		t.Fatalf("got %#v, %#v, want the absolute path", data.Path, data.Name)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+// workerDisplay keeps a status line for every parallel job and a bar of the
+// whole transfer at the bottom of a terminal, below the log lines, so that a
+// job stuck on a part stands out. It is both the events logger tracking the
+// jobs and the writer of the log lines, which redraws the status lines under
+// every line written.
+type workerDisplay struct {
+	out   io.Writer
+	width int
+
+	mutex  sync.Mutex
+	slots  []*workerSlot
+	active bool
+
+	// The number of the status lines on the terminal.
+	drawn int
+}
+
+// workerSlot is the status of a parallel job. The jobs are not identified by
+// the events, so every part started takes the first idle slot.
+type workerSlot struct {
+	part   *utils.Range
+	number int
+	start  time.Time
+
+	// The attempt of sending the part, and the cause of the last failed one.
+	attempt     int
+	maxAttempts int
+	cause       string
+
+	// The outcome of the last part of the job.
+	last string
+}
+
+// newWorkerDisplay creates the display drawing to out, a terminal of the
+// width in columns.
+func newWorkerDisplay(out io.Writer, width int) *workerDisplay {
+	return &workerDisplay{out: out, width: width}
+}
+
+// terminalWidth returns the width of the terminal from the COLUMNS variable,
+// 80 columns if it is not set.
+func terminalWidth() int {
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return 80
+}
+
+// Log updates the status of the jobs. The status lines are redrawn with the
+// next log line, or on the next tick.
+func (d *workerDisplay) Log(e *events.Event) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	switch e.Type {
+	case events.Initiated:
+		d.active = true
+	case events.PartStarted:
+		d.active = true
+		slot := d.slot(nil)
+		if slot == nil {
+			slot = &workerSlot{}
+			d.slots = append(d.slots, slot)
+		}
+		slot.part = e.Range
+		slot.number = e.Part
+		slot.start = time.Now()
+		slot.attempt = 1
+		slot.maxAttempts = 0
+		slot.cause = ""
+	case events.Retry:
+		if slot := d.slot(e.Range); slot != nil {
+			// A part sent once more after it failed starts over from the
+			// first attempt.
+			slot.attempt = 1
+			if e.Attempt > 0 {
+				slot.attempt = e.Attempt
+			}
+			if e.MaxAttempts > 0 {
+				slot.maxAttempts = e.MaxAttempts
+			}
+			slot.cause = e.Cause
+		}
+	case events.PartFinished:
+		if slot := d.slot(e.Range); slot != nil {
+			slot.part = nil
+			slot.last = "last part at " + utils.FormatRate(e.Range.Limit, e.Elapsed)
+		}
+	case events.PartFailed:
+		if slot := d.slot(e.Range); slot != nil {
+			slot.part = nil
+			slot.last = "last part failed"
+		}
+	case events.Summary, events.Completed, events.Error:
+		// The status lines are left out of the final output.
+		d.active = false
+		d.slots = nil
+	}
+}
+
+// slot returns the slot of the part, or the first idle slot if the part is
+// nil. It returns nil if there is no such slot.
+func (d *workerDisplay) slot(part *utils.Range) *workerSlot {
+	for _, slot := range d.slots {
+		switch {
+		case part == nil && slot.part == nil:
+			return slot
+		case part != nil && slot.part != nil && slot.part.Offset == part.Offset:
+			return slot
+		}
+	}
+	return nil
+}
+
+// Write writes the log line above the status lines.
+func (d *workerDisplay) Write(p []byte) (int, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.clear()
+	n, err := d.out.Write(p)
+	d.draw()
+
+	return n, err
+}
+
+// redrawEvery redraws the status lines at the interval, which keeps the time
+// spent on the parts current between the log lines.
+func (d *workerDisplay) redrawEvery(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			d.mutex.Lock()
+			d.clear()
+			d.draw()
+			d.mutex.Unlock()
+		}
+	}()
+}
+
+// clear erases the status lines, leaving the cursor where the first was.
+func (d *workerDisplay) clear() {
+	if d.drawn > 0 {
+		fmt.Fprintf(d.out, "\x1b[%dA\x1b[J", d.drawn)
+		d.drawn = 0
+	}
+}
+
+// draw writes the status lines below the cursor.
+func (d *workerDisplay) draw() {
+	if !d.active {
+		return
+	}
+
+	lines := make([]string, 0, len(d.slots)+1)
+	for i, slot := range d.slots {
+		line := fmt.Sprintf("job %d: idle", i+1)
+		if slot.part != nil {
+			line = fmt.Sprintf("job %d: %s, %v", i+1, events.PartName(slot.number, slot.part), time.Since(slot.start).Round(time.Second))
+			if slot.attempt > 1 || slot.cause != "" {
+				line += ", " + events.AttemptName(slot.attempt, slot.maxAttempts)
+			}
+			if slot.cause != "" {
+				line += " (" + slot.cause + ")"
+			}
+		}
+		if slot.last != "" {
+			line += "; " + slot.last
+		}
+		lines = append(lines, line)
+	}
+	if progress != nil {
+		lines = append(lines, progress.bar(20))
+	}
+
+	for _, line := range lines {
+		if len(line) >= d.width {
+			line = line[:d.width-1]
+		}
+		fmt.Fprintln(d.out, line)
+	}
+	d.drawn = len(lines)
+}
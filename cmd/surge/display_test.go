@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+func TestWorkerDisplay(t *testing.T) {
+	defer func(p *progressTracker) { progress = p }(progress)
+	progress = newProgressTracker(events.Discard)
+
+	var out bytes.Buffer
+	d := newWorkerDisplay(&out, 60)
+
+	part := func(offset int64) *utils.Range {
+		return &utils.Range{Offset: offset, Limit: 1 << 20}
+	}
+	log := func(e *events.Event) {
+		progress.Log(e)
+		d.Log(e)
+	}
+
+	log(&events.Event{Type: events.Initiated, Parts: 4, Bytes: 4 << 20})
+	log(&events.Event{Type: events.PartStarted, Range: part(0), Part: 1})
+	log(&events.Event{Type: events.PartStarted, Range: part(1 << 20)})
+	log(&events.Event{Type: events.Retry, Range: part(0), Attempt: 2, MaxAttempts: 4, Cause: events.CauseThrottled})
+	log(&events.Event{Type: events.PartFinished, Range: part(1 << 20), Elapsed: 2})
+	log(&events.Event{Type: events.PartStarted, Range: part(2 << 20)})
+	log(&events.Event{Type: events.PartStarted, Range: part(3 << 20)})
+
+	d.Write([]byte("first\n"))
+	d.Write([]byte("second\n"))
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	want := []string{
+		"first",
+		"job 1: part 1 (0-1048575), 0s, attempt 2/4 (throttled)",
+		"job 2: part (2097152-3145727), 0s; last part at 512 KiB/s",
+		"job 3: part (3145728-4194303), 0s",
+		"[#####...............] 1 part(s) done, 3 in flight, 0 remai",
+		"\x1b[4A\x1b[Jsecond",
+	}
+	if len(lines) != 10 || !reflect.DeepEqual(lines[:6], want) {
+		t.Fatalf("got %#v, want %#v...", lines, want)
+	}
+
+	out.Reset()
+	log(&events.Event{Type: events.Summary})
+	d.Write([]byte("summary\n"))
+
+	if got, want := out.String(), "\x1b[4A\x1b[Jsummary\n"; got != want {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/31z4/surge/pkg/downloader"
+	"github.com/31z4/surge/pkg/s3object"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+var downloadCommand = &command{
+	name:        "download",
+	args:        "VAULT|REMOTE: FILE",
+	summary:     "Download a retrieved archive",
+	description: "Download an archive retrieved from the Amazon Glacier vault",
+}
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	downloadCommand.setup = setupDownload
+}
+
+func setupDownload(flags *flag.FlagSet) func(args []string) error {
+	jobId := flags.String("job-id", "", "the job ID whose data is downloaded (required)")
+	force := flags.Bool("force", false, "overwrite the file if it exists, after confirmation")
+	var writeBuffer sizeValue
+	flags.Var(&writeBuffer, "write-buffer", "buffer the adjacent parts and write them at once in chunks of the `size`")
+	quarantine := flags.Bool("quarantine", false, "save the data of a part failing its tree hash to FILE.badpart-OFFSET")
+	gpgDecrypt := flags.Bool("gpg-decrypt", false, "download the archive to FILE.gpg and decrypt it to FILE with gpg")
+	indexName := flags.String("index", "", "reassemble FILE from the archives listed in the split index `file`, retrieved by the jobs of -job-id separated by commas")
+
+	return func(args []string) error {
+		if *jobId == "" {
+			return newUsageError(downloadCommand, "the -job-id option is required")
+		}
+
+		if len(args) != 2 {
+			return newUsageError(downloadCommand, "expected VAULT and FILE arguments, got %d argument(s)", len(args))
+		}
+		target, fileName := args[0], args[1]
+
+		bucket, key, toS3 := s3object.ParseURL(fileName)
+		if toS3 && writeBuffer > 0 {
+			return newUsageError(downloadCommand, "-write-buffer is not supported for an object stored in S3")
+		}
+		if toS3 && *quarantine {
+			return newUsageError(downloadCommand, "-quarantine is not supported for an object stored in S3")
+		}
+		if toS3 && *gpgDecrypt {
+			return newUsageError(downloadCommand, "-gpg-decrypt is not supported for an object stored in S3")
+		}
+		if *indexName != "" {
+			if toS3 {
+				return newUsageError(downloadCommand, "-index is not supported for an object stored in S3")
+			}
+			if writeBuffer > 0 || *quarantine || *gpgDecrypt {
+				return newUsageError(downloadCommand, "-write-buffer, -quarantine and -gpg-decrypt are not supported with -index")
+			}
+		}
+
+		service, vaultName, err := globals.start(downloadCommand.name, target, fileName)
+		if err != nil {
+			return err
+		}
+
+		var destination downloader.Destination
+		if toS3 {
+			writer, err := createObject(service.Config, bucket, key, *force)
+			if err != nil {
+				return err
+			}
+			destination = writer
+		} else if *force {
+			if info, err := os.Stat(fileName); err == nil {
+				if err := confirmOverwrite(fileName, info); err != nil {
+					return err
+				}
+			}
+		} else if *gpgDecrypt || *indexName != "" {
+			// Fail before the download rather than once it is decrypted
+			// or the first segment is downloaded.
+			if _, err := os.Stat(fileName); err == nil {
+				return fmt.Errorf("%s exists, pass -force to overwrite it", fileName)
+			}
+		}
+
+		// The segments are downloaded into the file once more if the
+		// reassembly is interrupted.
+		if *indexName != "" {
+			resumable = func(r *result) ([]string, bool) {
+				if r.Index != "" {
+					return nil, false
+				}
+				return invocation.rewrite([]string{"force"}, "-force"), true
+			}
+			return downloadSplit(service, vaultName, *indexName, strings.Split(*jobId, ","), fileName)
+		}
+
+		// The encrypted archive is downloaded next to the file, and the
+		// download of it is resumed like the download of any file.
+		downloadName := fileName
+		if *gpgDecrypt {
+			downloadName += gpgSuffix
+		}
+
+		input := &downloader.Input{
+			AccountId:      globals.accountId,
+			PartSize:       globals.partSize.download(),
+			Order:          utils.Order(globals.partOrder),
+			MaxRate:        int64(globals.maxRate),
+			MaxMemory:      int64(globals.maxMemory),
+			OnFailure:      utils.FailurePolicy(globals.onFailure),
+			SlowPartFactor: globals.slowFactor,
+			WriteBuffer:    int64(writeBuffer),
+			DropCache:      globals.dropCache,
+			Quarantine:     *quarantine,
+			VaultName:      vaultName,
+			FileName:       downloadName,
+			Overwrite:      *force,
+			JobId:          *jobId,
+			Destination:    destination,
+			Logger:         logger,
+		}
+		if destination == nil {
+			input.StateFile = downloadName + stateFileSuffix
+		}
+
+		// The state file resumes the download of a file, which -force would
+		// overwrite. The parts written to an object are discarded instead.
+		resumable = func(r *result) ([]string, bool) {
+			if r.Verified != nil && *r.Verified {
+				return nil, false
+			}
+			if destination != nil {
+				return invocation.rewrite(nil), true
+			}
+			return invocation.rewrite([]string{"force"}), false
+		}
+
+		d := downloader.New(service, input)
+
+		downloaded, err := d.Download(globals.jobs)
+		if err != nil {
+			return err
+		}
+
+		// Only an archive matching its tree hash is worth decrypting.
+		if *gpgDecrypt && downloaded.Verified {
+			return decrypt(downloadName, fileName, *force)
+		}
+		return nil
+	}
+}
+
+// stateFileSuffix is appended to the name of the downloaded file to name the
+// state file that lets an interrupted download resume.
+const stateFileSuffix = ".surge-state"
+
+// confirmOverwrite asks to confirm overwriting the existing file.
+func confirmOverwrite(fileName string, info os.FileInfo) error {
+	prompt := fmt.Sprintf("overwrite %s of %s modified at %s", fileName,
+		utils.FormatSize(info.Size()), info.ModTime().Format(time.RFC3339))
+	return confirm(prompt)
+}
+
+// createObject creates the writer of the object the archive is downloaded to.
+// Like a file, an existing object is only overwritten with force, after
+// confirmation.
+func createObject(config aws.Config, bucket, key string, force bool) (*s3object.Writer, error) {
+	object, err := s3object.Open(config, bucket, key)
+	if err == nil {
+		if !force {
+			return nil, fmt.Errorf("%s exists, pass -force to overwrite it", object)
+		}
+		prompt := fmt.Sprintf("overwrite %s of %s", object, utils.FormatSize(object.Size()))
+		if err := confirm(prompt); err != nil {
+			return nil, err
+		}
+	} else if !s3object.IsNotFound(err) {
+		return nil, err
+	}
+
+	// The parts of the object are aligned to the downloaded parts if their
+	// size is given, otherwise to the tree hash leaves.
+	align := globals.partSize.download()
+	if align == 0 {
+		align = 1 << 20
+	}
+	return s3object.Create(config, bucket, key, align)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// setEcho fails to turn the echo off, which is not supported on this platform.
+func setEcho(file *os.File, on bool) error {
+	if on {
+		return nil
+	}
+	return errors.New("hiding the terminal input is not supported on this platform")
+}
@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setEcho turns the echo of the terminal input on or off with stty, which
+// knows the terminal flags of every Unix system.
+func setEcho(file *os.File, on bool) error {
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = file
+	return cmd.Run()
+}
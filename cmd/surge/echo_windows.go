@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var procSetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// ENABLE_ECHO_INPUT of the console mode.
+const enableEchoInput = 0x4
+
+// setEcho turns the echo of the console input on or off.
+func setEcho(file *os.File, on bool) error {
+	handle := syscall.Handle(file.Fd())
+
+	var mode uint32
+	if err := syscall.GetConsoleMode(handle, &mode); err != nil {
+		return err
+	}
+
+	if on {
+		mode |= enableEchoInput
+	} else {
+		mode &^= enableEchoInput
+	}
+
+	if r, _, err := procSetConsoleMode.Call(uintptr(handle), uintptr(mode)); r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/31z4/surge/pkg/awsconfig"
+	"github.com/31z4/surge/pkg/downloader"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/inventory"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+// Exit codes allow scripts wrapping surge to branch on the failure mode.
+const (
+	exitOK           = 0   // the command succeeded
+	exitError        = 1   // an error not covered by the codes below
+	exitUsage        = 2   // invalid command line
+	exitAuth         = 3   // missing, expired or rejected credentials, or invalid AWS configuration
+	exitJobNotReady  = 4   // the retrieval job has not succeeded yet
+	exitVerification = 5   // the transferred data doesn't match its tree hash
+	exitPartial      = 6   // some of the parts could not be transferred
+	exitJobFailed    = 7   // the job waited for failed
+	exitInterrupted  = 130 // interrupted by a signal
+)
+
+// authErrorCodes are AWS error codes caused by missing or rejected credentials.
+var authErrorCodes = map[string]struct{}{
+	"AccessDenied":                        {}, // returned by STS when the role cannot be assumed
+	"AccessDeniedException":               {},
+	"AssumeRoleTokenNotAvailable":         {},
+	"EC2RoleRequestError":                 {},
+	"ExpiredTokenException":               {},
+	"IncompleteSignatureException":        {},
+	"InvalidClientTokenId":                {},
+	"InvalidSignatureException":           {},
+	"MissingAuthenticationTokenException": {},
+	"NoCredentialProviders":               {},
+	"SignatureDoesNotMatch":               {},
+	"UnrecognizedClientException":         {},
+}
+
+// codedError is an error that exits with a specific code.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// withCode makes the error exit with the code.
+func withCode(code int, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+// exitCode maps an error returned by a command to the exit code. The error
+// is classified by the errors it wraps as well.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	var usage *usageError
+	if errors.As(err, &usage) {
+		return exitUsage
+	}
+	var partial *utils.PartialTransferError
+	if errors.As(err, &partial) {
+		return exitPartial
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		if _, ok := authErrorCodes[awsErr.Code()]; ok || aws.IsErrorExpiredCreds(awsErr) {
+			return exitAuth
+		}
+		return exitError
+	}
+
+	switch {
+	case errors.Is(err, awsconfig.ErrSSOSessionNotFound), errors.Is(err, awsconfig.ErrSSOSessionExpired):
+		return exitAuth
+	case errors.Is(err, downloader.ErrJobNotReady), errors.Is(err, inventory.ErrJobNotReady):
+		return exitJobNotReady
+	case errors.Is(err, downloader.ErrHashMismatch):
+		return exitVerification
+	}
+
+	return exitError
+}
+
+// fatal logs the error and exits with the code classifying it.
+func fatal(err error) {
+	fail(exitCode(err), err)
+}
+
+// exitStatus names the outcome of the command exiting with the code: success,
+// failure or interrupted.
+func exitStatus(code int) string {
+	switch code {
+	case exitOK:
+		return "success"
+	case exitInterrupted:
+		return "interrupted"
+	}
+	return "failure"
+}
+
+// cleanup is called before the process exits.
+var cleanup = func() {}
+
+// fail logs the error and exits with the code.
+func fail(code int, err error) {
+	logger.Log(&events.Event{
+		Type:    events.Error,
+		Message: err.Error(),
+		Error:   err.Error(),
+	})
+
+	logIncomplete(code)
+	finishStatusFile(code, err)
+	runPostHook(code, err)
+
+	printResult(code, err)
+	cleanup()
+
+	os.Exit(code)
+}
+
+var interruptOnce sync.Once
+
+// exitOnInterrupt makes the process exit with exitInterrupted on SIGINT or SIGTERM.
+// Calling it more than once has no effect.
+func exitOnInterrupt() {
+	interruptOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+		go func() {
+			s := <-signals
+			fail(exitInterrupted, fmt.Errorf("interrupted by %v", s))
+		}()
+	})
+}
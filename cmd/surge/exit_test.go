@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/31z4/surge/pkg/awsconfig"
+	"github.com/31z4/surge/pkg/downloader"
+	"github.com/31z4/surge/pkg/inventory"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+func TestExitCode(t *testing.T) {
+	wrap := func(err error) error {
+		return fmt.Errorf("test: %w", err)
+	}
+
+	cases := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"nil", nil, exitOK},
+		{"error", errors.New("test"), exitError},
+		{"coded", withCode(exitJobFailed, errors.New("test")), exitJobFailed},
+		{"coded sentinel", withCode(exitUsage, downloader.ErrHashMismatch), exitUsage},
+		{"usage", wrap(newUsageError(nil, "test")), exitUsage},
+		{"partial", wrap(utils.NewPartialTransferError(nil)), exitPartial},
+		{"auth", wrap(awserr.New("AccessDeniedException", "test", nil)), exitAuth},
+		{"aws", wrap(awserr.New("ResourceNotFoundException", "test", nil)), exitError},
+		{"sso", wrap(awsconfig.ErrSSOSessionExpired), exitAuth},
+		{"job not ready", wrap(inventory.ErrJobNotReady), exitJobNotReady},
+		{"hash mismatch", wrap(downloader.ErrHashMismatch), exitVerification},
+	}
+
+	for _, c := range cases {
+		if got := exitCode(c.err); got != c.code {
+			t.Errorf("%s: got %d, want %d", c.name, got, c.code)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+// sizeValue is an option accepting human-readable sizes such as 16MiB.
+type sizeValue int64
+
+func (v *sizeValue) Set(s string) error {
+	size, err := utils.ParseSize(s)
+	if err != nil {
+		return err
+	}
+	*v = sizeValue(size)
+	return nil
+}
+
+func (v *sizeValue) String() string {
+	return utils.FormatSize(int64(*v))
+}
+
+// partSizeValue is the part size option, which tells whether it was given
+// since the downloads choose the part size by default.
+type partSizeValue struct {
+	sizeValue
+	set bool
+}
+
+func (v *partSizeValue) Set(s string) error {
+	if err := v.sizeValue.Set(s); err != nil {
+		return err
+	}
+	v.set = true
+	return nil
+}
+
+// download returns the part size of the downloads, zero for automatic.
+func (v *partSizeValue) download() int64 {
+	if !v.set {
+		return 0
+	}
+	return int64(v.sizeValue)
+}
+
+// rateValue is an option accepting human-readable rates such as 20MB/s.
+type rateValue int64
+
+func (v *rateValue) Set(s string) error {
+	rate, err := utils.ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*v = rateValue(rate)
+	return nil
+}
+
+func (v *rateValue) String() string {
+	if *v == 0 {
+		return ""
+	}
+	return utils.FormatSize(int64(*v)) + "/s"
+}
+
+type orderValue utils.Order
+
+func (v *orderValue) Set(s string) error {
+	order, err := utils.ParseOrder(s)
+	if err != nil {
+		return err
+	}
+	*v = orderValue(order)
+	return nil
+}
+
+func (v *orderValue) String() string {
+	return utils.Order(*v).String()
+}
+
+type failurePolicyValue utils.FailurePolicy
+
+func (v *failurePolicyValue) Set(s string) error {
+	policy, err := utils.ParseFailurePolicy(s)
+	if err != nil {
+		return err
+	}
+	*v = failurePolicyValue(policy)
+	return nil
+}
+
+func (v *failurePolicyValue) String() string {
+	return utils.FailurePolicy(*v).String()
+}
+
+// failFastValue is a boolean option selecting the fail-fast policy.
+type failFastValue struct {
+	policy *failurePolicyValue
+}
+
+func (v *failFastValue) Set(s string) error {
+	enabled, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+
+	if enabled {
+		*v.policy = failurePolicyValue(utils.FailFast)
+	} else if *v.policy == failurePolicyValue(utils.FailFast) {
+		*v.policy = failurePolicyValue(utils.RetryThenSkip)
+	}
+	return nil
+}
+
+func (v *failFastValue) String() string {
+	return strconv.FormatBool(v.policy != nil && *v.policy == failurePolicyValue(utils.FailFast))
+}
+
+func (v *failFastValue) IsBoolFlag() bool {
+	return true
+}
+
+// listValue is an option that may be given several times, every value is
+// appended to the list.
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	*v = append(*v, s)
+	return nil
+}
+
+func (v *listValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+// levelValue is a boolean option selecting a verbosity level.
+// Several options share the same level, the last one given wins.
+type levelValue struct {
+	level *events.Level
+	value events.Level
+}
+
+func (v *levelValue) Set(s string) error {
+	enabled, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+
+	if enabled {
+		*v.level = v.value
+	} else if *v.level == v.value {
+		*v.level = events.LevelNormal
+	}
+	return nil
+}
+
+func (v *levelValue) String() string {
+	return strconv.FormatBool(v.level != nil && *v.level == v.value)
+}
+
+func (v *levelValue) IsBoolFlag() bool {
+	return true
+}
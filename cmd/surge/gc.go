@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/inventory"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+var gcCommand = &command{
+	name:    "gc",
+	args:    "VAULT|REMOTE:",
+	summary: "Delete the archives unknown to the local catalog",
+	description: "Delete the archives of the Amazon Glacier vault that are not in the local catalog,\n" +
+		"reclaiming the storage of abandoned uploads. The archives are found in an inventory\n" +
+		"of the vault newer than the cached one, and deleted once confirmed. A catalog with\n" +
+		"no archive of the vault is refused unless -allow-empty-catalog is given.",
+}
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	gcCommand.setup = setupGC
+}
+
+func setupGC(flags *flag.FlagSet) func(args []string) error {
+	jobId := flags.String("job-id", "", "the inventory retrieval job ID, the latest succeeded job is used by default")
+	cached := flags.Bool("cached", false, "use the cached inventory rather than retrieving a newer one")
+	allowEmpty := flags.Bool("allow-empty-catalog", false, "delete the archives even if the catalog has none of the vault, i.e. every archive of the vault")
+	dryRun := flags.Bool("dry-run", false, "only list the archives that would be deleted")
+	var columns tableOptions
+	columns.register(flags, archiveColumns...)
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return newUsageError(gcCommand, "expected VAULT argument, got %d argument(s)", len(args))
+		}
+		if err := columns.parse(); err != nil {
+			return newUsageError(gcCommand, "%v", err)
+		}
+
+		service, vaultName, err := globals.start(gcCommand.name, args[0], "")
+		if err != nil {
+			return err
+		}
+
+		c, err := globals.loadCatalog()
+		if err != nil {
+			return err
+		}
+
+		// A mistyped -catalog or a new host loads an empty catalog, which
+		// would have every archive of the vault deleted.
+		known := c.Vault(service.Region, vaultName)
+		if len(known) == 0 && !*allowEmpty && !*dryRun {
+			return fmt.Errorf("the catalog has no archive of vault %s, so every archive of the vault would be deleted; "+
+				"check -catalog, or pass -allow-empty-catalog to delete them", vaultName)
+		}
+
+		cache, err := globals.inventoryCache(service.Region)
+		if err != nil {
+			return err
+		}
+
+		input := &inventory.Input{
+			AccountId: globals.accountId,
+			VaultName: vaultName,
+			JobId:     *jobId,
+			Cache:     cache,
+			Refresh:   !*cached,
+			Logger:    logger,
+		}
+
+		inv, err := inventory.New(service, input).Retrieve()
+		if err != nil {
+			return err
+		}
+
+		orphans := inventory.Compare(inv, known).RemoteOnly
+
+		if globals.output == "text" {
+			if err := printOrphans(os.Stdout, inv, orphans, &columns); err != nil {
+				return err
+			}
+		}
+		if len(orphans) == 0 || *dryRun {
+			return nil
+		}
+
+		var size int64
+		for _, a := range orphans {
+			size += a.Size
+		}
+
+		prompt := fmt.Sprintf("permanently delete %d archive(s) of %s from vault %s",
+			len(orphans), utils.FormatSize(size), vaultName)
+		if err := confirm(prompt); err != nil {
+			return err
+		}
+
+		for _, a := range orphans {
+			archiveId := a.ArchiveId
+			_, err = service.DeleteArchiveRequest(&glacier.DeleteArchiveInput{
+				AccountId: &globals.accountId,
+				ArchiveId: &archiveId,
+				VaultName: &vaultName,
+			}).Send()
+			if err != nil {
+				return err
+			}
+
+			logger.Log(&events.Event{
+				Type:      events.Completed,
+				Message:   fmt.Sprint("archive ", archiveId, " deleted"),
+				Operation: gcCommand.name,
+				ArchiveId: archiveId,
+			})
+
+			results.update(func(r *result) {
+				r.Deleted = append(r.Deleted, archiveId)
+			})
+		}
+
+		return nil
+	}
+}
+
+// printOrphans writes the archives of the inventory unknown to the catalog.
+func printOrphans(w io.Writer, inv *inventory.Inventory, orphans []inventory.Archive, columns *tableOptions) error {
+	fmt.Fprintf(w, "inventory of %s\n", inv.InventoryDate.Format(time.RFC3339))
+
+	if len(orphans) == 0 {
+		fmt.Fprintln(w, "every archive in the vault is in the catalog")
+		return nil
+	}
+
+	fmt.Fprintf(w, "\n%d archive(s) in the vault unknown to the catalog:\n", len(orphans))
+	return printArchives(w, orphans, columns)
+}
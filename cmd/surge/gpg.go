@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// gpgProgram encrypts and decrypts the archives with OpenPGP, using the
+// keys of the GnuPG keyring.
+const gpgProgram = "gpg"
+
+// gpgSuffix is appended to the name of the downloaded file to name the
+// encrypted archive it is decrypted from.
+const gpgSuffix = ".gpg"
+
+// startEncryption runs gpg encrypting the input to the recipients and returns
+// its output. If the input is nil then the file is encrypted.
+func startEncryption(recipients []string, fileName string, input io.Reader) (*commandOutput, error) {
+	args := []string{"--batch", "--encrypt", "--output", "-"}
+	for _, recipient := range recipients {
+		args = append(args, "--recipient", recipient)
+	}
+	if input == nil {
+		args = append(args, "--", fileName)
+	}
+
+	// A failure to read the input fails gpg once its output is read, so that
+	// the encrypted part of the input is not taken for a complete archive.
+	cmd := exec.Command(gpgProgram, args...)
+	cmd.Stdin = input
+
+	return startOutput(cmd, gpgProgram)
+}
+
+// decrypt runs gpg decrypting the encrypted archive to the file and removes
+// the archive once it is decrypted. The file is only overwritten with force.
+func decrypt(encrypted, fileName string, force bool) error {
+	args := []string{"--decrypt", "--output", fileName}
+	if force {
+		args = append(args, "--yes")
+	}
+	args = append(args, "--", encrypted)
+
+	// The agent may ask for the passphrase of the secret key on the terminal.
+	cmd := exec.Command(gpgProgram, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not decrypt %s, it is kept: %v", encrypted, err)
+	}
+	return os.Remove(encrypted)
+}
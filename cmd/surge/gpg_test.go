@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withTestKey creates a keyring with a key of the test recipient, without
+// a passphrase, in a temporary GnuPG home and returns the function removing it.
+func withTestKey(t *testing.T) func() {
+	t.Helper()
+
+	if _, err := exec.LookPath(gpgProgram); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	home := os.Getenv("GNUPGHOME")
+	os.Setenv("GNUPGHOME", dir)
+	cleanup := func() {
+		exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+		os.Setenv("GNUPGHOME", home)
+		os.RemoveAll(dir)
+	}
+
+	cmd := exec.Command(gpgProgram, "--batch", "--passphrase", "", "--quick-generate-key", "test@surge", "default", "default", "never")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		cleanup()
+		t.Fatalf("could not generate a key: %v\n%s", err, output)
+	}
+
+	return cleanup
+}
+
+// failingReader returns the data, then fails.
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("test")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestGPG(t *testing.T) {
+	defer withTestKey(t)()
+
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("test"), 1<<10)
+	fileName := filepath.Join(dir, "archive")
+	if err := ioutil.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name  string
+		input io.Reader
+	}{
+		{"file", nil},
+		{"stream", bytes.NewReader(data)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := startEncryption([]string{"test@surge"}, fileName, test.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			defer output.Close()
+
+			encrypted, err := ioutil.ReadAll(output)
+			if err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			if bytes.Contains(encrypted, data[:16]) {
+				t.Fatal("the archive is not encrypted")
+			}
+
+			encryptedName := filepath.Join(dir, "downloaded"+gpgSuffix)
+			if err := ioutil.WriteFile(encryptedName, encrypted, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			downloaded := filepath.Join(dir, "downloaded")
+			if err := decrypt(encryptedName, downloaded, true); err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+
+			if got, err := ioutil.ReadFile(downloaded); err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("the decrypted file differs from the archive: %v", err)
+			}
+			if _, err := os.Stat(encryptedName); !os.IsNotExist(err) {
+				t.Fatalf("the encrypted archive is not removed: %v", err)
+			}
+		})
+	}
+
+	t.Run("input error", func(t *testing.T) {
+		output, err := startEncryption([]string{"test@surge"}, fileName, &failingReader{data: data})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		defer output.Close()
+
+		if _, err := ioutil.ReadAll(output); err == nil || !strings.Contains(err.Error(), "test") {
+			t.Fatalf("got %#v, want the error of the input", err)
+		}
+	})
+}
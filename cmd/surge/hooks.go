@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/31z4/surge/pkg/events"
+)
+
+// transferCommands are the transfers, run between the -pre-cmd and -post-cmd
+// hooks and tuned by -auto-tune.
+var transferCommands = map[string]bool{
+	uploadCommand.name:   true,
+	downloadCommand.name: true,
+	restoreCommand.name:  true,
+}
+
+// postHook is the -post-cmd hook of the transfer, armed once the -pre-cmd
+// hook succeeded so that it runs only after a transfer that was attempted.
+var postHook struct {
+	sync.Mutex
+	command string
+}
+
+// runPreHook runs the -pre-cmd hook before the transfer of the command, e.g.
+// to snapshot the file system, and arms the -post-cmd hook. The transfer is
+// not attempted if the hook fails.
+func (o *globalOptions) runPreHook(command string) error {
+	if !transferCommands[command] {
+		return nil
+	}
+
+	if o.preCmd != "" {
+		if err := runHook(o.preCmd, hookEnv("pre", results.get(), exitOK, nil)); err != nil {
+			return fmt.Errorf("-pre-cmd failed: %v", err)
+		}
+	}
+
+	postHook.Lock()
+	postHook.command = o.postCmd
+	postHook.Unlock()
+
+	return nil
+}
+
+// runPostHook runs the -post-cmd hook once the transfer is done with the exit
+// code, at most once even if the process is interrupted meanwhile. A failing
+// hook is only warned about, the outcome of the transfer stands.
+func runPostHook(code int, err error) {
+	postHook.Lock()
+	command := postHook.command
+	postHook.command = ""
+	postHook.Unlock()
+
+	if command == "" {
+		return
+	}
+
+	if err := runHook(command, hookEnv("post", results.get(), code, err)); err != nil {
+		logger.Log(&events.Event{
+			Type:    events.Warning,
+			Message: fmt.Sprintf("-post-cmd failed: %v", err),
+		})
+	}
+}
+
+// runHook runs the hook command by the shell with the environment added.
+// Its output goes to the standard error, which keeps the standard output
+// for the command result.
+func runHook(command string, env []string) error {
+	cmd := shellCommand(command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// hookEnv returns the environment describing the transfer to the hook. The
+// post hook also receives the outcome of the transfer.
+func hookEnv(hook string, r result, code int, err error) []string {
+	env := []string{
+		"SURGE_HOOK=" + hook,
+		"SURGE_COMMAND=" + r.Command,
+		"SURGE_VAULT=" + r.Vault,
+		"SURGE_FILE=" + r.File,
+	}
+	if hook != "post" {
+		return env
+	}
+
+	env = append(env,
+		"SURGE_STATUS="+exitStatus(code),
+		"SURGE_EXIT_CODE="+strconv.Itoa(code),
+		"SURGE_ARCHIVE_ID="+r.ArchiveId,
+		"SURGE_UPLOAD_ID="+r.UploadId,
+		"SURGE_JOB_ID="+r.JobId,
+		"SURGE_LOCATION="+r.Location,
+		"SURGE_CHECKSUM="+r.Checksum,
+		"SURGE_INDEX="+r.Index,
+		"SURGE_BYTES="+strconv.FormatInt(r.Bytes, 10),
+	)
+	if err != nil {
+		env = append(env, "SURGE_ERROR="+err.Error())
+	}
+
+	return env
+}
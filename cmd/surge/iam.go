@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var iamPolicyCommand = &command{
+	name:    "iam-policy",
+	args:    "VAULT_ARN",
+	summary: "Print the IAM policy the commands need",
+	description: "Print the least-privilege IAM policy allowing the commands on the vault, e.g.\n" +
+		"arn:aws:glacier:us-east-1:111111111111:vaults/my-vault. The ARN may contain\n" +
+		"wildcards. Only the Amazon Glacier actions are included, the permissions to read\n" +
+		"or write the objects stored in Amazon S3 must be granted separately.",
+}
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	iamPolicyCommand.setup = setupIAMPolicy
+}
+
+// commandActions are the Amazon Glacier actions every command calls on the vault.
+var commandActions = map[string][]string{
+	"abort":          {"ListParts", "AbortMultipartUpload"},
+	"delete-archive": {"DeleteArchive"},
+	"delete-vault":   {"DescribeVault", "DeleteVault"},
+	// The transfers time DescribeVault requests with -auto-tune.
+	"download":       {"DescribeJob", "GetJobOutput", "DescribeVault"},
+	"gc":             {"ListJobs", "InitiateJob", "DescribeJob", "GetJobOutput", "DeleteArchive"},
+	"inventory diff": {"ListJobs", "InitiateJob", "DescribeJob", "GetJobOutput"},
+	"jobs list":      {"ListJobs"},
+	"prune":          {"DeleteArchive"},
+	"restore":        {"ListJobs", "InitiateJob", "DescribeJob", "GetJobOutput", "DescribeVault"},
+	// An upload looks for a duplicate in the inventory with -check-inventory.
+	"upload": {"InitiateMultipartUpload", "UploadMultipartPart", "ListParts", "CompleteMultipartUpload",
+		"ListJobs", "InitiateJob", "DescribeJob", "GetJobOutput", "DescribeVault"},
+	"wait": {"DescribeJob"},
+}
+
+// accountActions are the actions of the commands on the account rather than
+// on a vault, which are granted on any resource.
+var accountActions = map[string][]string{
+	// The provisioned capacity is checked before the expedited retrievals.
+	"restore": {"ListProvisionedCapacity"},
+}
+
+// vaultARN matches the ARN of a vault, or of several vaults with wildcards.
+var vaultARN = regexp.MustCompile(`^arn:aws[a-z-]*:glacier:[a-z0-9*?-]+:(\d{12}|[*?]+):vaults/[A-Za-z0-9_.*?-]+$`)
+
+// policyStatement is a statement of an IAM policy.
+type policyStatement struct {
+	Sid      string   `json:"Sid"`
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource string   `json:"Resource"`
+}
+
+// policyDocument is an IAM policy.
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+func setupIAMPolicy(flags *flag.FlagSet) func(args []string) error {
+	var commandNames listValue
+	flags.Var(&commandNames, "commands", "the comma separated `list` of the commands to allow (default all)")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return newUsageError(iamPolicyCommand, "expected VAULT_ARN argument, got %d argument(s)", len(args))
+		}
+		if !vaultARN.MatchString(args[0]) {
+			return newUsageError(iamPolicyCommand, "invalid vault ARN %q, expected arn:aws:glacier:REGION:ACCOUNT:vaults/VAULT", args[0])
+		}
+
+		var names []string
+		for _, list := range commandNames {
+			for _, name := range strings.Split(list, ",") {
+				name = strings.TrimSpace(name)
+				if _, ok := commandActions[name]; !ok {
+					return newUsageError(iamPolicyCommand, "unknown command %q, expected one of %s", name, strings.Join(policyCommands(), ", "))
+				}
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			names = policyCommands()
+		}
+
+		return printPolicy(os.Stdout, newPolicy(names, args[0]))
+	}
+}
+
+// policyCommands returns the names of the commands calling Amazon Glacier.
+func policyCommands() []string {
+	names := make([]string, 0, len(commandActions))
+	for name := range commandActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newPolicy creates the policy allowing the commands on the vault.
+func newPolicy(commands []string, vault string) *policyDocument {
+	policy := &policyDocument{Version: "2012-10-17"}
+
+	if actions := glacierActions(commandActions, commands); len(actions) > 0 {
+		policy.Statement = append(policy.Statement, policyStatement{
+			Sid:      "SurgeVault",
+			Effect:   "Allow",
+			Action:   actions,
+			Resource: vault,
+		})
+	}
+	if actions := glacierActions(accountActions, commands); len(actions) > 0 {
+		policy.Statement = append(policy.Statement, policyStatement{
+			Sid:      "SurgeAccount",
+			Effect:   "Allow",
+			Action:   actions,
+			Resource: "*",
+		})
+	}
+
+	return policy
+}
+
+// glacierActions returns the sorted actions of the commands, without duplicates.
+func glacierActions(actions map[string][]string, commands []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+
+	for _, c := range commands {
+		for _, a := range actions[c] {
+			if !seen[a] {
+				seen[a] = true
+				result = append(result, "glacier:"+a)
+			}
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// printPolicy writes the policy as indented JSON.
+func printPolicy(w io.Writer, policy *policyDocument) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(policy); err != nil {
+		return fmt.Errorf("could not print the policy: %v", err)
+	}
+	return nil
+}
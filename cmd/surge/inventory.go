@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/31z4/surge/pkg/catalog"
+	"github.com/31z4/surge/pkg/inventory"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+var (
+	inventoryCommand = &command{
+		name:        "inventory",
+		description: "Work with the Amazon Glacier vault inventory",
+		subcommands: []*command{
+			inventoryDiffCommand,
+		},
+	}
+
+	inventoryDiffCommand = &command{
+		name:    "diff",
+		args:    "VAULT|REMOTE:",
+		summary: "Compare the vault inventory against the local catalog",
+		description: "Compare the latest inventory of the Amazon Glacier vault against the local catalog\n" +
+			"and report the archives unknown to either of them. If there is no inventory\n" +
+			"retrieval job yet, one is initiated and the command should be run again once it\n" +
+			"completes, which takes several hours. The retrieved inventory is cached, see\n" +
+			"-inventory-ttl and -refresh.",
+	}
+)
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	inventoryDiffCommand.setup = setupInventoryDiff
+}
+
+func setupInventoryDiff(flags *flag.FlagSet) func(args []string) error {
+	jobId := flags.String("job-id", "", "the inventory retrieval job ID, the latest succeeded job is used by default")
+	var columns tableOptions
+	columns.register(flags, archiveColumns...)
+	refresh := flags.Bool("refresh", false, "retrieve an inventory newer than the cached one, initiating an inventory retrieval job if there is none")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return newUsageError(inventoryDiffCommand, "expected VAULT argument, got %d argument(s)", len(args))
+		}
+		if err := columns.parse(); err != nil {
+			return newUsageError(inventoryDiffCommand, "%v", err)
+		}
+
+		service, vaultName, err := globals.start("inventory diff", args[0], "")
+		if err != nil {
+			return err
+		}
+
+		c, err := globals.loadCatalog()
+		if err != nil {
+			return err
+		}
+
+		cache, err := globals.inventoryCache(service.Region)
+		if err != nil {
+			return err
+		}
+
+		input := &inventory.Input{
+			AccountId: globals.accountId,
+			VaultName: vaultName,
+			JobId:     *jobId,
+			Cache:     cache,
+			Refresh:   *refresh,
+			Logger:    logger,
+		}
+
+		inv, err := inventory.New(service, input).Retrieve()
+		if err != nil {
+			return err
+		}
+
+		diff := inventory.Compare(inv, c.Vault(service.Region, vaultName))
+		results.update(func(r *result) {
+			r.Diff = diff
+		})
+
+		if globals.output == "text" {
+			return printDiff(os.Stdout, diff, &columns)
+		}
+
+		return nil
+	}
+}
+
+// printDiff writes the difference between the inventory and the catalog,
+// listing the archives in the columns.
+func printDiff(w io.Writer, diff *inventory.Diff, columns *tableOptions) error {
+	fmt.Fprintf(w, "inventory of %s\n", diff.InventoryDate.Format(time.RFC3339))
+
+	if diff.Empty() {
+		fmt.Fprintln(w, "the inventory matches the catalog")
+	}
+
+	if len(diff.RemoteOnly) != 0 {
+		fmt.Fprintf(w, "\n%d archive(s) in the vault unknown to the catalog:\n", len(diff.RemoteOnly))
+		if err := printArchives(w, diff.RemoteOnly, columns); err != nil {
+			return err
+		}
+	}
+
+	if len(diff.LocalOnly) != 0 {
+		fmt.Fprintf(w, "\n%d archive(s) in the catalog missing from the vault:\n", len(diff.LocalOnly))
+		if err := printEntries(w, diff.LocalOnly, columns); err != nil {
+			return err
+		}
+	}
+
+	if len(diff.Pending) != 0 {
+		fmt.Fprintf(w, "\n%d archive(s) uploaded after the inventory was taken:\n", len(diff.Pending))
+		if err := printEntries(w, diff.Pending, columns); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archiveColumns are the columns listing the archives of the inventory and
+// the catalog: the name is the description of an archive in the inventory and
+// the uploaded file of a catalog entry.
+var archiveColumns = []string{"id", "date", "size", "name"}
+
+func printArchives(w io.Writer, archives []inventory.Archive, columns *tableOptions) error {
+	t := newTable(columns, archiveColumns...)
+	t.indent = "  "
+	for _, a := range archives {
+		t.add(a.ArchiveId, a.CreationDate.Format(time.RFC3339), utils.FormatSize(a.Size), a.Description)
+	}
+	return t.write(w)
+}
+
+func printEntries(w io.Writer, entries []*catalog.Entry, columns *tableOptions) error {
+	t := newTable(columns, archiveColumns...)
+	t.indent = "  "
+	for _, e := range entries {
+		t.add(e.ArchiveId, e.UploadedAt.Format(time.RFC3339), utils.FormatSize(e.Size), e.FileName)
+	}
+	return t.write(w)
+}
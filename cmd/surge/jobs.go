@@ -0,0 +1,224 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+var (
+	jobsCommand = &command{
+		name:        "jobs",
+		description: "Work with the Amazon Glacier vault jobs",
+		subcommands: []*command{
+			jobsListCommand,
+		},
+	}
+
+	jobsListCommand = &command{
+		name:    "list",
+		args:    "VAULT|REMOTE:",
+		summary: "List the jobs of the vault",
+		description: "List the jobs of the Amazon Glacier vault, the ones in progress and the ones\n" +
+			"completed recently. The filters are applied to every page of the listing, since a\n" +
+			"vault may accumulate thousands of jobs.",
+	}
+)
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	jobsListCommand.setup = setupJobsList
+}
+
+// jobStatuses are the status codes of a job by their normalized names.
+var jobStatuses = map[string]string{
+	"inprogress": "InProgress",
+	"succeeded":  "Succeeded",
+	"failed":     "Failed",
+}
+
+// jobActions are the actions of a job by their normalized names.
+var jobActions = map[string]string{
+	"archiveretrieval":   "ArchiveRetrieval",
+	"inventoryretrieval": "InventoryRetrieval",
+	"select":             "Select",
+}
+
+// lookupName returns the name matching s regardless of the case and the hyphens,
+// e.g. in-progress for InProgress.
+func lookupName(names map[string]string, s string) (string, bool) {
+	name, ok := names[strings.ToLower(strings.Replace(s, "-", "", -1))]
+	return name, ok
+}
+
+// jobFilter selects the listed jobs.
+type jobFilter struct {
+	status    string    // the status code, empty for any
+	action    string    // the action, empty for any
+	since     time.Time // the jobs created before are left out
+	completed bool      // only the completed jobs, succeeded or failed
+}
+
+// match reports whether the job is selected by the filter.
+func (f *jobFilter) match(job *glacier.DescribeJobOutput) bool {
+	if f.status != "" && string(job.StatusCode) != f.status {
+		return false
+	}
+	if f.action != "" && string(job.Action) != f.action {
+		return false
+	}
+	if f.completed && !aws.BoolValue(job.Completed) {
+		return false
+	}
+	if !f.since.IsZero() {
+		created, err := time.Parse(time.RFC3339, aws.StringValue(job.CreationDate))
+		if err != nil || created.Before(f.since) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSince parses a time given as a date, an RFC 3339 time or a duration
+// before now.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a date such as 2018-05-05, an RFC 3339 time or a duration such as 36h", s)
+}
+
+// jobInfo describes a job in the command result.
+type jobInfo struct {
+	JobId          string `json:"job_id"`
+	Action         string `json:"action"`
+	Status         string `json:"status"`
+	StatusMessage  string `json:"status_message,omitempty"`
+	ArchiveId      string `json:"archive_id,omitempty"`
+	Size           int64  `json:"size,omitempty"`
+	ByteRange      string `json:"byte_range,omitempty"`
+	Tier           string `json:"tier,omitempty"`
+	CreationDate   string `json:"creation_date,omitempty"`
+	CompletionDate string `json:"completion_date,omitempty"`
+}
+
+func newJobInfo(job *glacier.DescribeJobOutput) jobInfo {
+	return jobInfo{
+		JobId:          aws.StringValue(job.JobId),
+		Action:         string(job.Action),
+		Status:         string(job.StatusCode),
+		StatusMessage:  aws.StringValue(job.StatusMessage),
+		ArchiveId:      aws.StringValue(job.ArchiveId),
+		Size:           aws.Int64Value(job.ArchiveSizeInBytes),
+		ByteRange:      aws.StringValue(job.RetrievalByteRange),
+		Tier:           aws.StringValue(job.Tier),
+		CreationDate:   aws.StringValue(job.CreationDate),
+		CompletionDate: aws.StringValue(job.CompletionDate),
+	}
+}
+
+func setupJobsList(flags *flag.FlagSet) func(args []string) error {
+	status := flags.String("status", "", "list only the jobs with the `status`, either InProgress, Succeeded or Failed")
+	action := flags.String("action", "", "list only the jobs of the `action`, either ArchiveRetrieval, InventoryRetrieval or Select")
+	since := flags.String("since", "", "list only the jobs created since the `time`, a date such as 2018-05-05, an RFC 3339 time or a duration such as 36h")
+	completed := flags.Bool("completed", false, "list only the completed jobs, succeeded or failed")
+	var columns tableOptions
+	columns.register(flags, "id", "action", "status", "created", "completed", "archive", "size")
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return newUsageError(jobsListCommand, "expected VAULT argument, got %d argument(s)", len(args))
+		}
+
+		filter := &jobFilter{completed: *completed}
+		if *status != "" {
+			var ok bool
+			if filter.status, ok = lookupName(jobStatuses, *status); !ok {
+				return newUsageError(jobsListCommand, "unknown job status %q, expected InProgress, Succeeded or Failed", *status)
+			}
+		}
+		if *action != "" {
+			var ok bool
+			if filter.action, ok = lookupName(jobActions, *action); !ok {
+				return newUsageError(jobsListCommand, "unknown job action %q, expected ArchiveRetrieval, InventoryRetrieval or Select", *action)
+			}
+		}
+		if *since != "" {
+			var err error
+			if filter.since, err = parseSince(*since, time.Now()); err != nil {
+				return newUsageError(jobsListCommand, "%v", err)
+			}
+		}
+		if err := columns.parse(); err != nil {
+			return newUsageError(jobsListCommand, "%v", err)
+		}
+
+		service, vaultName, err := globals.start("jobs list", args[0], "")
+		if err != nil {
+			return err
+		}
+
+		request := service.ListJobsRequest(&glacier.ListJobsInput{
+			AccountId: &globals.accountId,
+			VaultName: &vaultName,
+		})
+		pager := request.Paginate()
+
+		jobs := []jobInfo{}
+		total := 0
+		for pager.Next() {
+			for _, job := range pager.CurrentPage().JobList {
+				job := job
+				total++
+				if filter.match(&job) {
+					jobs = append(jobs, newJobInfo(&job))
+				}
+			}
+		}
+		if err := pager.Err(); err != nil {
+			return err
+		}
+
+		logger.Log(&events.Event{
+			Type:      events.Completed,
+			Message:   fmt.Sprintf("listed %d job(s) of %d in the vault", len(jobs), total),
+			Operation: "jobs list",
+		})
+		results.update(func(r *result) {
+			r.Jobs = jobs
+		})
+
+		if globals.output == "text" {
+			return printJobs(os.Stdout, jobs, &columns)
+		}
+
+		return nil
+	}
+}
+
+// printJobs writes the listed jobs in the columns.
+func printJobs(w io.Writer, jobs []jobInfo, columns *tableOptions) error {
+	t := newTable(columns, "id", "action", "status", "created", "completed", "archive", "size")
+	for _, j := range jobs {
+		var size string
+		if j.ArchiveId != "" {
+			size = utils.FormatSize(j.Size)
+		}
+		t.add(j.JobId, j.Action, j.Status, j.CreationDate, j.CompletionDate, j.ArchiveId, size)
+	}
+	return t.write(w)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+func TestJobFilter(t *testing.T) {
+	running := &glacier.DescribeJobOutput{
+		Action:       glacier.ActionCode("InventoryRetrieval"),
+		StatusCode:   glacier.StatusCode("InProgress"),
+		Completed:    aws.Bool(false),
+		CreationDate: aws.String("2018-05-05T10:00:00.000Z"),
+	}
+	done := &glacier.DescribeJobOutput{
+		Action:       glacier.ActionCode("ArchiveRetrieval"),
+		StatusCode:   glacier.StatusCode("Succeeded"),
+		Completed:    aws.Bool(true),
+		CreationDate: aws.String("2018-05-04T10:00:00.000Z"),
+	}
+
+	cases := []struct {
+		name    string
+		filter  jobFilter
+		running bool
+		done    bool
+	}{
+		{"none", jobFilter{}, true, true},
+		{"status", jobFilter{status: "Succeeded"}, false, true},
+		{"action", jobFilter{action: "InventoryRetrieval"}, true, false},
+		{"completed", jobFilter{completed: true}, false, true},
+		{"since", jobFilter{since: time.Date(2018, 5, 5, 0, 0, 0, 0, time.UTC)}, true, false},
+		{"combined", jobFilter{status: "InProgress", completed: true}, false, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.filter.match(running); got != c.running {
+				t.Fatalf("got %v, want %v for the running job", got, c.running)
+			}
+			if got := c.filter.match(done); got != c.done {
+				t.Fatalf("got %v, want %v for the done job", got, c.done)
+			}
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2018, 5, 5, 12, 0, 0, 0, time.UTC)
+
+	cases := map[string]time.Time{
+		"36h":                  time.Date(2018, 5, 4, 0, 0, 0, 0, time.UTC),
+		"2018-05-01T08:00:00Z": time.Date(2018, 5, 1, 8, 0, 0, 0, time.UTC),
+		"2018-05-01":           time.Date(2018, 5, 1, 0, 0, 0, 0, time.Local),
+	}
+	for s, want := range cases {
+		got, err := parseSince(s, now)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %#v", s, err)
+		}
+		if !got.Equal(want) {
+			t.Fatalf("%s: got %v, want %v", s, got, want)
+		}
+	}
+
+	if _, err := parseSince("yesterday", now); err == nil {
+		t.Fatal("got nil, want error")
+	}
+}
+
+func TestLookupName(t *testing.T) {
+	for _, s := range []string{"InProgress", "in-progress", "INPROGRESS"} {
+		if got, ok := lookupName(jobStatuses, s); !ok || got != "InProgress" {
+			t.Fatalf("%s: got %q, %v, want %q", s, got, ok, "InProgress")
+		}
+	}
+	if _, ok := lookupName(jobStatuses, "done"); ok {
+		t.Fatal("got ok for an unknown status")
+	}
+}
@@ -1,146 +1,566 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 
-	"github.com/31z4/surge/pkg/downloader"
-	"github.com/31z4/surge/pkg/uploader"
-	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/31z4/surge/pkg/awsconfig"
+	"github.com/31z4/surge/pkg/catalog"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/inventory"
+	"github.com/31z4/surge/pkg/keychain"
+	"github.com/31z4/surge/pkg/remotes"
+	"github.com/31z4/surge/pkg/sealed"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/glacier"
 )
 
-func main() {
-	flag.Usage = func() {
-		const (
-			usage = "Usage: surge [options] <command>\n\n" +
-				"Amazon Glacier multipart download and upload\n\n" +
-				"Options:\n"
-			commands = "\nCommands:\n" +
-				"  download   Download a retrieved archive\n" +
-				"  upload     Upload an archive to the existing vault\n"
-		)
+// logger outputs the events of the command, including the errors.
+var logger events.Logger = events.TextLogger{}
+
+// globalOptions are the options accepted by every command.
+type globalOptions struct {
+	config           string
+	catalog          string
+	profile          string
+	region           string
+	endpointURL      string
+	roleARN          string
+	externalId       string
+	accountId        string
+	partSize         partSizeValue
+	maxRate          rateValue
+	maxMemory        sizeValue
+	partOrder        orderValue
+	dropCache        bool
+	jobs             int
+	onFailure        failurePolicyValue
+	slowFactor       float64
+	logFormat        string
+	output           string
+	verbosity        events.Level
+	progressInterval time.Duration
+	inventoryTTL     time.Duration
+	noColor          bool
+	redact           bool
+	debugAWS         bool
+	yes              bool
+	tune             bool
+
+	// The names of the global options given on the command line.
+	given map[string]bool
+
+	logFile        string
+	logFileSize    sizeValue
+	logFileBackups int
+
+	auditFile    string
+	awsAuditFile string
+	statusFile   string
+
+	preCmd  string
+	postCmd string
+
+	passphraseFile     string
+	passphraseEnv      string
+	passphraseKeychain string
+	passphrasePrompt   bool
+
+	// The passphrase once it is read, so that it is prompted for only once.
+	passphraseRead []byte
+
+	cpuProfile string
+	memProfile string
+	pprofAddr  string
+}
+
+// redactor masks the IDs in the logs if -redact is given.
+var redactor *events.Redactor
+
+// remote is the remote the command target refers to, if any.
+var remote *remotes.Remote
+
+// globals holds the global options of the command line.
+var globals globalOptions
+
+func (o *globalOptions) register(flags *flag.FlagSet) {
+	flags.StringVar(&o.config, "config", "", "the config file defining the remotes (default ~/.surge/config)")
+	flags.StringVar(&o.catalog, "catalog", "", "the local catalog of the uploaded archives (default ~/.surge/catalog.json)")
+	flags.DurationVar(&o.inventoryTTL, "inventory-ttl", inventory.DefaultCacheTTL, "use the vault inventory cached in ~/.surge/inventory for the `duration` once it is retrieved, 0 disables the cache")
+	flags.StringVar(&o.passphraseFile, "passphrase-file", "", "encrypt the local catalog with the passphrase read from the `file`")
+	flags.StringVar(&o.passphraseEnv, "passphrase-env", "", "encrypt the local catalog with the passphrase of the environment `variable` (default SURGE_PASSPHRASE)")
+	flags.StringVar(&o.passphraseKeychain, "passphrase-keychain", "", "encrypt the local catalog with the passphrase of the `account` of the surge service in the OS keychain")
+	flags.BoolVar(&o.passphrasePrompt, "passphrase-prompt", false, "encrypt the local catalog with the passphrase prompted for on the terminal")
+	flags.StringVar(&o.profile, "profile", "", "use a specific AWS profile")
+	flags.StringVar(&o.region, "region", "", "the AWS region to use, overrides the profile region")
+	flags.StringVar(&o.endpointURL, "endpoint-url", "", "override the Amazon Glacier endpoint URL, e.g. to use an emulator")
+	flags.StringVar(&o.roleARN, "role-arn", "", "assume the role with the `ARN`, e.g. in the account that owns the vault")
+	flags.StringVar(&o.externalId, "external-id", "", "the external `ID` required to assume the role")
+	flags.StringVar(&o.accountId, "account-id", "-", "the AWS account ID of the account that owns the vault")
+	o.partSize.sizeValue = 1 << 20
+	flags.Var(&o.partSize, "part-size", "the `size` of each part except the last, e.g. 16MiB; automatic for downloads unless given")
+	flags.Var(&o.maxRate, "max-rate", "limit the transfer `rate`, e.g. 20MB/s")
+	flags.Var(&o.maxMemory, "max-memory", "buffer at most the `size` of part data in memory, transferring fewer parts at once if needed, e.g. 512MiB")
+	flags.Var(&o.partOrder, "part-order", "transfer the parts in the `order`, either sequential, largest-remaining-first or random")
+	flags.BoolVar(&o.dropCache, "drop-cache", false, "drop the transferred file from the page cache, only on Linux")
+	flags.BoolVar(&o.tune, "auto-tune", false, "choose the jobs, the memory limit and the upload part size from the CPUs, the available memory and the round trip to Amazon Glacier, unless given")
+	flags.IntVar(&o.jobs, "jobs", runtime.GOMAXPROCS(0), "the maximum number of the parallel jobs")
+	flags.Var(&o.onFailure, "on-failure", "the `policy` for a part that fails, either retry-then-skip, retry-then-fail or fail-fast")
+	flags.Var(&failFastValue{policy: &o.onFailure}, "fail-fast", "shorthand for -on-failure fail-fast")
+	flags.Float64Var(&o.slowFactor, "slow-part-factor", 3, "warn about a part taking more than the `factor` times the median of the recent parts, 0 disables it")
+	flags.StringVar(&o.logFormat, "log-format", "text", "the log format, either text or json")
+	flags.StringVar(&o.output, "output", "text", "the format of the command result, either text or json")
+
+	flags.BoolVar(&o.yes, "yes", false, "do not ask for confirmation of destructive operations")
+	flags.BoolVar(&o.yes, "y", false, "shorthand for -yes")
+	flags.BoolVar(&o.noColor, "no-color", false, "disable the colored output, also disabled by the NO_COLOR environment variable")
+	flags.BoolVar(&o.redact, "redact", false, "mask the upload, job and account IDs in the logs, e.g. shipped to a shared log system")
+
+	o.logFileSize = 100 << 20
+	flags.StringVar(&o.logFile, "log-file", "", "also log the progress of every part to the `file`")
+	flags.Var(&o.logFileSize, "log-file-size", "rotate the log file when it grows over the `size`")
+	flags.IntVar(&o.logFileBackups, "log-file-backups", 5, "the number of the rotated log files to keep")
+	flags.DurationVar(&o.progressInterval, "progress-interval", time.Minute, "log the progress at the `interval` instead of every part when the output is not a terminal, 0 disables it")
+	flags.StringVar(&o.auditFile, "audit-file", "", "append the outcome of every part to the `file` as JSON")
+	flags.StringVar(&o.awsAuditFile, "aws-audit", "", "append a record of every AWS API call to the `file` as JSON")
+	flags.StringVar(&o.statusFile, "status-file", "", "rewrite the `file` every second with the progress of the transfer as JSON, e.g. for a dashboard")
+	flags.StringVar(&o.preCmd, "pre-cmd", "", "run the `command` by the shell before a transfer, which is not attempted if the command fails")
+	flags.StringVar(&o.postCmd, "post-cmd", "", "run the `command` by the shell after a transfer, with its outcome in the SURGE_STATUS environment variable")
+
+	o.verbosity = events.LevelNormal
+	quiet := &levelValue{level: &o.verbosity, value: events.LevelQuiet}
+	verbose := &levelValue{level: &o.verbosity, value: events.LevelVerbose}
+	flags.Var(quiet, "quiet", "output only the errors and the final result")
+	flags.Var(quiet, "q", "shorthand for -quiet")
+	flags.Var(verbose, "verbose", "output the progress of every part")
+	flags.Var(verbose, "v", "shorthand for -verbose")
+	flags.Var(&levelValue{level: &o.verbosity, value: events.LevelDebug}, "vv", "output the progress of every part and the AWS requests")
+	flags.BoolVar(&o.debugAWS, "debug-aws", false, "output the AWS requests and responses without their signatures, and the retry decisions, at any verbosity")
+
+	flags.StringVar(&o.cpuProfile, "cpuprofile", "", "write a CPU profile to the `file`")
+	flags.StringVar(&o.memProfile, "memprofile", "", "write a memory profile to the `file` on exit")
+	flags.StringVar(&o.pprofAddr, "pprof-addr", "", "serve the runtime profiles over HTTP at the `address`, e.g. localhost:6060")
+}
+
+// applyRemote sets the options not given on the command line from the remote.
+func (o *globalOptions) applyRemote(remote *remotes.Remote) {
+	if o.profile == "" {
+		o.profile = remote.Profile
+	}
+	if o.region == "" {
+		o.region = remote.Region
+	}
+	if o.endpointURL == "" {
+		o.endpointURL = remote.EndpointURL
+	}
+	if o.roleARN == "" {
+		o.roleARN = remote.RoleARN
+	}
+	if o.externalId == "" {
+		o.externalId = remote.ExternalId
+	}
+	if o.accountId == "-" && remote.AccountId != "" {
+		o.accountId = remote.AccountId
+	}
+	if o.preCmd == "" {
+		o.preCmd = remote.PreCmd
+	}
+	if o.postCmd == "" {
+		o.postCmd = remote.PostCmd
+	}
+}
+
+// isTerminal reports whether the file is a terminal.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
 
-		fmt.Fprint(flag.CommandLine.Output(), usage)
-		flag.PrintDefaults()
-		fmt.Fprint(flag.CommandLine.Output(), commands)
+	// The null device is a character device as well.
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
 
-		os.Exit(2)
+// color reports whether the output to the standard error is colored.
+func (o *globalOptions) color() bool {
+	if o.noColor {
+		return false
 	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isTerminal(os.Stderr)
+}
 
-	uploadCommand := flag.NewFlagSet("upload", flag.ExitOnError)
-	uploadCommand.Usage = func() {
-		const usage = "Usage: surge upload [options] VAULT FILE\n\n" +
-			"Upload the file to the existing Amazon Glacier vault\n\n" +
-			"Options:\n"
+// newLogger creates a logger writing the events in the log format to w.
+func (o *globalOptions) newLogger(w io.Writer, color bool) (events.Logger, error) {
+	switch o.logFormat {
+	case "text":
+		return events.TextLogger{Logger: log.New(w, "", log.LstdFlags), Color: color}, nil
+	case "json":
+		return events.NewJSONLogger(w), nil
+	}
+	return nil, withCode(exitUsage, fmt.Errorf("unknown log format %q", o.logFormat))
+}
 
-		fmt.Fprint(flag.CommandLine.Output(), usage)
-		uploadCommand.PrintDefaults()
+// setupLogger sets the logger up to output the events at the verbosity level
+// to the standard error. If there is a log file, it also receives the progress
+// of every part regardless of the level, and so does the audit file.
+func (o *globalOptions) setupLogger() error {
+	console, err := o.newLogger(os.Stderr, o.color())
+	if err != nil {
+		return err
+	}
 
-		os.Exit(2)
+	// A verbose terminal shows the status of every job below the log.
+	if o.verbosity >= events.LevelVerbose && o.logFormat == "text" && isTerminal(os.Stderr) {
+		display := newWorkerDisplay(os.Stderr, terminalWidth())
+		display.redrawEvery(time.Second)
+
+		console, _ = o.newLogger(display, o.color())
+		console = events.Tee(display, console)
+	} else if o.periodicProgress() {
+		console = partFilter{next: console}
+	}
+	logger = events.NewLevelFilter(console, o.verbosity)
+	if o.debugAWS {
+		logger = debugFilter{debug: console, next: logger}
 	}
 
-	downloadCommand := flag.NewFlagSet("download", flag.ExitOnError)
-	downloadCommand.Usage = func() {
-		const usage = "Usage: surge download [options] VAULT FILE\n\n" +
-			"Download an archive retrieved from the Amazon Glacier vault\n\n" +
-			"Options:\n"
+	if o.auditFile != "" {
+		file, err := events.OpenAuditFile(o.auditFile)
+		if err != nil {
+			return err
+		}
+		logger = events.Tee(logger, events.NewAuditLogger(file))
+	}
 
-		fmt.Fprint(flag.CommandLine.Output(), usage)
-		downloadCommand.PrintDefaults()
+	if o.logFile == "" {
+		return nil
+	}
 
-		os.Exit(2)
+	file, err := events.OpenRotatingFile(o.logFile, int64(o.logFileSize), o.logFileBackups)
+	if err != nil {
+		return err
 	}
 
-	profile := flag.String("profile", "", "use a specific AWS profile")
-	accountId := flag.String("account-id", "-", "the AWS account ID of the account that owns the vault")
-	partSize := flag.Int64("part-size", 1048576, "the size of each part except the last, in bytes")
-	jobs := flag.Int("jobs", runtime.GOMAXPROCS(0), "the maximum number of the parallel jobs")
+	fileLogger, _ := o.newLogger(file, false)
 
-	uploadId := uploadCommand.String("upload-id", "", "the upload ID of the multipart upload")
+	level := o.verbosity
+	if level < events.LevelVerbose {
+		level = events.LevelVerbose
+	}
+	var fileFilter events.Logger = events.NewLevelFilter(fileLogger, level)
+	if o.debugAWS {
+		fileFilter = debugFilter{debug: fileLogger, next: fileFilter}
+	}
+	logger = events.Tee(logger, fileFilter)
 
-	jobId := downloadCommand.String("job-id", "", "the job ID whose data is downloaded (required)")
+	return nil
+}
 
-	flag.Parse()
-	args := flag.Args()
+// periodicProgress reports whether the progress is logged at an interval
+// rather than for every part, which keeps the logs of a long transfer
+// redirected to a file or a log collector readable.
+func (o *globalOptions) periodicProgress() bool {
+	return o.progressInterval > 0 && o.logFormat == "text" && !isTerminal(os.Stderr)
+}
 
-	if len(args) == 0 {
-		flag.Usage()
+// redactLogs makes the logger mask the IDs in all the logs, but not in the
+// command result, which scripts read the upload ID to resume from.
+func (o *globalOptions) redactLogs() {
+	if o.redact {
+		redactor = events.NewRedactor(logger)
+		logger = redactor
 	}
+}
 
-	switch args[0] {
-	case "download":
-		downloadCommand.Parse(args[1:])
-	case "upload":
-		uploadCommand.Parse(args[1:])
-	default:
-		flag.Usage()
+// catalogPath returns the path of the local catalog.
+func (o *globalOptions) catalogPath() string {
+	if o.catalog != "" {
+		return o.catalog
 	}
+	return catalog.DefaultPath()
+}
 
-	if downloadCommand.Parsed() {
-		if *jobId == "" {
-			downloadCommand.Usage()
-		}
+// passphraseEnvVar is the environment variable holding the passphrase
+// if no other source of the passphrase is given.
+const passphraseEnvVar = "SURGE_PASSPHRASE"
 
-		args = downloadCommand.Args()
-		if len(args) != 2 {
-			downloadCommand.Usage()
-		}
+// keychainService is the service of the secrets of surge in the OS keychain.
+const keychainService = "surge"
+
+// passphrase returns the passphrase encrypting the local files.
+// An empty passphrase disables the encryption.
+//
+// At most one source of the passphrase may be given: a file, an environment
+// variable, the OS keychain or the prompt. Without any, the passphrase is read
+// from SURGE_PASSPHRASE. A source that is given must yield a passphrase, so
+// that the encryption is never disabled by mistake.
+func (o *globalOptions) passphrase() ([]byte, error) {
+	if o.passphraseRead != nil {
+		return o.passphraseRead, nil
+	}
+
+	var given []string
+	if o.passphraseFile != "" {
+		given = append(given, "-passphrase-file")
+	}
+	if o.passphraseEnv != "" {
+		given = append(given, "-passphrase-env")
+	}
+	if o.passphraseKeychain != "" {
+		given = append(given, "-passphrase-keychain")
+	}
+	if o.passphrasePrompt {
+		given = append(given, "-passphrase-prompt")
+	}
+	if len(given) > 1 {
+		return nil, withCode(exitUsage, fmt.Errorf("%s cannot be used together", strings.Join(given, " and ")))
 	}
 
-	if uploadCommand.Parsed() {
-		args = uploadCommand.Args()
-		if len(args) != 2 {
-			uploadCommand.Usage()
+	var passphrase []byte
+	switch {
+	case o.passphraseFile != "":
+		data, err := ioutil.ReadFile(o.passphraseFile)
+		if err != nil {
+			return nil, withCode(exitUsage, err)
 		}
+		passphrase = bytes.TrimRight(data, "\r\n")
+	case o.passphraseEnv != "":
+		passphrase = []byte(os.Getenv(o.passphraseEnv))
+	case o.passphraseKeychain != "":
+		var err error
+		passphrase, err = keychain.Lookup(keychainService, o.passphraseKeychain)
+		if err != nil {
+			return nil, withCode(exitUsage, fmt.Errorf("could not read the passphrase of %s from the keychain: %v", o.passphraseKeychain, err))
+		}
+	case o.passphrasePrompt:
+		var err error
+		passphrase, err = promptSecret("Catalog passphrase")
+		if err != nil {
+			return nil, err
+		}
+	default:
+		passphrase = []byte(os.Getenv(passphraseEnvVar))
+	}
+
+	if len(passphrase) == 0 && len(given) > 0 {
+		return nil, withCode(exitUsage, fmt.Errorf("the passphrase given by %s is empty", given[0]))
 	}
 
-	vaultName, fileName := args[0], args[1]
+	o.passphraseRead = passphrase
+	return passphrase, nil
+}
 
-	var configs external.Configs
-	if *profile != "" {
-		configs = append(configs, external.WithSharedConfigProfile(*profile))
+// loadCatalog reads the local catalog, decrypting it if necessary.
+func (o *globalOptions) loadCatalog() (*catalog.Catalog, error) {
+	passphrase, err := o.passphrase()
+	if err != nil {
+		return nil, err
 	}
 
-	config, err := external.LoadDefaultAWSConfig(configs...)
+	c, err := catalog.Load(o.catalogPath(), passphrase)
+	switch err {
+	case sealed.ErrPassphraseRequired:
+		return nil, withCode(exitUsage, fmt.Errorf("the catalog %s is encrypted, set %s or pass one of the -passphrase options", o.catalogPath(), passphraseEnvVar))
+	case sealed.ErrDecrypt:
+		return nil, withCode(exitUsage, fmt.Errorf("could not decrypt the catalog %s, the passphrase is wrong or the file is corrupted", o.catalogPath()))
+	}
+	return c, err
+}
+
+// inventoryCache returns the cache of the vault inventories of the region, or
+// nil if it is disabled. The inventories are encrypted like the catalog.
+func (o *globalOptions) inventoryCache(region string) (*inventory.Cache, error) {
+	if o.inventoryTTL <= 0 {
+		return nil, nil
+	}
+
+	passphrase, err := o.passphrase()
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, err
 	}
 
-	service := glacier.New(config)
+	return &inventory.Cache{
+		Dir:        inventory.DefaultCacheDir(),
+		Region:     region,
+		TTL:        o.inventoryTTL,
+		Passphrase: passphrase,
+	}, nil
+}
+
+// saveCatalog writes the local catalog, encrypting it if there is a passphrase.
+func (o *globalOptions) saveCatalog(c *catalog.Catalog) error {
+	passphrase, err := o.passphrase()
+	if err != nil {
+		return err
+	}
+	return c.Save(o.catalogPath(), passphrase)
+}
+
+// start sets up the output of the command and creates the Glacier service
+// according to the options. The target is either a vault name or a remote,
+// the name of the vault it refers to is returned.
+func (o *globalOptions) start(command, target, fileName string) (*glacier.Glacier, string, error) {
+	configFile := o.config
+	if configFile == "" {
+		configFile = remotes.DefaultConfigFile()
+	}
+
+	vaultName := target
+	var remoteErr error
+	remote, remoteErr = remotes.Resolve(configFile, target)
+	if remote != nil {
+		vaultName = remote.VaultName
+	}
 
-	if uploadCommand.Parsed() {
-		input := &uploader.Input{
-			AccountId: *accountId,
-			PartSize:  *partSize,
-			VaultName: vaultName,
-			FileName:  fileName,
-			UploadId:  *uploadId,
+	if err := o.setupLogger(); err != nil {
+		return nil, "", err
+	}
+	o.redactLogs()
+
+	if o.output != "text" && o.output != "json" {
+		return nil, "", withCode(exitUsage, fmt.Errorf("unknown output format %q", o.output))
+	}
+	results = newResultCollector(logger, command, vaultName, fileName)
+	progress = newProgressTracker(results)
+	logger = progress
+
+	if remoteErr != nil {
+		return nil, "", withCode(exitUsage, remoteErr)
+	}
+	if remote != nil {
+		o.applyRemote(remote)
+	}
+
+	accountId, err := utils.NormalizeAccountId(o.accountId)
+	if err != nil {
+		return nil, "", withCode(exitUsage, err)
+	}
+	o.accountId = accountId
+	if redactor != nil {
+		redactor.Add(accountId)
+	}
+
+	// Prompt for the passphrase before the transfer rather than when the
+	// catalog is updated once it is done.
+	if o.passphrasePrompt {
+		if _, err := o.passphrase(); err != nil {
+			return nil, "", err
 		}
+	}
 
-		u := uploader.New(service, input)
+	exitOnInterrupt()
+	logProgressOnSignal()
+	if o.periodicProgress() && o.verbosity >= events.LevelNormal {
+		logProgressEvery(o.progressInterval)
+	}
+	o.startStatusFile(command)
 
-		if err := u.Upload(*jobs); err != nil {
-			log.Fatal(err.Error())
+	options := &awsconfig.Options{
+		Profile:     o.profile,
+		Region:      o.region,
+		EndpointURL: o.endpointURL,
+		RoleARN:     o.roleARN,
+		ExternalID:  o.externalId,
+		Connections: o.jobs,
+		UserAgent:   userAgent(command, o.jobs),
+	}
+	if o.awsAuditFile != "" {
+		file, err := events.OpenAuditFile(o.awsAuditFile)
+		if err != nil {
+			return nil, "", err
 		}
+		options.Audit = file
+		if o.redact {
+			options.AuditMask = events.Mask
+		}
+	}
+	if o.verbosity >= events.LevelDebug || o.debugAWS {
+		options.LogLevel = aws.LogDebugWithRequestRetries | aws.LogDebugWithRequestErrors
+		options.Logger = aws.LoggerFunc(logDebug)
 	}
 
-	if downloadCommand.Parsed() {
-		input := &downloader.Input{
-			AccountId: *accountId,
-			PartSize:  *partSize,
-			VaultName: vaultName,
-			FileName:  fileName,
-			JobId:     *jobId,
+	config, err := awsconfig.Load(options)
+	if err != nil {
+		return nil, "", withCode(exitAuth, err)
+	}
+
+	if err := o.runPreHook(command); err != nil {
+		return nil, "", err
+	}
+
+	service := glacier.New(config)
+	if o.tune && transferCommands[command] && o.autoTune(service, vaultName) {
+		config.HTTPClient = awsconfig.NewHTTPClient(o.jobs)
+		awsconfig.SetUserAgent(&config, userAgent(command, o.jobs))
+		service = glacier.New(config)
+	}
+
+	return service, vaultName, nil
+}
+
+// recordGiven records the global options among the flags given on the command line.
+func (o *globalOptions) recordGiven(global, flags *flag.FlagSet) {
+	flags.Visit(func(f *flag.Flag) {
+		if global.Lookup(f.Name) == nil {
+			return
+		}
+		if o.given == nil {
+			o.given = make(map[string]bool)
 		}
+		o.given[f.Name] = true
+	})
+}
+
+// logDebug outputs an SDK log message as a debug event.
+func logDebug(args ...interface{}) {
+	logger.Log(&events.Event{
+		Type:    events.Debug,
+		Message: events.RedactSignatures(fmt.Sprint(args...)),
+	})
+}
 
-		d := downloader.New(service, input)
+// debugFilter passes the debug events to the debug logger whatever the
+// verbosity, and the other events to the next logger.
+type debugFilter struct {
+	debug events.Logger
+	next  events.Logger
+}
+
+// Log passes the event to the logger of its type.
+func (f debugFilter) Log(e *events.Event) {
+	if e.Type == events.Debug {
+		f.debug.Log(e)
+	} else {
+		f.next.Log(e)
+	}
+}
 
-		if err := d.Download(*jobs); err != nil {
-			log.Fatal(err.Error())
+func main() {
+	global := newGlobalFlagSet()
+
+	if err := runCommandLine(global, os.Args[1:]); err != nil {
+		if err, ok := err.(*usageError); ok {
+			printUsageError(os.Stderr, err, global)
+			os.Exit(exitUsage)
 		}
+		fatal(err)
 	}
+
+	finishStatusFile(exitOK, nil)
+	runPostHook(exitOK, nil)
+	printResult(exitOK, nil)
+	cleanup()
 }
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/split"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// mainEnvVar makes the test binary run surge instead of the tests.
+const mainEnvVar = "SURGE_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mainEnvVar) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// normalizers replace the parts of the output that vary between the runs.
+var normalizers = []struct {
+	pattern *regexp.Regexp
+	replace string
+}{
+	{regexp.MustCompile(`\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} `), "YYYY/MM/DD hh:mm:ss "},
+	{regexp.MustCompile(`"time":"[^"]*"`), `"time":"TIME"`},
+	{regexp.MustCompile(`("(?:uploaded|updated)_at": ?)"[^"]*"`), `${1}"TIME"`},
+	{regexp.MustCompile(`("elapsed_seconds": ?)[0-9.e-]+`), "${1}ELAPSED"},
+	{regexp.MustCompile(`in [0-9.]+(ns|µs|ms|s|m[0-9.]+s) \([0-9.]+ [KMGT]?i?B/s\)`), "in DURATION (RATE)"},
+	{regexp.MustCompile(`peak [0-9.]+ [KMGT]?i?B/s`), "peak RATE"},
+	{regexp.MustCompile(`("(?:peak_)?bytes_per_second": ?)[0-9]+`), "${1}RATE"},
+	{regexp.MustCompile(`part latency p50 [^,]+, p90 [^,]+, p99 [^,]+, slowest [0-9]+-[0-9]+ \([^)]*\)(, [0-9]+-[0-9]+ \([^)]*\))*`), "part latency LATENCY"},
+	{regexp.MustCompile(`("latency": ?)\{[^\]]*\]\s*\}`), "${1}LATENCY"},
+	{regexp.MustCompile(`http://127\.0\.0\.1:[0-9]+`), "ENDPOINT"},
+}
+
+func normalize(output []byte) []byte {
+	for _, n := range normalizers {
+		output = n.pattern.ReplaceAll(output, []byte(n.replace))
+	}
+	return output
+}
+
+// runSurge runs surge with the arguments in the directory and returns
+// its standard output, standard error and the exit code.
+func runSurge(t *testing.T, dir string, args ...string) ([]byte, []byte, int) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		mainEnvVar+"=1",
+		"HOME="+dir,
+		"AWS_ACCESS_KEY_ID=test",
+		"AWS_SECRET_ACCESS_KEY=test",
+		"AWS_REGION=us-east-1",
+		"AWS_CONFIG_FILE="+filepath.Join(dir, "aws-config"),
+		"AWS_SHARED_CREDENTIALS_FILE="+filepath.Join(dir, "aws-credentials"),
+		"NO_COLOR=1",
+		// The default of -jobs depends on the number of CPUs.
+		"GOMAXPROCS=8",
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	code := 0
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		code = exitErr.ExitCode()
+	}
+
+	return normalize(stdout.Bytes()), normalize(stderr.Bytes()), code
+}
+
+// checkGolden compares the output to the golden file, or updates the file
+// if the -update flag is given.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file, rerun with -update if the change is intended\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	const size = 3 << 20
+
+	backend := httptest.NewServer(newBenchBackend(1<<20, size))
+	defer backend.Close()
+
+	cases := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"usage", []string{"-h"}, exitOK},
+		{"upload-usage", []string{"help", "upload"}, exitOK},
+		{"unknown-command", []string{"test"}, exitUsage},
+		{"version", []string{"version"}, exitOK},
+		{"iam-policy", []string{"iam-policy", "-commands", "upload,restore", "arn:aws:glacier:us-east-1:111111111111:vaults/my-vault"}, exitOK},
+		{"iam-policy-unknown", []string{"iam-policy", "-commands", "bench", "arn:aws:glacier:us-east-1:111111111111:vaults/my-vault"}, exitUsage},
+		{"jobs-list", []string{"jobs", "list", "vault"}, exitOK},
+		{"jobs-list-json", []string{"-output", "json", "jobs", "list", "-action", "archive-retrieval", "vault"}, exitOK},
+		{"jobs-list-filtered", []string{"jobs", "list", "-status", "in-progress", "vault"}, exitOK},
+		{"jobs-list-columns", []string{"jobs", "list", "-no-header", "-columns", "status,id", "vault"}, exitOK},
+		{"jobs-list-unknown-column", []string{"jobs", "list", "-columns", "id,tier", "vault"}, exitUsage},
+		{"jobs-list-unknown-status", []string{"jobs", "list", "-status", "done", "vault"}, exitUsage},
+		{"upload", []string{"upload", "vault", "archive"}, exitOK},
+		{"upload-verbose", []string{"-v", "upload", "vault", "archive"}, exitOK},
+		{"upload-verbose-parts", []string{"-v", "-progress-interval", "0", "upload", "-multipart-threshold", "0", "vault", "archive"}, exitOK},
+		{"upload-json", []string{"-output", "json", "upload", "vault", "archive"}, exitOK},
+		{"upload-redact", []string{"-redact", "-output", "json", "upload", "-multipart-threshold", "0", "vault", "archive"}, exitOK},
+		{"upload-json-log", []string{"-log-format", "json", "upload", "vault", "archive"}, exitOK},
+		{"upload-checksum-file", []string{"upload", "-checksum-file", "vault", "archive"}, exitOK},
+		{"upload-audit", []string{"-audit-file", "audit.log", "upload", "-multipart-threshold", "0", "vault", "archive"}, exitOK},
+		{"upload-exec", []string{"upload", "-exec", "head -c 3145728 /dev/zero", "vault", "archive.bin"}, exitOK},
+		{"upload-exec-failure", []string{"upload", "-exec", "printf test; exit 3", "vault", "archive.bin"}, exitError},
+		{"upload-stdin-empty", []string{"upload", "vault", "-"}, exitError},
+		{"upload-empty", []string{"upload", "vault", "empty"}, exitError},
+		{"upload-sparse", []string{"upload", "vault", "sparse"}, exitOK},
+		{"upload-compress-sparse", []string{"-output", "json", "upload", "-compress-sparse", "vault", "sparse"}, exitOK},
+		{"upload-hooks", []string{"-output", "json", "-pre-cmd", "echo pre $SURGE_COMMAND $SURGE_FILE", "-post-cmd", "echo post $SURGE_STATUS $SURGE_EXIT_CODE $SURGE_ARCHIVE_ID", "upload", "vault", "archive"}, exitOK},
+		{"upload-pre-cmd-failure", []string{"-pre-cmd", "exit 3", "-post-cmd", "echo post", "upload", "vault", "archive"}, exitError},
+		{"upload-post-cmd-failure", []string{"-post-cmd", "echo post $SURGE_STATUS $SURGE_EXIT_CODE; exit 1", "upload", "vault", "empty"}, exitError},
+		{"upload-description-invalid", []string{"upload", "-description", "{{.Vault}}", "vault", "archive"}, exitUsage},
+		{"upload-threshold-too-large", []string{"upload", "-multipart-threshold", "5GiB", "vault", "archive"}, exitUsage},
+		{"upload-passphrase-prompt", []string{"-passphrase-prompt", "upload", "vault", "archive"}, exitUsage},
+		{"upload-passphrase-conflict", []string{"-passphrase-env", "SURGE_TEST_PASSPHRASE", "-passphrase-file", "passphrase", "-passphrase-prompt", "upload", "vault", "archive"}, exitUsage},
+		{"upload-passphrase-env-empty", []string{"-passphrase-env", "SURGE_TEST_PASSPHRASE", "upload", "vault", "archive"}, exitUsage},
+		{"upload-unknown-id", []string{"-output", "json", "upload", "-upload-id", "unknown", "vault", "archive"}, exitError},
+		{"upload-verify-remote", []string{"upload", "-verify-remote", "-upload-id", "bench", "vault", "archive"}, exitOK},
+		{"upload-verify-remote-json", []string{"-output", "json", "upload", "-verify-remote", "-upload-id", "bench", "vault", "archive"}, exitOK},
+		{"upload-verify-remote-no-id", []string{"upload", "-verify-remote", "vault", "archive"}, exitUsage},
+		{"upload-max-memory", []string{"-jobs", "4", "-max-memory", "2MiB", "upload", "-multipart-threshold", "0", "vault", "archive"}, exitOK},
+		{"upload-max-memory-too-small", []string{"-max-memory", "512KiB", "upload", "-multipart-threshold", "0", "vault", "archive"}, exitError},
+		{"upload-status-file", []string{"-status-file", "status.json", "upload", "-multipart-threshold", "0", "vault", "archive"}, exitOK},
+		{"upload-status-file-failure", []string{"-status-file", "status.json", "upload", "vault", "empty"}, exitError},
+		{"upload-restore-info", []string{"upload", "-description", "test archive", "-restore-info", "restore", "vault", "archive"}, exitOK},
+		{"gc-empty-catalog", []string{"gc", "vault"}, exitError},
+		{"wait", []string{"wait", "vault", "bench"}, exitOK},
+		{"wait-json", []string{"-output", "json", "wait", "-timeout", "1h", "vault", "bench"}, exitOK},
+		{"wait-negative-timeout", []string{"wait", "-timeout", "-1s", "vault", "bench"}, exitUsage},
+		{"download", []string{"download", "-job-id", "bench", "vault", "downloaded"}, exitOK},
+		{"download-json", []string{"-output", "json", "download", "-job-id", "bench", "vault", "downloaded"}, exitOK},
+		{"restore", []string{"restore", "vault", "manifest.csv"}, exitOK},
+		{"restore-exists", []string{"restore", "vault", "manifest-exists.csv"}, exitError},
+		{"restore-index", []string{"restore", "vault", "manifest-index.csv"}, exitOK},
+		{"download-index", []string{"download", "-index", "archive.surge-index", "-job-id", "bench", "vault", "reassembled"}, exitOK},
+		{"restore-usage", []string{"help", "restore"}, exitOK},
+		{"restore-downgrade", []string{"restore", "-downgrade", "vault", "manifest.csv"}, exitUsage},
+		{"download-s3-write-buffer", []string{"download", "-job-id", "bench", "-write-buffer", "8MiB", "vault", "s3://bucket/key"}, exitUsage},
+		{"download-s3-quarantine", []string{"download", "-job-id", "bench", "-quarantine", "vault", "s3://bucket/key"}, exitUsage},
+		{"download-s3-gpg", []string{"download", "-job-id", "bench", "-gpg-decrypt", "vault", "s3://bucket/key"}, exitUsage},
+		{"upload-s3-gpg", []string{"upload", "-gpg-recipient", "test", "vault", "s3://bucket/key"}, exitUsage},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "surge")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+
+			if err := ioutil.WriteFile(filepath.Join(dir, "archive"), make([]byte, size), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "empty"), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+			// A file of a single hole, which allocates no block.
+			if err := ioutil.WriteFile(filepath.Join(dir, "sparse"), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(filepath.Join(dir, "sparse"), size); err != nil {
+				t.Fatal(err)
+			}
+			manifests := map[string]string{
+				"manifest.csv":        "# archive ID, file\nbench,restored\n",
+				"manifest-exists.csv": "bench,archive\n",
+				"manifest-index.csv":  "archive.surge-index,reassembled\n",
+			}
+			// The index of the archive uploaded as a single segment.
+			index := split.New("archive", size, size)
+			index.Segments[0].ArchiveId = "bench"
+			index.Segments[0].TreeHash = *utils.TreeHashBytes(make([]byte, size))
+			index.TreeHash = index.Segments[0].TreeHash
+			if err := index.Write(filepath.Join(dir, "archive"+split.IndexSuffix)); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, manifest := range manifests {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(manifest), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			args := append([]string{"-endpoint-url", backend.URL, "-jobs", "1"}, test.args...)
+			stdout, stderr, code := runSurge(t, dir, args...)
+			if code != test.code {
+				t.Errorf("got exit code %d, want %d\n%s", code, test.code, stderr)
+			}
+
+			checkGolden(t, test.name+".stdout", stdout)
+			checkGolden(t, test.name+".stderr", stderr)
+
+			if checksums, err := ioutil.ReadFile(filepath.Join(dir, "archive"+checksumFileSuffix)); err == nil {
+				checkGolden(t, test.name+checksumFileSuffix, checksums)
+			}
+			if status, err := ioutil.ReadFile(filepath.Join(dir, "status.json")); err == nil {
+				checkGolden(t, test.name+".status", normalize(status))
+			}
+			if audit, err := ioutil.ReadFile(filepath.Join(dir, "audit.log")); err == nil {
+				checkGolden(t, test.name+".audit", normalize(audit))
+			}
+			if info, err := ioutil.ReadFile(filepath.Join(dir, "restore", "bench.json")); err == nil {
+				checkGolden(t, test.name+".restore", normalize(bytes.Replace(info, []byte(dir), []byte("DIR"), -1)))
+			}
+		})
+	}
+}
+
+func TestDebugFilter(t *testing.T) {
+	var debug, next []events.Type
+	filter := debugFilter{
+		debug: loggerFunc(func(e *events.Event) { debug = append(debug, e.Type) }),
+		next:  loggerFunc(func(e *events.Event) { next = append(next, e.Type) }),
+	}
+
+	for _, eventType := range []events.Type{events.Initiated, events.Debug, events.PartStarted, events.Debug} {
+		filter.Log(&events.Event{Type: eventType})
+	}
+
+	if want := []events.Type{events.Debug, events.Debug}; !reflect.DeepEqual(debug, want) {
+		t.Fatalf("got %#v, want %#v", debug, want)
+	}
+	if want := []events.Type{events.Initiated, events.PartStarted}; !reflect.DeepEqual(next, want) {
+		t.Fatalf("got %#v, want %#v", next, want)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/inventory"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+// result is the final result of a command printed with -output json.
+type result struct {
+	Command string `json:"command"`
+	Vault   string `json:"vault"`
+	File    string `json:"file,omitempty"`
+
+	UploadId  string `json:"upload_id,omitempty"`
+	JobId     string `json:"job_id,omitempty"`
+	ArchiveId string `json:"archive_id,omitempty"`
+	Location  string `json:"location,omitempty"`
+	Index     string `json:"index,omitempty"`
+	Checksum  string `json:"checksum,omitempty"`
+	Verified  *bool  `json:"verified,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+
+	Diff    *inventory.Diff `json:"diff,omitempty"`
+	Deleted []string        `json:"deleted,omitempty"`
+	Jobs    []jobInfo       `json:"jobs,omitempty"`
+	Ranges  []rangeStatus   `json:"ranges,omitempty"`
+
+	Parts   int64   `json:"parts"`
+	Bytes   int64   `json:"bytes"`
+	Elapsed float64 `json:"elapsed_seconds"`
+
+	Retries  int64 `json:"retries,omitempty"`
+	PeakRate int64 `json:"peak_bytes_per_second,omitempty"`
+
+	Latency *utils.LatencySummary `json:"latency,omitempty"`
+
+	// The command line resuming the transfer that failed or was interrupted.
+	Resume string `json:"resume_command,omitempty"`
+
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// results collects the command result once the command has started.
+var results *resultCollector
+
+// resultCollector fills the result from the events passing through it to the next logger.
+type resultCollector struct {
+	next events.Logger
+
+	mutex  sync.Mutex
+	result result
+}
+
+func newResultCollector(next events.Logger, command, vault, file string) *resultCollector {
+	return &resultCollector{
+		next: next,
+		result: result{
+			Command: command,
+			Vault:   vault,
+			File:    file,
+		},
+	}
+}
+
+// Log records the result fields carried by the event.
+func (c *resultCollector) Log(e *events.Event) {
+	c.mutex.Lock()
+	r := &c.result
+
+	switch e.Type {
+	case events.Initiated, events.Completed:
+		if e.UploadId != "" {
+			r.UploadId = e.UploadId
+		}
+		if e.ArchiveId != "" {
+			r.ArchiveId = e.ArchiveId
+		}
+		if e.Location != "" {
+			r.Location = e.Location
+		}
+		if e.Checksum != "" {
+			r.Checksum = e.Checksum
+		}
+	case events.Verification:
+		r.Verified = e.Verified
+		if e.Checksum != "" {
+			r.Checksum = e.Checksum
+		}
+	case events.Summary:
+		r.Parts = e.Parts
+		r.Bytes = e.Bytes
+		r.Elapsed = e.Elapsed
+		r.Retries = e.Retries
+		r.PeakRate = e.PeakRate
+		r.Latency = e.Latency
+	}
+	if e.JobId != "" {
+		r.JobId = e.JobId
+	}
+
+	c.mutex.Unlock()
+
+	c.next.Log(e)
+}
+
+// update modifies the result with fn.
+func (c *resultCollector) update(fn func(r *result)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	fn(&c.result)
+}
+
+// get returns a copy of the result.
+func (c *resultCollector) get() result {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.result
+}
+
+// printResult prints the result of the command if it is requested with -output json.
+func printResult(code int, err error) {
+	if results != nil && globals.output == "json" {
+		results.print(os.Stdout, code, err)
+	}
+}
+
+// print writes the result as a single JSON document.
+func (c *resultCollector) print(w io.Writer, code int, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.result.ExitCode = code
+	if err != nil {
+		c.result.Error = err.Error()
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(&c.result)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // registers the profiling handlers
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+
+	"github.com/31z4/surge/pkg/events"
+)
+
+// startProfiling starts the profiling requested by the options. The profiles
+// are written by cleanup, which is called whenever the process exits.
+func (o *globalOptions) startProfiling() error {
+	var stops []func()
+
+	if o.cpuProfile != "" {
+		file, err := os.Create(o.cpuProfile)
+		if err != nil {
+			return err
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return err
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			file.Close()
+		})
+	}
+
+	if o.memProfile != "" {
+		file, err := os.Create(o.memProfile)
+		if err != nil {
+			return err
+		}
+		stops = append(stops, func() {
+			runtime.GC()
+			pprof.WriteHeapProfile(file)
+			file.Close()
+		})
+	}
+
+	if o.pprofAddr != "" {
+		listener, err := net.Listen("tcp", o.pprofAddr)
+		if err != nil {
+			return err
+		}
+		go http.Serve(listener, nil)
+
+		logger.Log(&events.Event{
+			Type:    events.Debug,
+			Message: fmt.Sprintf("serving the profiles at http://%v/debug/pprof/", listener.Addr()),
+		})
+	}
+
+	if len(stops) == 0 {
+		return nil
+	}
+
+	// Write the profiles of an interrupted command as well.
+	exitOnInterrupt()
+
+	var once sync.Once
+	cleanup = func() {
+		once.Do(func() {
+			for _, stop := range stops {
+				stop()
+			}
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+// progress tracks the transfer state from the events passing through it.
+var progress *progressTracker
+
+// progressTracker counts the parts and the bytes of the transfer from the
+// events passing through it to the next logger.
+type progressTracker struct {
+	next events.Logger
+
+	mutex sync.Mutex
+	start time.Time
+
+	// The time the first part started, the throughput is measured from, so
+	// that checking the parts of a resumed transfer doesn't slow it down.
+	transferStart time.Time
+
+	// The totals to transfer, known once the transfer is initiated
+	// unless the size of the archive is unknown.
+	totalParts int64
+	totalBytes int64
+
+	started  bool
+	active   bool
+	done     int64
+	inFlight int64
+	failed   int64
+	retries  int64
+
+	// The bytes transferred since the start, and the bytes of the parts
+	// found already uploaded, which count as done but not for the throughput.
+	transferred int64
+	skipped     int64
+
+	// The ranges of the parts in flight by their offsets.
+	ranges map[int64]utils.Range
+}
+
+func newProgressTracker(next events.Logger) *progressTracker {
+	return &progressTracker{next: next, start: time.Now(), ranges: make(map[int64]utils.Range)}
+}
+
+// Log counts the event and passes it to the next logger.
+func (p *progressTracker) Log(e *events.Event) {
+	p.mutex.Lock()
+
+	switch e.Type {
+	case events.Initiated:
+		// The totals add up if several archives are transferred.
+		if !p.started {
+			p.started = true
+			p.start = time.Now()
+		}
+		p.totalParts += e.Parts
+		p.totalBytes += e.Bytes
+		p.active = true
+	case events.Summary:
+		p.active = false
+	case events.PartStarted:
+		p.inFlight++
+		if p.transferStart.IsZero() {
+			p.transferStart = time.Now()
+		}
+		if e.Range != nil {
+			p.ranges[e.Range.Offset] = *e.Range
+		}
+	case events.PartFinished:
+		p.inFlight--
+		p.done++
+		if e.Range != nil {
+			p.transferred += e.Range.Limit
+			delete(p.ranges, e.Range.Offset)
+		}
+	case events.PartFailed:
+		p.inFlight--
+		p.failed++
+		if e.Range != nil {
+			delete(p.ranges, e.Range.Offset)
+		}
+	case events.PartVerified:
+		p.done++
+		if e.Range != nil {
+			p.skipped += e.Range.Limit
+		}
+	case events.Retry:
+		p.retries++
+	}
+
+	p.mutex.Unlock()
+
+	p.next.Log(e)
+}
+
+// transferring reports whether a transfer is initiated and not summarized yet.
+func (p *progressTracker) transferring() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.active
+}
+
+// snapshot returns the event describing the current state of the transfer.
+func (p *progressTracker) snapshot() *events.Event {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.started && p.inFlight == 0 && p.done == 0 {
+		return &events.Event{
+			Type:    events.Progress,
+			Message: "progress: no transfer in progress",
+		}
+	}
+
+	elapsed := time.Since(p.start)
+	message := fmt.Sprintf("progress: %d part(s) done, %d in flight", p.done, p.inFlight)
+
+	if p.totalParts > 0 {
+		remaining := p.totalParts - p.done - p.inFlight - p.failed
+		if remaining < 0 {
+			remaining = 0
+		}
+		message += fmt.Sprintf(", %d remaining", remaining)
+	} else {
+		message += ", remaining unknown"
+	}
+	if p.failed > 0 {
+		message += fmt.Sprintf(", %d failed", p.failed)
+	}
+
+	done := p.transferred + p.skipped
+	if p.totalBytes > 0 {
+		message += fmt.Sprintf("; %s of %s (%d%%", utils.FormatSize(done), utils.FormatSize(p.totalBytes), done*100/p.totalBytes)
+		if p.skipped > 0 {
+			message += fmt.Sprintf(", %s resumed", utils.FormatSize(p.skipped))
+		}
+		message += ")"
+	} else {
+		message += "; " + utils.FormatSize(done)
+	}
+
+	var transferElapsed time.Duration
+	if !p.transferStart.IsZero() {
+		transferElapsed = time.Since(p.transferStart)
+	}
+	message += fmt.Sprintf(" at %s", utils.FormatRate(p.transferred, transferElapsed.Seconds()))
+
+	if p.totalBytes > 0 {
+		message += ", ETA " + eta(p.totalBytes-done, p.transferred, transferElapsed)
+	}
+	message += fmt.Sprintf("; %d retries", p.retries)
+
+	return &events.Event{
+		Type:    events.Progress,
+		Message: message,
+		Parts:   p.done,
+		Bytes:   done,
+		Elapsed: elapsed.Seconds(),
+	}
+}
+
+// completion returns the event describing the parts and the bytes done out
+// of the totals, or nil if no transfer was initiated.
+func (p *progressTracker) completion() *events.Event {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.started {
+		return nil
+	}
+
+	done := p.transferred + p.skipped
+	message := fmt.Sprintf("%d part(s) done", p.done)
+	if p.totalParts > 0 {
+		remaining := p.totalParts - p.done
+		if remaining < 0 {
+			remaining = 0
+		}
+		message = fmt.Sprintf("%d of %d part(s) done, %d remaining", p.done, p.totalParts, remaining)
+	}
+	if p.totalBytes > 0 {
+		message += fmt.Sprintf(", %s of %s (%d%%)", utils.FormatSize(done), utils.FormatSize(p.totalBytes), done*100/p.totalBytes)
+	} else {
+		message += ", " + utils.FormatSize(done)
+	}
+
+	return &events.Event{
+		Message: message,
+		Parts:   p.done,
+		Bytes:   done,
+		Elapsed: time.Since(p.start).Seconds(),
+	}
+}
+
+// bar returns a bar of the width filled by the share of the bytes done,
+// followed by the progress snapshot.
+func (p *progressTracker) bar(width int) string {
+	snapshot := p.snapshot()
+
+	p.mutex.Lock()
+	total := p.totalBytes
+	p.mutex.Unlock()
+
+	filled := 0
+	if total > 0 {
+		filled = int(snapshot.Bytes * int64(width) / total)
+		if filled > width {
+			filled = width
+		}
+	}
+
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "] " +
+		strings.TrimPrefix(snapshot.Message, "progress: ")
+}
+
+// eta estimates the time to transfer the remaining bytes at the rate of
+// transferring the bytes in the elapsed time.
+func eta(remaining, transferred int64, elapsed time.Duration) string {
+	if remaining <= 0 {
+		return "0s"
+	}
+	if transferred <= 0 {
+		return "unknown"
+	}
+
+	seconds := float64(remaining) * elapsed.Seconds() / float64(transferred)
+	return (time.Duration(seconds * float64(time.Second))).Round(time.Second).String()
+}
+
+var progressOnce sync.Once
+
+// logProgressOnSignal makes the process log a progress snapshot whenever it
+// receives one of the progress signals, without exiting. Calling it more than
+// once has no effect.
+func logProgressOnSignal() {
+	if len(progressSignals) == 0 {
+		return
+	}
+
+	progressOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, progressSignals...)
+
+		go func() {
+			for range signals {
+				progress.next.Log(progress.snapshot())
+			}
+		}()
+	})
+}
+
+// logProgressEvery makes the process log a progress snapshot at the interval
+// while a transfer is in progress.
+func logProgressEvery(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if progress.transferring() {
+				progress.next.Log(progress.snapshot())
+			}
+		}
+	}()
+}
+
+// partFilter drops the events of the parts started and finished, which the
+// periodic progress snapshots sum up, and passes the rest to the next logger.
+type partFilter struct {
+	next events.Logger
+}
+
+// Log passes the event to the next logger unless it is a part started or finished.
+func (f partFilter) Log(e *events.Event) {
+	switch e.Type {
+	case events.PartStarted, events.PartFinished, events.PartVerified:
+		return
+	}
+	f.next.Log(e)
+}
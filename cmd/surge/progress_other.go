@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package main
+
+import "os"
+
+// progressSignals make the process log a progress snapshot. There is no
+// SIGQUIT on this platform.
+var progressSignals []os.Signal
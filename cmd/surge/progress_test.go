@@ -0,0 +1,173 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+func TestProgressTracker(t *testing.T) {
+	t.Run("no transfer", func(t *testing.T) {
+		p := newProgressTracker(events.Discard)
+
+		want := "progress: no transfer in progress"
+		if got := p.snapshot().Message; got != want {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("counts", func(t *testing.T) {
+		p := newProgressTracker(events.Discard)
+
+		part := func(offset int64) *utils.Range {
+			return &utils.Range{Offset: offset, Limit: 1 << 20}
+		}
+
+		for _, e := range []*events.Event{
+			{Type: events.Initiated, Parts: 5, Bytes: 5 << 20},
+			{Type: events.PartVerified, Range: part(0)},
+			{Type: events.PartStarted, Range: part(1 << 20)},
+			{Type: events.PartStarted, Range: part(2 << 20)},
+			{Type: events.PartStarted, Range: part(3 << 20)},
+			{Type: events.Retry, Range: part(1 << 20)},
+			{Type: events.PartFinished, Range: part(1 << 20)},
+			{Type: events.PartFailed, Range: part(2 << 20)},
+		} {
+			p.Log(e)
+		}
+
+		got := p.snapshot()
+		if got.Type != events.Progress || got.Parts != 2 || got.Bytes != 2<<20 {
+			t.Fatalf("got %#v", got)
+		}
+
+		prefix := "progress: 2 part(s) done, 1 in flight, 1 remaining, 1 failed; 2 MiB of 5 MiB (40%, 1 MiB resumed) at "
+		if !strings.HasPrefix(got.Message, prefix) || !strings.HasSuffix(got.Message, "; 1 retries") {
+			t.Fatalf("got %#v, want %#v...", got.Message, prefix)
+		}
+	})
+
+	t.Run("transferring", func(t *testing.T) {
+		p := newProgressTracker(events.Discard)
+
+		for _, test := range []struct {
+			event *events.Event
+			want  bool
+		}{
+			{&events.Event{Type: events.CheckStarted}, false},
+			{&events.Event{Type: events.Initiated}, true},
+			{&events.Event{Type: events.PartFinished}, true},
+			{&events.Event{Type: events.Summary}, false},
+		} {
+			p.Log(test.event)
+			if got := p.transferring(); got != test.want {
+				t.Fatalf("got %#v after %s, want %#v", got, test.event.Type, test.want)
+			}
+		}
+	})
+
+	t.Run("resumed", func(t *testing.T) {
+		p := newProgressTracker(events.Discard)
+
+		p.Log(&events.Event{Type: events.Initiated, Parts: 10, Bytes: 10 << 20})
+		for i := int64(0); i < 8; i++ {
+			p.Log(&events.Event{Type: events.PartVerified, Range: &utils.Range{Offset: i << 20, Limit: 1 << 20}})
+		}
+
+		want := "progress: 8 part(s) done, 0 in flight, 2 remaining; 8 MiB of 10 MiB (80%, 8 MiB resumed) at - B/s, ETA unknown; 0 retries"
+		if got := p.snapshot().Message; got != want {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+
+		p.Log(&events.Event{Type: events.PartStarted, Range: &utils.Range{Offset: 8 << 20, Limit: 1 << 20}})
+		p.Log(&events.Event{Type: events.PartFinished, Range: &utils.Range{Offset: 8 << 20, Limit: 1 << 20}})
+
+		// Checking the resumed parts took an hour, which doesn't count
+		// for the throughput of the parts transferred since.
+		p.start = time.Now().Add(-time.Hour)
+		p.transferStart = time.Now().Add(-10 * time.Second)
+
+		if got := p.snapshot().Message; !strings.Contains(got, "(90%, 8 MiB resumed) at 102.4 KiB/s, ETA 10s;") {
+			t.Fatalf("got %#v, want the rate and the ETA of the parts transferred since the check", got)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		p := newProgressTracker(events.Discard)
+
+		part := func(offset int64) *utils.Range {
+			return &utils.Range{Offset: offset, Limit: 1 << 20}
+		}
+
+		for _, e := range []*events.Event{
+			{Type: events.Initiated, Parts: 4, Bytes: 4 << 20},
+			{Type: events.PartStarted, Range: part(2 << 20)},
+			{Type: events.PartStarted, Range: part(0)},
+			{Type: events.PartStarted, Range: part(1 << 20)},
+			{Type: events.PartFinished, Range: part(0)},
+		} {
+			p.Log(e)
+		}
+
+		now := time.Now()
+		p.transferStart = now.Add(-10 * time.Second)
+
+		got := p.status(result{Command: "upload", Vault: "vault"}, now)
+		if got.State != statusRunning || got.Parts != 1 || got.PartsInFlight != 2 || got.Bytes != 1<<20 || got.Rate != 104857 {
+			t.Fatalf("got %#v", got)
+		}
+		if want := []utils.Range{*part(1 << 20), *part(2 << 20)}; !reflect.DeepEqual(got.Ranges, want) {
+			t.Fatalf("got %#v, want %#v", got.Ranges, want)
+		}
+		if got.ETA == nil || *got.ETA != 30 {
+			t.Fatalf("got %#v, want an ETA of 30 seconds", got.ETA)
+		}
+	})
+
+	t.Run("unknown size", func(t *testing.T) {
+		p := newProgressTracker(events.Discard)
+
+		p.Log(&events.Event{Type: events.Initiated})
+		p.Log(&events.Event{Type: events.PartStarted})
+
+		prefix := "progress: 0 part(s) done, 1 in flight, remaining unknown; 0 B at "
+		if got := p.snapshot().Message; !strings.HasPrefix(got, prefix) || strings.Contains(got, "ETA") {
+			t.Fatalf("got %#v, want %#v...", got, prefix)
+		}
+	})
+}
+
+func TestPartFilter(t *testing.T) {
+	var passed []events.Type
+	filter := partFilter{next: loggerFunc(func(e *events.Event) {
+		passed = append(passed, e.Type)
+	})}
+
+	for _, eventType := range []events.Type{
+		events.Initiated,
+		events.PartStarted,
+		events.Retry,
+		events.PartFinished,
+		events.PartVerified,
+		events.PartFailed,
+		events.Summary,
+	} {
+		filter.Log(&events.Event{Type: eventType})
+	}
+
+	want := []events.Type{events.Initiated, events.Retry, events.PartFailed, events.Summary}
+	if !reflect.DeepEqual(passed, want) {
+		t.Fatalf("got %#v, want %#v", passed, want)
+	}
+}
+
+// loggerFunc is a logger calling the function with every event.
+type loggerFunc func(e *events.Event)
+
+func (r loggerFunc) Log(e *events.Event) {
+	r(e)
+}
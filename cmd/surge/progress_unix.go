@@ -0,0 +1,11 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// progressSignals make the process log a progress snapshot.
+var progressSignals = []os.Signal{syscall.SIGQUIT}
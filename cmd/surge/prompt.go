@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// promptSecret asks for a secret on the terminal without echoing it. It fails
+// if the standard input is not a terminal to ask on.
+func promptSecret(prompt string) ([]byte, error) {
+	if !isTerminal(os.Stdin) {
+		return nil, withCode(exitUsage, fmt.Errorf("%s: a terminal is required to prompt for it", prompt))
+	}
+
+	if err := setEcho(os.Stdin, false); err != nil {
+		return nil, err
+	}
+
+	// Turn the echo back on if the process exits while prompting.
+	restore := cleanup
+	cleanup = func() {
+		setEcho(os.Stdin, true)
+		restore()
+	}
+	defer func() {
+		setEcho(os.Stdin, true)
+		cleanup = restore
+	}()
+
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	// The newline typed is not echoed either.
+	fmt.Fprintln(os.Stderr)
+	if err != nil && line == "" {
+		return nil, errors.New("no input")
+	}
+
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
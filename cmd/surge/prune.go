@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/31z4/surge/pkg/catalog"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/retention"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+var pruneCommand = &command{
+	name:    "prune",
+	args:    "VAULT|REMOTE:",
+	summary: "Delete the archives expired by the retention rules",
+	description: "Delete the archives of the Amazon Glacier vault expired by the retention rules,\n" +
+		"once confirmed. The rules are defined by the keep_last and keep_monthly keys of\n" +
+		"the remote, or by the options, and apply to the successive uploads of every file\n" +
+		"in the local catalog.",
+}
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	pruneCommand.setup = setupPrune
+}
+
+func setupPrune(flags *flag.FlagSet) func(args []string) error {
+	keepLast := flags.Int("keep-last", 0, "keep the `n` latest uploads of every file, overrides the remote rules")
+	keepMonthly := flags.Int("keep-monthly", 0, "keep the last upload of every file for the `n` latest months, overrides the remote rules")
+	dryRun := flags.Bool("dry-run", false, "only list the archives that would be deleted")
+	var columns tableOptions
+	columns.register(flags, archiveColumns...)
+
+	return func(args []string) error {
+		if len(args) != 1 {
+			return newUsageError(pruneCommand, "expected VAULT argument, got %d argument(s)", len(args))
+		}
+
+		policy := retention.Policy{KeepLast: *keepLast, KeepMonthly: *keepMonthly}
+		if policy.KeepLast < 0 || policy.KeepMonthly < 0 {
+			return newUsageError(pruneCommand, "the number of the archives to keep must not be negative")
+		}
+		if err := columns.parse(); err != nil {
+			return newUsageError(pruneCommand, "%v", err)
+		}
+
+		service, vaultName, err := globals.start(pruneCommand.name, args[0], "")
+		if err != nil {
+			return err
+		}
+
+		if policy.Empty() && remote != nil {
+			policy = retention.Policy{KeepLast: remote.KeepLast, KeepMonthly: remote.KeepMonthly}
+		}
+		if policy.Empty() {
+			return newUsageError(pruneCommand, "no retention rules, pass -keep-last or -keep-monthly or define them in the remote")
+		}
+
+		c, err := globals.loadCatalog()
+		if err != nil {
+			return err
+		}
+
+		keep, expired := policy.Apply(c.Vault(service.Region, vaultName))
+
+		if globals.output == "text" {
+			if err := printPrune(os.Stdout, keep, expired, &columns); err != nil {
+				return err
+			}
+		}
+		if len(expired) == 0 || *dryRun {
+			return nil
+		}
+
+		var size int64
+		for _, e := range expired {
+			size += e.Size
+		}
+
+		prompt := fmt.Sprintf("permanently delete %d expired archive(s) of %s from vault %s",
+			len(expired), utils.FormatSize(size), vaultName)
+		if err := confirm(prompt); err != nil {
+			return err
+		}
+
+		for _, e := range expired {
+			archiveId := e.ArchiveId
+			_, err = service.DeleteArchiveRequest(&glacier.DeleteArchiveInput{
+				AccountId: &globals.accountId,
+				ArchiveId: &archiveId,
+				VaultName: &vaultName,
+			}).Send()
+			if err != nil {
+				break
+			}
+
+			c.Remove(service.Region, vaultName, archiveId)
+
+			logger.Log(&events.Event{
+				Type:      events.Completed,
+				Message:   fmt.Sprint("archive ", archiveId, " deleted"),
+				Operation: pruneCommand.name,
+				ArchiveId: archiveId,
+			})
+
+			results.update(func(r *result) {
+				r.Deleted = append(r.Deleted, archiveId)
+			})
+		}
+
+		// The deleted archives are forgotten even if some deletion failed.
+		if saveErr := globals.saveCatalog(c); err == nil {
+			err = saveErr
+		}
+
+		return err
+	}
+}
+
+// printPrune writes the archives kept and expired by the retention rules.
+func printPrune(w io.Writer, keep, expired []*catalog.Entry, columns *tableOptions) error {
+	fmt.Fprintf(w, "%d archive(s) kept by the retention rules\n", len(keep))
+
+	if len(expired) == 0 {
+		fmt.Fprintln(w, "no archive expired")
+		return nil
+	}
+
+	fmt.Fprintf(w, "\n%d archive(s) expired:\n", len(expired))
+	return printEntries(w, expired, columns)
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/31z4/surge/pkg/downloader"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/retrieval"
+	"github.com/31z4/surge/pkg/s3object"
+	"github.com/31z4/surge/pkg/split"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+var restoreCommand = &command{
+	name:    "restore",
+	args:    "VAULT|REMOTE: MANIFEST",
+	summary: "Retrieve and download the archives listed in a manifest",
+	description: "Retrieve the archives listed in the manifest from the Amazon Glacier vault and download\n" +
+		"them. Every line of the CSV manifest is an archive ID and the FILE to download it to.\n" +
+		"The index of a file split into several archives, FILE.surge-index, may replace the\n" +
+		"archive ID to reassemble the file from them.\n" +
+		"The retrieval jobs are initiated, unless some already retrieve the archives, and every\n" +
+		"archive is downloaded as soon as its job succeeds.",
+}
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	restoreCommand.setup = setupRestore
+}
+
+func setupRestore(flags *flag.FlagSet) func(args []string) error {
+	force := flags.Bool("force", false, "overwrite the files that exist, after confirmation")
+	tier := flags.String("tier", "Standard", "the retrieval option, either Expedited, Standard or Bulk")
+	pollInterval := flags.Duration("poll-interval", 15*time.Minute, "the `interval` of checking the retrieval jobs")
+	parallel := flags.Int("parallel", 2, "the maximum number of the archives downloaded at the same time")
+	downgrade := flags.Bool("downgrade", false, "retrieve with the Standard tier the archives whose Expedited retrieval is rejected")
+
+	return func(args []string) error {
+		if len(args) != 2 {
+			return newUsageError(restoreCommand, "expected VAULT and MANIFEST arguments, got %d argument(s)", len(args))
+		}
+		target, manifest := args[0], args[1]
+
+		if !validTier(*tier) {
+			return newUsageError(restoreCommand, "unknown retrieval tier %q", *tier)
+		}
+		if *parallel < 1 {
+			return newUsageError(restoreCommand, "-parallel must be at least 1")
+		}
+		if *downgrade && *tier != "Expedited" {
+			return newUsageError(restoreCommand, "-downgrade only applies to the Expedited tier")
+		}
+
+		service, vaultName, err := globals.start(restoreCommand.name, target, manifest)
+		if err != nil {
+			return err
+		}
+
+		targets, err := readManifest(manifest)
+		if err != nil {
+			return err
+		}
+
+		// Checked before the retrieval is paid for.
+		for _, t := range targets {
+			if err := t.prepare(service.Config, *force); err != nil {
+				return err
+			}
+		}
+
+		r := &restorer{
+			targets:   targets,
+			parallel:  make(chan struct{}, *parallel),
+			overwrite: *force,
+			logger:    &downloadTotals{next: logger},
+		}
+
+		input := &retrieval.Input{
+			AccountId:    globals.accountId,
+			VaultName:    vaultName,
+			ArchiveIds:   r.archiveIds(),
+			Tier:         *tier,
+			Downgrade:    *downgrade,
+			PollInterval: *pollInterval,
+			Logger:       logger,
+		}
+		retriever := retrieval.New(service, input)
+
+		if *tier == "Expedited" {
+			checkCapacity(retriever, *downgrade)
+		}
+
+		ready := make(chan *retrieval.Job)
+		retrieved := make(chan error, 1)
+		go func() {
+			retrieved <- retriever.Retrieve(ready)
+		}()
+
+		for job := range ready {
+			for _, t := range targets {
+				if t.archiveId == job.ArchiveId {
+					r.restore(service, vaultName, t, job)
+				} else if s := t.segment(job.ArchiveId); s != nil {
+					r.restoreSegment(service, vaultName, t, s, job)
+				}
+			}
+		}
+		r.wg.Wait()
+
+		if err := <-retrieved; err != nil {
+			return err
+		}
+		return r.summarize()
+	}
+}
+
+func validTier(tier string) bool {
+	for _, t := range retrieval.Tiers {
+		if t == tier {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCapacity warns that the Expedited retrievals can be rejected if the
+// account has no provisioned capacity. The check itself failing is only
+// warned about, since listing the capacity might not be permitted.
+func checkCapacity(retriever *retrieval.Retriever, downgrade bool) {
+	units, err := retriever.ProvisionedCapacity()
+
+	var message string
+	switch {
+	case err != nil:
+		message = fmt.Sprint("could not check the provisioned capacity: ", err)
+	case units > 0:
+		return
+	case downgrade:
+		message = "no provisioned capacity is active, the archives whose Expedited retrieval is rejected " +
+			"for insufficient capacity are retrieved with the Standard tier"
+	default:
+		message = "no provisioned capacity is active, the Expedited retrievals are rejected if Amazon Glacier " +
+			"has no capacity to spare; pass -downgrade to retrieve them with the Standard tier instead"
+	}
+
+	e := &events.Event{
+		Type:      events.Warning,
+		Message:   message,
+		Operation: restoreCommand.name,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	logger.Log(e)
+}
+
+// restoreTarget is a line of the manifest.
+type restoreTarget struct {
+	archiveId string
+	fileName  string
+
+	// destination is set if the file is an object stored in S3.
+	destination downloader.Destination
+
+	// The index of a file split into several archives replaces the archive
+	// ID. The file is created once, and the segments left to download are
+	// counted down to verify the file once all of them are.
+	index    *split.Index
+	file     *os.File
+	fileErr  error
+	openOnce sync.Once
+	pending  int32
+	failOnce sync.Once
+}
+
+// segment returns the segment of the split file stored as the archive, or nil.
+func (t *restoreTarget) segment(archiveId string) *split.Segment {
+	if t.index == nil {
+		return nil
+	}
+	for _, s := range t.index.Segments {
+		if s.ArchiveId == archiveId {
+			return s
+		}
+	}
+	return nil
+}
+
+// open creates the file the split archive is reassembled into.
+func (t *restoreTarget) open() (*os.File, error) {
+	t.openOnce.Do(func() {
+		t.file, t.fileErr = createSplitFile(t.fileName, t.index.Size)
+	})
+	return t.file, t.fileErr
+}
+
+// readManifest reads the archive IDs and the files of the CSV manifest.
+func readManifest(name string) ([]*restoreTarget, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("the manifest %s lists no archives", name)
+	}
+
+	var targets []*restoreTarget
+	files := make(map[string]bool)
+
+	for i, record := range records {
+		if record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("invalid manifest: line %d: empty archive ID or file", i+1)
+		}
+		if files[record[1]] {
+			return nil, fmt.Errorf("invalid manifest: %s is listed more than once", record[1])
+		}
+		files[record[1]] = true
+
+		t := &restoreTarget{archiveId: record[0], fileName: record[1]}
+		if strings.HasSuffix(t.archiveId, split.IndexSuffix) {
+			index, err := split.Read(t.archiveId)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkIndex(index, t.archiveId); err != nil {
+				return nil, err
+			}
+			t.index, t.archiveId = index, ""
+			t.pending = int32(len(index.Segments))
+		}
+
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// prepare checks that the file can be downloaded to, overwriting an existing
+// one only with force after confirmation.
+func (t *restoreTarget) prepare(config aws.Config, force bool) error {
+	if bucket, key, ok := s3object.ParseURL(t.fileName); ok {
+		if t.index != nil {
+			return fmt.Errorf("%s cannot be reassembled into an object stored in S3", t.fileName)
+		}
+		writer, err := createObject(config, bucket, key, force)
+		if err != nil {
+			return err
+		}
+		t.destination = writer
+		return nil
+	}
+
+	info, err := os.Stat(t.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		return fmt.Errorf("%s exists, pass -force to overwrite it", t.fileName)
+	}
+	return confirmOverwrite(t.fileName, info)
+}
+
+// restorer downloads the archives of the manifest as their jobs succeed.
+type restorer struct {
+	targets   []*restoreTarget
+	parallel  chan struct{}
+	overwrite bool
+	logger    *downloadTotals
+
+	wg        sync.WaitGroup
+	start     time.Time
+	startOnce sync.Once
+	restored  int64
+	failed    int64
+}
+
+// archiveIds returns the archive IDs of the manifest.
+func (r *restorer) archiveIds() []string {
+	var ids []string
+	seen := make(map[string]bool)
+
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	for _, t := range r.targets {
+		if t.index == nil {
+			add(t.archiveId)
+			continue
+		}
+		for _, s := range t.index.Segments {
+			add(s.ArchiveId)
+		}
+	}
+	return ids
+}
+
+// restore downloads the archive of the job to the target in the background.
+func (r *restorer) restore(service *glacier.Glacier, vaultName string, t *restoreTarget, job *retrieval.Job) {
+	if job.Err != nil {
+		r.fail(t, job.Err)
+		return
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		r.parallel <- struct{}{}
+		defer func() { <-r.parallel }()
+
+		r.startOnce.Do(func() {
+			r.start = time.Now()
+		})
+
+		input := &downloader.Input{
+			AccountId:      globals.accountId,
+			PartSize:       globals.partSize.download(),
+			Order:          utils.Order(globals.partOrder),
+			MaxRate:        int64(globals.maxRate),
+			MaxMemory:      int64(globals.maxMemory),
+			OnFailure:      utils.FailurePolicy(globals.onFailure),
+			SlowPartFactor: globals.slowFactor,
+			DropCache:      globals.dropCache,
+			VaultName:      vaultName,
+			FileName:       t.fileName,
+			Overwrite:      r.overwrite,
+			JobId:          job.JobId,
+			Destination:    t.destination,
+			Logger:         r.logger,
+		}
+
+		if _, err := downloader.New(service, input).Download(globals.jobs); err != nil {
+			r.fail(t, err)
+			return
+		}
+
+		restored := atomic.AddInt64(&r.restored, 1)
+		logger.Log(&events.Event{
+			Type:      events.Completed,
+			Message:   fmt.Sprintf("archive %s restored to %s (%d of %d)", t.archiveId, t.fileName, restored, len(r.targets)),
+			Operation: restoreCommand.name,
+			JobId:     job.JobId,
+			ArchiveId: t.archiveId,
+		})
+	}()
+}
+
+// restoreSegment downloads the archive of the job into the split file of the
+// target at the offset of the segment in the background, and verifies the
+// file once all of its segments are downloaded.
+func (r *restorer) restoreSegment(service *glacier.Glacier, vaultName string, t *restoreTarget, s *split.Segment, job *retrieval.Job) {
+	if job.Err != nil {
+		r.failSegment(t, s, job.Err)
+		return
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		r.parallel <- struct{}{}
+		defer func() { <-r.parallel }()
+
+		r.startOnce.Do(func() {
+			r.start = time.Now()
+		})
+
+		file, err := t.open()
+		if err != nil {
+			r.failSegment(t, s, err)
+			return
+		}
+		if err := downloadSegment(service, vaultName, file, s, job.JobId, r.logger); err != nil {
+			r.failSegment(t, s, err)
+			return
+		}
+
+		if atomic.AddInt32(&t.pending, -1) > 0 {
+			return
+		}
+		if err := file.Close(); err != nil {
+			r.failSegment(t, s, err)
+			return
+		}
+		if err := verifySplit(t.index, restoreCommand.name); err != nil {
+			r.failSegment(t, s, err)
+			return
+		}
+
+		restored := atomic.AddInt64(&r.restored, 1)
+		logger.Log(&events.Event{
+			Type:      events.Completed,
+			Message:   fmt.Sprintf("%d archive(s) reassembled into %s (%d of %d)", len(t.index.Segments), t.fileName, restored, len(r.targets)),
+			Operation: restoreCommand.name,
+			Checksum:  t.index.TreeHash,
+		})
+	}()
+}
+
+// failSegment logs the error restoring the segment of the split file, which
+// counts the target as failed once.
+func (r *restorer) failSegment(t *restoreTarget, s *split.Segment, err error) {
+	t.failOnce.Do(func() {
+		atomic.AddInt64(&r.failed, 1)
+	})
+	logger.Log(&events.Event{
+		Type:      events.Error,
+		Message:   fmt.Sprintf("could not restore archive %s to %s at offset %d: %v", s.ArchiveId, t.fileName, s.Offset, err),
+		Operation: restoreCommand.name,
+		ArchiveId: s.ArchiveId,
+		Error:     err.Error(),
+	})
+}
+
+// fail logs the error restoring the archive to the target.
+func (r *restorer) fail(t *restoreTarget, err error) {
+	atomic.AddInt64(&r.failed, 1)
+	logger.Log(&events.Event{
+		Type:      events.Error,
+		Message:   fmt.Sprintf("could not restore archive %s to %s: %v", t.archiveId, t.fileName, err),
+		Operation: restoreCommand.name,
+		ArchiveId: t.archiveId,
+		Error:     err.Error(),
+	})
+}
+
+// summarize logs the totals of the downloads, and returns an error if any
+// archive could not be restored.
+func (r *restorer) summarize() error {
+	var elapsed time.Duration
+	if !r.start.IsZero() {
+		elapsed = time.Since(r.start)
+	}
+
+	parts, bytes := r.logger.totals()
+	logger.Log(&events.Event{
+		Type: events.Summary,
+		Message: fmt.Sprintf("restored %d of %d archive(s), %s in %v (%s)",
+			r.restored, len(r.targets), utils.FormatSize(bytes), elapsed.Round(time.Millisecond),
+			utils.FormatRate(bytes, elapsed.Seconds())),
+		Operation: restoreCommand.name,
+		Parts:     parts,
+		Bytes:     bytes,
+		Elapsed:   elapsed.Seconds(),
+	})
+
+	if r.failed > 0 {
+		return fmt.Errorf("%d of %d archive(s) could not be restored", r.failed, len(r.targets))
+	}
+	return nil
+}
+
+// downloadTotals sums the totals of the download summaries passing through
+// it to the next logger.
+type downloadTotals struct {
+	next  events.Logger
+	parts int64
+	bytes int64
+}
+
+// Log adds the totals of the summary, if the event is one.
+func (d *downloadTotals) Log(e *events.Event) {
+	if e.Type == events.Summary {
+		atomic.AddInt64(&d.parts, e.Parts)
+		atomic.AddInt64(&d.bytes, e.Bytes)
+	}
+	d.next.Log(e)
+}
+
+func (d *downloadTotals) totals() (int64, int64) {
+	return atomic.LoadInt64(&d.parts), atomic.LoadInt64(&d.bytes)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/31z4/surge/pkg/events"
+)
+
+// invocation is the command line surge was run with.
+var invocation commandLine
+
+// commandLine holds the arguments following the program name and the index
+// of the command name among them.
+type commandLine struct {
+	args    []string
+	command int
+}
+
+// rewrite returns the arguments without the boolean options named by drop and
+// with the extra arguments inserted after the command name. The arguments
+// following "--" are positional and kept as they are.
+func (c commandLine) rewrite(drop []string, extra ...string) []string {
+	if c.command >= len(c.args) {
+		return nil
+	}
+
+	args := append([]string{}, c.args[:c.command+1]...)
+	args = append(args, extra...)
+
+	positional := false
+	for _, arg := range c.args[c.command+1:] {
+		if arg == "--" {
+			positional = true
+		}
+		if !positional && isOption(arg, drop) {
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	return args
+}
+
+// isOption reports whether the argument is one of the boolean options
+// given as -name, --name or -name=value.
+func isOption(arg string, names []string) bool {
+	name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+	if name == arg {
+		return false
+	}
+	if i := strings.IndexByte(name, '='); i >= 0 {
+		name = name[:i]
+	}
+
+	for _, n := range names {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// resumable returns the arguments of the command line resuming the transfer
+// of the result, and whether the transfer starts over rather than resuming.
+// It returns nil arguments if nothing is left to transfer. The commands whose
+// transfer can be resumed set it.
+var resumable func(r *result) (args []string, restart bool)
+
+// logIncomplete logs what the failed or interrupted transfer has done, what
+// remains, and the command resuming it, so that a long transfer is not
+// started over by mistake. It also records the command in the result.
+func logIncomplete(code int) {
+	if resumable == nil || progress == nil || results == nil {
+		return
+	}
+
+	r := results.get()
+	args, restart := resumable(&r)
+	if args == nil {
+		return
+	}
+	e := progress.completion()
+	if e == nil {
+		return
+	}
+
+	command := shellJoin(append([]string{"surge"}, args...))
+	results.update(func(r *result) {
+		r.Resume = command
+	})
+
+	outcome := "failed"
+	if code == exitInterrupted {
+		outcome = "interrupted"
+	}
+	message := fmt.Sprintf("%s %s: %s", r.Command, outcome, e.Message)
+	if r.UploadId != "" {
+		message += "; upload ID " + r.UploadId
+	}
+	if r.JobId != "" {
+		message += "; job ID " + r.JobId
+	}
+	if restart {
+		message += "; start over with: " + command
+	} else {
+		message += "; resume with: " + command
+	}
+
+	e.Type = events.Incomplete
+	e.Message = message
+	e.Operation = r.Command
+	e.UploadId = r.UploadId
+	e.JobId = r.JobId
+	logger.Log(e)
+}
+
+// shellSafe matches the arguments that need no quoting in a POSIX shell.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellJoin joins the arguments into a command line for a POSIX shell,
+// quoting the arguments that need it.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if shellSafe.MatchString(arg) {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
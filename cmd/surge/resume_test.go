@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+func TestCommandLineRewrite(t *testing.T) {
+	line := commandLine{
+		args:    []string{"-jobs", "4", "download", "-force", "-job-id", "ID", "vault", "file", "--", "-force"},
+		command: 2,
+	}
+
+	cases := []struct {
+		drop  []string
+		extra []string
+		want  []string
+	}{
+		{nil, nil, line.args},
+		{[]string{"force"}, nil, []string{"-jobs", "4", "download", "-job-id", "ID", "vault", "file", "--", "-force"}},
+		{nil, []string{"-upload-id", "ID"}, []string{"-jobs", "4", "download", "-upload-id", "ID", "-force", "-job-id", "ID", "vault", "file", "--", "-force"}},
+	}
+
+	for _, test := range cases {
+		if got := line.rewrite(test.drop, test.extra...); !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("got %#v, want %#v", got, test.want)
+		}
+	}
+}
+
+func TestIsOption(t *testing.T) {
+	cases := map[string]bool{
+		"-force":       true,
+		"--force":      true,
+		"-force=false": true,
+		"force":        false,
+		"-forced":      false,
+		"-":            false,
+	}
+
+	for arg, want := range cases {
+		if got := isOption(arg, []string{"force"}); got != want {
+			t.Fatalf("%s: got %#v, want %#v", arg, got, want)
+		}
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	args := []string{"surge", "upload", "-description", "it's mine", "vault", "s3://bucket/my file", "-"}
+
+	want := `surge upload -description 'it'\''s mine' vault 's3://bucket/my file' -`
+	if got := shellJoin(args); got != want {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestProgressCompletion(t *testing.T) {
+	p := newProgressTracker(events.Discard)
+	if got := p.completion(); got != nil {
+		t.Fatalf("got %#v, want nil", got)
+	}
+
+	for _, e := range []*events.Event{
+		{Type: events.Initiated, Parts: 4, Bytes: 4 << 20},
+		{Type: events.PartVerified, Range: &utils.Range{Offset: 0, Limit: 1 << 20}},
+		{Type: events.PartStarted, Range: &utils.Range{Offset: 1 << 20, Limit: 1 << 20}},
+		{Type: events.PartFinished, Range: &utils.Range{Offset: 1 << 20, Limit: 1 << 20}},
+		{Type: events.PartStarted, Range: &utils.Range{Offset: 2 << 20, Limit: 1 << 20}},
+	} {
+		p.Log(e)
+	}
+
+	got := p.completion()
+	want := "2 of 4 part(s) done, 2 remaining, 2 MiB of 4 MiB (50%)"
+	if got == nil || got.Message != want || got.Parts != 2 || got.Bytes != 2<<20 {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
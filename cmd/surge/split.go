@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/31z4/surge/pkg/catalog"
+	"github.com/31z4/surge/pkg/downloader"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/split"
+	"github.com/31z4/surge/pkg/uploader"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+// uploadSplit uploads the file too large for a single archive as the archives
+// of its segments, recording them in the index next to the file. Running the
+// upload again resumes it from the index: the uploaded segments are skipped
+// and the upload of the segment in progress is resumed.
+func uploadSplit(service *glacier.Glacier, input *uploader.Input, size int64) error {
+	name := input.FileName + split.IndexSuffix
+
+	index, err := split.Read(name)
+	switch {
+	case os.IsNotExist(err):
+		fileName := input.FileName
+		if abs, err := filepath.Abs(fileName); err == nil {
+			fileName = abs
+		}
+		index = split.New(fileName, size, split.SegmentSize)
+	case err != nil:
+		return err
+	case index.Size != size:
+		return fmt.Errorf("the index %s belongs to a file of %s, remove it to upload %s anew", name, utils.FormatSize(index.Size), input.FileName)
+	}
+
+	file, err := os.Open(input.FileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	client := uploader.NewClient(service)
+	for i, s := range index.Segments {
+		description := segmentDescription(input.Description, i, len(index.Segments))
+
+		if s.Uploaded() {
+			logger.Log(&events.Event{
+				Type:      events.Completed,
+				Message:   fmt.Sprintf("%s is already stored as archive %s, skipping it", description, s.ArchiveId),
+				Operation: uploadCommand.name,
+				ArchiveId: s.ArchiveId,
+				Checksum:  s.TreeHash,
+			})
+			continue
+		}
+
+		segmentInput := *input
+		segmentInput.Source = io.NewSectionReader(file, s.Offset, s.Size)
+		segmentInput.PartSize = split.PartSize(s.Size, input.PartSize)
+		segmentInput.Description = description
+		segmentInput.UploadId = s.UploadId
+		segmentInput.Logger = &segmentRecorder{next: input.Logger, index: index, name: name, segment: s}
+
+		result, err := client.Upload(&segmentInput, globals.jobs)
+		if err != nil {
+			return err
+		}
+
+		s.ArchiveId, s.TreeHash, s.UploadId = result.ArchiveId, result.Checksum, ""
+		if err := index.Write(name); err != nil {
+			return fmt.Errorf("could not record archive %s in the index: %v", s.ArchiveId, err)
+		}
+
+		err = addToCatalog(&catalog.Entry{
+			Region:      service.Region,
+			Vault:       input.VaultName,
+			ArchiveId:   s.ArchiveId,
+			Description: description,
+			FileName:    index.FileName,
+			Size:        s.Size,
+			TreeHash:    s.TreeHash,
+			UploadedAt:  time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	checksum, err := index.Checksum()
+	if err != nil {
+		return err
+	}
+	index.TreeHash = checksum
+	if err := index.Write(name); err != nil {
+		return err
+	}
+
+	logger.Log(&events.Event{
+		Type:      events.Completed,
+		Message:   fmt.Sprintf("%s is stored as %d archive(s) listed in the index %s", input.FileName, len(index.Segments), name),
+		Operation: uploadCommand.name,
+		Checksum:  checksum,
+	})
+	results.update(func(r *result) {
+		r.ArchiveId = ""
+		r.Location = ""
+		r.Index = name
+	})
+
+	return nil
+}
+
+// segmentDescription returns the description of the archive of the segment
+// number i out of n.
+func segmentDescription(description string, i, n int) string {
+	if description == "" {
+		return fmt.Sprintf("segment %d of %d", i+1, n)
+	}
+	return fmt.Sprintf("%s (segment %d of %d)", description, i+1, n)
+}
+
+// segmentRecorder records the upload ID of the segment in the index once its
+// upload is initiated, so that an interrupted upload resumes it, and passes
+// the events to the next logger.
+type segmentRecorder struct {
+	next    events.Logger
+	index   *split.Index
+	name    string
+	segment *split.Segment
+}
+
+// Log records the upload ID of the initiated upload.
+func (r *segmentRecorder) Log(e *events.Event) {
+	if e.Type == events.Initiated && e.UploadId != r.segment.UploadId {
+		r.segment.UploadId = e.UploadId
+		if err := r.index.Write(r.name); err != nil {
+			r.next.Log(&events.Event{
+				Type:     events.Warning,
+				Message:  fmt.Sprint("could not record the upload ID in the index: ", err),
+				UploadId: e.UploadId,
+				Error:    err.Error(),
+			})
+		}
+	}
+	r.next.Log(e)
+}
+
+// createSplitFile creates the file a split archive is reassembled into,
+// replacing the existing one.
+func createSplitFile(fileName string, size int64) (*os.File, error) {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// offsetWriter writes to the file at the offsets of a segment.
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (w offsetWriter) WriteAt(p []byte, off int64) (int, error) {
+	return w.file.WriteAt(p, w.offset+off)
+}
+
+// downloadSegment downloads the archive of the segment retrieved by the job
+// into the file at the offset of the segment.
+func downloadSegment(service *glacier.Glacier, vaultName string, file *os.File, s *split.Segment, jobId string, logger events.Logger) error {
+	input := &downloader.Input{
+		AccountId:      globals.accountId,
+		PartSize:       globals.partSize.download(),
+		Order:          utils.Order(globals.partOrder),
+		MaxRate:        int64(globals.maxRate),
+		MaxMemory:      int64(globals.maxMemory),
+		OnFailure:      utils.FailurePolicy(globals.onFailure),
+		SlowPartFactor: globals.slowFactor,
+		VaultName:      vaultName,
+		FileName:       file.Name(),
+		JobId:          jobId,
+		Logger:         logger,
+	}
+
+	result, err := downloader.NewWriterAt(service, input, offsetWriter{file, s.Offset}).Download(globals.jobs)
+	if err != nil {
+		return err
+	}
+	if result.TreeHash != s.TreeHash {
+		return fmt.Errorf("job %s retrieved an archive with tree hash %s instead of archive %s", jobId, result.TreeHash, s.ArchiveId)
+	}
+	return nil
+}
+
+// verifySplit checks that the segments downloaded into the file combine into
+// the tree hash of the split file.
+func verifySplit(index *split.Index, operation string) error {
+	if err := index.Verify(); err != nil {
+		logger.Log(&events.Event{
+			Type:      events.Verification,
+			Message:   fmt.Sprint("tree hash verification failed: ", err),
+			Operation: operation,
+			Error:     err.Error(),
+			Verified:  aws.Bool(false),
+		})
+		return withCode(exitVerification, err)
+	}
+
+	logger.Log(&events.Event{
+		Type:      events.Verification,
+		Message:   fmt.Sprintf("tree hash of the %d reassembled segment(s) verified", len(index.Segments)),
+		Operation: operation,
+		Checksum:  index.TreeHash,
+		Verified:  aws.Bool(true),
+	})
+	return nil
+}
+
+// checkIndex checks that every segment of the index is uploaded, so that
+// the file can be reassembled from their archives.
+func checkIndex(index *split.Index, name string) error {
+	if !index.Uploaded() || index.TreeHash == "" {
+		return fmt.Errorf("the index %s lists segments not uploaded yet", name)
+	}
+	return nil
+}
+
+// downloadSplit reassembles the file split into the archives of the index
+// from the jobs retrieving them, given in the order of the segments.
+func downloadSplit(service *glacier.Glacier, vaultName, indexName string, jobIds []string, fileName string) error {
+	index, err := split.Read(indexName)
+	if err != nil {
+		return err
+	}
+	if err := checkIndex(index, indexName); err != nil {
+		return err
+	}
+	if len(jobIds) != len(index.Segments) {
+		return withCode(exitUsage, fmt.Errorf("the index %s lists %d segment(s), got %d job ID(s)", indexName, len(index.Segments), len(jobIds)))
+	}
+
+	// Checked before any segment is downloaded.
+	for i, jobId := range jobIds {
+		archiveId, err := jobArchive(service, vaultName, jobId)
+		if err != nil {
+			return err
+		}
+		if s := index.Segments[i]; archiveId != s.ArchiveId {
+			return fmt.Errorf("job %s retrieves archive %s instead of archive %s of segment %d", jobId, archiveId, s.ArchiveId, i+1)
+		}
+	}
+
+	file, err := createSplitFile(fileName, index.Size)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for i, s := range index.Segments {
+		if err := downloadSegment(service, vaultName, file, s, jobIds[i], logger); err != nil {
+			return err
+		}
+	}
+
+	if err := verifySplit(index, downloadCommand.name); err != nil {
+		return err
+	}
+
+	results.update(func(r *result) {
+		r.Index = indexName
+	})
+	return nil
+}
+
+// jobArchive returns the ID of the archive the job retrieves.
+func jobArchive(service *glacier.Glacier, vaultName, jobId string) (string, error) {
+	input := &glacier.DescribeJobInput{
+		AccountId: &globals.accountId,
+		JobId:     &jobId,
+		VaultName: &vaultName,
+	}
+
+	result, err := service.DescribeJobRequest(input).Send()
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(result.ArchiveId), nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+// statusInterval is how often the -status-file is rewritten during a transfer.
+const statusInterval = time.Second
+
+// statusRunning is the state of a transfer in the -status-file until it is
+// over. The state is then the outcome of the command, as in SURGE_STATUS.
+const statusRunning = "running"
+
+// transferStatus is the state of a transfer written to the -status-file.
+type transferStatus struct {
+	Command  string `json:"command"`
+	Vault    string `json:"vault"`
+	File     string `json:"file,omitempty"`
+	UploadId string `json:"upload_id,omitempty"`
+	JobId    string `json:"job_id,omitempty"`
+
+	State     string    `json:"state"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Parts         int64 `json:"parts"`
+	TotalParts    int64 `json:"total_parts,omitempty"`
+	PartsInFlight int64 `json:"parts_in_flight"`
+	PartsFailed   int64 `json:"parts_failed"`
+
+	Bytes        int64 `json:"bytes"`
+	TotalBytes   int64 `json:"total_bytes,omitempty"`
+	ResumedBytes int64 `json:"resumed_bytes"`
+
+	// The throughput, measured from the start of the transfer of the parts,
+	// and the estimated time left, unknown until a part is transferred.
+	Rate int64    `json:"bytes_per_second"`
+	ETA  *float64 `json:"eta_seconds,omitempty"`
+
+	// The ranges of the parts in flight.
+	Ranges []utils.Range `json:"ranges"`
+
+	Retries int64   `json:"retries"`
+	Elapsed float64 `json:"elapsed_seconds"`
+
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// status returns the state of the transfer of the result.
+func (p *progressTracker) status(r result, now time.Time) *transferStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s := &transferStatus{
+		Command:       r.Command,
+		Vault:         r.Vault,
+		File:          r.File,
+		UploadId:      r.UploadId,
+		JobId:         r.JobId,
+		State:         statusRunning,
+		UpdatedAt:     now.UTC(),
+		Parts:         p.done,
+		TotalParts:    p.totalParts,
+		PartsInFlight: p.inFlight,
+		PartsFailed:   p.failed,
+		Bytes:         p.transferred + p.skipped,
+		TotalBytes:    p.totalBytes,
+		ResumedBytes:  p.skipped,
+		Ranges:        []utils.Range{},
+		Retries:       p.retries,
+		Elapsed:       now.Sub(p.start).Seconds(),
+	}
+
+	if !p.transferStart.IsZero() && p.transferred > 0 {
+		elapsed := now.Sub(p.transferStart).Seconds()
+		s.Rate = int64(float64(p.transferred) / elapsed)
+		if p.totalBytes > 0 {
+			eta := float64(p.totalBytes-s.Bytes) * elapsed / float64(p.transferred)
+			if eta < 0 {
+				eta = 0
+			}
+			s.ETA = &eta
+		}
+	}
+
+	for _, r := range p.ranges {
+		s.Ranges = append(s.Ranges, r)
+	}
+	sort.Slice(s.Ranges, func(i, j int) bool {
+		return s.Ranges[i].Offset < s.Ranges[j].Offset
+	})
+
+	return s
+}
+
+// statusFile rewrites the -status-file while the transfer runs, and once
+// more with its outcome. Every write replaces the file at once, so that a
+// script polling it never reads a part of it.
+var statusFile struct {
+	mutex sync.Mutex
+	path  string
+	done  bool
+
+	// Whether a failed write was reported, so that it is reported once.
+	warned bool
+}
+
+// startStatusFile writes the status of the transfer to the -status-file
+// every statusInterval until the command exits.
+func (o *globalOptions) startStatusFile(command string) {
+	if o.statusFile == "" || !transferCommands[command] {
+		return
+	}
+
+	statusFile.mutex.Lock()
+	statusFile.path = o.statusFile
+	statusFile.done = false
+	statusFile.warned = false
+	statusFile.mutex.Unlock()
+
+	writeStatus(nil, nil)
+	statusOnce.Do(func() {
+		go func() {
+			for range time.Tick(statusInterval) {
+				writeStatus(nil, nil)
+			}
+		}()
+	})
+}
+
+var statusOnce sync.Once
+
+// finishStatusFile writes the outcome of the transfer to the -status-file,
+// after which it is not rewritten anymore.
+func finishStatusFile(code int, err error) {
+	writeStatus(&code, err)
+}
+
+// writeStatus writes the status of the transfer to the -status-file, with
+// the exit code once the transfer is over. A failed write is warned about
+// but doesn't fail the transfer.
+func writeStatus(code *int, err error) {
+	statusFile.mutex.Lock()
+	defer statusFile.mutex.Unlock()
+
+	if statusFile.path == "" || statusFile.done {
+		return
+	}
+
+	s := progress.status(results.get(), time.Now())
+	if code != nil {
+		statusFile.done = true
+		s.State = exitStatus(*code)
+		s.ExitCode = code
+		s.Ranges = []utils.Range{}
+		if err != nil {
+			s.Error = err.Error()
+		}
+	}
+
+	data, _ := json.MarshalIndent(s, "", "  ")
+	if writeErr := utils.WriteFileAtomic(statusFile.path, append(data, '\n'), 0644); writeErr != nil && !statusFile.warned {
+		statusFile.warned = true
+		logger.Log(&events.Event{
+			Type:    events.Warning,
+			Message: fmt.Sprintf("could not write the status file: %v", writeErr),
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// stdinFileName is the file name standing for the standard input.
+const stdinFileName = "-"
+
+// commandOutput is the output of a command run by the shell. Once the output
+// ends, the command is waited for and its failure is reported instead of the
+// end of the output, so that a partial output is never taken for a complete one.
+type commandOutput struct {
+	io.ReadCloser
+	cmd  *exec.Cmd
+	name string // names the command in its failure
+
+	// The result of waiting for the command, once it is done.
+	done bool
+	err  error
+}
+
+// startCommand runs the command by the shell with its standard output piped.
+func startCommand(command string) (*commandOutput, error) {
+	cmd := shellCommand(command)
+	cmd.Stdin = os.Stdin
+
+	return startOutput(cmd, command)
+}
+
+// shellCommand returns the command line run by the shell of the platform.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// startOutput starts the command with its standard output piped. Its errors
+// are written to the standard error.
+func startOutput(cmd *exec.Cmd, name string) (*commandOutput, error) {
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not run %q: %v", name, err)
+	}
+
+	return &commandOutput{ReadCloser: stdout, cmd: cmd, name: name}, nil
+}
+
+func (o *commandOutput) Read(p []byte) (int, error) {
+	if o.done {
+		return 0, o.err
+	}
+
+	n, err := o.ReadCloser.Read(p)
+	if err == io.EOF {
+		o.wait()
+		return n, o.err
+	}
+	return n, err
+}
+
+func (o *commandOutput) wait() {
+	o.done = true
+	o.err = io.EOF
+
+	if err := o.cmd.Wait(); err != nil {
+		o.err = fmt.Errorf("%q failed: %v", o.name, err)
+	}
+}
+
+// Close kills the command unless it is done, for example when the upload fails.
+func (o *commandOutput) Close() error {
+	if o.done {
+		return nil
+	}
+
+	o.cmd.Process.Kill()
+	o.wait()
+	return nil
+}
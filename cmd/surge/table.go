@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// tableOptions select the columns of the listings of a command and whether
+// their header is written.
+type tableOptions struct {
+	noHeader  bool
+	names     listValue
+	available []string
+
+	// The selected columns, all of the available ones by default.
+	columns []string
+}
+
+// register adds the -no-header and -columns options among the columns to the flags.
+func (o *tableOptions) register(flags *flag.FlagSet, columns ...string) {
+	o.available = columns
+	flags.BoolVar(&o.noHeader, "no-header", false, "omit the header line of the listings, e.g. for a script")
+	flags.Var(&o.names, "columns", fmt.Sprintf("the comma separated `list` of the columns to show in the order, among %s (default all)", strings.Join(columns, ", ")))
+}
+
+// parse selects the columns given with -columns, it returns an error naming
+// an unknown column.
+func (o *tableOptions) parse() error {
+	o.columns = nil
+	for _, list := range o.names {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if !contains(o.available, name) {
+				return fmt.Errorf("unknown column %q, expected one of %s", name, strings.Join(o.available, ", "))
+			}
+			o.columns = append(o.columns, name)
+		}
+	}
+	if len(o.columns) == 0 {
+		o.columns = o.available
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// table renders the rows of a listing in columns as wide as their widest cell.
+// The header names the columns in upper case.
+type table struct {
+	options *tableOptions
+	columns []string
+	rows    []map[string]string
+
+	// indent prefixes every line, e.g. below a heading.
+	indent string
+}
+
+// newTable creates a table with the columns rendered according to the options.
+// Nil options render all the columns with the header.
+func newTable(options *tableOptions, columns ...string) *table {
+	if options == nil {
+		options = &tableOptions{available: columns, columns: columns}
+	}
+	return &table{options: options, columns: columns}
+}
+
+// add appends a row with a value for every column of the table, in the order.
+// An empty value is rendered as a dash, so that the columns can be split on
+// the spaces.
+func (t *table) add(values ...string) {
+	row := make(map[string]string, len(t.columns))
+	for i, column := range t.columns {
+		value := "-"
+		if i < len(values) && values[i] != "" {
+			value = values[i]
+		}
+		row[column] = value
+	}
+	t.rows = append(t.rows, row)
+}
+
+// write renders the header, unless -no-header is given, and the rows.
+func (t *table) write(w io.Writer) error {
+	var columns []string
+	for _, column := range t.options.columns {
+		if contains(t.columns, column) {
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	writeRow := func(cells []string) {
+		fmt.Fprintf(tw, "%s%s\n", t.indent, strings.Join(cells, "\t"))
+	}
+
+	if !t.options.noHeader {
+		header := make([]string, len(columns))
+		for i, column := range columns {
+			header[i] = strings.ToUpper(column)
+		}
+		writeRow(header)
+	}
+
+	for _, row := range t.rows {
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			cells[i] = row[column]
+		}
+		writeRow(cells)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"all", nil, "ID     STATUS     SIZE\n" +
+			"first  Succeeded  3 MiB\n" +
+			"2      -          10 B\n"},
+		{"no header", []string{"-no-header"}, "first  Succeeded  3 MiB\n" +
+			"2      -          10 B\n"},
+		{"columns", []string{"-columns", "size, ID", "-columns", "status"}, "SIZE   ID     STATUS\n" +
+			"3 MiB  first  Succeeded\n" +
+			"10 B   2      -\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var options tableOptions
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			options.register(flags, "id", "status", "size")
+			if err := flags.Parse(c.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := options.parse(); err != nil {
+				t.Fatal(err)
+			}
+
+			table := newTable(&options, "id", "status", "size")
+			table.add("first", "Succeeded", "3 MiB")
+			table.add("2", "", "10 B")
+
+			var b bytes.Buffer
+			if err := table.write(&b); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != c.want {
+				t.Fatalf("got %#v, want %#v", got, c.want)
+			}
+		})
+	}
+
+	t.Run("unknown column", func(t *testing.T) {
+		var options tableOptions
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		options.register(flags, "id", "status")
+		if err := flags.Parse([]string{"-columns", "id,size"}); err != nil {
+			t.Fatal(err)
+		}
+
+		want := `unknown column "size", expected one of id, status`
+		if err := options.parse(); err == nil || err.Error() != want {
+			t.Fatalf("got %#v, want %#v", err, want)
+		}
+	})
+
+	t.Run("default options", func(t *testing.T) {
+		table := newTable(nil, "id", "name")
+		table.indent = "  "
+		table.add("first", "photos.tar")
+
+		var b bytes.Buffer
+		if err := table.write(&b); err != nil {
+			t.Fatal(err)
+		}
+		want := "  ID     NAME\n  first  photos.tar\n"
+		if got := b.String(); got != want {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/31z4/surge/pkg/downloader"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/split"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+// Bounds of the jobs chosen by -auto-tune.
+const (
+	minTunedJobs = 2
+	maxTunedJobs = 32
+)
+
+// longRoundTrip is the round trip to Amazon Glacier from which every job
+// waits long enough between its parts that twice the jobs keep the network busy.
+const longRoundTrip = 100 * time.Millisecond
+
+// roundTripProbes is the number of the requests timed to measure the round
+// trip. The fastest one is kept, the first one also dials the connection.
+const roundTripProbes = 3
+
+// tuning is what -auto-tune chose for the host and the network.
+type tuning struct {
+	jobs int
+
+	// The memory limit of the buffered parts, zero if the available memory
+	// is not known.
+	maxMemory int64
+}
+
+// chooseTuning returns the jobs for the CPUs, which hash the parts, and the
+// round trip to Amazon Glacier, along with a memory limit leaving three
+// quarters of the available memory to the rest of the host.
+func chooseTuning(cpus int, available int64, roundTrip time.Duration) tuning {
+	jobs := 2 * cpus
+	if roundTrip >= longRoundTrip {
+		jobs *= 2
+	}
+	if jobs < minTunedJobs {
+		jobs = minTunedJobs
+	}
+	if jobs > maxTunedJobs {
+		jobs = maxTunedJobs
+	}
+
+	return tuning{
+		jobs:      jobs,
+		maxMemory: available / 4,
+	}
+}
+
+// tunedPartSize returns the part size of an upload of the size, chosen like
+// the part size of a download: the smallest power of two megabytes uploading
+// it in at most 1000 parts, up to 64 MiB, and larger only to stay within the
+// parts of an upload. A file split into several archives is tuned for the
+// largest archive.
+func tunedPartSize(size int64) int64 {
+	if size > split.MaxArchiveSize {
+		size = split.MaxArchiveSize
+	}
+	return split.PartSize(size, downloader.AutoPartSize(size))
+}
+
+// probeRoundTrip times a DescribeVault request, which transfers next to no
+// data, so that it measures the round trip to Amazon Glacier.
+func probeRoundTrip(service *glacier.Glacier, accountId, vaultName string) (time.Duration, error) {
+	var fastest time.Duration
+	for i := 0; i < roundTripProbes; i++ {
+		start := time.Now()
+		_, err := service.DescribeVaultRequest(&glacier.DescribeVaultInput{
+			AccountId: &accountId,
+			VaultName: &vaultName,
+		}).Send()
+		if err != nil {
+			return 0, err
+		}
+
+		if elapsed := time.Since(start); i == 0 || elapsed < fastest {
+			fastest = elapsed
+		}
+	}
+	return fastest, nil
+}
+
+// autoTune chooses the jobs and the memory limit of a transfer from the CPUs,
+// the available memory and the round trip to Amazon Glacier, unless they are
+// given on the command line. It reports whether the jobs changed.
+func (o *globalOptions) autoTune(service *glacier.Glacier, vaultName string) bool {
+	roundTrip, err := probeRoundTrip(service, o.accountId, vaultName)
+	if err != nil {
+		logger.Log(&events.Event{
+			Type:    events.Warning,
+			Message: fmt.Sprintf("could not measure the round trip to Amazon Glacier, tuning for a short one: %v", err),
+		})
+	}
+
+	cpus, available := runtime.NumCPU(), utils.AvailableMemory()
+	t := chooseTuning(cpus, available, roundTrip)
+
+	jobs := o.jobs
+	if !o.given["jobs"] {
+		o.jobs = t.jobs
+	}
+	if !o.given["max-memory"] {
+		o.maxMemory = sizeValue(t.maxMemory)
+	}
+
+	memory, limit := "an unknown amount", "no memory limit"
+	if available > 0 {
+		memory = utils.FormatSize(available)
+	}
+	if o.maxMemory > 0 {
+		limit = "a memory limit of " + utils.FormatSize(int64(o.maxMemory))
+	}
+	logger.Log(&events.Event{
+		Type: events.Tuned,
+		Message: fmt.Sprintf("tuned %d job(s) and %s for %d CPU(s), %s of memory available and a round trip of %v",
+			o.jobs, limit, cpus, memory, roundTrip.Round(time.Millisecond)),
+	})
+
+	return o.jobs != jobs
+}
+
+// tunePartSize chooses the part size of the upload of the size with
+// -auto-tune, unless -part-size is given.
+func (o *globalOptions) tunePartSize(size int64) {
+	if !o.tune || o.partSize.set {
+		return
+	}
+
+	o.partSize.sizeValue = sizeValue(tunedPartSize(size))
+	logger.Log(&events.Event{
+		Type:    events.Tuned,
+		Message: fmt.Sprintf("tuned the part size to %s for %s", utils.FormatSize(int64(o.partSize.sizeValue)), utils.FormatSize(size)),
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChooseTuning(t *testing.T) {
+	cases := []struct {
+		name      string
+		cpus      int
+		available int64
+		roundTrip time.Duration
+		want      tuning
+	}{
+		{"short round trip", 4, 8 << 30, 20 * time.Millisecond, tuning{jobs: 8, maxMemory: 2 << 30}},
+		{"long round trip", 4, 8 << 30, 150 * time.Millisecond, tuning{jobs: 16, maxMemory: 2 << 30}},
+		{"single CPU", 1, 0, 0, tuning{jobs: 2}},
+		{"many CPUs", 64, 256 << 30, 0, tuning{jobs: 32, maxMemory: 64 << 30}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := chooseTuning(c.cpus, c.available, c.roundTrip); got != c.want {
+				t.Fatalf("got %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestTunedPartSize(t *testing.T) {
+	cases := []struct {
+		size, want int64
+	}{
+		{1, 1 << 20},
+		{10 << 30, 16 << 20},
+		{1 << 40, 128 << 20},
+		{100 << 40, 4 << 30},
+	}
+
+	for _, c := range cases {
+		if got := tunedPartSize(c.size); got != c.want {
+			t.Errorf("%d: got %d, want %d", c.size, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/31z4/surge/pkg/catalog"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/inventory"
+	"github.com/31z4/surge/pkg/s3object"
+	"github.com/31z4/surge/pkg/split"
+	"github.com/31z4/surge/pkg/uploader"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+var uploadCommand = &command{
+	name:        "upload",
+	args:        "VAULT|REMOTE: FILE",
+	summary:     "Upload an archive to the existing vault",
+	description: "Upload the file to the existing Amazon Glacier vault",
+}
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	uploadCommand.setup = setupUpload
+}
+
+func setupUpload(flags *flag.FlagSet) func(args []string) error {
+	uploadId := flags.String("upload-id", "", "the upload ID of the multipart upload")
+	verify := flags.Bool("verify-remote", false, "only compare the parts of the -upload-id upload with the file and report the ranges that match, mismatch or are missing")
+	allowDuplicate := flags.Bool("allow-duplicate", false, "upload the file even if an archive with the same content is stored")
+	checkInventory := flags.Bool("check-inventory", false, "also look for an archive with the same content in the latest inventory")
+	multipartThreshold := sizeValue(100 << 20)
+	flags.Var(&multipartThreshold, "multipart-threshold", "upload a file smaller than the `size` in a single request instead of in parts, 0 uploads every file in parts")
+	compressSparse := flags.Bool("compress-sparse", false, "upload a sparse file compressed with gzip, FILE then only names the archive")
+	memoryMap := flags.Bool("mmap", false, "map the file into memory instead of reading every part")
+	checksumFile := flags.Bool("checksum-file", false, "write the checksums of the file to FILE.treehash after the upload")
+	description := flags.String("description", "", "the description of the archive, a template such as {{.Hostname}}:{{.Path}}@{{.Date}}")
+	restoreInfo := flags.String("restore-info", "", "write the information needed to restore the archive to a JSON file in the `directory`")
+	execCommand := flags.String("exec", "", "upload the output of the `command` run by the shell, FILE then only names the archive")
+	var gpgRecipients listValue
+	flags.Var(&gpgRecipients, "gpg-recipient", "encrypt the archive with gpg to the `key`, may be given several times")
+
+	return func(args []string) error {
+		if len(args) != 2 {
+			return newUsageError(uploadCommand, "expected VAULT and FILE arguments, got %d argument(s)", len(args))
+		}
+		target, fileName := args[0], args[1]
+
+		if multipartThreshold > uploader.MaxSingleSize {
+			return newUsageError(uploadCommand, "-multipart-threshold must not exceed %s, the largest archive uploaded in a single request", utils.FormatSize(uploader.MaxSingleSize))
+		}
+
+		// The output of a command and the standard input are piped, and
+		// streamed like the output of gpg encrypting the archive.
+		piped := *execCommand != "" || fileName == stdinFileName
+		encrypted := len(gpgRecipients) > 0
+		streamed := piped || encrypted
+		if streamed && *uploadId != "" {
+			return newUsageError(uploadCommand, "-upload-id is not supported for a stream")
+		}
+		if *verify && *uploadId == "" {
+			return newUsageError(uploadCommand, "-verify-remote requires -upload-id")
+		}
+		if streamed && *checksumFile {
+			return newUsageError(uploadCommand, "-checksum-file is not supported for a stream")
+		}
+
+		bucket, key, fromS3 := s3object.ParseURL(fileName)
+		fromS3 = fromS3 && !piped
+		if fromS3 && *checksumFile {
+			return newUsageError(uploadCommand, "-checksum-file is not supported for an object stored in S3")
+		}
+		if fromS3 && encrypted {
+			return newUsageError(uploadCommand, "-gpg-recipient is not supported for an object stored in S3")
+		}
+
+		// A description template is expanded at the start of the upload,
+		// every segment of a split file shares it.
+		expanded, err := expandDescription(*description, newDescriptionData(fileName, !piped && !fromS3, time.Now()))
+		if err != nil {
+			return newUsageError(uploadCommand, "%v", err)
+		}
+		*description = expanded
+
+		service, vaultName, err := globals.start(uploadCommand.name, target, fileName)
+		if err != nil {
+			return err
+		}
+
+		// The holes of a sparse file are compressed rather than uploaded as
+		// zeros, unless the upload of the file in full is resumed.
+		compressed := false
+		if *uploadId == "" && !fromS3 && !streamed {
+			compressed = checkSparse(fileName, *compressSparse) && *compressSparse
+		}
+		if compressed && *checksumFile {
+			return withCode(exitUsage, errors.New("-checksum-file is not supported for a sparse file uploaded compressed"))
+		}
+
+		var source uploader.Source
+		if fromS3 {
+			object, err := s3object.Open(service.Config, bucket, key)
+			if err != nil {
+				return err
+			}
+			source = object
+		}
+
+		// The part size of a resumed upload is the one it was initiated
+		// with, and the size of a stream is not known in advance.
+		if *uploadId == "" && !streamed && !compressed {
+			if fromS3 {
+				globals.tunePartSize(source.Size())
+			} else if info, err := os.Stat(fileName); err == nil {
+				globals.tunePartSize(info.Size())
+			}
+		}
+
+		if *verify {
+			return verifyRemote(service, vaultName, fileName, source, *uploadId, *memoryMap)
+		}
+
+		var stream io.Reader
+		if *execCommand != "" {
+			output, err := startCommand(*execCommand)
+			if err != nil {
+				return err
+			}
+			defer output.Close()
+			stream = output
+		} else if piped {
+			stream = os.Stdin
+		}
+		if compressed {
+			output, err := startCompression(fileName)
+			if err != nil {
+				return err
+			}
+			defer output.Close()
+			stream = output
+			streamed = true
+		}
+		if encrypted {
+			output, err := startEncryption(gpgRecipients, fileName, stream)
+			if err != nil {
+				return err
+			}
+			defer output.Close()
+			stream = output
+		}
+
+		// A file too large for a single archive is uploaded as several.
+		var splitSize int64
+		if !fromS3 && !streamed {
+			if info, err := os.Stat(fileName); err == nil && info.Size() > split.MaxArchiveSize {
+				splitSize = info.Size()
+			}
+		}
+		if splitSize > 0 && (*uploadId != "" || *checksumFile || *restoreInfo != "") {
+			return withCode(exitUsage, fmt.Errorf("-upload-id, -checksum-file and -restore-info are not supported for a file larger than %s, "+
+				"which is split into several archives recorded in %s", utils.FormatSize(split.MaxArchiveSize), fileName+split.IndexSuffix))
+		}
+
+		// Looking for a duplicate of an object would read it once more,
+		// and a stream can only be read once.
+		var sums *checksums
+		if *uploadId == "" && !*allowDuplicate && !fromS3 && !streamed && splitSize == 0 {
+			var archiveId string
+			archiveId, sums, err = findDuplicate(service, vaultName, fileName, *checkInventory)
+			if err != nil {
+				return err
+			}
+
+			if archiveId != "" {
+				logger.Log(&events.Event{
+					Type:      events.Completed,
+					Message:   fmt.Sprint("archive ", archiveId, " with the same content is already stored, skipping the upload"),
+					Operation: uploadCommand.name,
+					ArchiveId: archiveId,
+					Checksum:  sums.treeHash,
+				})
+				results.update(func(r *result) {
+					r.Duplicate = true
+				})
+				return nil
+			}
+		}
+
+		input := &uploader.Input{
+			AccountId:          globals.accountId,
+			PartSize:           int64(globals.partSize.sizeValue),
+			Order:              utils.Order(globals.partOrder),
+			MaxRate:            int64(globals.maxRate),
+			MaxMemory:          int64(globals.maxMemory),
+			OnFailure:          utils.FailurePolicy(globals.onFailure),
+			SlowPartFactor:     globals.slowFactor,
+			VaultName:          vaultName,
+			FileName:           fileName,
+			Description:        *description,
+			Source:             source,
+			Stream:             stream,
+			UploadId:           *uploadId,
+			MemoryMap:          *memoryMap,
+			MultipartThreshold: int64(multipartThreshold),
+			DropCache:          globals.dropCache,
+			Logger:             logger,
+		}
+
+		// The index resumes the upload of a split file.
+		if splitSize > 0 {
+			resumable = func(r *result) ([]string, bool) {
+				if r.Index != "" {
+					return nil, false
+				}
+				return invocation.rewrite(nil), false
+			}
+			return uploadSplit(service, input, splitSize)
+		}
+
+		// A stream is read once, so its upload cannot be resumed.
+		if !streamed {
+			resumable = func(r *result) ([]string, bool) {
+				if r.UploadId == "" || r.ArchiveId != "" {
+					return nil, false
+				}
+				if *uploadId != "" {
+					return invocation.rewrite(nil), false
+				}
+				return invocation.rewrite(nil, "-upload-id", r.UploadId), false
+			}
+		}
+
+		uploaded, err := uploader.NewClient(service).Upload(input, globals.jobs)
+		if err != nil {
+			return err
+		}
+
+		entry, err := recordUpload(service.Region, vaultName, fileName, *description, source, streamed, uploaded)
+		if err != nil {
+			return err
+		}
+
+		if *checksumFile {
+			if err := writeChecksumFile(fileName, uploaded, sums); err != nil {
+				return err
+			}
+		}
+		if *restoreInfo != "" {
+			return writeRestoreInfo(*restoreInfo, entry, input.PartSize)
+		}
+		return nil
+	}
+}
+
+// findDuplicate returns the archive of the vault with the same tree hash and
+// size as the file, looking it up in the catalog and optionally in the latest
+// inventory. If the inventory is not retrieved yet, only the catalog is checked.
+// The checksums of the file are returned as well, read from its checksum file
+// if it records the size of the file, or computed otherwise, and nil if the
+// file is not hashed since no archive of the same size is known.
+func findDuplicate(service *glacier.Glacier, vaultName, fileName string, checkInventory bool) (archiveId string, sums *checksums, err error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+	if info.Size() == 0 {
+		return "", nil, uploader.ErrEmpty
+	}
+
+	c, err := globals.loadCatalog()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Only an archive of the same size can be a duplicate, so the file is
+	// not hashed unless the catalog has one, or the inventory is checked.
+	candidate := checkInventory
+	for _, e := range c.Vault(service.Region, vaultName) {
+		if e.Size == info.Size() {
+			candidate = true
+			break
+		}
+	}
+
+	sums = readChecksumFile(fileName)
+	if sums != nil && sums.size != info.Size() {
+		sums = nil
+	}
+	if sums == nil {
+		if !candidate {
+			return "", nil, nil
+		}
+		if sums, err = hashFile(file, info.Size()); err != nil {
+			return "", nil, fmt.Errorf("could not compute hash: %v", err)
+		}
+	}
+
+	if e := c.Find(service.Region, vaultName, sums.treeHash, info.Size()); e != nil {
+		return e.ArchiveId, sums, nil
+	}
+
+	if !checkInventory {
+		return "", sums, nil
+	}
+
+	cache, err := globals.inventoryCache(service.Region)
+	if err != nil {
+		return "", nil, err
+	}
+
+	input := &inventory.Input{
+		AccountId: globals.accountId,
+		VaultName: vaultName,
+		Cache:     cache,
+		Logger:    logger,
+	}
+
+	inv, err := inventory.New(service, input).Retrieve()
+	if err == inventory.ErrJobNotReady {
+		return "", sums, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, a := range inv.ArchiveList {
+		if a.TreeHash == sums.treeHash && a.Size == info.Size() {
+			return a.ArchiveId, sums, nil
+		}
+	}
+
+	return "", sums, nil
+}
+
+// recordUpload adds the uploaded archive to the local catalog and returns its
+// entry. The source or the stream, if any, was uploaded instead of the file.
+func recordUpload(region, vaultName, fileName, description string, source uploader.Source, streamed bool, uploaded *uploader.Result) (*catalog.Entry, error) {
+	var size int64
+	if source != nil {
+		size = source.Size()
+	} else if streamed {
+		// A stream is uploaded at once, it is never resumed.
+		size = uploaded.BytesTransferred
+	} else {
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return nil, err
+		}
+		size = info.Size()
+
+		if abs, err := filepath.Abs(fileName); err == nil {
+			fileName = abs
+		}
+	}
+
+	entry := &catalog.Entry{
+		Region:      region,
+		Vault:       vaultName,
+		ArchiveId:   uploaded.ArchiveId,
+		Description: description,
+		FileName:    fileName,
+		Size:        size,
+		TreeHash:    uploaded.Checksum,
+		UploadedAt:  time.Now().UTC(),
+	}
+
+	if err := addToCatalog(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// addToCatalog records the uploaded archive in the local catalog.
+func addToCatalog(entry *catalog.Entry) error {
+	c, err := globals.loadCatalog()
+	if err != nil {
+		return fmt.Errorf("could not record archive %s in the catalog: %v", entry.ArchiveId, err)
+	}
+
+	c.Add(entry)
+
+	if err := globals.saveCatalog(c); err != nil {
+		return fmt.Errorf("could not record archive %s in the catalog: %v", entry.ArchiveId, err)
+	}
+
+	return nil
+}
+
+// restoreInfo is everything needed to find and restore an archive years
+// after it was uploaded, when the catalog and the logs may be long gone.
+type restoreInfo struct {
+	*catalog.Entry
+
+	AccountId string `json:"account_id,omitempty"`
+	PartSize  int64  `json:"part_size"`
+}
+
+// writeRestoreInfo writes the restore information of the uploaded archive
+// to a JSON file in the directory, named after the archive ID.
+func writeRestoreInfo(dir string, entry *catalog.Entry, partSize int64) error {
+	info := &restoreInfo{
+		Entry:    entry,
+		PartSize: partSize,
+	}
+	if globals.accountId != "-" {
+		info.AccountId = globals.accountId
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not write the restore information of archive %s: %v", entry.ArchiveId, err)
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(dir, entry.ArchiveId+".json"), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("could not write the restore information of archive %s: %v", entry.ArchiveId, err)
+	}
+
+	return nil
+}
+
+// checksumFileSuffix is appended to the name of the uploaded file to name
+// its checksum file.
+const checksumFileSuffix = ".treehash"
+
+// checksums are the size and the hex encoded hashes of a file, as recorded
+// in its checksum file.
+type checksums struct {
+	size     int64
+	sha256   string
+	treeHash string
+}
+
+// hashFile computes the checksums of the file of the size in a single read,
+// passing Hashing events to show how far it got.
+func hashFile(file *os.File, size int64) (*checksums, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	treeHasher, linearHasher := utils.NewTreeHasher(), utils.NewSHA256()
+	progress := events.OnHashed(logger.Log, file.Name(), size)
+
+	buf := make([]byte, 1<<20)
+	sums := &checksums{}
+	for {
+		n, err := io.ReadFull(file, buf)
+		treeHasher.Write(buf[:n])
+		linearHasher.Write(buf[:n])
+		sums.size += int64(n)
+
+		if n > 0 {
+			progress(sums.size)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sums.sha256 = hex.EncodeToString(linearHasher.Sum(nil))
+	sums.treeHash = hex.EncodeToString(treeHasher.Sum(nil))
+	return sums, nil
+}
+
+// readChecksumFile returns the checksums recorded in the checksum file of
+// the file, or nil if there is no such file or it cannot be parsed.
+func readChecksumFile(fileName string) *checksums {
+	data, err := ioutil.ReadFile(fileName + checksumFileSuffix)
+	if err != nil {
+		return nil
+	}
+
+	var archiveId string
+	sums := &checksums{}
+	if n, _ := fmt.Sscanf(string(data), "archive-id: %s\nsize: %d\nsha256: %s\ntree-hash: %s\n",
+		&archiveId, &sums.size, &sums.sha256, &sums.treeHash); n != 4 {
+		return nil
+	}
+	if len(sums.sha256) != 64 || len(sums.treeHash) != 64 {
+		return nil
+	}
+	return sums
+}
+
+// writeChecksumFile writes the tree hash, the SHA-256 hash, the size and the
+// archive ID of the uploaded file next to it, so that the file can be checked
+// later without hashing the archive again. The checksums of the file computed
+// before the upload, if any, spare reading it once more.
+func writeChecksumFile(fileName string, uploaded *uploader.Result, sums *checksums) error {
+	if sums == nil {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if sums, err = hashFile(file, info.Size()); err != nil {
+			return err
+		}
+	}
+
+	if sums.treeHash != uploaded.Checksum {
+		return fmt.Errorf("%s was changed during the upload, its tree hash is %s instead of %s", fileName, sums.treeHash, uploaded.Checksum)
+	}
+
+	content := fmt.Sprintf("archive-id: %s\nsize: %d\nsha256: %s\ntree-hash: %s\n",
+		uploaded.ArchiveId, sums.size, sums.sha256, uploaded.Checksum)
+	if err := utils.WriteFileAtomic(fileName+checksumFileSuffix, []byte(content), 0644); err != nil {
+		return fmt.Errorf("could not write the checksum file of archive %s: %v", uploaded.ArchiveId, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/uploader"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+func TestChecksumFile(t *testing.T) {
+	defer func(l events.Logger) { logger = l }(logger)
+	logger = events.Discard
+
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "archive")
+	data := make([]byte, 3<<20+1)
+	if err := ioutil.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readChecksumFile(fileName); got != nil {
+		t.Fatalf("got %#v, want no checksums without a checksum file", got)
+	}
+
+	uploaded := &uploader.Result{ArchiveId: "archive", Checksum: *utils.TreeHashBytes(data)}
+	if err := writeChecksumFile(fileName, uploaded, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	want, err := hashFile(file, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want.treeHash != uploaded.Checksum || want.size != int64(len(data)) {
+		t.Fatalf("got %#v, want the tree hash %s and the size %d", want, uploaded.Checksum, len(data))
+	}
+	if got := readChecksumFile(fileName); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	// The checksums of the file before the upload tell a changed file.
+	changed := &uploader.Result{ArchiveId: "archive", Checksum: *utils.TreeHashBytes([]byte("changed"))}
+	if err := writeChecksumFile(fileName, changed, want); err == nil {
+		t.Fatal("got no error, want the file to be reported as changed")
+	}
+
+	if err := ioutil.WriteFile(fileName+checksumFileSuffix, []byte("size: 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := readChecksumFile(fileName); got != nil {
+		t.Fatalf("got %#v, want no checksums from a malformed checksum file", got)
+	}
+}
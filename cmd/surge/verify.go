@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/uploader"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+// The states of the parts of a verified upload.
+const (
+	partMatch    = "match"
+	partMismatch = "mismatch"
+	partMissing  = "missing"
+)
+
+// rangeStatus is a run of consecutive parts of an upload in the same state.
+type rangeStatus struct {
+	Range  string `json:"range"`
+	Parts  int    `json:"parts"`
+	Status string `json:"status"`
+}
+
+// verifyRemote compares the parts of the multipart upload against the file
+// without uploading anything, and reports which ranges match, mismatch or are
+// missing. The mismatched parts are uploaded again when the upload is resumed,
+// but they fail the verification, since they are likely of another file.
+func verifyRemote(service *glacier.Glacier, vaultName, fileName string, source uploader.Source, uploadId string, memoryMap bool) error {
+	input := &uploader.Input{
+		AccountId: globals.accountId,
+		PartSize:  int64(globals.partSize.sizeValue),
+		VaultName: vaultName,
+		FileName:  fileName,
+		Source:    source,
+		UploadId:  uploadId,
+		MemoryMap: memoryMap,
+		Logger:    logger,
+	}
+
+	v, err := uploader.NewClient(service).Verify(input)
+	if err != nil {
+		return err
+	}
+
+	ranges := coalesceRanges(v)
+	logger.Log(&events.Event{
+		Type: events.Completed,
+		Message: fmt.Sprintf("verified upload %s against %s: %d part(s) match, %d mismatch, %d missing",
+			uploadId, fileName, len(v.Matched), len(v.Mismatched), len(v.Missing)),
+		Operation: uploadCommand.name,
+		UploadId:  uploadId,
+	})
+	results.update(func(r *result) {
+		r.Ranges = ranges
+	})
+
+	if globals.output == "text" {
+		if err := printRanges(os.Stdout, ranges); err != nil {
+			return err
+		}
+	}
+
+	if len(v.Mismatched) > 0 {
+		return withCode(exitVerification, fmt.Errorf("%d part(s) of upload %s do not match %s", len(v.Mismatched), uploadId, fileName))
+	}
+	return nil
+}
+
+// coalesceRanges joins the consecutive parts of the verification in the same
+// state, in the order of the offsets.
+func coalesceRanges(v *uploader.Verification) []rangeStatus {
+	type part struct {
+		r      utils.Range
+		status string
+	}
+
+	var parts []part
+	for _, r := range v.Matched {
+		parts = append(parts, part{r, partMatch})
+	}
+	for _, r := range v.Mismatched {
+		parts = append(parts, part{r, partMismatch})
+	}
+	for _, r := range v.Missing {
+		parts = append(parts, part{r, partMissing})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].r.Offset < parts[j].r.Offset
+	})
+
+	ranges := []rangeStatus{}
+	var run utils.Range
+	for i, p := range parts {
+		if i > 0 && p.status == parts[i-1].status {
+			run.Limit += p.r.Limit
+			ranges[len(ranges)-1].Range = run.String()
+			ranges[len(ranges)-1].Parts++
+			continue
+		}
+		run = p.r
+		ranges = append(ranges, rangeStatus{Range: run.String(), Parts: 1, Status: p.status})
+	}
+	return ranges
+}
+
+// printRanges writes the verified ranges of an upload.
+func printRanges(w io.Writer, ranges []rangeStatus) error {
+	t := newTable(nil, "range", "parts", "status")
+	for _, r := range ranges {
+		t.add(r.Range, fmt.Sprint(r.Parts), r.Status)
+	}
+	return t.write(w)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/31z4/surge/pkg/uploader"
+	"github.com/31z4/surge/pkg/utils"
+)
+
+func TestCoalesceRanges(t *testing.T) {
+	v := &uploader.Verification{
+		PartSize:   4,
+		Matched:    []utils.Range{{Offset: 0, Limit: 4}, {Offset: 4, Limit: 4}, {Offset: 16, Limit: 4}},
+		Mismatched: []utils.Range{{Offset: 8, Limit: 4}},
+		Missing:    []utils.Range{{Offset: 12, Limit: 4}, {Offset: 20, Limit: 4}, {Offset: 24, Limit: 1}},
+	}
+
+	got := coalesceRanges(v)
+	want := []rangeStatus{
+		{"0-7", 2, partMatch},
+		{"8-11", 1, partMismatch},
+		{"12-15", 1, partMissing},
+		{"16-19", 1, partMatch},
+		{"20-24", 2, partMissing},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	if got := coalesceRanges(&uploader.Verification{}); len(got) != 0 {
+		t.Fatalf("got %#v, want no ranges", got)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// version is the version of surge, stamped at build time with
+// -ldflags "-X main.version=1.4.0".
+var version = "dev"
+
+var versionCommand = &command{
+	name:        "version",
+	summary:     "Print the version of surge",
+	description: "Print the version of surge, which is also sent in the User-Agent of every request",
+}
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	versionCommand.setup = setupVersion
+}
+
+func setupVersion(flags *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		if len(args) != 0 {
+			return newUsageError(versionCommand, "expected no arguments, got %d argument(s)", len(args))
+		}
+
+		fmt.Fprintf(os.Stdout, "surge %s\n", version)
+		return nil
+	}
+}
+
+// userAgent identifies the requests of the command in CloudTrail and in
+// AWS support cases, e.g. surge/1.4.0 (upload; jobs=8).
+func userAgent(command string, jobs int) string {
+	return fmt.Sprintf("surge/%s (%s; jobs=%d)", version, command, jobs)
+}
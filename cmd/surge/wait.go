@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+var waitCommand = &command{
+	name:    "wait",
+	args:    "VAULT|REMOTE: JOB_ID",
+	summary: "Wait for a job to complete",
+	description: "Wait until the job of the Amazon Glacier vault succeeds or fails, checking it every\n" +
+		"poll interval. The exit status tells a succeeded job, a failed one and a timeout\n" +
+		"apart, so that a script can sequence a retrieval and its download.",
+}
+
+func init() {
+	// Assigned here since the setup refers to the command itself.
+	waitCommand.setup = setupWait
+}
+
+func setupWait(flags *flag.FlagSet) func(args []string) error {
+	timeout := flags.Duration("timeout", 0, "give up once the job is still in progress after the `duration`, 0 waits indefinitely")
+	pollInterval := flags.Duration("poll-interval", 15*time.Minute, "the `interval` of checking the job")
+
+	return func(args []string) error {
+		if len(args) != 2 {
+			return newUsageError(waitCommand, "expected VAULT and JOB_ID arguments, got %d argument(s)", len(args))
+		}
+		target, jobId := args[0], args[1]
+
+		if *timeout < 0 {
+			return newUsageError(waitCommand, "-timeout must not be negative")
+		}
+		if *pollInterval <= 0 {
+			return newUsageError(waitCommand, "-poll-interval must be positive")
+		}
+
+		service, vaultName, err := globals.start(waitCommand.name, target, "")
+		if err != nil {
+			return err
+		}
+		results.update(func(r *result) {
+			r.JobId = jobId
+		})
+
+		describe := func() (*glacier.DescribeJobOutput, error) {
+			return service.DescribeJobRequest(&glacier.DescribeJobInput{
+				AccountId: &globals.accountId,
+				JobId:     &jobId,
+				VaultName: &vaultName,
+			}).Send()
+		}
+
+		start := time.Now()
+		job, err := waitJob(describe, *pollInterval, *timeout)
+		if job != nil {
+			results.update(func(r *result) {
+				r.Jobs = []jobInfo{newJobInfo(job)}
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		logger.Log(&events.Event{
+			Type:      events.Completed,
+			Message:   fmt.Sprintf("job %s succeeded after waiting %v", jobId, time.Since(start).Round(time.Second)),
+			Operation: waitCommand.name,
+			JobId:     jobId,
+			ArchiveId: aws.StringValue(job.ArchiveId),
+		})
+		return nil
+	}
+}
+
+// waitJob describes the job every interval until it is completed, or until
+// it is still in progress after the timeout, zero for no timeout. It returns
+// the last description of the job, along with an error exiting with
+// exitJobFailed if the job failed, or exitJobNotReady on the timeout.
+func waitJob(describe func() (*glacier.DescribeJobOutput, error), interval, timeout time.Duration) (*glacier.DescribeJobOutput, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	waiting := false
+	for {
+		job, err := describe()
+		if err != nil {
+			return nil, err
+		}
+		jobId := aws.StringValue(job.JobId)
+
+		switch status := string(job.StatusCode); status {
+		case "Succeeded":
+			return job, nil
+		case "Failed":
+			return job, withCode(exitJobFailed, fmt.Errorf("job %s failed: %s", jobId, aws.StringValue(job.StatusMessage)))
+		case "InProgress":
+		default:
+			return job, fmt.Errorf("job %s has an unexpected status %s", jobId, status)
+		}
+
+		sleep := interval
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return job, withCode(exitJobNotReady, fmt.Errorf("job %s is still in progress after %v", jobId, timeout))
+			}
+			if left < sleep {
+				sleep = left
+			}
+		}
+
+		if !waiting {
+			waiting = true
+			logger.Log(&events.Event{
+				Type:      events.CheckStarted,
+				Message:   fmt.Sprintf("waiting for job %s, checking it every %v", jobId, interval),
+				Operation: waitCommand.name,
+				JobId:     jobId,
+			})
+		}
+		time.Sleep(sleep)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+func TestWaitJob(t *testing.T) {
+	defer func(l events.Logger) { logger = l }(logger)
+	logger = events.Discard
+
+	cases := []struct {
+		name     string
+		statuses []string
+		timeout  time.Duration
+		code     int
+		checks   int
+	}{
+		{"succeeded", []string{"InProgress", "InProgress", "Succeeded"}, 0, exitOK, 3},
+		{"failed", []string{"InProgress", "Failed"}, 0, exitJobFailed, 2},
+		{"timeout", []string{"InProgress"}, 5 * time.Millisecond, exitJobNotReady, 0},
+		{"unexpected", []string{"Paused"}, 0, exitError, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			checks := 0
+			describe := func() (*glacier.DescribeJobOutput, error) {
+				status := c.statuses[len(c.statuses)-1]
+				if checks < len(c.statuses) {
+					status = c.statuses[checks]
+				}
+				checks++
+				return &glacier.DescribeJobOutput{
+					JobId:         aws.String("job"),
+					StatusCode:    glacier.StatusCode(status),
+					StatusMessage: aws.String("message"),
+				}, nil
+			}
+
+			job, err := waitJob(describe, time.Millisecond, c.timeout)
+			if got := exitCode(err); got != c.code {
+				t.Fatalf("got exit code %d (%v), want %d", got, err, c.code)
+			}
+			if job == nil || aws.StringValue(job.JobId) != "job" {
+				t.Fatalf("got %#v, want the last description of the job", job)
+			}
+			// The checks before a timeout depend on the scheduling.
+			if c.checks > 0 && checks != c.checks {
+				t.Fatalf("got %d check(s), want %d", checks, c.checks)
+			}
+		})
+	}
+}
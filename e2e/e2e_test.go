@@ -0,0 +1,327 @@
+//go:build e2e
+
+// Package e2e runs the surge command against an Amazon Glacier emulator.
+//
+// The tests are opt-in, run them with
+//
+//	go test -tags e2e ./e2e
+//
+// By default a LocalStack container is started with docker, set
+// SURGE_E2E_IMAGE to use another image serving Glacier on port 4566 or
+// SURGE_E2E_ENDPOINT to use an emulator that is already running.
+package e2e
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+const (
+	defaultImage = "localstack/localstack"
+	partSize     = 1 << 20
+)
+
+var (
+	endpoint string
+	binary   string
+	service  *glacier.Glacier
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	dir, err := ioutil.TempDir("", "surge-e2e")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	binary = filepath.Join(dir, "surge")
+	build := exec.Command("go", "build", "-o", binary, "github.com/31z4/surge/cmd/surge")
+	build.Stdout, build.Stderr = os.Stderr, os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "could not build surge:", err)
+		return 1
+	}
+
+	endpoint = os.Getenv("SURGE_E2E_ENDPOINT")
+	if endpoint == "" {
+		var stop func()
+		endpoint, stop, err = startEmulator()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "could not start the emulator:", err)
+			return 1
+		}
+		defer stop()
+	}
+
+	config := defaults.Config()
+	config.Region = "us-east-1"
+	config.Credentials = aws.NewStaticCredentialsProvider("test", "test", "")
+	config.EndpointResolver = aws.ResolveWithEndpointURL(endpoint)
+	service = glacier.New(config)
+
+	return m.Run()
+}
+
+// startEmulator starts the emulator container and waits until it accepts
+// the requests. The returned function removes the container.
+func startEmulator() (string, func(), error) {
+	image := os.Getenv("SURGE_E2E_IMAGE")
+	if image == "" {
+		image = defaultImage
+	}
+
+	out, err := exec.Command("docker", "run", "--rm", "-d", "-e", "SERVICES=glacier", "-p", "127.0.0.1::4566", image).Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("docker run %s: %v", image, err)
+	}
+
+	id := strings.TrimSpace(string(out))
+	stop := func() {
+		exec.Command("docker", "rm", "-f", id).Run()
+	}
+
+	out, err = exec.Command("docker", "port", id, "4566/tcp").Output()
+	if err != nil {
+		stop()
+		return "", nil, fmt.Errorf("docker port: %v", err)
+	}
+	address := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+	url := "http://" + address
+
+	for deadline := time.Now().Add(2 * time.Minute); ; time.Sleep(time.Second) {
+		if response, err := http.Get(url); err == nil {
+			response.Body.Close()
+			return url, stop, nil
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return "", nil, fmt.Errorf("%s is not ready", url)
+		}
+	}
+}
+
+// result is the part of the command result checked by the tests.
+type result struct {
+	ArchiveId string `json:"archive_id"`
+	Checksum  string `json:"checksum"`
+	Parts     int64  `json:"parts"`
+	ExitCode  int    `json:"exit_code"`
+	Error     string `json:"error"`
+}
+
+// surge runs the command and returns its result.
+func surge(t *testing.T, dir string, args ...string) *result {
+	t.Helper()
+
+	global := []string{
+		"-endpoint-url", endpoint,
+		"-catalog", filepath.Join(dir, "catalog.json"),
+		"-config", filepath.Join(dir, "config"),
+		"-part-size", fmt.Sprint(partSize),
+		"-jobs", "4",
+		"-output", "json",
+		"-yes",
+	}
+
+	cmd := exec.Command(binary, append(global, args...)...)
+	cmd.Env = append(os.Environ(),
+		"AWS_ACCESS_KEY_ID=test",
+		"AWS_SECRET_ACCESS_KEY=test",
+		"AWS_REGION=us-east-1",
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err := cmd.Run()
+
+	var r result
+	if decodeErr := json.Unmarshal(stdout.Bytes(), &r); decodeErr != nil {
+		t.Fatalf("surge %s: %v\n%s", strings.Join(args, " "), decodeErr, stderr.String())
+	}
+	if err != nil || r.ExitCode != 0 {
+		t.Fatalf("surge %s: %v: %s\n%s", strings.Join(args, " "), err, r.Error, stderr.String())
+	}
+
+	return &r
+}
+
+// setup creates a vault and a file of random data.
+func setup(t *testing.T, size int) (dir, vaultName, fileName string, data []byte) {
+	dir, err := ioutil.TempDir("", "surge-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vaultName = strings.Replace(t.Name(), "/", "-", -1) + fmt.Sprint("-", time.Now().UnixNano())
+	_, err = service.CreateVaultRequest(&glacier.CreateVaultInput{
+		AccountId: aws.String("-"),
+		VaultName: &vaultName,
+	}).Send()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("could not create the vault: %v", err)
+	}
+
+	data = make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	fileName = filepath.Join(dir, "archive")
+	if err := ioutil.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, vaultName, fileName, data
+}
+
+// retrieve initiates the archive retrieval job and waits until it completes.
+func retrieve(t *testing.T, vaultName, archiveId string) string {
+	t.Helper()
+
+	job, err := service.InitiateJobRequest(&glacier.InitiateJobInput{
+		AccountId: aws.String("-"),
+		VaultName: &vaultName,
+		JobParameters: &glacier.JobParameters{
+			Type:      aws.String("archive-retrieval"),
+			ArchiveId: &archiveId,
+		},
+	}).Send()
+	if err != nil {
+		t.Fatalf("could not initiate the retrieval: %v", err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Minute); ; time.Sleep(time.Second) {
+		description, err := service.DescribeJobRequest(&glacier.DescribeJobInput{
+			AccountId: aws.String("-"),
+			VaultName: &vaultName,
+			JobId:     job.JobId,
+		}).Send()
+		if err != nil {
+			t.Fatalf("could not describe the retrieval: %v", err)
+		}
+
+		if aws.BoolValue(description.Completed) {
+			return *job.JobId
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the retrieval job %s did not complete", *job.JobId)
+		}
+	}
+}
+
+// download downloads the archive and checks that it has the data.
+func download(t *testing.T, dir, vaultName, archiveId string, data []byte) {
+	t.Helper()
+
+	jobId := retrieve(t, vaultName, archiveId)
+	fileName := filepath.Join(dir, "downloaded")
+	surge(t, dir, "download", "-job-id", jobId, vaultName, fileName)
+
+	downloaded, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Fatalf("the downloaded file differs from the uploaded one")
+	}
+}
+
+func TestUploadDownload(t *testing.T) {
+	dir, vaultName, fileName, data := setup(t, 3*partSize+partSize/2)
+	defer os.RemoveAll(dir)
+
+	r := surge(t, dir, "upload", vaultName, fileName)
+	if r.ArchiveId == "" {
+		t.Fatal("no archive ID")
+	}
+	if want := *utils.TreeHashBytes(data); r.Checksum != want {
+		t.Fatalf("got %#v, want %#v", r.Checksum, want)
+	}
+
+	download(t, dir, vaultName, r.ArchiveId, data)
+}
+
+func TestResume(t *testing.T) {
+	dir, vaultName, fileName, data := setup(t, 3*partSize)
+	defer os.RemoveAll(dir)
+
+	upload, err := service.InitiateMultipartUploadRequest(&glacier.InitiateMultipartUploadInput{
+		AccountId: aws.String("-"),
+		VaultName: &vaultName,
+		PartSize:  aws.String(fmt.Sprint(partSize)),
+	}).Send()
+	if err != nil {
+		t.Fatalf("could not initiate the upload: %v", err)
+	}
+
+	// Upload the first part only, as an interrupted upload would.
+	_, err = service.UploadMultipartPartRequest(&glacier.UploadMultipartPartInput{
+		AccountId: aws.String("-"),
+		VaultName: &vaultName,
+		UploadId:  upload.UploadId,
+		Body:      bytes.NewReader(data[:partSize]),
+		Checksum:  utils.TreeHashBytes(data[:partSize]),
+		Range:     aws.String(fmt.Sprintf("bytes 0-%d/*", partSize-1)),
+	}).Send()
+	if err != nil {
+		t.Fatalf("could not upload the first part: %v", err)
+	}
+
+	r := surge(t, dir, "upload", "-upload-id", *upload.UploadId, vaultName, fileName)
+	if r.Parts != 2 {
+		t.Fatalf("got %d parts uploaded, want 2", r.Parts)
+	}
+
+	download(t, dir, vaultName, r.ArchiveId, data)
+}
+
+func TestAbort(t *testing.T) {
+	dir, vaultName, _, _ := setup(t, 0)
+	defer os.RemoveAll(dir)
+
+	upload, err := service.InitiateMultipartUploadRequest(&glacier.InitiateMultipartUploadInput{
+		AccountId: aws.String("-"),
+		VaultName: &vaultName,
+		PartSize:  aws.String(fmt.Sprint(partSize)),
+	}).Send()
+	if err != nil {
+		t.Fatalf("could not initiate the upload: %v", err)
+	}
+
+	surge(t, dir, "abort", vaultName, *upload.UploadId)
+
+	uploads, err := service.ListMultipartUploadsRequest(&glacier.ListMultipartUploadsInput{
+		AccountId: aws.String("-"),
+		VaultName: &vaultName,
+	}).Send()
+	if err != nil {
+		t.Fatalf("could not list the uploads: %v", err)
+	}
+	for _, u := range uploads.UploadsList {
+		if aws.StringValue(u.MultipartUploadId) == *upload.UploadId {
+			t.Fatalf("the upload %s was not aborted", *upload.UploadId)
+		}
+	}
+}
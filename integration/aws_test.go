@@ -0,0 +1,282 @@
+//go:build integration
+
+// Package integration runs surge against Amazon Glacier in a real AWS account.
+//
+// The tests are opt-in, they run only with the integration build tag and
+// SURGE_TEST_VAULT set to the name of an existing vault dedicated to them:
+//
+//	SURGE_TEST_VAULT=surge-test go test -tags integration ./integration
+//
+// The credentials and the region are resolved like by the surge command,
+// SURGE_TEST_PROFILE and SURGE_TEST_REGION select the profile and the region.
+// The archives uploaded by the tests are deleted, which incurs the early
+// deletion fee of Glacier for the few bytes uploaded.
+package integration
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/31z4/surge/pkg/awsconfig"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/uploader"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+const partSize = 1 << 20
+
+// setup returns the Glacier service and the name of the test vault, or skips
+// the test if the vault is not given.
+func setup(t *testing.T) (*glacier.Glacier, string) {
+	vaultName := os.Getenv("SURGE_TEST_VAULT")
+	if vaultName == "" {
+		t.Skip("SURGE_TEST_VAULT is not set")
+	}
+
+	config, err := awsconfig.Load(&awsconfig.Options{
+		Profile:     os.Getenv("SURGE_TEST_PROFILE"),
+		Region:      os.Getenv("SURGE_TEST_REGION"),
+		Connections: 4,
+	})
+	if err != nil {
+		t.Fatalf("could not load the AWS config: %v", err)
+	}
+
+	return glacier.New(config), vaultName
+}
+
+// checkRequestFailure checks that the error is the service error with the code and status.
+func checkRequestFailure(t *testing.T, err error, code string, status int) {
+	t.Helper()
+
+	failure, ok := err.(awserr.RequestFailure)
+	if !ok {
+		t.Fatalf("got %#v, want a request failure", err)
+	}
+	if failure.Code() != code || failure.StatusCode() != status {
+		t.Fatalf("got %s (%d), want %s (%d)", failure.Code(), failure.StatusCode(), code, status)
+	}
+	if failure.RequestID() == "" {
+		t.Fatal("the request ID is empty")
+	}
+}
+
+func deleteArchive(t *testing.T, service *glacier.Glacier, vaultName, archiveId string) {
+	_, err := service.DeleteArchiveRequest(&glacier.DeleteArchiveInput{
+		AccountId: aws.String("-"),
+		VaultName: &vaultName,
+		ArchiveId: &archiveId,
+	}).Send()
+	if err != nil {
+		t.Errorf("could not delete the archive %s: %v", archiveId, err)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	service, vaultName := setup(t)
+
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, partSize+1)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := filepath.Join(dir, "archive")
+	if err := ioutil.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var archiveId string
+	logger := loggerFunc(func(e *events.Event) {
+		if e.Type == events.Completed {
+			archiveId = e.ArchiveId
+		}
+	})
+
+	input := &uploader.Input{
+		AccountId: "-",
+		VaultName: vaultName,
+		FileName:  fileName,
+		PartSize:  partSize,
+		Logger:    logger,
+	}
+	if _, err := uploader.New(service, input).Upload(2); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	if archiveId == "" {
+		t.Fatal("no archive ID")
+	}
+	deleteArchive(t, service, vaultName, archiveId)
+}
+
+// loggerFunc is a logger calling the function with every event.
+type loggerFunc func(e *events.Event)
+
+func (f loggerFunc) Log(e *events.Event) {
+	f(e)
+}
+
+func TestAbort(t *testing.T) {
+	service, vaultName := setup(t)
+
+	upload, err := service.InitiateMultipartUploadRequest(&glacier.InitiateMultipartUploadInput{
+		AccountId: aws.String("-"),
+		VaultName: &vaultName,
+		PartSize:  aws.String(fmt.Sprint(partSize)),
+	}).Send()
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	_, err = service.AbortMultipartUploadRequest(&glacier.AbortMultipartUploadInput{
+		AccountId: aws.String("-"),
+		VaultName: &vaultName,
+		UploadId:  upload.UploadId,
+	}).Send()
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	// The aborted upload is gone, which the resume relies on to fail cleanly.
+	_, err = service.ListPartsRequest(&glacier.ListPartsInput{
+		AccountId: aws.String("-"),
+		VaultName: &vaultName,
+		UploadId:  upload.UploadId,
+	}).Send()
+	checkRequestFailure(t, err, "ResourceNotFoundException", http.StatusNotFound)
+}
+
+func TestErrors(t *testing.T) {
+	service, vaultName := setup(t)
+
+	t.Run("missing vault", func(t *testing.T) {
+		_, err := service.DescribeVaultRequest(&glacier.DescribeVaultInput{
+			AccountId: aws.String("-"),
+			VaultName: aws.String(vaultName + "-missing"),
+		}).Send()
+		checkRequestFailure(t, err, "ResourceNotFoundException", http.StatusNotFound)
+	})
+
+	t.Run("invalid part size", func(t *testing.T) {
+		_, err := service.InitiateMultipartUploadRequest(&glacier.InitiateMultipartUploadInput{
+			AccountId: aws.String("-"),
+			VaultName: &vaultName,
+			PartSize:  aws.String(fmt.Sprint(partSize + 1)),
+		}).Send()
+		checkRequestFailure(t, err, "InvalidParameterValueException", http.StatusBadRequest)
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		upload, err := service.InitiateMultipartUploadRequest(&glacier.InitiateMultipartUploadInput{
+			AccountId: aws.String("-"),
+			VaultName: &vaultName,
+			PartSize:  aws.String(fmt.Sprint(partSize)),
+		}).Send()
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		defer service.AbortMultipartUploadRequest(&glacier.AbortMultipartUploadInput{
+			AccountId: aws.String("-"),
+			VaultName: &vaultName,
+			UploadId:  upload.UploadId,
+		}).Send()
+
+		data := []byte("test")
+		_, err = service.UploadMultipartPartRequest(&glacier.UploadMultipartPartInput{
+			AccountId: aws.String("-"),
+			VaultName: &vaultName,
+			UploadId:  upload.UploadId,
+			Body:      bytes.NewReader(data),
+			Checksum:  utils.TreeHashBytes([]byte("tset")),
+			Range:     aws.String("bytes 0-3/*"),
+		}).Send()
+		checkRequestFailure(t, err, "InvalidParameterValueException", http.StatusBadRequest)
+	})
+}
+
+// throttlingCodes are the error codes of the throttled requests.
+var throttlingCodes = map[string]bool{
+	"ThrottlingException":      true,
+	"RequestLimitExceeded":     true,
+	"TooManyRequestsException": true,
+}
+
+// TestThrottling bursts the requests to check that the SDK retries the
+// throttled ones until they succeed. It is slow, so set SURGE_TEST_THROTTLING
+// to run it.
+func TestThrottling(t *testing.T) {
+	service, vaultName := setup(t)
+	if os.Getenv("SURGE_TEST_THROTTLING") == "" {
+		t.Skip("SURGE_TEST_THROTTLING is not set")
+	}
+
+	const requests = 200
+
+	var (
+		wg        sync.WaitGroup
+		mutex     sync.Mutex
+		retried   = make(map[string]int)
+		failures  []error
+		semaphore = make(chan struct{}, 50)
+	)
+
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			request := service.DescribeVaultRequest(&glacier.DescribeVaultInput{
+				AccountId: aws.String("-"),
+				VaultName: &vaultName,
+			})
+			events.OnRetry(request.Request, func(attempt int, err error) {
+				code := err.Error()
+				if e, ok := err.(awserr.Error); ok {
+					code = e.Code()
+				}
+
+				mutex.Lock()
+				retried[code]++
+				mutex.Unlock()
+			})
+
+			if _, err := request.Send(); err != nil {
+				mutex.Lock()
+				failures = append(failures, err)
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failures) != 0 {
+		t.Errorf("%d of %d requests failed, the first with %v", len(failures), requests, failures[0])
+	}
+	for code, n := range retried {
+		if !throttlingCodes[code] {
+			t.Errorf("%d request(s) retried after an unexpected %s", n, code)
+		}
+	}
+	t.Logf("retried requests: %v", retried)
+}
@@ -0,0 +1,252 @@
+package mocks
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+// Faults describes the failures injected into the requests, either by the
+// Glacier mock or by a fake server wrapped with Handler. The zero value
+// injects no failures. It is safe for concurrent use.
+type Faults struct {
+	// The numbers of the requests that fail with an internal server error and
+	// that time out, counting every attempt from one.
+	FailRequests    []int
+	TimeoutRequests []int
+
+	// The rates of the requests failing with an internal server error and
+	// timing out, from 0 to 1.
+	ErrorRate   float64
+	TimeoutRate float64
+
+	// The rate of the responses whose checksum is corrupted, from 0 to 1.
+	CorruptRate float64
+
+	// Latency delays every request.
+	Latency time.Duration
+
+	// The seed of the random failures, so that they are reproducible.
+	Seed int64
+
+	// The injected failures are retried by the mock up to MaxRetries times,
+	// the way the SDK retries them.
+	MaxRetries int
+
+	mutex    sync.Mutex
+	requests int
+	random   *rand.Rand
+}
+
+// fault is a failure injected into a request.
+type fault int
+
+const (
+	noFault fault = iota
+	internalError
+	timeout
+	corruptChecksum
+)
+
+// Requests returns the number of the requests seen so far.
+func (f *Faults) Requests() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.requests
+}
+
+// next decides the fault of the next request.
+func (f *Faults) next() fault {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.requests++
+	if f.random == nil {
+		f.random = rand.New(rand.NewSource(f.Seed))
+	}
+
+	for _, n := range f.FailRequests {
+		if n == f.requests {
+			return internalError
+		}
+	}
+	for _, n := range f.TimeoutRequests {
+		if n == f.requests {
+			return timeout
+		}
+	}
+
+	if p := f.random.Float64(); p < f.ErrorRate {
+		return internalError
+	} else if p < f.ErrorRate+f.TimeoutRate {
+		return timeout
+	}
+
+	if f.random.Float64() < f.CorruptRate {
+		return corruptChecksum
+	}
+	return noFault
+}
+
+// timeoutError is a network timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout (injected)" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// injectedError reports whether the error was injected.
+func injectedError(err error) bool {
+	if e, ok := err.(awserr.RequestFailure); ok {
+		return e.Code() == "InternalFailure"
+	}
+	if e, ok := err.(awserr.Error); ok {
+		_, ok := e.OrigErr().(timeoutError)
+		return ok
+	}
+	return false
+}
+
+// Inject returns a copy of the request failing according to the faults.
+// The original request is left intact, so that fixtures may be shared.
+func (f *Faults) Inject(r *aws.Request) *aws.Request {
+	if r == nil {
+		return nil
+	}
+
+	injected := *r
+	injected.Handlers = r.Handlers.Copy()
+
+	// Set up enough of the request for the SDK to retry it.
+	if injected.HTTPRequest == nil {
+		injected.HTTPRequest, _ = http.NewRequest("POST", "https://glacier.mock/", nil)
+	}
+	if injected.Body == nil {
+		injected.Body = bytes.NewReader(nil)
+	}
+	if injected.Operation == nil {
+		injected.Operation = &aws.Operation{}
+	}
+
+	injected.Handlers.Send.PushBack(func(r *aws.Request) {
+		fault := f.next()
+		time.Sleep(f.Latency)
+
+		switch fault {
+		case internalError:
+			r.Error = awserr.NewRequestFailure(awserr.New("InternalFailure", "injected failure", nil), http.StatusInternalServerError, "")
+		case timeout:
+			r.Error = awserr.New("RequestError", "send request failed", timeoutError{})
+		case corruptChecksum:
+			r.Data = corruptData(r.Data)
+		}
+	})
+	injected.Handlers.Retry.PushBack(func(r *aws.Request) {
+		if injectedError(r.Error) && r.RetryCount < f.MaxRetries {
+			r.Retryable = aws.Bool(true)
+		}
+	})
+	injected.Handlers.AfterRetry.PushBack(func(r *aws.Request) {
+		if aws.BoolValue(r.Retryable) {
+			r.RetryCount++
+			r.Error = nil
+		}
+	})
+
+	return &injected
+}
+
+// corrupt returns a different hex encoded checksum.
+func corrupt(checksum string) string {
+	if checksum == "" {
+		return "0"
+	}
+
+	last := checksum[len(checksum)-1]
+	if last == '0' {
+		return checksum[:len(checksum)-1] + "1"
+	}
+	return checksum[:len(checksum)-1] + "0"
+}
+
+// corruptData returns a copy of the output with its checksum corrupted.
+func corruptData(data interface{}) interface{} {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return data
+	}
+
+	copied := reflect.New(v.Elem().Type())
+	copied.Elem().Set(v.Elem())
+
+	for _, name := range []string{"Checksum", "SHA256TreeHash"} {
+		field := copied.Elem().FieldByName(name)
+		if field.IsValid() && field.Type() == reflect.TypeOf((*string)(nil)) {
+			field.Set(reflect.ValueOf(aws.String(corrupt(aws.StringValue(field.Interface().(*string))))))
+		}
+	}
+
+	return copied.Interface()
+}
+
+// treeHashHeader is the response header carrying the checksum.
+const treeHashHeader = "X-Amz-Sha256-Tree-Hash"
+
+// Handler wraps the handler of a fake Glacier server with the faults. The
+// timed out requests have their connection dropped without a response.
+func (f *Faults) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fault := f.next()
+		time.Sleep(f.Latency)
+
+		switch fault {
+		case internalError:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"code":"ServiceUnavailableException","message":"injected failure","type":"Server"}`)
+		case timeout:
+			if hijacker, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hijacker.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			panic(http.ErrAbortHandler)
+		case corruptChecksum:
+			next.ServeHTTP(&corruptingWriter{ResponseWriter: w}, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// corruptingWriter corrupts the checksum header of the response.
+type corruptingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *corruptingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if checksum := w.Header().Get(treeHashHeader); checksum != "" {
+			w.Header().Set(treeHashHeader, corrupt(checksum))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *corruptingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
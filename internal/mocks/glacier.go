@@ -2,8 +2,11 @@
 package mocks
 
 import (
+	"net/http"
+	"sync"
 	"sync/atomic"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/glacier"
 	"github.com/aws/aws-sdk-go-v2/service/glacier/glacieriface"
 )
@@ -14,76 +17,578 @@ type Glacier struct {
 
 	CallCount uint32
 
+	// Faults are injected into the mocked requests if set.
+	Faults *Faults
+
+	mutex  sync.Mutex
+	inputs []interface{}
+
 	InitiateMultipartUploadRequestMock func() glacier.InitiateMultipartUploadRequest
 	ListPartsRequestMock               func() glacier.ListPartsRequest
 	UploadMultipartPartRequestMock     func() glacier.UploadMultipartPartRequest
 	CompleteMultipartUploadRequestMock func() glacier.CompleteMultipartUploadRequest
+	UploadArchiveRequestMock           func() glacier.UploadArchiveRequest
 	DescribeJobRequestMock             func() glacier.DescribeJobRequest
 	GetJobOutputRequestMock            func() glacier.GetJobOutputRequest
+	InitiateJobRequestMock             func() glacier.InitiateJobRequest
+	ListJobsRequestMock                func() glacier.ListJobsRequest
+	DescribeVaultRequestMock           func() glacier.DescribeVaultRequest
+	CreateVaultRequestMock             func() glacier.CreateVaultRequest
+	DeleteVaultRequestMock             func() glacier.DeleteVaultRequest
+	ListVaultsRequestMock              func() glacier.ListVaultsRequest
+	ListMultipartUploadsRequestMock    func() glacier.ListMultipartUploadsRequest
+	AbortMultipartUploadRequestMock    func() glacier.AbortMultipartUploadRequest
+	DeleteArchiveRequestMock           func() glacier.DeleteArchiveRequest
+	AddTagsToVaultRequestMock          func() glacier.AddTagsToVaultRequest
+	RemoveTagsFromVaultRequestMock     func() glacier.RemoveTagsFromVaultRequest
+	ListTagsForVaultRequestMock        func() glacier.ListTagsForVaultRequest
+	ListProvisionedCapacityRequestMock func() glacier.ListProvisionedCapacityRequest
 }
 
 // InitiateMultipartUploadRequest returns a mocked request value for making API operation for Amazon Glacier.
 // It calls InitiateMultipartUploadRequestMock if set and returns uninitialized InitiateMultipartUploadRequest otherwise.
-// Calling this method increases CallCount.
-func (g *Glacier) InitiateMultipartUploadRequest(*glacier.InitiateMultipartUploadInput) glacier.InitiateMultipartUploadRequest {
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) InitiateMultipartUploadRequest(input *glacier.InitiateMultipartUploadInput) glacier.InitiateMultipartUploadRequest {
 	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
 	if g.InitiateMultipartUploadRequestMock != nil {
-		return g.InitiateMultipartUploadRequestMock()
+		request := g.InitiateMultipartUploadRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
 	}
 	return glacier.InitiateMultipartUploadRequest{}
 }
 
 // ListPartsRequest returns a mocked request value for making API operation for Amazon Glacier.
 // It calls ListPartsRequestMock if set and returns uninitialized ListPartsRequest otherwise.
-// Calling this method increases CallCount.
-func (g *Glacier) ListPartsRequest(*glacier.ListPartsInput) glacier.ListPartsRequest {
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) ListPartsRequest(input *glacier.ListPartsInput) glacier.ListPartsRequest {
 	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
 	if g.ListPartsRequestMock != nil {
-		return g.ListPartsRequestMock()
+		request := g.ListPartsRequestMock()
+		request.Request = g.inject(request.Request)
+		if copyRequest := request.Copy; copyRequest != nil {
+			request.Copy = func(input *glacier.ListPartsInput) glacier.ListPartsRequest {
+				copied := copyRequest(input)
+				copied.Request = g.inject(copied.Request)
+				return copied
+			}
+		}
+		return request
 	}
 	return glacier.ListPartsRequest{}
 }
 
 // UploadMultipartPartRequest returns a mocked request value for making API operation for Amazon Glacier.
 // It calls UploadMultipartPartRequestMock if set and returns uninitialized UploadMultipartPartRequest otherwise.
-// Calling this method increases CallCount.
-func (g *Glacier) UploadMultipartPartRequest(*glacier.UploadMultipartPartInput) glacier.UploadMultipartPartRequest {
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) UploadMultipartPartRequest(input *glacier.UploadMultipartPartInput) glacier.UploadMultipartPartRequest {
 	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
 	if g.UploadMultipartPartRequestMock != nil {
-		return g.UploadMultipartPartRequestMock()
+		request := g.UploadMultipartPartRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
 	}
 	return glacier.UploadMultipartPartRequest{}
 }
 
 // CompleteMultipartUploadRequest returns a mocked request value for making API operation for Amazon Glacier.
 // It calls CompleteMultipartUploadRequestMock if set and returns uninitialized CompleteMultipartUploadRequest otherwise.
-// Calling this method increases CallCount.
-func (g *Glacier) CompleteMultipartUploadRequest(*glacier.CompleteMultipartUploadInput) glacier.CompleteMultipartUploadRequest {
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) CompleteMultipartUploadRequest(input *glacier.CompleteMultipartUploadInput) glacier.CompleteMultipartUploadRequest {
 	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
 	if g.CompleteMultipartUploadRequestMock != nil {
-		return g.CompleteMultipartUploadRequestMock()
+		request := g.CompleteMultipartUploadRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
 	}
 	return glacier.CompleteMultipartUploadRequest{}
 }
 
+// UploadArchiveRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls UploadArchiveRequestMock if set and returns uninitialized UploadArchiveRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) UploadArchiveRequest(input *glacier.UploadArchiveInput) glacier.UploadArchiveRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.UploadArchiveRequestMock != nil {
+		request := g.UploadArchiveRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
+	}
+	return glacier.UploadArchiveRequest{}
+}
+
 // DescribeJobRequest returns a mocked request value for making API operation for Amazon Glacier.
 // It calls DescribeJobRequestMock if set and returns uninitialized DescribeJobRequest otherwise.
-// Calling this method increases CallCount.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
 func (g *Glacier) DescribeJobRequest(input *glacier.DescribeJobInput) glacier.DescribeJobRequest {
 	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
 	if g.DescribeJobRequestMock != nil {
-		return g.DescribeJobRequestMock()
+		request := g.DescribeJobRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
 	}
 	return glacier.DescribeJobRequest{}
 }
 
 // GetJobOutputRequest returns a mocked request value for making API operation for Amazon Glacier.
 // It calls GetJobOutputRequestMock if set and returns uninitialized GetJobOutputRequest otherwise.
-// Calling this method increases CallCount.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
 func (g *Glacier) GetJobOutputRequest(input *glacier.GetJobOutputInput) glacier.GetJobOutputRequest {
 	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
 	if g.GetJobOutputRequestMock != nil {
-		return g.GetJobOutputRequestMock()
+		request := g.GetJobOutputRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
 	}
 	return glacier.GetJobOutputRequest{}
 }
+
+// InitiateJobRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls InitiateJobRequestMock if set and returns uninitialized InitiateJobRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) InitiateJobRequest(input *glacier.InitiateJobInput) glacier.InitiateJobRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.InitiateJobRequestMock != nil {
+		request := g.InitiateJobRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
+	}
+	return glacier.InitiateJobRequest{}
+}
+
+// ListJobsRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls ListJobsRequestMock if set and returns uninitialized ListJobsRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) ListJobsRequest(input *glacier.ListJobsInput) glacier.ListJobsRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.ListJobsRequestMock != nil {
+		request := g.ListJobsRequestMock()
+		request.Request = g.inject(request.Request)
+		if copyRequest := request.Copy; copyRequest != nil {
+			request.Copy = func(input *glacier.ListJobsInput) glacier.ListJobsRequest {
+				copied := copyRequest(input)
+				copied.Request = g.inject(copied.Request)
+				return copied
+			}
+		}
+		return request
+	}
+	return glacier.ListJobsRequest{}
+}
+
+// DescribeVaultRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls DescribeVaultRequestMock if set and returns uninitialized DescribeVaultRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) DescribeVaultRequest(input *glacier.DescribeVaultInput) glacier.DescribeVaultRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.DescribeVaultRequestMock != nil {
+		request := g.DescribeVaultRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
+	}
+	return glacier.DescribeVaultRequest{}
+}
+
+// CreateVaultRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls CreateVaultRequestMock if set and returns uninitialized CreateVaultRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) CreateVaultRequest(input *glacier.CreateVaultInput) glacier.CreateVaultRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.CreateVaultRequestMock != nil {
+		request := g.CreateVaultRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
+	}
+	return glacier.CreateVaultRequest{}
+}
+
+// DeleteVaultRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls DeleteVaultRequestMock if set and returns uninitialized DeleteVaultRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) DeleteVaultRequest(input *glacier.DeleteVaultInput) glacier.DeleteVaultRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.DeleteVaultRequestMock != nil {
+		request := g.DeleteVaultRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
+	}
+	return glacier.DeleteVaultRequest{}
+}
+
+// ListVaultsRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls ListVaultsRequestMock if set and returns uninitialized ListVaultsRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) ListVaultsRequest(input *glacier.ListVaultsInput) glacier.ListVaultsRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.ListVaultsRequestMock != nil {
+		request := g.ListVaultsRequestMock()
+		request.Request = g.inject(request.Request)
+		if copyRequest := request.Copy; copyRequest != nil {
+			request.Copy = func(input *glacier.ListVaultsInput) glacier.ListVaultsRequest {
+				copied := copyRequest(input)
+				copied.Request = g.inject(copied.Request)
+				return copied
+			}
+		}
+		return request
+	}
+	return glacier.ListVaultsRequest{}
+}
+
+// ListMultipartUploadsRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls ListMultipartUploadsRequestMock if set and returns uninitialized ListMultipartUploadsRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) ListMultipartUploadsRequest(input *glacier.ListMultipartUploadsInput) glacier.ListMultipartUploadsRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.ListMultipartUploadsRequestMock != nil {
+		request := g.ListMultipartUploadsRequestMock()
+		request.Request = g.inject(request.Request)
+		if copyRequest := request.Copy; copyRequest != nil {
+			request.Copy = func(input *glacier.ListMultipartUploadsInput) glacier.ListMultipartUploadsRequest {
+				copied := copyRequest(input)
+				copied.Request = g.inject(copied.Request)
+				return copied
+			}
+		}
+		return request
+	}
+	return glacier.ListMultipartUploadsRequest{}
+}
+
+// AbortMultipartUploadRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls AbortMultipartUploadRequestMock if set and returns uninitialized AbortMultipartUploadRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) AbortMultipartUploadRequest(input *glacier.AbortMultipartUploadInput) glacier.AbortMultipartUploadRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.AbortMultipartUploadRequestMock != nil {
+		request := g.AbortMultipartUploadRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
+	}
+	return glacier.AbortMultipartUploadRequest{}
+}
+
+// DeleteArchiveRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls DeleteArchiveRequestMock if set and returns uninitialized DeleteArchiveRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) DeleteArchiveRequest(input *glacier.DeleteArchiveInput) glacier.DeleteArchiveRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.DeleteArchiveRequestMock != nil {
+		request := g.DeleteArchiveRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
+	}
+	return glacier.DeleteArchiveRequest{}
+}
+
+// AddTagsToVaultRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls AddTagsToVaultRequestMock if set and returns uninitialized AddTagsToVaultRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) AddTagsToVaultRequest(input *glacier.AddTagsToVaultInput) glacier.AddTagsToVaultRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.AddTagsToVaultRequestMock != nil {
+		request := g.AddTagsToVaultRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
+	}
+	return glacier.AddTagsToVaultRequest{}
+}
+
+// RemoveTagsFromVaultRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls RemoveTagsFromVaultRequestMock if set and returns uninitialized RemoveTagsFromVaultRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) RemoveTagsFromVaultRequest(input *glacier.RemoveTagsFromVaultInput) glacier.RemoveTagsFromVaultRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.RemoveTagsFromVaultRequestMock != nil {
+		request := g.RemoveTagsFromVaultRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
+	}
+	return glacier.RemoveTagsFromVaultRequest{}
+}
+
+// ListTagsForVaultRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls ListTagsForVaultRequestMock if set and returns uninitialized ListTagsForVaultRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) ListTagsForVaultRequest(input *glacier.ListTagsForVaultInput) glacier.ListTagsForVaultRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.ListTagsForVaultRequestMock != nil {
+		request := g.ListTagsForVaultRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
+	}
+	return glacier.ListTagsForVaultRequest{}
+}
+
+// ListProvisionedCapacityRequest returns a mocked request value for making API operation for Amazon Glacier.
+// It calls ListProvisionedCapacityRequestMock if set and returns uninitialized ListProvisionedCapacityRequest otherwise.
+// Calling this method increases CallCount, records the input and injects the Faults if set.
+func (g *Glacier) ListProvisionedCapacityRequest(input *glacier.ListProvisionedCapacityInput) glacier.ListProvisionedCapacityRequest {
+	atomic.AddUint32(&g.CallCount, 1)
+	g.record(input)
+	if g.ListProvisionedCapacityRequestMock != nil {
+		request := g.ListProvisionedCapacityRequestMock()
+		request.Request = g.inject(request.Request)
+		return request
+	}
+	return glacier.ListProvisionedCapacityRequest{}
+}
+
+// inject injects the faults into the request if there are any. A request
+// without an HTTP request gets one, so that it can be given a context.
+func (g *Glacier) inject(r *aws.Request) *aws.Request {
+	if g.Faults != nil {
+		return g.Faults.Inject(r)
+	}
+	if r == nil || r.HTTPRequest != nil {
+		return r
+	}
+
+	copied := *r
+	copied.HTTPRequest, _ = http.NewRequest("POST", "https://glacier.mock/", nil)
+	return &copied
+}
+
+// record records the input of a call.
+func (g *Glacier) record(input interface{}) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.inputs = append(g.inputs, input)
+}
+
+// Inputs returns the inputs of all the calls in the order they were made.
+func (g *Glacier) Inputs() []interface{} {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return append([]interface{}(nil), g.inputs...)
+}
+
+// InitiateMultipartUploadInputs returns the inputs of the InitiateMultipartUploadRequest calls in the order they were made.
+func (g *Glacier) InitiateMultipartUploadInputs() []*glacier.InitiateMultipartUploadInput {
+	var inputs []*glacier.InitiateMultipartUploadInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.InitiateMultipartUploadInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// ListPartsInputs returns the inputs of the ListPartsRequest calls in the order they were made.
+func (g *Glacier) ListPartsInputs() []*glacier.ListPartsInput {
+	var inputs []*glacier.ListPartsInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.ListPartsInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// UploadMultipartPartInputs returns the inputs of the UploadMultipartPartRequest calls in the order they were made.
+func (g *Glacier) UploadMultipartPartInputs() []*glacier.UploadMultipartPartInput {
+	var inputs []*glacier.UploadMultipartPartInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.UploadMultipartPartInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// CompleteMultipartUploadInputs returns the inputs of the CompleteMultipartUploadRequest calls in the order they were made.
+func (g *Glacier) CompleteMultipartUploadInputs() []*glacier.CompleteMultipartUploadInput {
+	var inputs []*glacier.CompleteMultipartUploadInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.CompleteMultipartUploadInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// UploadArchiveInputs returns the inputs of the UploadArchiveRequest calls in the order they were made.
+func (g *Glacier) UploadArchiveInputs() []*glacier.UploadArchiveInput {
+	var inputs []*glacier.UploadArchiveInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.UploadArchiveInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// DescribeJobInputs returns the inputs of the DescribeJobRequest calls in the order they were made.
+func (g *Glacier) DescribeJobInputs() []*glacier.DescribeJobInput {
+	var inputs []*glacier.DescribeJobInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.DescribeJobInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// GetJobOutputInputs returns the inputs of the GetJobOutputRequest calls in the order they were made.
+func (g *Glacier) GetJobOutputInputs() []*glacier.GetJobOutputInput {
+	var inputs []*glacier.GetJobOutputInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.GetJobOutputInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// InitiateJobInputs returns the inputs of the InitiateJobRequest calls in the order they were made.
+func (g *Glacier) InitiateJobInputs() []*glacier.InitiateJobInput {
+	var inputs []*glacier.InitiateJobInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.InitiateJobInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// ListJobsInputs returns the inputs of the ListJobsRequest calls in the order they were made.
+func (g *Glacier) ListJobsInputs() []*glacier.ListJobsInput {
+	var inputs []*glacier.ListJobsInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.ListJobsInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// DescribeVaultInputs returns the inputs of the DescribeVaultRequest calls in the order they were made.
+func (g *Glacier) DescribeVaultInputs() []*glacier.DescribeVaultInput {
+	var inputs []*glacier.DescribeVaultInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.DescribeVaultInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// CreateVaultInputs returns the inputs of the CreateVaultRequest calls in the order they were made.
+func (g *Glacier) CreateVaultInputs() []*glacier.CreateVaultInput {
+	var inputs []*glacier.CreateVaultInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.CreateVaultInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// DeleteVaultInputs returns the inputs of the DeleteVaultRequest calls in the order they were made.
+func (g *Glacier) DeleteVaultInputs() []*glacier.DeleteVaultInput {
+	var inputs []*glacier.DeleteVaultInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.DeleteVaultInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// ListVaultsInputs returns the inputs of the ListVaultsRequest calls in the order they were made.
+func (g *Glacier) ListVaultsInputs() []*glacier.ListVaultsInput {
+	var inputs []*glacier.ListVaultsInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.ListVaultsInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// ListMultipartUploadsInputs returns the inputs of the ListMultipartUploadsRequest calls in the order they were made.
+func (g *Glacier) ListMultipartUploadsInputs() []*glacier.ListMultipartUploadsInput {
+	var inputs []*glacier.ListMultipartUploadsInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.ListMultipartUploadsInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// AbortMultipartUploadInputs returns the inputs of the AbortMultipartUploadRequest calls in the order they were made.
+func (g *Glacier) AbortMultipartUploadInputs() []*glacier.AbortMultipartUploadInput {
+	var inputs []*glacier.AbortMultipartUploadInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.AbortMultipartUploadInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// DeleteArchiveInputs returns the inputs of the DeleteArchiveRequest calls in the order they were made.
+func (g *Glacier) DeleteArchiveInputs() []*glacier.DeleteArchiveInput {
+	var inputs []*glacier.DeleteArchiveInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.DeleteArchiveInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// AddTagsToVaultInputs returns the inputs of the AddTagsToVaultRequest calls in the order they were made.
+func (g *Glacier) AddTagsToVaultInputs() []*glacier.AddTagsToVaultInput {
+	var inputs []*glacier.AddTagsToVaultInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.AddTagsToVaultInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// RemoveTagsFromVaultInputs returns the inputs of the RemoveTagsFromVaultRequest calls in the order they were made.
+func (g *Glacier) RemoveTagsFromVaultInputs() []*glacier.RemoveTagsFromVaultInput {
+	var inputs []*glacier.RemoveTagsFromVaultInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.RemoveTagsFromVaultInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// ListTagsForVaultInputs returns the inputs of the ListTagsForVaultRequest calls in the order they were made.
+func (g *Glacier) ListTagsForVaultInputs() []*glacier.ListTagsForVaultInput {
+	var inputs []*glacier.ListTagsForVaultInput
+	for _, input := range g.Inputs() {
+		if input, ok := input.(*glacier.ListTagsForVaultInput); ok {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
@@ -0,0 +1,155 @@
+package awsconfig
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+// Call is the record of an AWS API call written to the audit.
+type Call struct {
+	Time      time.Time `json:"time"`
+	Service   string    `json:"service"`
+	Operation string    `json:"operation"`
+
+	// The scalar parameters of the call, such as the vault name and the
+	// upload ID, leaving out the request body.
+	Params map[string]interface{} `json:"params,omitempty"`
+
+	RequestId string  `json:"request_id,omitempty"`
+	Status    int     `json:"status,omitempty"`
+	Attempts  int     `json:"attempts"`
+	Elapsed   float64 `json:"elapsed_seconds"`
+
+	ErrorCode string `json:"error_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// maskedParams are the parameters masked by the audit mask.
+var maskedParams = map[string]bool{
+	"AccountId": true,
+	"UploadId":  true,
+	"JobId":     true,
+}
+
+// callAuditor writes a record of every completed call as a line of JSON.
+type callAuditor struct {
+	mask func(string) string
+
+	mutex   sync.Mutex
+	encoder *json.Encoder
+
+	// The time every request in flight was sent for the first time.
+	started map[*aws.Request]time.Time
+}
+
+// auditCalls makes every call made with the handlers be recorded to w. If the
+// mask is not nil then it is applied to the account, upload and job IDs.
+func auditCalls(handlers *aws.Handlers, w io.Writer, mask func(string) string) {
+	a := &callAuditor{
+		mask:    mask,
+		encoder: json.NewEncoder(w),
+		started: make(map[*aws.Request]time.Time),
+	}
+
+	// A request is built once, right before it is sent for the first time,
+	// which leaves out the time it waits for the rate limit.
+	handlers.Build.PushFront(a.start)
+	handlers.Complete.PushBack(a.complete)
+}
+
+func (a *callAuditor) start(r *aws.Request) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.started[r] = time.Now()
+}
+
+func (a *callAuditor) complete(r *aws.Request) {
+	now := time.Now()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	start, ok := a.started[r]
+	if !ok {
+		// The request failed before it was built.
+		start = r.Time
+	}
+	delete(a.started, r)
+
+	call := &Call{
+		Time:      now,
+		Service:   r.Metadata.ServiceName,
+		Params:    a.params(r.Params),
+		RequestId: r.RequestID,
+		Attempts:  r.RetryCount + 1,
+		Elapsed:   now.Sub(start).Seconds(),
+	}
+	if r.Operation != nil {
+		call.Operation = r.Operation.Name
+	}
+	if r.HTTPResponse != nil {
+		call.Status = r.HTTPResponse.StatusCode
+	}
+	if r.Error != nil {
+		call.Error = r.Error.Error()
+		if err, ok := r.Error.(awserr.Error); ok {
+			call.ErrorCode = err.Code()
+		}
+	}
+
+	// There is nowhere to report a failure to write the audit.
+	_ = a.encoder.Encode(call)
+}
+
+// params returns the scalar fields of the input set for the call.
+func (a *callAuditor) params(input interface{}) map[string]interface{} {
+	v := reflect.ValueOf(input)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	params := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+
+		if field.PkgPath != "" || field.Name == "Body" {
+			continue
+		}
+		if value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		}
+
+		switch value.Kind() {
+		case reflect.String:
+			s := value.String()
+			if s == "" {
+				continue
+			}
+			if a.mask != nil && maskedParams[field.Name] {
+				s = a.mask(s)
+			}
+			params[field.Name] = s
+		case reflect.Bool, reflect.Int, reflect.Int64:
+			params[field.Name] = value.Interface()
+		}
+	}
+
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
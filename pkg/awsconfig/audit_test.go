@@ -0,0 +1,114 @@
+package awsconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+func TestAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	defer setTestEnv(t, dir)()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amzn-Requestid", "test_request")
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"ResourceNotFoundException","message":"not found"}`))
+			return
+		}
+		w.Write([]byte(`{"VaultName":"test_vault"}`))
+	}))
+	defer server.Close()
+
+	load := func(t *testing.T, mask func(string) string) (*glacier.Glacier, *bytes.Buffer) {
+		var audit bytes.Buffer
+		config, err := Load(&Options{
+			Profile:     "static",
+			Region:      "eu-central-1",
+			EndpointURL: server.URL,
+			Audit:       &audit,
+			AuditMask:   mask,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		config.Retryer = aws.DefaultRetryer{NumMaxRetries: 0}
+
+		return glacier.New(config), &audit
+	}
+
+	decode := func(t *testing.T, audit *bytes.Buffer) *Call {
+		var call Call
+		if err := json.Unmarshal(audit.Bytes(), &call); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if call.Time.IsZero() || call.Elapsed <= 0 {
+			t.Fatalf("unexpected time: %#v", call)
+		}
+		return &call
+	}
+
+	t.Run("success", func(t *testing.T) {
+		service, audit := load(t, nil)
+
+		_, err := service.DescribeVaultRequest(&glacier.DescribeVaultInput{
+			AccountId: aws.String("111111111111"),
+			VaultName: aws.String("test_vault"),
+		}).Send()
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		call := decode(t, audit)
+		want := &Call{
+			Time:      call.Time,
+			Service:   "glacier",
+			Operation: "DescribeVault",
+			Params:    map[string]interface{}{"AccountId": "111111111111", "VaultName": "test_vault"},
+			RequestId: "test_request",
+			Status:    http.StatusOK,
+			Attempts:  1,
+			Elapsed:   call.Elapsed,
+		}
+		if !reflect.DeepEqual(call, want) {
+			t.Fatalf("got %#v, want %#v", call, want)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		service, audit := load(t, events.Mask)
+
+		_, err := service.AbortMultipartUploadRequest(&glacier.AbortMultipartUploadInput{
+			AccountId: aws.String("111111111111"),
+			UploadId:  aws.String("test_upload_id"),
+			VaultName: aws.String("test_vault"),
+		}).Send()
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		call := decode(t, audit)
+		wantParams := map[string]interface{}{"AccountId": "***1111", "UploadId": "***d_id", "VaultName": "test_vault"}
+		if !reflect.DeepEqual(call.Params, wantParams) {
+			t.Fatalf("got %#v, want %#v", call.Params, wantParams)
+		}
+		if call.Status != http.StatusNotFound || call.ErrorCode != "ResourceNotFoundException" || call.Error == "" {
+			t.Fatalf("unexpected outcome: %#v", call)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+// Package awsconfig loads the AWS configuration used to access Amazon Glacier.
+//
+// On top of what the SDK resolves from the environment and the shared config
+// files, it supports AWS SSO profiles, MFA-protected assume-role profiles and
+// assuming a role in another account, e.g. the account owning the vault.
+package awsconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/aws/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// MFASessionDuration is the duration of the role session assumed with an MFA token.
+// It is longer than the SDK default so that the user is not prompted for a new
+// token code in the middle of a long transfer.
+var MFASessionDuration = time.Hour
+
+// Options provides options for loading the AWS configuration.
+type Options struct {
+	// The name of the shared config profile to use.
+	// If the value is empty then the SDK default profile is used.
+	Profile string
+
+	// The AWS region to send requests to. It takes precedence over the region
+	// set in the environment or the profile.
+	Region string
+
+	// The URL of the Amazon Glacier endpoint. If the value is empty then the
+	// endpoint is resolved from the region. Specify it to use a service emulator.
+	EndpointURL string
+
+	// TokenProvider returns the MFA token code required to assume a role
+	// of a profile with mfa_serial set. If the value is nil then the token
+	// code is read from the standard input.
+	TokenProvider func() (string, error)
+
+	// The ARN of the role to assume with the resolved credentials, e.g. in the
+	// account owning the vault. If the value is empty then no role is assumed.
+	RoleARN string
+
+	// The external ID required by the trust policy of the role. It is only
+	// valid with RoleARN.
+	ExternalID string
+
+	// The number of concurrent requests the HTTP connection pool is sized for.
+	// If the value is zero then the SDK default HTTP client is used.
+	Connections int
+
+	// UserAgent is appended to the User-Agent header of every request, so that
+	// the requests of the application can be told apart in CloudTrail.
+	UserAgent string
+
+	// Audit receives a line of JSON recording every API call, with its key
+	// parameters, request ID, status and latency. If the value is nil then
+	// the calls are not recorded.
+	Audit io.Writer
+
+	// AuditMask masks the account, upload and job IDs recorded to the audit.
+	// If the value is nil then they are recorded as is.
+	AuditMask func(string) string
+
+	// The SDK log level and the logger receiving its messages. If the logger
+	// is nil then the SDK default logger writing to the standard output is used.
+	LogLevel aws.LogLevel
+	Logger   aws.Logger
+}
+
+// StdinTokenProvider prompts on the standard error and reads an MFA token code
+// from the standard input. Unlike the SDK provider it keeps the standard output
+// clean for the command results.
+func StdinTokenProvider() (string, error) {
+	var code string
+	fmt.Fprint(os.Stderr, "Assume role MFA token code: ")
+	_, err := fmt.Scanln(&code)
+
+	return code, err
+}
+
+// Load reads the external configuration and resolves it into the AWS configuration.
+func Load(options *Options) (aws.Config, error) {
+	var configs external.Configs
+	if options.Region != "" {
+		configs = append(configs, external.WithRegion(options.Region))
+	}
+	if options.Profile != "" {
+		configs = append(configs, external.WithSharedConfigProfile(options.Profile))
+	}
+
+	tokenProvider := options.TokenProvider
+	if tokenProvider == nil {
+		tokenProvider = StdinTokenProvider
+	}
+	configs = append(configs, external.WithMFATokenFunc(tokenProvider))
+
+	configs, err := configs.AppendFromLoaders(external.DefaultConfigLoaders)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	resolvers := make([]external.AWSConfigResolver, 0, len(external.DefaultAWSConfigResolvers)+2)
+	resolvers = append(resolvers, external.DefaultAWSConfigResolvers...)
+	resolvers = append(resolvers, resolveMFASessionDuration, resolveSSOCredentials)
+
+	config, err := configs.ResolveAWSConfig(resolvers)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if config.Region == "" {
+		return aws.Config{}, errors.New("region is not set, specify it in the profile or with the region option")
+	}
+
+	if options.ExternalID != "" && options.RoleARN == "" {
+		return aws.Config{}, errors.New("the external ID is given without the role ARN")
+	}
+
+	if options.EndpointURL != "" {
+		if err := validateEndpointURL(options.EndpointURL); err != nil {
+			return aws.Config{}, err
+		}
+		config.EndpointResolver = aws.ResolveWithEndpointURL(options.EndpointURL)
+	}
+
+	if options.Connections > 0 {
+		config.HTTPClient = NewHTTPClient(options.Connections)
+	}
+
+	if options.UserAgent != "" {
+		SetUserAgent(&config, options.UserAgent)
+	}
+
+	if options.Audit != nil {
+		auditCalls(&config.Handlers, options.Audit, options.AuditMask)
+	}
+
+	if options.LogLevel != aws.LogOff {
+		config.LogLevel = options.LogLevel
+		if options.Logger != nil {
+			config.Logger = options.Logger
+		}
+	}
+
+	if options.RoleARN != "" {
+		config.Credentials = newRoleProvider(config, options)
+	}
+
+	return config, nil
+}
+
+// newRoleProvider returns the provider of the credentials of the role assumed
+// with the credentials of the config. The STS client shares the HTTP client,
+// the handlers and the logger of the config.
+func newRoleProvider(config aws.Config, options *Options) *stscreds.AssumeRoleProvider {
+	provider := stscreds.NewAssumeRoleProvider(sts.New(config), options.RoleARN)
+	provider.RoleSessionName = fmt.Sprintf("surge-%d", time.Now().Unix())
+	provider.ExpiryWindow = time.Minute
+	if options.ExternalID != "" {
+		provider.ExternalID = aws.String(options.ExternalID)
+	}
+
+	return provider
+}
+
+func validateEndpointURL(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint URL: %v", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint URL %q: must be an absolute http or https URL", endpoint)
+	}
+
+	return nil
+}
+
+func resolveMFASessionDuration(cfg *aws.Config, configs external.Configs) error {
+	provider, ok := cfg.Credentials.(*stscreds.AssumeRoleProvider)
+	if !ok || provider.SerialNumber == nil {
+		return nil
+	}
+
+	provider.Duration = MFASessionDuration
+	provider.ExpiryWindow = time.Minute
+
+	return nil
+}
+
+// userAgentHandler names the handler appending Options.UserAgent.
+const userAgentHandler = "awsconfig.UserAgentHandler"
+
+// SetUserAgent replaces the user agent appended to the User-Agent header of
+// the requests of the config, e.g. once the options it reports are tuned.
+func SetUserAgent(config *aws.Config, userAgent string) {
+	config.Handlers.Build.SetBackNamed(aws.NamedHandler{
+		Name: userAgentHandler,
+		Fn:   aws.MakeAddToUserAgentFreeFormHandler(userAgent),
+	})
+}
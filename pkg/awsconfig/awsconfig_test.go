@@ -0,0 +1,293 @@
+package awsconfig
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+func setTestEnv(t *testing.T, dir string) func() {
+	config := writeTestFile(t, dir, "config", `
+[profile mfa]
+role_arn = arn:aws:iam::111111111111:role/test
+source_profile = static
+mfa_serial = arn:aws:iam::111111111111:mfa/test
+
+[profile regional]
+region = eu-west-1
+
+[profile sso]
+sso_start_url = https://test.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 111111111111
+sso_role_name = Test
+`)
+	credentials := writeTestFile(t, dir, "credentials", `
+[static]
+aws_access_key_id = test_id
+aws_secret_access_key = test_secret
+`)
+
+	saved := make(map[string]string)
+	env := map[string]string{
+		"AWS_CONFIG_FILE":             config,
+		"AWS_SHARED_CREDENTIALS_FILE": credentials,
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_SECRET_ACCESS_KEY":       "",
+		"AWS_PROFILE":                 "",
+		"AWS_REGION":                  "",
+		"AWS_DEFAULT_REGION":          "",
+	}
+	for k, v := range env {
+		saved[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+
+	return func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	defer setTestEnv(t, dir)()
+
+	t.Run("static", func(t *testing.T) {
+		config, err := Load(&Options{Profile: "static", Region: "eu-central-1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if _, ok := config.Credentials.(aws.StaticCredentialsProvider); !ok {
+			t.Fatalf("unexpected credentials provider: %T", config.Credentials)
+		}
+	})
+
+	t.Run("mfa", func(t *testing.T) {
+		tokenProvider := func() (string, error) {
+			return "123456", nil
+		}
+
+		config, err := Load(&Options{Profile: "mfa", Region: "eu-central-1", TokenProvider: tokenProvider})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		provider, ok := config.Credentials.(*stscreds.AssumeRoleProvider)
+		if !ok {
+			t.Fatalf("unexpected credentials provider: %T", config.Credentials)
+		}
+
+		if provider.TokenProvider == nil {
+			t.Fatal("token provider must not be nil")
+		}
+
+		if provider.Duration != MFASessionDuration {
+			t.Fatalf("unexpected duration: %v", provider.Duration)
+		}
+	})
+
+	t.Run("sso", func(t *testing.T) {
+		config, err := Load(&Options{Profile: "sso", Region: "eu-central-1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		provider, ok := config.Credentials.(*SSOProvider)
+		if !ok {
+			t.Fatalf("unexpected credentials provider: %T", config.Credentials)
+		}
+
+		if provider.Config.AccountID != "111111111111" {
+			t.Fatalf("unexpected account ID: %v", provider.Config.AccountID)
+		}
+	})
+
+	t.Run("no region", func(t *testing.T) {
+		errString := "region is not set, specify it in the profile or with the region option"
+
+		if _, got := Load(&Options{Profile: "static"}); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	t.Run("profile region", func(t *testing.T) {
+		config, err := Load(&Options{Profile: "regional"})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if config.Region != "eu-west-1" {
+			t.Fatalf("got %q, want %q", config.Region, "eu-west-1")
+		}
+	})
+
+	t.Run("region override", func(t *testing.T) {
+		config, err := Load(&Options{Profile: "regional", Region: "us-west-2"})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if config.Region != "us-west-2" {
+			t.Fatalf("got %q, want %q", config.Region, "us-west-2")
+		}
+	})
+
+	t.Run("invalid endpoint", func(t *testing.T) {
+		errString := `invalid endpoint URL "localhost:4566": must be an absolute http or https URL`
+		options := &Options{
+			Profile:     "regional",
+			EndpointURL: "localhost:4566",
+		}
+
+		if _, got := Load(options); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	t.Run("endpoint", func(t *testing.T) {
+		options := &Options{
+			Profile:     "regional",
+			EndpointURL: "http://localhost:4566",
+		}
+
+		config, err := Load(options)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		endpoint, err := config.EndpointResolver.ResolveEndpoint("glacier", config.Region)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if endpoint.URL != options.EndpointURL || endpoint.SigningRegion != "eu-west-1" {
+			t.Fatalf("unexpected endpoint: %#v", endpoint)
+		}
+	})
+
+	t.Run("connections", func(t *testing.T) {
+		config, err := Load(&Options{Profile: "regional", Connections: 16})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		transport, ok := config.HTTPClient.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("unexpected transport: %T", config.HTTPClient.Transport)
+		}
+
+		if want := 18; transport.MaxIdleConnsPerHost != want {
+			t.Fatalf("got %#v, want %#v", transport.MaxIdleConnsPerHost, want)
+		}
+	})
+
+	t.Run("user agent", func(t *testing.T) {
+		config, err := Load(&Options{Profile: "regional", UserAgent: "surge/1.4.0 (upload; jobs=8)"})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		req := glacier.New(config).ListVaultsRequest(&glacier.ListVaultsInput{})
+		if err := req.Build(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if got := req.HTTPRequest.Header.Get("User-Agent"); !strings.HasSuffix(got, " surge/1.4.0 (upload; jobs=8)") {
+			t.Fatalf("unexpected User-Agent: %q", got)
+		}
+
+		// The user agent is replaced rather than appended once more.
+		SetUserAgent(&config, "surge/1.4.0 (upload; jobs=16)")
+		req = glacier.New(config).ListVaultsRequest(&glacier.ListVaultsInput{})
+		if err := req.Build(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		got := req.HTTPRequest.Header.Get("User-Agent")
+		if !strings.HasSuffix(got, " surge/1.4.0 (upload; jobs=16)") || strings.Contains(got, "jobs=8") {
+			t.Fatalf("unexpected User-Agent: %q", got)
+		}
+	})
+
+	t.Run("role", func(t *testing.T) {
+		options := &Options{
+			Profile:    "static",
+			Region:     "eu-central-1",
+			RoleARN:    "arn:aws:iam::222222222222:role/backup",
+			ExternalID: "customer-1",
+		}
+
+		config, err := Load(options)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		provider, ok := config.Credentials.(*stscreds.AssumeRoleProvider)
+		if !ok {
+			t.Fatalf("unexpected credentials provider: %T", config.Credentials)
+		}
+
+		if provider.RoleARN != options.RoleARN {
+			t.Fatalf("got %#v, want %#v", provider.RoleARN, options.RoleARN)
+		}
+		if provider.ExternalID == nil || *provider.ExternalID != options.ExternalID {
+			t.Fatalf("got %#v, want %#v", provider.ExternalID, options.ExternalID)
+		}
+
+		client, ok := provider.Client.(*sts.STS)
+		if !ok {
+			t.Fatalf("unexpected client: %T", provider.Client)
+		}
+		if _, ok := client.Credentials.(aws.StaticCredentialsProvider); !ok {
+			t.Fatalf("unexpected client credentials provider: %T", client.Credentials)
+		}
+	})
+
+	t.Run("external ID without role", func(t *testing.T) {
+		errString := "the external ID is given without the role ARN"
+
+		if _, got := Load(&Options{Profile: "regional", ExternalID: "customer-1"}); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	t.Run("log level", func(t *testing.T) {
+		var logged []interface{}
+		options := &Options{
+			Profile:  "regional",
+			LogLevel: aws.LogDebug,
+			Logger: aws.LoggerFunc(func(args ...interface{}) {
+				logged = append(logged, args...)
+			}),
+		}
+
+		config, err := Load(options)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if config.LogLevel != aws.LogDebug {
+			t.Fatalf("got %#v, want %#v", config.LogLevel, aws.LogDebug)
+		}
+
+		config.Logger.Log("test")
+		if len(logged) != 1 || logged[0] != "test" {
+			t.Fatalf("got %#v, want the message passed to the logger", logged)
+		}
+	})
+}
@@ -0,0 +1,272 @@
+package awsconfig
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/go-ini/ini"
+)
+
+const (
+	ssoStartURLKey  = "sso_start_url"
+	ssoRegionKey    = "sso_region"
+	ssoAccountIDKey = "sso_account_id"
+	ssoRoleNameKey  = "sso_role_name"
+	ssoSessionKey   = "sso_session"
+)
+
+var (
+	// ErrSSOSessionNotFound is returned when there is no cached SSO access token.
+	ErrSSOSessionNotFound = errors.New("SSO session not found, run `aws sso login` first")
+
+	// ErrSSOSessionExpired is returned when the cached SSO access token has expired.
+	ErrSSOSessionExpired = errors.New("SSO session has expired, run `aws sso login` again")
+)
+
+// SSOProviderName provides a name of the SSO credentials provider.
+const SSOProviderName = "SSOProvider"
+
+// SSOConfig holds the AWS SSO settings of a shared config profile.
+type SSOConfig struct {
+	// The name of the sso-session section, if the profile refers to one.
+	Session string
+
+	StartURL  string
+	Region    string
+	AccountID string
+	RoleName  string
+}
+
+// cacheKey returns the key under which `aws sso login` caches the access token.
+func (c *SSOConfig) cacheKey() string {
+	key := c.StartURL
+	if c.Session != "" {
+		key = c.Session
+	}
+
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadSSOConfig reads the SSO settings of a profile from the shared config files.
+// Values in subsequent files overwrite values defined in earlier files.
+// If the profile is not an SSO profile nil is returned.
+func LoadSSOConfig(profile string, filenames []string) (*SSOConfig, error) {
+	var config SSOConfig
+
+	for _, filename := range filenames {
+		file, err := ini.Load(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		section, err := file.GetSection(profile)
+		if err != nil {
+			section, err = file.GetSection("profile " + profile)
+			if err != nil {
+				continue
+			}
+		}
+
+		if v := section.Key(ssoSessionKey).String(); v != "" {
+			config.Session = v
+
+			if session, err := file.GetSection("sso-session " + v); err == nil {
+				config.StartURL = session.Key(ssoStartURLKey).String()
+				config.Region = session.Key(ssoRegionKey).String()
+			}
+		}
+		if v := section.Key(ssoStartURLKey).String(); v != "" {
+			config.StartURL = v
+		}
+		if v := section.Key(ssoRegionKey).String(); v != "" {
+			config.Region = v
+		}
+		if v := section.Key(ssoAccountIDKey).String(); v != "" {
+			config.AccountID = v
+		}
+		if v := section.Key(ssoRoleNameKey).String(); v != "" {
+			config.RoleName = v
+		}
+	}
+
+	if config.AccountID == "" && config.RoleName == "" {
+		return nil, nil
+	}
+
+	if config.StartURL == "" || config.Region == "" || config.AccountID == "" || config.RoleName == "" {
+		return nil, fmt.Errorf("profile %s has incomplete SSO configuration", profile)
+	}
+
+	return &config, nil
+}
+
+// SSOProvider retrieves role credentials from the AWS SSO portal
+// using the access token cached by `aws sso login`.
+type SSOProvider struct {
+	aws.SafeCredentialsProvider
+
+	Config *SSOConfig
+
+	// The HTTP client used to call the portal.
+	Client *http.Client
+
+	// The portal endpoint. Defaults to the regional AWS SSO portal endpoint.
+	Endpoint string
+
+	// The directory with cached access tokens. Defaults to ~/.aws/sso/cache.
+	CacheDir string
+}
+
+// NewSSOProvider constructs and returns a credentials provider for an SSO profile.
+func NewSSOProvider(client *http.Client, config *SSOConfig) *SSOProvider {
+	p := &SSOProvider{
+		Config:   config,
+		Client:   client,
+		Endpoint: fmt.Sprintf("https://portal.sso.%s.amazonaws.com", config.Region),
+		CacheDir: filepath.Join(userHomeDir(), ".aws", "sso", "cache"),
+	}
+	p.RetrieveFn = p.retrieveFn
+
+	return p
+}
+
+type ssoToken struct {
+	AccessToken string `json:"accessToken"`
+	ExpiresAt   string `json:"expiresAt"`
+}
+
+func (p *SSOProvider) readToken() (string, error) {
+	filename := filepath.Join(p.CacheDir, p.Config.cacheKey()+".json")
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrSSOSessionNotFound
+		}
+		return "", err
+	}
+
+	var token ssoToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return "", fmt.Errorf("invalid SSO token cache %s: %v", filename, err)
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, token.ExpiresAt)
+	if err != nil {
+		return "", fmt.Errorf("invalid SSO token expiration: %v", err)
+	}
+
+	if !expiresAt.After(time.Now()) || token.AccessToken == "" {
+		return "", ErrSSOSessionExpired
+	}
+
+	return token.AccessToken, nil
+}
+
+type ssoRoleCredentials struct {
+	RoleCredentials struct {
+		AccessKeyID     string `json:"accessKeyId"`
+		SecretAccessKey string `json:"secretAccessKey"`
+		SessionToken    string `json:"sessionToken"`
+		Expiration      int64  `json:"expiration"`
+	} `json:"roleCredentials"`
+}
+
+func (p *SSOProvider) retrieveFn() (aws.Credentials, error) {
+	token, err := p.readToken()
+	if err != nil {
+		return aws.Credentials{Source: SSOProviderName}, err
+	}
+
+	query := url.Values{}
+	query.Set("account_id", p.Config.AccountID)
+	query.Set("role_name", p.Config.RoleName)
+
+	request, err := http.NewRequest("GET", p.Endpoint+"/federation/credentials?"+query.Encode(), nil)
+	if err != nil {
+		return aws.Credentials{Source: SSOProviderName}, err
+	}
+	request.Header.Set("x-amz-sso_bearer_token", token)
+
+	response, err := p.Client.Do(request)
+	if err != nil {
+		return aws.Credentials{Source: SSOProviderName}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return aws.Credentials{Source: SSOProviderName},
+			fmt.Errorf("could not get SSO role credentials: %s", response.Status)
+	}
+
+	var result ssoRoleCredentials
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return aws.Credentials{Source: SSOProviderName}, err
+	}
+
+	credentials := result.RoleCredentials
+	return aws.Credentials{
+		AccessKeyID:     credentials.AccessKeyID,
+		SecretAccessKey: credentials.SecretAccessKey,
+		SessionToken:    credentials.SessionToken,
+		Source:          SSOProviderName,
+
+		CanExpire: true,
+		Expires:   time.Unix(0, credentials.Expiration*int64(time.Millisecond)).Add(-time.Minute),
+	}, nil
+}
+
+// resolveSSOCredentials replaces the SDK fallback credentials with the SSO provider
+// if the selected profile is an SSO profile and no static credentials were found.
+func resolveSSOCredentials(cfg *aws.Config, configs external.Configs) error {
+	if _, found, err := external.GetCredentialsValue(configs); err != nil || found {
+		return err
+	}
+
+	profile, found, err := external.GetSharedConfigProfile(configs)
+	if err != nil {
+		return err
+	}
+	if !found {
+		profile = external.DefaultSharedConfigProfile
+	}
+
+	filenames, found, err := external.GetSharedConfigFiles(configs)
+	if err != nil {
+		return err
+	}
+	if !found {
+		filenames = external.DefaultSharedConfigFiles
+	}
+
+	config, err := LoadSSOConfig(profile, filenames)
+	if err != nil || config == nil {
+		return err
+	}
+
+	cfg.Credentials = NewSSOProvider(cfg.HTTPClient, config)
+
+	return nil
+}
+
+func userHomeDir() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return home
+	}
+	return ""
+}
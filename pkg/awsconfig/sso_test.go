@@ -0,0 +1,224 @@
+package awsconfig
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	filename := path.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadSSOConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	filename := writeTestFile(t, dir, "config", `
+[default]
+region = eu-central-1
+
+[profile legacy]
+sso_start_url = https://test.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 111111111111
+sso_role_name = Test
+
+[profile session]
+sso_session = test
+sso_account_id = 111111111111
+sso_role_name = Test
+
+[sso-session test]
+sso_start_url = https://test.awsapps.com/start
+sso_region = us-east-1
+
+[profile incomplete]
+sso_account_id = 111111111111
+`)
+	filenames := []string{path.Join(dir, "nonexistent"), filename}
+
+	t.Run("not sso", func(t *testing.T) {
+		config, err := LoadSSOConfig("default", filenames)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if config != nil {
+			t.Fatalf("got %#v, want nil", config)
+		}
+	})
+
+	t.Run("nonexistent profile", func(t *testing.T) {
+		config, err := LoadSSOConfig("nonexistent", filenames)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if config != nil {
+			t.Fatalf("got %#v, want nil", config)
+		}
+	})
+
+	t.Run("incomplete", func(t *testing.T) {
+		errString := "profile incomplete has incomplete SSO configuration"
+
+		if _, got := LoadSSOConfig("incomplete", filenames); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	cases := map[string]SSOConfig{
+		"legacy": {
+			StartURL:  "https://test.awsapps.com/start",
+			Region:    "us-east-1",
+			AccountID: "111111111111",
+			RoleName:  "Test",
+		},
+		"session": {
+			Session:   "test",
+			StartURL:  "https://test.awsapps.com/start",
+			Region:    "us-east-1",
+			AccountID: "111111111111",
+			RoleName:  "Test",
+		},
+	}
+
+	for name, want := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := LoadSSOConfig(name, filenames)
+			if err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			if *got != want {
+				t.Fatalf("got %#v, want %#v", *got, want)
+			}
+		})
+	}
+}
+
+func newTestSSOProvider(t *testing.T, cacheDir, endpoint string) *SSOProvider {
+	config := &SSOConfig{
+		StartURL:  "https://test.awsapps.com/start",
+		Region:    "us-east-1",
+		AccountID: "111111111111",
+		RoleName:  "Test",
+	}
+
+	provider := NewSSOProvider(http.DefaultClient, config)
+	provider.CacheDir = cacheDir
+	provider.Endpoint = endpoint
+
+	return provider
+}
+
+func TestSSOProviderRetrieve(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-amz-sso_bearer_token") != "test_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/federation/credentials" ||
+			r.URL.Query().Get("account_id") != "111111111111" ||
+			r.URL.Query().Get("role_name") != "Test" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"roleCredentials":{"accessKeyId":"test_id","secretAccessKey":"test_secret","sessionToken":"test_session","expiration":4102444800000}}`))
+	}))
+
+	defer server.Close()
+
+	t.Run("no session", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.RemoveAll(dir)
+
+		provider := newTestSSOProvider(t, dir, server.URL)
+		errString := "SSO session not found, run `aws sso login` first"
+
+		if _, got := provider.Retrieve(); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.RemoveAll(dir)
+
+		provider := newTestSSOProvider(t, dir, server.URL)
+		expiresAt := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+		writeTestFile(t, dir, provider.Config.cacheKey()+".json",
+			`{"accessToken":"test_token","expiresAt":"`+expiresAt+`"}`)
+		errString := "SSO session has expired, run `aws sso login` again"
+
+		if _, got := provider.Retrieve(); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	t.Run("portal error", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.RemoveAll(dir)
+
+		provider := newTestSSOProvider(t, dir, server.URL)
+		expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		writeTestFile(t, dir, provider.Config.cacheKey()+".json",
+			`{"accessToken":"wrong_token","expiresAt":"`+expiresAt+`"}`)
+		errString := "could not get SSO role credentials: 401 Unauthorized"
+
+		if _, got := provider.Retrieve(); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.RemoveAll(dir)
+
+		provider := newTestSSOProvider(t, dir, server.URL)
+		expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		writeTestFile(t, dir, provider.Config.cacheKey()+".json",
+			`{"accessToken":"test_token","expiresAt":"`+expiresAt+`"}`)
+
+		credentials, err := provider.Retrieve()
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if credentials.AccessKeyID != "test_id" ||
+			credentials.SecretAccessKey != "test_secret" ||
+			credentials.SessionToken != "test_session" {
+			t.Fatalf("unexpected credentials: %#v", credentials)
+		}
+
+		if !credentials.CanExpire || credentials.Expired() {
+			t.Fatalf("unexpected expiration: %v", credentials.Expires)
+		}
+	})
+}
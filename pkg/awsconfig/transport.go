@@ -0,0 +1,48 @@
+package awsconfig
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// IdleConnTimeout is how long an idle connection is kept open. It is longer
+// than the SDK default, so that the connections survive the pauses between
+// the parts, such as while a part is being read and hashed.
+var IdleConnTimeout = 90 * time.Second
+
+// NewHTTPClient creates an HTTP client with the connection pool sized for
+// the number of concurrent requests. The SDK client keeps at most 10 idle
+// connections per host, so that with more jobs the connections are closed
+// and dialed again, repeating the TLS handshake, after every part.
+func NewHTTPClient(conns int) *http.Client {
+	if conns < 1 {
+		conns = 1
+	}
+
+	// A few more connections are kept for the requests made alongside
+	// the parts, such as listing the parts.
+	idle := conns + 2
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          idle,
+			MaxIdleConnsPerHost:   idle,
+			IdleConnTimeout:       IdleConnTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 5 * time.Second,
+			// The new connections resume the TLS sessions of the earlier ones.
+			TLSClientConfig: &tls.Config{
+				ClientSessionCache: tls.NewLRUClientSessionCache(idle),
+			},
+		},
+	}
+}
@@ -0,0 +1,30 @@
+package awsconfig
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	for _, test := range []struct {
+		conns, idle int
+	}{
+		{0, 3},
+		{1, 3},
+		{4, 6},
+		{64, 66},
+	} {
+		client := NewHTTPClient(test.conns)
+		transport := client.Transport.(*http.Transport)
+
+		if transport.MaxIdleConnsPerHost != test.idle || transport.MaxIdleConns != test.idle {
+			t.Errorf("%d connections: got %#v idle, want %#v", test.conns, transport.MaxIdleConnsPerHost, test.idle)
+		}
+		if transport.IdleConnTimeout != IdleConnTimeout {
+			t.Errorf("got %#v, want %#v", transport.IdleConnTimeout, IdleConnTimeout)
+		}
+		if transport.TLSClientConfig == nil || transport.TLSClientConfig.ClientSessionCache == nil {
+			t.Errorf("%d connections: the TLS sessions are not cached", test.conns)
+		}
+	}
+}
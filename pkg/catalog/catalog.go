@@ -0,0 +1,139 @@
+// Package catalog keeps the local record of the archives uploaded with surge.
+//
+// Amazon Glacier only lists the archives of a vault in an inventory, which is
+// updated once a day and takes hours to retrieve. The catalog remembers what was
+// uploaded, so that it can be found and compared against the inventory.
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/31z4/surge/pkg/sealed"
+)
+
+const catalogEnvVar = "SURGE_CATALOG"
+
+// Entry describes an archive stored in a vault.
+type Entry struct {
+	// The region and the name of the vault the archive is stored in.
+	Region string `json:"region,omitempty"`
+	Vault  string `json:"vault"`
+
+	ArchiveId   string `json:"archive_id"`
+	Description string `json:"description,omitempty"`
+
+	// The path of the uploaded file.
+	FileName string `json:"file,omitempty"`
+
+	Size int64 `json:"size"`
+
+	// The hex encoded tree hash of the archive.
+	TreeHash string `json:"tree_hash"`
+
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// Catalog is the list of the known archives.
+type Catalog struct {
+	Archives []*Entry `json:"archives"`
+}
+
+// DefaultPath returns the SURGE_CATALOG environment variable
+// or ~/.surge/catalog.json if it is not set.
+func DefaultPath() string {
+	if path := os.Getenv(catalogEnvVar); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".surge", "catalog.json")
+}
+
+// Load reads the catalog from the file. A missing file is an empty catalog.
+// An encrypted catalog is decrypted with the passphrase. A catalog that can't
+// be parsed is reported corrupted rather than taken for an empty one.
+func Load(path string, passphrase []byte) (*Catalog, error) {
+	data, err := sealed.ReadFile(path, passphrase)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Catalog{}, nil
+		}
+		return nil, err
+	}
+
+	var c Catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("the catalog %s is corrupted: %v", path, err)
+	}
+
+	return &c, nil
+}
+
+// Save writes the catalog to the file, creating its directory if necessary.
+// The catalog is encrypted with the passphrase unless it is empty. The file
+// is replaced at once, so that a crash keeps either the previous catalog or
+// the saved one.
+func (c *Catalog) Save(path string, passphrase []byte) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	return sealed.WriteFile(path, data, 0600, passphrase)
+}
+
+// Add records the archive.
+func (c *Catalog) Add(e *Entry) {
+	c.Archives = append(c.Archives, e)
+}
+
+// Remove forgets the archive of the vault. It returns false if the archive
+// was not in the catalog.
+func (c *Catalog) Remove(region, vault, archiveId string) bool {
+	for i, e := range c.Archives {
+		if e.matches(region, vault) && e.ArchiveId == archiveId {
+			c.Archives = append(c.Archives[:i], c.Archives[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Vault returns the archives stored in the vault.
+func (c *Catalog) Vault(region, vault string) []*Entry {
+	var entries []*Entry
+	for _, e := range c.Archives {
+		if e.matches(region, vault) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Find returns the archive of the vault with the tree hash and size,
+// or nil if there is none.
+func (c *Catalog) Find(region, vault, treeHash string, size int64) *Entry {
+	for _, e := range c.Archives {
+		if e.matches(region, vault) && e.TreeHash == treeHash && e.Size == size {
+			return e
+		}
+	}
+	return nil
+}
+
+// matches reports whether the archive is stored in the vault. An empty region
+// matches any region, since it may be unknown when the archive is recorded.
+func (e *Entry) matches(region, vault string) bool {
+	return e.Vault == vault && (e.Region == "" || region == "" || e.Region == region)
+}
@@ -0,0 +1,146 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/31z4/surge/pkg/sealed"
+)
+
+func TestCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "surge", "catalog.json")
+
+	c, err := Load(filename, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if len(c.Archives) != 0 {
+		t.Fatalf("got %#v, want an empty catalog", c.Archives)
+	}
+
+	first := &Entry{
+		Region:     "eu-central-1",
+		Vault:      "test",
+		ArchiveId:  "first",
+		FileName:   "first.tar",
+		Size:       4,
+		TreeHash:   "hash",
+		UploadedAt: time.Date(2018, 5, 5, 19, 1, 52, 0, time.UTC),
+	}
+	second := &Entry{
+		Region:    "us-east-1",
+		Vault:     "test",
+		ArchiveId: "second",
+	}
+
+	c.Add(first)
+	c.Add(second)
+
+	if err := c.Save(filename, nil); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	c, err = Load(filename, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	if got, want := c.Vault("eu-central-1", "test"), []*Entry{first}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	if got := c.Vault("", "test"); len(got) != 2 {
+		t.Fatalf("got %d archives, want 2", len(got))
+	}
+
+	if got := c.Find("", "test", "hash", 4); !reflect.DeepEqual(got, first) {
+		t.Fatalf("got %#v, want %#v", got, first)
+	}
+
+	if got := c.Find("us-east-1", "test", "hash", 4); got != nil {
+		t.Fatalf("got %#v, want nil", got)
+	}
+
+	if c.Remove("eu-central-1", "test", "second") {
+		t.Fatal("the archive of another region must not be removed")
+	}
+
+	if !c.Remove("eu-central-1", "test", "first") {
+		t.Fatal("the archive must be removed")
+	}
+
+	if got, want := c.Archives, []*Entry{second}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file, err := ioutil.TempFile("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err := file.WriteString("not json"); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if _, err := Load(file.Name(), nil); err == nil || !strings.Contains(err.Error(), "is corrupted") {
+		t.Fatalf("got %#v, want the catalog corrupted", err)
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "catalog.json")
+	passphrase := []byte("test")
+
+	sealed.Iterations = 1000
+
+	c := &Catalog{}
+	c.Add(&Entry{Vault: "test", ArchiveId: "secret"})
+
+	if err := c.Save(filename, passphrase); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sealed.IsSealed(data) {
+		t.Fatalf("the catalog must be encrypted, got %q", data)
+	}
+
+	if _, err := Load(filename, nil); err != sealed.ErrPassphraseRequired {
+		t.Fatalf("got %#v, want %#v", err, sealed.ErrPassphraseRequired)
+	}
+
+	got, err := Load(filename, passphrase)
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Fatalf("got %#v, want %#v", got, c)
+	}
+}
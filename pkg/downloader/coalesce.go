@@ -0,0 +1,141 @@
+package downloader
+
+import (
+	"sync"
+)
+
+// coalescer buffers the downloaded parts and writes the adjacent ones at once,
+// so that parts completed out of order don't turn into scattered small writes.
+// It is safe for concurrent use.
+type coalescer struct {
+	write func(data []byte, offset int64) error
+
+	// A run of adjacent parts is written once it reaches size bytes.
+	// Everything is written once limit bytes are buffered.
+	size  int64
+	limit int64
+
+	mutex    sync.Mutex
+	starts   map[int64][]byte // the buffered parts by their start offset
+	ends     map[int64]int64  // the start offsets of the buffered parts by their end offset
+	buffered int64
+	err      error
+}
+
+// coalesceLimit returns the most part data buffered by a coalescer writing
+// runs of the size before it writes everything.
+func coalesceLimit(size int64) int64 {
+	return 4 * size
+}
+
+func newCoalescer(write func(data []byte, offset int64) error, size int64) *coalescer {
+	return &coalescer{
+		write:  write,
+		size:   size,
+		limit:  coalesceLimit(size),
+		starts: make(map[int64][]byte),
+		ends:   make(map[int64]int64),
+	}
+}
+
+// add buffers the part data at the offset, writing it along with the
+// adjacent parts if they are large enough. The data must not be modified
+// afterwards. A write error is returned by every subsequent call.
+func (c *coalescer) add(data []byte, offset int64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	end := offset + int64(len(data))
+	c.starts[offset] = data
+	c.ends[end] = offset
+	c.buffered += int64(len(data))
+
+	start := offset
+	for {
+		prev, ok := c.ends[start]
+		if !ok {
+			break
+		}
+		start = prev
+	}
+	for {
+		next, ok := c.starts[end]
+		if !ok {
+			break
+		}
+		end += int64(len(next))
+	}
+
+	if end-start >= c.size {
+		c.err = c.writeRun(start)
+	} else if c.buffered >= c.limit {
+		c.err = c.flushAll()
+	}
+
+	return c.err
+}
+
+// flush writes all the buffered parts.
+// It is safe to call on a nil coalescer.
+func (c *coalescer) flush() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.err == nil {
+		c.err = c.flushAll()
+	}
+	return c.err
+}
+
+func (c *coalescer) flushAll() error {
+	for start := range c.starts {
+		if _, ok := c.ends[start]; ok {
+			// Not the first part of a run.
+			continue
+		}
+		if err := c.writeRun(start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRun writes the run of adjacent parts beginning at the start offset
+// and forgets them.
+func (c *coalescer) writeRun(start int64) error {
+	var parts [][]byte
+	var size int64
+
+	offset := start
+	for {
+		data, ok := c.starts[offset]
+		if !ok {
+			break
+		}
+		parts = append(parts, data)
+		size += int64(len(data))
+
+		delete(c.starts, offset)
+		offset += int64(len(data))
+		delete(c.ends, offset)
+	}
+	c.buffered -= size
+
+	data := parts[0]
+	if len(parts) > 1 {
+		data = make([]byte, 0, size)
+		for _, p := range parts {
+			data = append(data, p...)
+		}
+	}
+
+	return c.write(data, start)
+}
@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type write struct {
+	offset int64
+	data   string
+}
+
+func TestCoalescer(t *testing.T) {
+	var writes []write
+	c := newCoalescer(func(data []byte, offset int64) error {
+		writes = append(writes, write{offset, string(data)})
+		return nil
+	}, 4)
+
+	add := func(data string, offset int64) {
+		if err := c.add([]byte(data), offset); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+	}
+
+	add("cd", 2)
+	add("gh", 6)
+	if len(writes) != 0 {
+		t.Fatalf("got %#v, want no writes", writes)
+	}
+
+	add("ab", 0)
+	add("ij", 8)
+	add("ef", 4)
+
+	if err := c.flush(); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	want := []write{{0, "abcd"}, {6, "ghij"}, {4, "ef"}}
+	if !reflect.DeepEqual(writes, want) {
+		t.Fatalf("got %#v, want %#v", writes, want)
+	}
+
+	if c.buffered != 0 || len(c.starts) != 0 || len(c.ends) != 0 {
+		t.Fatalf("the coalescer must be empty, got %#v", c)
+	}
+}
+
+func TestCoalescerLimit(t *testing.T) {
+	var writes []write
+	c := newCoalescer(func(data []byte, offset int64) error {
+		writes = append(writes, write{offset, string(data)})
+		return nil
+	}, 4)
+	c.limit = 3
+
+	c.add([]byte("a"), 0)
+	c.add([]byte("c"), 2)
+	if len(writes) != 0 {
+		t.Fatalf("got %#v, want no writes", writes)
+	}
+
+	c.add([]byte("e"), 4)
+	if len(writes) != 3 {
+		t.Fatalf("got %#v, want every part written", writes)
+	}
+}
+
+func TestCoalescerError(t *testing.T) {
+	err := errors.New("test")
+	c := newCoalescer(func(data []byte, offset int64) error {
+		return err
+	}, 1)
+
+	if got := c.add([]byte("a"), 0); got != err {
+		t.Fatalf("got %#v, want %#v", got, err)
+	}
+	if got := c.add([]byte("b"), 1); got != err {
+		t.Fatalf("got %#v, want %#v", got, err)
+	}
+	if got := c.flush(); got != err {
+		t.Fatalf("got %#v, want %#v", got, err)
+	}
+
+	var nilCoalescer *coalescer
+	if err := nilCoalescer.flush(); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+}
+
+func BenchmarkCoalescer(b *testing.B) {
+	const partSize = 64 << 10
+	data := make([]byte, partSize)
+
+	c := newCoalescer(func(data []byte, offset int64) error {
+		return nil
+	}, 16<<20)
+
+	b.SetBytes(partSize)
+	for i := 0; i < b.N; i++ {
+		// Swap every pair of the adjacent parts to simulate out of order completion.
+		offset := int64(i^1) * partSize
+		if err := c.add(data, offset); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	if err := c.flush(); err != nil {
+		b.Fatal(err)
+	}
+}
@@ -4,19 +4,32 @@
 package downloader
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/31z4/surge/pkg/events"
 	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/glacier"
 	"github.com/aws/aws-sdk-go-v2/service/glacier/glacieriface"
 )
 
+var (
+	// ErrJobNotReady is returned when the retrieval job has not succeeded yet.
+	ErrJobNotReady = errors.New("the job is not succeeded yet")
+
+	// ErrHashMismatch is returned when the downloaded data doesn't match its tree hash.
+	ErrHashMismatch = errors.New("hash mismatch")
+)
+
 // Input provides options for multipart download from an Amazon Glacier vault.
 type Input struct {
 	// The AccountId value is the AWS account ID of the account that owns the vault.
@@ -32,12 +45,140 @@ type Input struct {
 	// Filename where the content will be saved.
 	FileName string
 
+	// Overwrite allows replacing the content of an existing file.
+	// Otherwise the download fails if the file exists.
+	Overwrite bool
+
 	// The job ID whose data is downloaded.
 	JobId string
 
 	// The size of each part except the last, in bytes. The last part can be smaller
-	// than this part size.
+	// than this part size. If the value is zero then the part size is chosen
+	// from the size of the archive, see AutoPartSize.
 	PartSize int64
+
+	// The order the parts are downloaded in.
+	Order utils.Order
+
+	// The maximum transfer rate in bytes per second shared by all the parallel
+	// jobs. If the value is zero then the rate is not limited.
+	MaxRate int64
+
+	// WriteBuffer is the size of the adjacent parts buffered in memory and
+	// written to the file at once. If the value is zero then every part is
+	// written as soon as it is downloaded.
+	WriteBuffer int64
+
+	// MaxMemory bounds the part data buffered in memory, in bytes, including
+	// the write buffer. Every job buffers the part it downloads. If the parts
+	// of the jobs would exceed the limit, fewer parts are downloaded at once.
+	// The parts buffered by a destination are not counted. If the value is
+	// zero then the memory is not limited.
+	MaxMemory int64
+
+	// DropCache advises the kernel to drop the cached pages of the file once
+	// they are transferred, so that a large transfer doesn't evict the page
+	// cache of other processes. It only has effect on Linux.
+	DropCache bool
+
+	// Quarantine saves the data of a part that doesn't match its tree hash to
+	// FileName.badpart-OFFSET before the part is downloaded again, so that
+	// the source of the corruption can be investigated. It is ignored for a
+	// destination.
+	Quarantine bool
+
+	// StateFile records the tree hashes of the parts written to the file. If
+	// the download is interrupted and run again for the same job, the parts
+	// of the file that match the state file are not downloaded again, even
+	// though the file exists and Overwrite is not set. The state file is
+	// removed once the download is verified. If the value is empty then the
+	// download always starts over.
+	StateFile string
+
+	// Destination receives the archive instead of the file. The tree hash of
+	// the archive is then verified from the hashes of the parts, which requires
+	// the part size to be a multiple of 1 MiB, and the write buffer is not used.
+	Destination Destination
+
+	// OnFailure is what the download does with a part that fails once its
+	// requests are not retried anymore, including a part that doesn't match
+	// its tree hash. By default the part is downloaded once more, then
+	// skipped, so that the download fails once the other parts are written.
+	OnFailure utils.FailurePolicy
+
+	// SlowPartFactor makes the download warn about a part that takes more than
+	// the factor times the rolling median of the recent parts, which tells a
+	// degrading connection early. If the value is zero then no part is reported.
+	SlowPartFactor float64
+
+	// Context cancels every request of the download and hashing the parts
+	// checked when the download is resumed or corrupted and the whole archive
+	// once it is done. If the value is nil then the download is not canceled.
+	Context context.Context
+
+	// Logger receives the download events.
+	// If the value is nil then the events are printed using the standard logger.
+	Logger events.Logger
+}
+
+// Result describes a download.
+type Result struct {
+	// Verified reports whether the archive matches its tree hash, the hex
+	// encoded tree hash returned by the job.
+	Verified bool
+	TreeHash string
+
+	// The parts downloaded and the parts that could not be downloaded.
+	PartsDownloaded int64
+	PartsFailed     int64
+
+	// The bytes downloaded, and the bytes of the parts found already in the
+	// file when the download was resumed, which were not downloaded again.
+	BytesDownloaded int64
+	BytesReused     int64
+
+	// The number of the retries, and the highest rate of a single part in
+	// bytes per second.
+	Retries  int64
+	PeakRate int64
+
+	Elapsed time.Duration
+}
+
+// Destination receives the downloaded archive a part at a time, in any order.
+type Destination interface {
+	io.WriterAt
+
+	// Truncate is called with the size of the archive before any part is written.
+	Truncate(size int64) error
+
+	// Commit is called once the whole archive is written and verified.
+	Commit() error
+
+	// Abort is called instead of Commit if the download fails.
+	Abort() error
+}
+
+// leafSize is the size of the data hashed by every leaf of a tree hash.
+const leafSize = 1 << 20
+
+// The bounds of the automatic part size.
+const (
+	autoParts       = 1000
+	maxAutoPartSize = 64 << 20
+)
+
+// AutoPartSize returns the part size an archive of the size is downloaded
+// with by default: the smallest power of two megabytes that downloads the
+// archive in at most 1000 parts, but no more than 64 MiB since every job
+// holds a whole part in memory. The parts are tree-hash aligned, so Amazon
+// Glacier returns the tree hash of every part to verify it with.
+func AutoPartSize(size int64) int64 {
+	partSize := int64(leafSize)
+	for partSize < maxAutoPartSize && (size+partSize-1)/partSize > autoParts {
+		partSize *= 2
+	}
+	return partSize
 }
 
 // Downloader holds internal downloader state.
@@ -49,6 +190,38 @@ type Downloader struct {
 	treeHash *string
 	size     int64
 	offset   int64
+
+	limiter   *utils.Limiter
+	coalescer *coalescer
+
+	// The context of the parts, canceled by the first failure if the policy
+	// stops the download, and the failed parts.
+	context  context.Context
+	failures *utils.PartFailures
+
+	// The tree hashes of the parts returned by the job output, which tell
+	// the corrupted parts of the file apart.
+	checksums []string
+
+	// The state file of the download, and the offsets of the parts found
+	// already in the file when the download is resumed.
+	state    *stateWriter
+	verified map[int64]bool
+
+	// The destination written instead of the file, and the leaves of the
+	// tree hash of the archive written to it.
+	destination Destination
+	leaves      [][sha256.Size]byte
+
+	partsDownloaded int64
+	bytesDownloaded int64
+	retries         int64
+
+	// The highest rate of a single part in bytes per second.
+	peakRate int64
+
+	// The latencies of the downloaded parts.
+	latencies *utils.PartLatencies
 }
 
 // New creates a new instance of the downloader with a service and input.
@@ -56,15 +229,74 @@ func New(service glacieriface.GlacierAPI, input *Input) *Downloader {
 	return &Downloader{
 		service: service,
 		input:   input,
+		limiter: utils.NewLimiter(input.MaxRate),
+	}
+}
+
+// NewWriterAt creates a new instance of the downloader writing the archive to w
+// instead of the file, for example a block device, a buffer in memory or a
+// file of a virtual filesystem. Like for a destination, the tree hash is
+// verified from the hashes of the parts, so the part size must be a multiple
+// of 1 MiB. The input is copied, so it is left unchanged.
+func NewWriterAt(service glacieriface.GlacierAPI, input *Input, w io.WriterAt) *Downloader {
+	in := *input
+	in.Destination = writerAtDestination{w}
+	return New(service, &in)
+}
+
+// writerAtDestination is a destination with nothing to prepare, commit or
+// discard.
+type writerAtDestination struct {
+	io.WriterAt
+}
+
+func (writerAtDestination) Truncate(int64) error { return nil }
+func (writerAtDestination) Commit() error        { return nil }
+func (writerAtDestination) Abort() error         { return nil }
+
+// ctx returns the context of the download, the context of the input or the
+// background context.
+func (d *Downloader) ctx() context.Context {
+	if d.context != nil {
+		return d.context
+	}
+	if d.input.Context != nil {
+		return d.input.Context
+	}
+	return context.Background()
+}
+
+// canceled returns the error of the failed parts, or the error of the
+// context once it is done.
+func (d *Downloader) canceled() error {
+	if d.failures != nil {
+		if err := d.failures.Err(); err != nil {
+			return err
+		}
 	}
+	return d.ctx().Err()
+}
+
+func (d *Downloader) log(e *events.Event) {
+	e.Operation = "download"
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	logger := d.input.Logger
+	if logger == nil {
+		logger = events.TextLogger{}
+	}
+	logger.Log(e)
 }
 
 func (d *Downloader) openFile() error {
-	file, err := os.OpenFile(
-		d.input.FileName,
-		os.O_RDWR|os.O_CREATE|os.O_EXCL,
-		0644,
-	)
+	flag := os.O_RDWR | os.O_CREATE | os.O_EXCL
+	if d.input.Overwrite {
+		flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(d.input.FileName, flag, 0644)
 	if err != nil {
 		return err
 	}
@@ -74,7 +306,9 @@ func (d *Downloader) openFile() error {
 	return nil
 }
 
-func (d *Downloader) downloadPart(r *utils.Range) error {
+// downloadPart downloads the part. The report receives the checksum of the
+// part, the ID of its request and the number of the attempts.
+func (d *Downloader) downloadPart(r *utils.Range, report *events.Event) error {
 	rangeString := fmt.Sprint("bytes=", r)
 	input := &glacier.GetJobOutputInput{
 		AccountId: &d.input.AccountId,
@@ -84,7 +318,29 @@ func (d *Downloader) downloadPart(r *utils.Range) error {
 	}
 
 	request := d.service.GetJobOutputRequest(input)
+	request.SetContext(d.ctx())
+	maxAttempts := events.MaxAttempts(request.Request)
+	events.OnRetry(request.Request, func(attempt int, err error) {
+		atomic.AddInt64(&d.retries, 1)
+		part, cause := utils.PartNumber(r, d.input.PartSize), events.ErrorCause(err)
+		d.log(&events.Event{
+			Type: events.Retry,
+			Message: fmt.Sprintf("retry downloading %s, %s (%s): %v",
+				events.PartName(part, r), events.AttemptName(attempt, maxAttempts), cause, err),
+			Range:       r,
+			Part:        part,
+			Attempt:     attempt,
+			MaxAttempts: maxAttempts,
+			Cause:       cause,
+			Error:       err.Error(),
+		})
+	})
+
+	d.limiter.Wait(r.Limit)
+
 	result, err := request.Send()
+	report.RequestId = request.RequestID
+	report.Attempt = request.RetryCount + 1
 	if err != nil {
 		return err
 	}
@@ -100,23 +356,72 @@ func (d *Downloader) downloadPart(r *utils.Range) error {
 	}
 
 	if result.Checksum != nil {
-		reader := bytes.NewReader(body)
-		treeHash := utils.ComputeTreeHash(reader)
+		report.Checksum = *result.Checksum
+
+		if d.checksums != nil {
+			d.checksums[r.Offset/d.input.PartSize] = *result.Checksum
+		}
+
+		treeHash := utils.TreeHashBytes(body)
 		if treeHash == nil {
 			return errors.New("could not compute hash")
 		}
 
 		if *result.Checksum != *treeHash {
-			return errors.New("hash mismatch")
+			if d.input.Quarantine && d.destination == nil {
+				d.quarantine(r, body)
+			}
+			return ErrHashMismatch
 		}
 	}
 
-	n, err := d.file.WriteAt(body, r.Offset)
+	if d.leaves != nil {
+		copy(d.leaves[r.Offset/leafSize:], utils.TreeHashLeaves(body))
+	}
+
+	if d.coalescer != nil {
+		err = d.coalescer.add(body, r.Offset)
+	} else {
+		err = d.writeAt(body, r.Offset)
+	}
 	if err != nil {
 		return err
 	}
-	if n != int(r.Limit) {
-		return fmt.Errorf("could not write %d bytes to the file", r.Limit)
+
+	if d.state != nil {
+		// A part recorded before the write buffer is flushed is found
+		// corrupted and downloaded again if the download is interrupted.
+		treeHash := report.Checksum
+		if treeHash == "" {
+			treeHash = *utils.TreeHashBytes(body)
+		}
+		if err := d.state.record(r, treeHash); err != nil {
+			return err
+		}
+	}
+
+	atomic.AddInt64(&d.partsDownloaded, 1)
+	atomic.AddInt64(&d.bytesDownloaded, r.Limit)
+
+	return nil
+}
+
+func (d *Downloader) writeAt(data []byte, offset int64) error {
+	var w io.WriterAt = d.file
+	if d.destination != nil {
+		w = d.destination
+	}
+
+	n, err := w.WriteAt(data, offset)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return fmt.Errorf("could not write %d bytes to the file", len(data))
+	}
+
+	if d.input.DropCache && d.file != nil {
+		utils.DropCache(d.file, offset, int64(len(data)))
 	}
 
 	return nil
@@ -141,9 +446,38 @@ func (d *Downloader) getNextRange() *utils.Range {
 	}
 }
 
-func (d *Downloader) multipartDownload(jobs int) {
+func (d *Downloader) multipartDownload(jobs int) error {
+	var parts []*utils.Range
+	for r := d.getNextRange(); r != nil; r = d.getNextRange() {
+		if !d.verified[r.Offset] {
+			parts = append(parts, r)
+		}
+	}
+	d.input.Order.Schedule(parts)
+
+	return d.downloadParts(jobs, rangeIterator(parts))
+}
+
+// rangeIterator returns a function returning the parts one by one, then nil.
+func rangeIterator(parts []*utils.Range) func() *utils.Range {
+	next := 0
+	return func() *utils.Range {
+		if next == len(parts) {
+			return nil
+		}
+		next++
+		return parts[next-1]
+	}
+}
+
+// downloadParts downloads the parts returned by next until it returns nil.
+func (d *Downloader) downloadParts(jobs int, next func() *utils.Range) error {
 	parts := make(chan *utils.Range)
 
+	if d.failures == nil {
+		d.context, d.failures = utils.WithPartFailures(d.ctx(), d.input.OnFailure)
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(jobs)
 
@@ -152,26 +486,97 @@ func (d *Downloader) multipartDownload(jobs int) {
 			defer wg.Done()
 
 			for p := range parts {
-				log.Printf("start downloading part (%v)", p)
-				if err := d.downloadPart(p); err != nil {
-					log.Printf("error downloading part (%v): %v", p, err)
+				if d.ctx().Err() != nil {
+					continue
+				}
+
+				d.log(&events.Event{
+					Type:    events.PartStarted,
+					Message: fmt.Sprintf("start downloading part (%v)", p),
+					Range:   p,
+					Part:    utils.PartNumber(p, d.input.PartSize),
+				})
+
+				report := &events.Event{
+					JobId: d.input.JobId,
+					Range: p,
+				}
+				start := time.Now()
+
+				err := d.downloadPart(p, report)
+				for retry := 0; err != nil && retry < d.input.OnFailure.Retries() && d.ctx().Err() == nil; retry++ {
+					atomic.AddInt64(&d.retries, 1)
+					if err == ErrHashMismatch {
+						d.log(&events.Event{
+							Type:     events.PartMismatch,
+							Message:  fmt.Sprintf("part (%v) is corrupted, downloading it again", p),
+							JobId:    d.input.JobId,
+							Range:    p,
+							Verified: aws.Bool(false),
+						})
+					} else {
+						part, cause := utils.PartNumber(p, d.input.PartSize), events.ErrorCause(err)
+						d.log(&events.Event{
+							Type:    events.Retry,
+							Message: fmt.Sprintf("download %s once more after it failed (%s): %v", events.PartName(part, p), cause, err),
+							Range:   p,
+							Part:    part,
+							Cause:   cause,
+							Error:   err.Error(),
+						})
+					}
+					err = d.downloadPart(p, report)
+				}
+				report.Elapsed = time.Since(start).Seconds()
+
+				if err != nil {
+					report.Type = events.PartFailed
+					report.Message = fmt.Sprintf("error downloading part (%v): %v", p, err)
+					report.Error = err.Error()
+					d.log(report)
+
+					d.failures.Record(utils.PartError{Range: p, Err: err})
 				} else {
-					log.Printf("finish downloading part (%v)", p)
+					report.Type = events.PartFinished
+					report.Message = fmt.Sprintf("finish downloading part (%v)", p)
+					d.log(report)
+					d.recordRate(p.Limit, report.Elapsed)
+					d.checkSlowPart(report)
+					d.latencies.Record(p, report.Elapsed)
 				}
 			}
 		}()
 	}
 
-	for {
-		if p := d.getNextRange(); p != nil {
-			parts <- p
-		} else {
-			break
+	done := d.ctx().Done()
+feed:
+	for p := next(); p != nil; p = next() {
+		select {
+		case parts <- p:
+		case <-done:
+			break feed
 		}
 	}
 
 	close(parts)
 	wg.Wait()
+
+	return d.canceled()
+}
+
+// recordRate raises the peak rate to the rate of the part if it is higher.
+func (d *Downloader) recordRate(bytes int64, seconds float64) {
+	if seconds <= 0 {
+		return
+	}
+
+	rate := int64(float64(bytes) / seconds)
+	for {
+		peak := atomic.LoadInt64(&d.peakRate)
+		if rate <= peak || atomic.CompareAndSwapInt64(&d.peakRate, peak, rate) {
+			return
+		}
+	}
 }
 
 func (d *Downloader) checkJob() error {
@@ -182,6 +587,7 @@ func (d *Downloader) checkJob() error {
 	}
 
 	request := d.service.DescribeJobRequest(input)
+	request.SetContext(d.ctx())
 	result, err := request.Send()
 	if err != nil {
 		return err
@@ -195,7 +601,7 @@ func (d *Downloader) checkJob() error {
 	status := string(result.StatusCode)
 	if status != "Succeeded" {
 		if status == "InProgress" {
-			return errors.New("the job is not succeeded yet")
+			return ErrJobNotReady
 		}
 		if status == "Failed" {
 			return errors.New("the job is failed: " + *result.StatusMessage)
@@ -214,39 +620,468 @@ func (d *Downloader) checkJob() error {
 }
 
 func (d *Downloader) checkTreeHash() error {
-	treeHash := utils.ComputeTreeHash(d.file)
+	var treeHash *string
+	if d.leaves != nil {
+		treeHash = utils.TreeHashOfLeaves(d.leaves)
+	} else {
+		progress := events.OnHashed(d.log, d.input.FileName, d.size)
+
+		var err error
+		treeHash, err = utils.ComputeTreeHashContext(d.ctx(), d.file, progress)
+		if err != nil && d.ctx().Err() != nil {
+			return err
+		}
+	}
 	if treeHash == nil {
 		return errors.New("could not compute hash")
 	}
 
 	if *treeHash != *d.treeHash {
-		return errors.New("hash mismatch")
+		return ErrHashMismatch
 	}
 
 	return nil
 }
 
-// Download performs parallel multipart download.
+// Download performs parallel multipart download and returns the result of
+// the verified download. The unverified result is returned along with the
+// error as well if the download failed once the parts were downloaded.
 // The maximum number of the parallel downloads is limited by the jobs parameter.
-func (d Downloader) Download(jobs int) error {
+func (d Downloader) Download(jobs int) (*Result, error) {
+	start := time.Now()
+
+	accountId, err := utils.NormalizeAccountId(d.input.AccountId)
+	if err != nil {
+		return nil, err
+	}
+	d.input.AccountId = accountId
+
+	destination := d.input.Destination
+	if destination != nil && d.input.PartSize%leafSize != 0 {
+		return nil, errors.New("the part size must be a multiple of 1 MiB to download to a destination")
+	}
+
 	if err := d.checkJob(); err != nil {
-		return err
+		return nil, err
+	}
+	if d.input.PartSize == 0 {
+		d.input.PartSize = AutoPartSize(d.size)
+	}
+	if jobs, err = d.limitJobs(jobs); err != nil {
+		return nil, err
 	}
 
-	if err := d.openFile(); err != nil {
-		return err
+	d.context, d.failures = utils.WithPartFailures(d.ctx(), d.input.OnFailure)
+	d.latencies = &utils.PartLatencies{}
+
+	d.log(&events.Event{
+		Type:    events.Initiated,
+		Message: fmt.Sprintf("download of job %s started, %s", d.input.JobId, utils.FormatSize(d.size)),
+		JobId:   d.input.JobId,
+		Parts:   (d.size + d.input.PartSize - 1) / d.input.PartSize,
+		Bytes:   d.size,
+	})
+
+	if destination != nil {
+		if err := destination.Truncate(d.size); err != nil {
+			return nil, err
+		}
+		d.destination = destination
+		d.leaves = make([][sha256.Size]byte, (d.size+leafSize-1)/leafSize)
+	} else {
+		d.checksums = make([]string, (d.size+d.input.PartSize-1)/d.input.PartSize)
+
+		resumed, err := d.resume()
+		if err != nil {
+			return nil, err
+		}
+		if !resumed {
+			if err := d.openFile(); err != nil {
+				return nil, err
+			}
+		}
+		defer d.file.Close()
+
+		if !resumed {
+			if err := os.Truncate(d.input.FileName, d.size); err != nil {
+				return nil, err
+			}
+		}
+
+		if d.input.StateFile != "" {
+			if resumed {
+				d.state, err = appendState(d.input.StateFile)
+			} else {
+				d.state, err = createState(d.input.StateFile, d.input.JobId, d.size, d.input.PartSize)
+			}
+			if err != nil {
+				return nil, err
+			}
+			defer d.state.Close()
+		}
+
+		if d.input.WriteBuffer > 0 {
+			d.coalescer = newCoalescer(d.writeAt, d.input.WriteBuffer)
+		}
 	}
-	defer d.file.Close()
 
-	if err := os.Truncate(d.input.FileName, d.size); err != nil {
-		return err
+	if err := d.multipartDownload(jobs); err != nil {
+		// The buffered parts are recorded in the state already, so they
+		// are written for a resumed download to find them.
+		if d.file != nil {
+			d.coalescer.flush()
+		}
+		d.abort()
+		if d.failures.Len() == 0 {
+			return nil, err
+		}
+		result := d.result(start, false)
+		d.summarize(result)
+		return result, err
 	}
 
-	d.multipartDownload(jobs)
+	if err := d.coalescer.flush(); err != nil {
+		return nil, err
+	}
 
-	if err := d.checkTreeHash(); err != nil {
-		return err
+	err = d.checkTreeHash()
+	if err == ErrHashMismatch && d.file != nil {
+		corrupted, findErr := d.findCorruptedParts()
+		if findErr != nil {
+			err = findErr
+		} else if len(corrupted) > 0 {
+			if err = d.redownload(jobs, corrupted); err == nil {
+				if err = d.coalescer.flush(); err == nil {
+					err = d.checkTreeHash()
+				}
+			}
+		}
+	}
+	if d.input.DropCache && d.file != nil {
+		utils.DropCache(d.file, 0, d.size)
 	}
 
+	if err != nil {
+		d.log(&events.Event{
+			Type:     events.Verification,
+			Message:  fmt.Sprint("tree hash verification failed: ", err),
+			JobId:    d.input.JobId,
+			Error:    err.Error(),
+			Verified: aws.Bool(false),
+		})
+		d.abort()
+		return d.result(start, false), err
+	}
+
+	d.log(&events.Event{
+		Type:     events.Verification,
+		Message:  "tree hash verified",
+		JobId:    d.input.JobId,
+		Checksum: *d.treeHash,
+		Verified: aws.Bool(true),
+	})
+
+	if destination != nil {
+		if err := destination.Commit(); err != nil {
+			d.abort()
+			return nil, err
+		}
+	}
+
+	if d.state != nil {
+		d.removeState()
+	}
+
+	result := d.result(start, true)
+	d.summarize(result)
+
+	return result, nil
+}
+
+// result returns the result of the download started at the time.
+func (d *Downloader) result(start time.Time, verified bool) *Result {
+	result := &Result{
+		Verified:        verified,
+		TreeHash:        aws.StringValue(d.treeHash),
+		PartsDownloaded: d.partsDownloaded,
+		BytesDownloaded: d.bytesDownloaded,
+		Retries:         d.retries,
+		PeakRate:        d.peakRate,
+		Elapsed:         time.Since(start),
+	}
+	for offset := range d.verified {
+		result.BytesReused += d.partLimit(offset)
+	}
+	if d.failures != nil {
+		result.PartsFailed = int64(d.failures.Len())
+	}
+	return result
+}
+
+// partLimit returns the size of the part at the offset.
+func (d *Downloader) partLimit(offset int64) int64 {
+	if offset+d.input.PartSize > d.size {
+		return d.size - offset
+	}
+	return d.input.PartSize
+}
+
+// limitJobs reduces the jobs so that the parts they buffer fit in the memory
+// limit, along with the write buffer.
+func (d *Downloader) limitJobs(jobs int) (int, error) {
+	// The coalescer buffers up to its limit rather than the write buffer.
+	var buffered int64
+	if d.input.Destination == nil {
+		buffered = coalesceLimit(d.input.WriteBuffer)
+	}
+
+	limited, err := utils.LimitJobs(jobs, d.input.PartSize, buffered, d.input.MaxMemory)
+	if err != nil {
+		return 0, err
+	}
+	if limited < jobs {
+		d.log(&events.Event{
+			Type: events.Warning,
+			Message: fmt.Sprintf("downloading %d part(s) at once instead of %d to buffer at most %s",
+				limited, jobs, utils.FormatSize(d.input.MaxMemory)),
+			JobId: d.input.JobId,
+		})
+	}
+	return limited, nil
+}
+
+// checkSlowPart warns if the finished part took much longer than the recent parts.
+func (d *Downloader) checkSlowPart(report *events.Event) {
+	median, slow := d.latencies.Slow(report.Elapsed, d.input.SlowPartFactor)
+	if !slow {
+		return
+	}
+
+	d.log(&events.Event{
+		Type: events.Warning,
+		Message: fmt.Sprintf("part (%v) took %v on attempt %d, %.1f times the median of the recent parts",
+			report.Range, time.Duration(report.Elapsed*float64(time.Second)).Round(time.Millisecond), report.Attempt, report.Elapsed/median),
+		JobId:   d.input.JobId,
+		Range:   report.Range,
+		Attempt: report.Attempt,
+		Elapsed: report.Elapsed,
+	})
+}
+
+// summarize logs the summary of the download with the result. The failed
+// parts are described by the failure policy.
+func (d *Downloader) summarize(r *Result) {
+	message := fmt.Sprintf("downloaded %d part(s), %s in %v (%s), peak %s, %d retries",
+		r.PartsDownloaded, utils.FormatSize(r.BytesDownloaded), r.Elapsed.Round(time.Millisecond),
+		utils.FormatRate(r.BytesDownloaded, r.Elapsed.Seconds()), utils.FormatRate(r.PeakRate, 1), r.Retries)
+	if r.Verified {
+		message += ", tree hash verified"
+	} else {
+		message += ", " + d.input.OnFailure.Summary(int(r.PartsFailed))
+	}
+	latency := d.latencies.Summary()
+	if latency != nil {
+		message += ", " + latency.String()
+	}
+
+	d.log(&events.Event{
+		Type:     events.Summary,
+		Message:  message,
+		JobId:    d.input.JobId,
+		Verified: aws.Bool(r.Verified),
+		Parts:    r.PartsDownloaded,
+		Bytes:    r.BytesDownloaded,
+		Elapsed:  r.Elapsed.Seconds(),
+		Retries:  r.Retries,
+		PeakRate: r.PeakRate,
+		Latency:  latency,
+	})
+}
+
+// findCorruptedParts returns the parts of the file that don't match the tree
+// hashes returned for them by the job output. The parts without a tree hash,
+// which is only returned for the tree-hash aligned ranges, are not checked.
+func (d *Downloader) findCorruptedParts() ([]*utils.Range, error) {
+	var corrupted []*utils.Range
+
+	for i, checksum := range d.checksums {
+		if checksum == "" {
+			continue
+		}
+
+		offset := int64(i) * d.input.PartSize
+
+		limit := d.input.PartSize
+		if offset+limit > d.size {
+			limit = d.size - offset
+		}
+
+		treeHash, err := utils.ComputeTreeHashContext(d.ctx(), io.NewSectionReader(d.file, offset, limit), nil)
+		if err != nil && d.ctx().Err() != nil {
+			return nil, err
+		}
+		if treeHash == nil || *treeHash != checksum {
+			corrupted = append(corrupted, &utils.Range{Offset: offset, Limit: limit})
+		}
+	}
+
+	return corrupted, nil
+}
+
+// redownload downloads the corrupted parts again.
+func (d *Downloader) redownload(jobs int, parts []*utils.Range) error {
+	for _, p := range parts {
+		d.log(&events.Event{
+			Type:     events.PartMismatch,
+			Message:  fmt.Sprintf("part (%v) is corrupted, downloading it again", p),
+			JobId:    d.input.JobId,
+			Range:    p,
+			Verified: aws.Bool(false),
+		})
+	}
+
+	return d.downloadParts(jobs, rangeIterator(parts))
+}
+
+// quarantine saves the data of the part that doesn't match its tree hash. The
+// part fails regardless, so a failure to save it is only logged.
+func (d *Downloader) quarantine(r *utils.Range, data []byte) {
+	name := fmt.Sprintf("%s.badpart-%d", d.input.FileName, r.Offset)
+
+	e := &events.Event{
+		Type:    events.PartMismatch,
+		Message: fmt.Sprintf("part (%v) hash mismatch, its data is saved to %s", r, name),
+		JobId:   d.input.JobId,
+		Range:   r,
+	}
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		e.Type = events.Error
+		e.Message = fmt.Sprintf("could not save the data of part (%v): %v", r, err)
+		e.Error = err.Error()
+	}
+	d.log(e)
+}
+
+// resume opens the file to continue the download recorded in the state file
+// and checks the parts recorded in it, which are then not downloaded again.
+// It reports false if there is no download to resume.
+func (d *Downloader) resume() (bool, error) {
+	if d.input.StateFile == "" || d.input.Overwrite {
+		return false, nil
+	}
+
+	parts, err := readState(d.input.StateFile, d.input.JobId, d.size, d.input.PartSize)
+	if err != nil || len(parts) == 0 {
+		return false, err
+	}
+
+	file, err := os.OpenFile(d.input.FileName, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.Size() != d.size {
+		// The file is not the one recorded in the state file.
+		file.Close()
+		return false, err
+	}
+
+	d.file = file
+	if err := d.checkDownloadedParts(parts); err != nil {
+		file.Close()
+		return false, err
+	}
+
+	return true, nil
+}
+
+// checkDownloadedParts compares the parts of the file with the tree hashes
+// recorded for them in the state file, and marks the matching ones verified.
+// It stops with the error of the context once the context is done.
+func (d *Downloader) checkDownloadedParts(parts map[int64]string) error {
+	d.log(&events.Event{
+		Type:    events.CheckStarted,
+		Message: "start checking downloaded parts",
+		JobId:   d.input.JobId,
+	})
+
+	d.verified = make(map[int64]bool)
+	for offset := int64(0); offset < d.size; offset += d.input.PartSize {
+		treeHash, ok := parts[offset]
+		if !ok {
+			continue
+		}
+
+		limit := d.input.PartSize
+		if offset+limit > d.size {
+			limit = d.size - offset
+		}
+		r := &utils.Range{Offset: offset, Limit: limit}
+
+		local, err := utils.ComputeTreeHashContext(d.ctx(), io.NewSectionReader(d.file, offset, limit), nil)
+		if err != nil && d.ctx().Err() != nil {
+			return err
+		}
+		if local == nil || *local != treeHash {
+			d.log(&events.Event{
+				Type:     events.PartMismatch,
+				Message:  fmt.Sprintf("part (%v) hash mismatch", r),
+				Range:    r,
+				Verified: aws.Bool(false),
+			})
+			continue
+		}
+
+		d.verified[offset] = true
+		d.checksums[offset/d.input.PartSize] = treeHash
+		d.log(&events.Event{
+			Type:     events.PartVerified,
+			Message:  fmt.Sprintf("part (%v) is ok", r),
+			Range:    r,
+			Verified: aws.Bool(true),
+		})
+	}
+
+	d.log(&events.Event{
+		Type:    events.CheckFinished,
+		Message: "finish checking downloaded parts",
+		JobId:   d.input.JobId,
+	})
+
 	return nil
 }
+
+// removeState removes the state file of the verified download. The download
+// has already succeeded, so a failure to remove it is only logged.
+func (d *Downloader) removeState() {
+	d.state.Close()
+	if err := os.Remove(d.input.StateFile); err != nil {
+		d.log(&events.Event{
+			Type:    events.Error,
+			Message: fmt.Sprint("could not remove the state file: ", err),
+			JobId:   d.input.JobId,
+			Error:   err.Error(),
+		})
+	}
+}
+
+// abort discards the parts written to the destination, if any. The download
+// has already failed, so a failure to abort is only logged.
+func (d *Downloader) abort() {
+	if d.input.Destination == nil {
+		return
+	}
+
+	if err := d.input.Destination.Abort(); err != nil {
+		d.log(&events.Event{
+			Type:    events.Error,
+			Message: fmt.Sprint("could not discard the written parts: ", err),
+			JobId:   d.input.JobId,
+			Error:   err.Error(),
+		})
+	}
+}
@@ -1,15 +1,31 @@
 package downloader
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/quick"
+	"testing/synctest"
+	"time"
 
 	"github.com/31z4/surge/internal/mocks"
+	"github.com/31z4/surge/pkg/events"
 	"github.com/31z4/surge/pkg/utils"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
 	"github.com/aws/aws-sdk-go-v2/service/glacier"
 )
 
@@ -237,6 +253,43 @@ func TestOpenFile(t *testing.T) {
 		}
 	})
 
+	t.Run("overwrite", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.WriteString("test"); err != nil {
+			t.Fatal(err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+
+		input := &Input{
+			FileName:  file.Name(),
+			Overwrite: true,
+		}
+		downloader := &Downloader{
+			input: input,
+		}
+
+		if err := downloader.openFile(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		defer downloader.file.Close()
+
+		info, err := downloader.file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != 0 {
+			t.Fatalf("got size %d, want the file truncated", info.Size())
+		}
+	})
+
 	t.Run("existing directory", func(t *testing.T) {
 		dir, err := ioutil.TempDir("", "surge")
 		if err != nil {
@@ -302,7 +355,7 @@ func TestDownloadPart(t *testing.T) {
 		downloader := New(mock, input)
 		r := &utils.Range{}
 
-		if got := downloader.downloadPart(r); got != err {
+		if got := downloader.downloadPart(r, &events.Event{}); got != err {
 			t.Fatalf("got %#v, want %#v", got, err)
 		}
 	})
@@ -335,7 +388,7 @@ func TestDownloadPart(t *testing.T) {
 		downloader := New(mock, input)
 		r := &utils.Range{}
 
-		err = downloader.downloadPart(r)
+		err = downloader.downloadPart(r, &events.Event{})
 		if err == nil {
 			t.Fatal("got nil, want error")
 		}
@@ -374,7 +427,7 @@ func TestDownloadPart(t *testing.T) {
 			Limit:  123,
 		}
 
-		err = downloader.downloadPart(r)
+		err = downloader.downloadPart(r, &events.Event{})
 		if err == nil {
 			t.Fatal("got nil, want error")
 		}
@@ -413,7 +466,7 @@ func TestDownloadPart(t *testing.T) {
 		downloader := New(mock, input)
 		r := &utils.Range{}
 
-		err = downloader.downloadPart(r)
+		err = downloader.downloadPart(r, &events.Event{})
 		if err == nil {
 			t.Fatal("got nil, want error")
 		}
@@ -460,7 +513,7 @@ func TestDownloadPart(t *testing.T) {
 			Limit:  4,
 		}
 
-		err = downloader.downloadPart(r)
+		err = downloader.downloadPart(r, &events.Event{})
 		if err == nil {
 			t.Fatal("got nil, want error")
 		}
@@ -471,6 +524,148 @@ func TestDownloadPart(t *testing.T) {
 		}
 	})
 
+	t.Run("quarantine", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		data := []byte{'t', 'e', 's', 't'}
+		checksum := *utils.TreeHashBytes([]byte("best"))
+		requestMock := func() glacier.GetJobOutputRequest {
+			return glacier.GetJobOutputRequest{
+				Request: &aws.Request{
+					Data: &glacier.GetJobOutputOutput{
+						Body:     ioutil.NopCloser(bytes.NewReader(data)),
+						Checksum: &checksum,
+					},
+				},
+			}
+		}
+		mock := &mocks.Glacier{
+			GetJobOutputRequestMock: requestMock,
+		}
+
+		input := newTestInput()
+		input.FileName = path.Join(dir, "out")
+		input.Quarantine = true
+		input.Logger = events.Discard
+		downloader := New(mock, input)
+
+		r := &utils.Range{Offset: 8, Limit: 4}
+		if err := downloader.downloadPart(r, &events.Event{}); err != ErrHashMismatch {
+			t.Fatalf("got %#v, want %#v", err, ErrHashMismatch)
+		}
+
+		got, err := ioutil.ReadFile(input.FileName + ".badpart-8")
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("got %#v, want %#v", got, data)
+		}
+	})
+
+	t.Run("corrupted checksum", func(t *testing.T) {
+		data := []byte{'t', 'e', 's', 't'}
+		checksum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+		requestMock := func() glacier.GetJobOutputRequest {
+			return glacier.GetJobOutputRequest{
+				Request: &aws.Request{
+					Data: &glacier.GetJobOutputOutput{
+						Body:     ioutil.NopCloser(bytes.NewReader(data)),
+						Checksum: &checksum,
+					},
+				},
+			}
+		}
+		mock := &mocks.Glacier{
+			GetJobOutputRequestMock: requestMock,
+			Faults:                  &mocks.Faults{CorruptRate: 1},
+		}
+
+		input := newTestInput()
+		downloader := New(mock, input)
+		r := &utils.Range{
+			Offset: 0,
+			Limit:  4,
+		}
+
+		if err := downloader.downloadPart(r, &events.Event{}); err != ErrHashMismatch {
+			t.Fatalf("got %#v, want %#v", err, ErrHashMismatch)
+		}
+	})
+
+	for name, test := range map[string]struct {
+		faults *mocks.Faults
+		err    error
+		cause  string
+	}{
+		"server failure retried": {faults: &mocks.Faults{FailRequests: []int{1}}, cause: events.CauseServer},
+		"server timeout retried": {faults: &mocks.Faults{TimeoutRequests: []int{1}}, cause: events.CauseConnection},
+		"server corrupted":       {faults: &mocks.Faults{CorruptRate: 1}, err: ErrHashMismatch},
+	} {
+		t.Run(name, func(t *testing.T) {
+			checksum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+			backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("x-amz-sha256-tree-hash", checksum)
+				w.Header().Set("Content-Range", "bytes 0-3/4")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte("test"))
+			})
+			server := httptest.NewServer(test.faults.Handler(backend))
+			defer server.Close()
+
+			config := defaults.Config()
+			config.Region = "us-east-1"
+			config.Credentials = aws.NewStaticCredentialsProvider("test", "test", "")
+			config.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+
+			dir, err := ioutil.TempDir("", "surge")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+
+			var retries []*events.Event
+			input := newTestInput()
+			input.FileName = path.Join(dir, "out")
+			input.PartSize = 4
+			input.Logger = loggerFunc(func(e *events.Event) {
+				if e.Type == events.Retry {
+					retries = append(retries, e)
+				}
+			})
+
+			downloader := New(glacier.New(config), input)
+			r := &utils.Range{
+				Offset: 0,
+				Limit:  4,
+			}
+
+			if err := downloader.openFile(); err != nil {
+				t.Fatal(err)
+			}
+			defer downloader.file.Close()
+
+			if err := downloader.downloadPart(r, &events.Event{}); err != test.err {
+				t.Fatalf("got %#v, want %#v", err, test.err)
+			}
+
+			if test.cause == "" {
+				return
+			}
+			if len(retries) != 1 {
+				t.Fatalf("got %d retries, want 1", len(retries))
+			}
+			if e := retries[0]; e.Part != 1 || e.Attempt != 2 || e.MaxAttempts != 4 || e.Cause != test.cause {
+				t.Fatalf("unexpected retry: %#v", e)
+			}
+		})
+	}
+
 	t.Run("write error", func(t *testing.T) {
 		file, err := ioutil.TempFile("", "surge")
 		if err != nil {
@@ -497,7 +692,7 @@ func TestDownloadPart(t *testing.T) {
 		downloader := New(mock, input)
 		r := &utils.Range{}
 
-		err = downloader.downloadPart(r)
+		err = downloader.downloadPart(r, &events.Event{})
 		if err == nil {
 			t.Fatal("got nil, want error")
 		}
@@ -555,9 +750,24 @@ func TestDownloadPart(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if err := downloader.downloadPart(r); err != nil {
+		if err := downloader.downloadPart(r, &events.Event{}); err != nil {
 			t.Fatalf("unexpected error: %#v", err)
 		}
+
+		sent := mock.GetJobOutputInputs()
+		if len(sent) != 1 {
+			t.Fatalf("got %d inputs, want 1", len(sent))
+		}
+
+		want := glacier.GetJobOutputInput{
+			AccountId: &input.AccountId,
+			JobId:     &input.JobId,
+			Range:     aws.String("bytes=0-3"),
+			VaultName: &input.VaultName,
+		}
+		if !reflect.DeepEqual(*sent[0], want) {
+			t.Fatalf("got %#v, want %#v", *sent[0], want)
+		}
 	})
 }
 
@@ -581,9 +791,18 @@ func TestMultipartDownload(t *testing.T) {
 		downloader := New(mock, input)
 		downloader.size = 11
 
-		downloader.multipartDownload(2)
+		got := downloader.multipartDownload(2)
+		if _, ok := got.(*utils.PartialTransferError); !ok {
+			t.Fatalf("got %T, want *utils.PartialTransferError", got)
+		}
 
-		if mock.CallCount != 3 {
+		errString := "could not transfer 3 part(s): 0-3: test; 4-7: test; 8-10: test"
+		if got.Error() != errString {
+			t.Fatalf("got %q, want %q", got.Error(), errString)
+		}
+
+		// Every part is downloaded once more after it fails.
+		if mock.CallCount != 6 {
 			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
 		}
 	})
@@ -633,7 +852,9 @@ func TestMultipartDownload(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		downloader.multipartDownload(4)
+		if err := downloader.multipartDownload(4); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
 
 		if mock.CallCount != 1 {
 			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
@@ -648,11 +869,295 @@ func TestMultipartDownload(t *testing.T) {
 			t.Fatalf("got %q, want \"test\"", content)
 		}
 	})
+
+	t.Run("coalesced", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.RemoveAll(dir)
+
+		data := []byte{'t', 'e', 's', 't'}
+		filename := path.Join(dir, "in")
+
+		if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer file.Close()
+
+		requestMock := func() glacier.GetJobOutputRequest {
+			return glacier.GetJobOutputRequest{
+				Request: &aws.Request{
+					Data: &glacier.GetJobOutputOutput{
+						Body: file,
+					},
+				},
+			}
+		}
+		mock := &mocks.Glacier{
+			GetJobOutputRequestMock: requestMock,
+		}
+
+		input := newTestInput()
+		input.PartSize = 4
+		input.FileName = path.Join(dir, "out")
+
+		downloader := New(mock, input)
+		downloader.size = 4
+
+		if err := downloader.openFile(); err != nil {
+			t.Fatal(err)
+		}
+
+		downloader.coalescer = newCoalescer(downloader.writeAt, 8)
+
+		if err := downloader.multipartDownload(4); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if mock.CallCount != 1 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+
+		content, err := ioutil.ReadFile(input.FileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(content) != 0 {
+			t.Fatalf("got %q, want the part buffered", content)
+		}
+
+		if err := downloader.coalescer.flush(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		content, err = ioutil.ReadFile(input.FileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(content) != "test" {
+			t.Fatalf("got %q, want \"test\"", content)
+		}
+	})
+}
+
+func TestGetNextRange(t *testing.T) {
+	// Every byte is covered exactly once by the parts in order, and each part
+	// is a valid Glacier range.
+	partition := func(size uint32, partSize uint16) bool {
+		downloader := New(nil, &Input{PartSize: (int64(partSize) + 1) << 8})
+		downloader.size = int64(size)
+
+		var offset int64
+		for r := downloader.getNextRange(); r != nil; r = downloader.getNextRange() {
+			if r.Offset != offset || r.Limit <= 0 || r.Limit > downloader.input.PartSize {
+				return false
+			}
+
+			s := r.String()
+			if parsed := utils.RangeFromString(&s); parsed == nil || *parsed != *r {
+				return false
+			}
+			offset += r.Limit
+		}
+		return offset == downloader.size
+	}
+	if err := quick.Check(partition, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMultipartDownloadSchedule runs the workers in a synctest bubble, where
+// the requests take exactly their latency of fake time, so that the elapsed
+// time shows how the parts were scheduled.
+func TestMultipartDownloadSchedule(t *testing.T) {
+	for name, test := range map[string]struct {
+		jobs    int
+		maxRate int64
+		elapsed time.Duration
+	}{
+		"sequential":   {jobs: 1, elapsed: 4 * time.Second},
+		"parallel":     {jobs: 2, elapsed: 2 * time.Second},
+		"all parallel": {jobs: 8, elapsed: time.Second},
+		"rate limited": {jobs: 8, maxRate: 1, elapsed: 7 * time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "surge")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+
+			synctest.Test(t, func(t *testing.T) {
+				requestMock := func() glacier.GetJobOutputRequest {
+					return glacier.GetJobOutputRequest{
+						Request: &aws.Request{
+							Data: &glacier.GetJobOutputOutput{
+								Body: ioutil.NopCloser(bytes.NewReader([]byte("ab"))),
+							},
+						},
+					}
+				}
+				mock := &mocks.Glacier{
+					GetJobOutputRequestMock: requestMock,
+					Faults:                  &mocks.Faults{Latency: time.Second},
+				}
+
+				input := newTestInput()
+				input.PartSize = 2
+				input.MaxRate = test.maxRate
+				input.FileName = path.Join(dir, "out")
+				input.Logger = events.Discard
+
+				downloader := New(mock, input)
+				downloader.size = 8
+
+				if err := downloader.openFile(); err != nil {
+					t.Fatal(err)
+				}
+				defer downloader.file.Close()
+
+				start := time.Now()
+				if err := downloader.multipartDownload(test.jobs); err != nil {
+					t.Fatalf("unexpected error: %#v", err)
+				}
+
+				if elapsed := time.Since(start); elapsed != test.elapsed {
+					t.Errorf("got %v elapsed, want %v", elapsed, test.elapsed)
+				}
+
+				content, err := ioutil.ReadFile(input.FileName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != "abababab" {
+					t.Errorf("got %q, want %q", content, "abababab")
+				}
+			})
+		})
+	}
+}
+
+func TestMultipartDownloadOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	mock := &mocks.Glacier{
+		GetJobOutputRequestMock: func() glacier.GetJobOutputRequest {
+			return glacier.GetJobOutputRequest{
+				Request: &aws.Request{
+					Data: &glacier.GetJobOutputOutput{
+						Body: ioutil.NopCloser(bytes.NewReader([]byte("ab"))),
+					},
+				},
+			}
+		},
+	}
+
+	input := newTestInput()
+	input.PartSize = 2
+	input.Order = utils.LargestRemainingFirst
+	input.FileName = path.Join(dir, "out")
+	input.Logger = events.Discard
+
+	downloader := New(mock, input)
+	downloader.size = 8
+	downloader.verified = map[int64]bool{2: true}
+
+	if err := downloader.openFile(); err != nil {
+		t.Fatal(err)
+	}
+	defer downloader.file.Close()
+
+	if err := downloader.multipartDownload(1); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	var got []string
+	for _, sent := range mock.GetJobOutputInputs() {
+		got = append(got, *sent.Range)
+	}
+	want := []string{"bytes=4-5", "bytes=6-7", "bytes=0-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestMultipartDownloadStop(t *testing.T) {
+	for policy, calls := range map[utils.FailurePolicy]uint32{
+		utils.FailFast:      1,
+		utils.RetryThenFail: 2,
+	} {
+		t.Run(policy.String(), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "surge")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+
+			// The first part fails every time.
+			var count int32
+			mock := &mocks.Glacier{
+				GetJobOutputRequestMock: func() glacier.GetJobOutputRequest {
+					if atomic.AddInt32(&count, 1) <= 2 {
+						return glacier.GetJobOutputRequest{
+							Request: &aws.Request{Error: errors.New("test")},
+						}
+					}
+					return glacier.GetJobOutputRequest{
+						Request: &aws.Request{
+							Data: &glacier.GetJobOutputOutput{
+								Body: ioutil.NopCloser(bytes.NewReader([]byte("ab"))),
+							},
+						},
+					}
+				},
+			}
+
+			input := newTestInput()
+			input.PartSize = 2
+			input.OnFailure = policy
+			input.FileName = path.Join(dir, "out")
+			input.Logger = events.Discard
+
+			downloader := New(mock, input)
+			downloader.size = 8
+			downloader.context, downloader.failures = utils.WithPartFailures(context.Background(), input.OnFailure)
+
+			if err := downloader.openFile(); err != nil {
+				t.Fatal(err)
+			}
+			defer downloader.file.Close()
+
+			errString := "could not transfer 1 part(s): 0-1: test"
+			if err := downloader.multipartDownload(1); err == nil || err.Error() != errString {
+				t.Fatalf("got %#v, want %#v", err, errString)
+			}
+
+			if mock.CallCount != calls {
+				t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+			}
+		})
+	}
 }
 
 func TestCheckTreeHash(t *testing.T) {
 	t.Run("hash error", func(t *testing.T) {
-		downloader := &Downloader{}
+		downloader := &Downloader{input: &Input{}}
 
 		err := downloader.checkTreeHash()
 		if err == nil {
@@ -681,6 +1186,7 @@ func TestCheckTreeHash(t *testing.T) {
 
 		hash := ""
 		downloader := &Downloader{
+			input:    &Input{},
 			file:     file,
 			treeHash: &hash,
 		}
@@ -696,6 +1202,34 @@ func TestCheckTreeHash(t *testing.T) {
 		}
 	})
 
+	t.Run("canceled", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		if err := ioutil.WriteFile(file.Name(), []byte("test"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		hash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+		downloader := &Downloader{
+			input:    &Input{Context: ctx},
+			file:     file,
+			treeHash: &hash,
+		}
+
+		if err := downloader.checkTreeHash(); err != context.Canceled {
+			t.Fatalf("got %#v, want %#v", err, context.Canceled)
+		}
+	})
+
 	t.Run("ok", func(t *testing.T) {
 		file, err := ioutil.TempFile("", "surge")
 		if err != nil {
@@ -712,6 +1246,7 @@ func TestCheckTreeHash(t *testing.T) {
 
 		hash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
 		downloader := &Downloader{
+			input:    &Input{},
 			file:     file,
 			treeHash: &hash,
 		}
@@ -721,3 +1256,537 @@ func TestCheckTreeHash(t *testing.T) {
 		}
 	})
 }
+
+func TestDownload(t *testing.T) {
+	t.Run("invalid account ID", func(t *testing.T) {
+		mock := &mocks.Glacier{}
+		input := newTestInput()
+		downloader := New(mock, input)
+		errString := `invalid account ID "test_account": must be '-' or a 12-digit AWS account ID`
+
+		if _, got := downloader.Download(1); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+
+		if mock.CallCount != 0 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+	})
+}
+
+// memoryDestination keeps the archive written to it in memory.
+type memoryDestination struct {
+	mutex     sync.Mutex
+	data      []byte
+	committed bool
+	aborted   bool
+}
+
+func (m *memoryDestination) WriteAt(p []byte, off int64) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return copy(m.data[off:], p), nil
+}
+
+func (m *memoryDestination) Truncate(size int64) error {
+	m.data = make([]byte, size)
+	return nil
+}
+
+func (m *memoryDestination) Commit() error {
+	m.committed = true
+	return nil
+}
+
+func (m *memoryDestination) Abort() error {
+	m.aborted = true
+	return nil
+}
+
+func TestDownloadToDestination(t *testing.T) {
+	data := make([]byte, 5<<19+3)
+	rand.Read(data)
+
+	for name, test := range map[string]struct {
+		partSize int64
+		treeHash string
+		err      string
+	}{
+		"ok":                  {partSize: 1 << 20, treeHash: *utils.TreeHashBytes(data)},
+		"hash mismatch":       {partSize: 2 << 20, treeHash: *utils.TreeHashBytes(data[1:]), err: ErrHashMismatch.Error()},
+		"unaligned part size": {partSize: 1<<20 + 1, err: "the part size must be a multiple of 1 MiB to download to a destination"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/output") {
+					http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+					return
+				}
+				fmt.Fprintf(w, `{"Action":"ArchiveRetrieval","StatusCode":"Succeeded","ArchiveSizeInBytes":%d,"SHA256TreeHash":"%s"}`,
+					len(data), test.treeHash)
+			})
+			server := httptest.NewServer(backend)
+			defer server.Close()
+
+			config := defaults.Config()
+			config.Region = "us-east-1"
+			config.Credentials = aws.NewStaticCredentialsProvider("test", "test", "")
+			config.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+
+			destination := &memoryDestination{}
+
+			input := newTestInput()
+			input.AccountId = "-"
+			input.PartSize = test.partSize
+			input.Destination = destination
+			input.Logger = events.Discard
+
+			_, err := New(glacier.New(config), input).Download(2)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got %#v, want %#v", err, test.err)
+				}
+				if destination.committed {
+					t.Fatal("the failed download is committed")
+				}
+				if destination.data != nil && !destination.aborted {
+					t.Fatal("the failed download is not aborted")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			if !destination.committed || destination.aborted {
+				t.Fatalf("got committed %v, aborted %v", destination.committed, destination.aborted)
+			}
+			if !bytes.Equal(destination.data, data) {
+				t.Fatal("the written archive differs")
+			}
+			if _, err := os.Stat(input.FileName); !os.IsNotExist(err) {
+				t.Fatalf("got %#v, want the file not created", err)
+			}
+		})
+	}
+}
+
+func TestFindCorruptedParts(t *testing.T) {
+	file, err := ioutil.TempFile("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	data := make([]byte, 5<<19+3)
+	rand.Read(data)
+
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{^data[2<<20]}, 2<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	downloader := &Downloader{
+		input: &Input{PartSize: 1 << 20},
+		file:  file,
+		size:  int64(len(data)),
+		checksums: []string{
+			*utils.TreeHashBytes(data[:1<<20]),
+			"",
+			*utils.TreeHashBytes(data[2<<20:]),
+		},
+	}
+
+	got, err := downloader.findCorruptedParts()
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	want := []*utils.Range{{Offset: 2 << 20, Limit: int64(len(data)) - 2<<20}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		downloader.input.Context = ctx
+
+		if got, err := downloader.findCorruptedParts(); err != context.Canceled || got != nil {
+			t.Fatalf("got %#v and %#v, want %#v", got, err, context.Canceled)
+		}
+	})
+}
+
+func TestDownloadCorruptedPart(t *testing.T) {
+	data := make([]byte, 5<<19+3)
+	rand.Read(data)
+
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The second part is corrupted the first time it is requested.
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/output") {
+			fmt.Fprintf(w, `{"Action":"ArchiveRetrieval","StatusCode":"Succeeded","ArchiveSizeInBytes":%d,"SHA256TreeHash":"%s"}`,
+				len(data), *utils.TreeHashBytes(data))
+			return
+		}
+
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		part := append([]byte(nil), data[start:end+1]...)
+
+		mutex.Lock()
+		requests[r.Header.Get("Range")]++
+		if start == 1<<20 && requests[r.Header.Get("Range")] == 1 {
+			part[0] = ^part[0]
+		}
+		mutex.Unlock()
+
+		w.Header().Set("x-amz-sha256-tree-hash", *utils.TreeHashBytes(data[start : end+1]))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(part)
+	})
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	config := defaults.Config()
+	config.Region = "us-east-1"
+	config.Credentials = aws.NewStaticCredentialsProvider("test", "test", "")
+	config.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+
+	input := newTestInput()
+	input.AccountId = "-"
+	input.PartSize = 1 << 20
+	input.FileName = path.Join(dir, "out")
+	input.Logger = events.Discard
+
+	if _, err := New(glacier.New(config), input).Download(2); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	got, err := ioutil.ReadFile(input.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("the downloaded archive differs")
+	}
+
+	want := map[string]int{
+		"bytes=0-1048575":       1,
+		"bytes=1048576-2097151": 2,
+		"bytes=2097152-2621442": 1,
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("got %#v, want %#v", requests, want)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	data := make([]byte, 5<<19+3)
+	rand.Read(data)
+
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mutex sync.Mutex
+	var requests []string
+
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/output") {
+			fmt.Fprintf(w, `{"Action":"ArchiveRetrieval","StatusCode":"Succeeded","ArchiveSizeInBytes":%d,"SHA256TreeHash":"%s"}`,
+				len(data), *utils.TreeHashBytes(data))
+			return
+		}
+
+		mutex.Lock()
+		requests = append(requests, r.Header.Get("Range"))
+		mutex.Unlock()
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	config := defaults.Config()
+	config.Region = "us-east-1"
+	config.Credentials = aws.NewStaticCredentialsProvider("test", "test", "")
+	config.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+
+	input := newTestInput()
+	input.AccountId = "-"
+	input.PartSize = 1 << 20
+	input.FileName = path.Join(dir, "out")
+	input.StateFile = path.Join(dir, "out.state")
+	input.Logger = events.Discard
+
+	// The first part is in the file, the last one is recorded but corrupted.
+	file := make([]byte, len(data))
+	copy(file, data[:1<<20])
+	if err := ioutil.WriteFile(input.FileName, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := stateHeader(input.JobId, int64(len(data)), input.PartSize) +
+		fmt.Sprintf("0-1048575 %s\n", *utils.TreeHashBytes(data[:1<<20])) +
+		fmt.Sprintf("2097152-2621442 %s\n", *utils.TreeHashBytes(data[2<<20:]))
+	if err := ioutil.WriteFile(input.StateFile, []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := New(glacier.New(config), input).Download(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if !result.Verified || result.BytesReused != 1<<20 || result.BytesDownloaded != int64(len(data))-1<<20 {
+		t.Fatalf("got %#v, want the first part reused", result)
+	}
+
+	got, err := ioutil.ReadFile(input.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("the downloaded archive differs")
+	}
+
+	want := []string{"bytes=1048576-2097151", "bytes=2097152-2621442"}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("got %#v, want %#v", requests, want)
+	}
+
+	if _, err := os.Stat(input.StateFile); !os.IsNotExist(err) {
+		t.Fatalf("got %#v, want the state file removed", err)
+	}
+}
+
+func TestDownloadFailureFlush(t *testing.T) {
+	data := make([]byte, 5<<19+3)
+	rand.Read(data)
+
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The last part is denied, after the others are buffered.
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/output") {
+			fmt.Fprintf(w, `{"Action":"ArchiveRetrieval","StatusCode":"Succeeded","ArchiveSizeInBytes":%d,"SHA256TreeHash":"%s"}`,
+				len(data), *utils.TreeHashBytes(data))
+			return
+		}
+		if r.Header.Get("Range") == "bytes=2097152-2621442" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"code":"AccessDeniedException","message":"test"}`)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	})
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	config := defaults.Config()
+	config.Region = "us-east-1"
+	config.Credentials = aws.NewStaticCredentialsProvider("test", "test", "")
+	config.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+
+	input := newTestInput()
+	input.AccountId = "-"
+	input.PartSize = 1 << 20
+	input.WriteBuffer = 8 << 20
+	input.OnFailure = utils.FailFast
+	input.FileName = path.Join(dir, "out")
+	input.StateFile = path.Join(dir, "out.state")
+	input.Logger = events.Discard
+
+	if _, err := New(glacier.New(config), input).Download(1); err == nil {
+		t.Fatal("got no error, want the denied part to fail the download")
+	}
+
+	// The parts recorded in the state are written for the resumed download.
+	parts, err := readState(input.StateFile, input.JobId, int64(len(data)), input.PartSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("got %d recorded part(s), want 2", len(parts))
+	}
+	got, err := ioutil.ReadFile(input.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[:2<<20], data[:2<<20]) {
+		t.Fatal("the recorded parts are not written")
+	}
+}
+
+func TestRecordRate(t *testing.T) {
+	var d Downloader
+
+	var wg sync.WaitGroup
+	for _, seconds := range []float64{4, 0, 1, 2} {
+		wg.Add(1)
+		go func(seconds float64) {
+			defer wg.Done()
+			d.recordRate(1<<20, seconds)
+		}(seconds)
+	}
+	wg.Wait()
+
+	if d.peakRate != 1<<20 {
+		t.Fatalf("got %#v, want %#v", d.peakRate, 1<<20)
+	}
+}
+
+func TestLimitJobs(t *testing.T) {
+	input := newTestInput()
+	input.PartSize = 4 << 20
+	input.WriteBuffer = 8 << 20
+	input.MaxMemory = 48 << 20
+	input.Logger = events.Discard
+
+	// The coalescer may buffer four times the write buffer along with the parts.
+	d := New(&mocks.Glacier{}, input)
+	if got, err := d.limitJobs(16); err != nil || got != 4 {
+		t.Fatalf("got %#v, %#v, want 4", got, err)
+	}
+
+	// A destination buffers no write buffer.
+	d.input.Destination = &memoryDestination{}
+	if got, err := d.limitJobs(16); err != nil || got != 12 {
+		t.Fatalf("got %#v, %#v, want 12", got, err)
+	}
+}
+
+func TestAutoPartSize(t *testing.T) {
+	cases := []struct {
+		size, want int64
+	}{
+		{1, 1 << 20},
+		{1000 << 20, 1 << 20},
+		{1000<<20 + 1, 2 << 20},
+		{10 << 30, 16 << 20},
+		{1 << 40, 64 << 20},
+	}
+
+	for _, test := range cases {
+		if got := AutoPartSize(test.size); got != test.want {
+			t.Errorf("%d: got %#v, want %#v", test.size, got, test.want)
+		}
+	}
+}
+
+func TestNewWriterAt(t *testing.T) {
+	data := make([]byte, 5<<19+3)
+	rand.Read(data)
+
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/output") {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+			return
+		}
+		fmt.Fprintf(w, `{"Action":"ArchiveRetrieval","StatusCode":"Succeeded","ArchiveSizeInBytes":%d,"SHA256TreeHash":"%s"}`,
+			len(data), *utils.TreeHashBytes(data))
+	})
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	config := defaults.Config()
+	config.Region = "us-east-1"
+	config.Credentials = aws.NewStaticCredentialsProvider("test", "test", "")
+	config.EndpointResolver = aws.ResolveWithEndpointURL(server.URL)
+
+	// Only the WriterAt of the buffer is used.
+	buffer := &memoryDestination{data: make([]byte, len(data))}
+
+	input := newTestInput()
+	input.AccountId = "-"
+	input.PartSize = 1 << 20
+	input.Logger = events.Discard
+
+	if _, err := NewWriterAt(glacier.New(config), input, io.WriterAt(buffer)).Download(2); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if !bytes.Equal(buffer.data, data) {
+		t.Fatal("the written archive differs")
+	}
+	if buffer.committed || buffer.aborted {
+		t.Fatalf("got committed %v, aborted %v", buffer.committed, buffer.aborted)
+	}
+	if _, err := os.Stat(input.FileName); !os.IsNotExist(err) {
+		t.Fatalf("got %#v, want the file not created", err)
+	}
+	if input.Destination != nil {
+		t.Fatalf("got %#v, want the input left unchanged", input.Destination)
+	}
+}
+
+func BenchmarkMultipartDownload(b *testing.B) {
+	const size = 16 << 20
+	const partSize = 1 << 20
+
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	part := make([]byte, partSize)
+	requestMock := func() glacier.GetJobOutputRequest {
+		return glacier.GetJobOutputRequest{
+			Request: &aws.Request{
+				Data: &glacier.GetJobOutputOutput{
+					Body: ioutil.NopCloser(bytes.NewReader(part)),
+				},
+			},
+		}
+	}
+	mock := &mocks.Glacier{
+		GetJobOutputRequestMock: requestMock,
+	}
+
+	input := newTestInput()
+	input.FileName = path.Join(dir, "out")
+	input.PartSize = partSize
+	input.Overwrite = true
+	input.Logger = events.Discard
+
+	b.SetBytes(size)
+	for i := 0; i < b.N; i++ {
+		downloader := New(mock, input)
+		downloader.size = size
+
+		if err := downloader.openFile(); err != nil {
+			b.Fatal(err)
+		}
+
+		if err := downloader.multipartDownload(4); err != nil {
+			b.Fatal(err)
+		}
+
+		downloader.file.Close()
+	}
+}
+
+// loggerFunc is a logger calling the function with every event.
+type loggerFunc func(e *events.Event)
+
+func (f loggerFunc) Log(e *events.Event) {
+	f(e)
+}
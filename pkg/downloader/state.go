@@ -0,0 +1,133 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/31z4/surge/pkg/utils"
+)
+
+// stateWriter appends the tree hashes of the parts written to the file to
+// the state file, so that an interrupted download can skip them when it is
+// run again. The lines are only appended, so a crash loses at most the last
+// one, and the parts recorded are checked against the file before they are
+// skipped. It is safe for concurrent use.
+type stateWriter struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// stateHeader returns the lines the state file of the download starts with.
+// The parts are only reused by a download of the same job with the same part
+// size.
+func stateHeader(jobId string, size, partSize int64) string {
+	return fmt.Sprintf("job-id: %s\nsize: %d\npart-size: %d\n", jobId, size, partSize)
+}
+
+// createState creates the state file of the download, replacing any earlier one.
+func createState(name, jobId string, size, partSize int64) (*stateWriter, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.WriteString(stateHeader(jobId, size, partSize)); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &stateWriter{file: file}, nil
+}
+
+// appendState opens the existing state file to record more parts. The line
+// a crash left unfinished is ended first, so that the next one is not
+// appended to it.
+func appendState(name string) (*stateWriter, error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if size := info.Size(); size > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, size-1); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if last[0] != '\n' {
+			if _, err := file.WriteString("\n"); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return &stateWriter{file: file}, nil
+}
+
+// record appends the tree hash of the part written to the file.
+func (s *stateWriter) record(r *utils.Range, treeHash string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := fmt.Fprintf(s.file, "%v %s\n", r, treeHash)
+	return err
+}
+
+func (s *stateWriter) Close() error {
+	return s.file.Close()
+}
+
+// readState returns the tree hashes of the parts recorded in the state file
+// by the offsets. It returns nil if there is no state file, or if it belongs
+// to another job or part size. A malformed line, which an interrupted write
+// leaves behind, is skipped, the parts recorded after it are still read.
+func readState(name, jobId string, size, partSize int64) (map[int64]string, error) {
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	var header string
+	for i := 0; i < 3 && scanner.Scan(); i++ {
+		header += scanner.Text() + "\n"
+	}
+	if header != stateHeader(jobId, size, partSize) {
+		return nil, scanner.Err()
+	}
+
+	parts := make(map[int64]string)
+	for scanner.Scan() {
+		var start, end int64
+		var treeHash string
+		if n, _ := fmt.Sscanf(scanner.Text(), "%d-%d %s", &start, &end, &treeHash); n != 3 {
+			continue
+		}
+		limit := partSize
+		if start+limit > size {
+			limit = size - start
+		}
+		if start%partSize != 0 || start >= size || end != start+limit-1 || len(treeHash) != 64 {
+			continue
+		}
+		parts[start] = treeHash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
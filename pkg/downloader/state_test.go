@@ -0,0 +1,122 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/31z4/surge/pkg/utils"
+)
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := path.Join(dir, "state")
+	hash := strings.Repeat("a", 64)
+
+	t.Run("missing", func(t *testing.T) {
+		parts, err := readState(name, "job", 10, 4)
+		if parts != nil || err != nil {
+			t.Fatalf("got %#v, %#v", parts, err)
+		}
+	})
+
+	t.Run("recorded", func(t *testing.T) {
+		state, err := createState(name, "job", 10, 4)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if err := state.record(&utils.Range{Offset: 8, Limit: 2}, hash); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		state.Close()
+
+		state, err = appendState(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if err := state.record(&utils.Range{Offset: 0, Limit: 4}, hash); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		state.Close()
+
+		parts, err := readState(name, "job", 10, 4)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		want := map[int64]string{0: hash, 8: hash}
+		if !reflect.DeepEqual(parts, want) {
+			t.Fatalf("got %#v, want %#v", parts, want)
+		}
+	})
+
+	t.Run("another download", func(t *testing.T) {
+		for _, other := range []struct {
+			jobId          string
+			size, partSize int64
+		}{
+			{"other job", 10, 4},
+			{"job", 11, 4},
+			{"job", 10, 2},
+		} {
+			parts, err := readState(name, other.jobId, other.size, other.partSize)
+			if parts != nil || err != nil {
+				t.Fatalf("%v: got %#v, %#v", other, parts, err)
+			}
+		}
+	})
+
+	t.Run("interrupted write", func(t *testing.T) {
+		content := stateHeader("job", 10, 4) +
+			"0-3 " + hash + "\n" +
+			"4-7 " + hash[:10] + "\n" +
+			"8-9 " + hash + "\n"
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		parts, err := readState(name, "job", 10, 4)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		want := map[int64]string{0: hash, 8: hash}
+		if !reflect.DeepEqual(parts, want) {
+			t.Fatalf("got %#v, want %#v", parts, want)
+		}
+	})
+
+	t.Run("resumed after a crash", func(t *testing.T) {
+		// The last line is cut short by the crash.
+		content := stateHeader("job", 10, 4) +
+			"0-3 " + hash + "\n" +
+			"4-7 " + hash[:10]
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		state, err := appendState(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if err := state.record(&utils.Range{Offset: 8, Limit: 2}, hash); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		state.Close()
+
+		parts, err := readState(name, "job", 10, 4)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		want := map[int64]string{0: hash, 8: hash}
+		if !reflect.DeepEqual(parts, want) {
+			t.Fatalf("got %#v, want %#v", parts, want)
+		}
+	})
+}
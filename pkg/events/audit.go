@@ -0,0 +1,31 @@
+package events
+
+import (
+	"io"
+	"os"
+)
+
+// auditLogger records the outcome of every part.
+type auditLogger struct {
+	next Logger
+}
+
+// NewAuditLogger creates a logger writing one JSON object to w for every
+// finished or failed part, with its range, checksum, request ID, number of
+// attempts and elapsed time, and dropping the other events.
+func NewAuditLogger(w io.Writer) Logger {
+	return &auditLogger{next: NewJSONLogger(w)}
+}
+
+// Log passes the event to the JSON logger if it is the outcome of a part.
+func (l *auditLogger) Log(e *Event) {
+	if e.Type == PartFinished || e.Type == PartFailed {
+		l.next.Log(e)
+	}
+}
+
+// OpenAuditFile opens the audit file for appending, creating it if necessary.
+// Unlike a log file, it is never rotated or truncated.
+func OpenAuditFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
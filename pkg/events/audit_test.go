@@ -0,0 +1,53 @@
+package events
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/31z4/surge/pkg/utils"
+)
+
+func TestAuditLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "audit.log")
+	now := time.Date(2018, 4, 15, 20, 19, 45, 0, time.UTC)
+	r := &utils.Range{Offset: 0, Limit: 4}
+
+	// The file is appended to by every run.
+	for _, e := range []*Event{
+		{Time: now, Type: PartStarted, Range: r},
+		{Time: now, Type: PartFinished, Range: r, Checksum: "abc", RequestId: "id", Attempt: 1, Elapsed: 0.5},
+		{Time: now, Type: Summary, Parts: 1},
+		{Time: now, Type: PartFailed, Range: r, RequestId: "id", Attempt: 3, Error: "test"},
+	} {
+		file, err := OpenAuditFile(filename)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		NewAuditLogger(file).Log(e)
+		file.Close()
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`{"time":"2018-04-15T20:19:45Z","event":"part_finished","message":"","checksum":"abc","range":{"offset":0,"limit":4},"request_id":"id","attempt":1,"elapsed_seconds":0.5}`,
+		`{"time":"2018-04-15T20:19:45Z","event":"part_failed","message":"","range":{"offset":0,"limit":4},"request_id":"id","attempt":3,"error":"test"}`,
+	}
+	if got := strings.Split(strings.TrimSpace(string(data)), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
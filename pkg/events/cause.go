@@ -0,0 +1,93 @@
+package events
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+// Causes of the failed requests reported with the retries.
+const (
+	CauseCanceled    = "canceled"
+	CauseThrottled   = "throttled"
+	CauseTimeout     = "timeout"
+	CauseConnection  = "connection"
+	CauseCredentials = "credentials"
+	CauseServer      = "server error"
+	CauseClient      = "client error"
+	CauseOther       = "error"
+)
+
+// timeoutCodes are the AWS error codes of the requests timed out by the service.
+var timeoutCodes = map[string]bool{
+	"RequestTimeout":          true,
+	"RequestTimeoutException": true,
+}
+
+// ErrorCause classifies the error of a request, so that a transfer retrying
+// the requests of a throttled account can be told apart from the one retrying
+// over a broken connection.
+func ErrorCause(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case err == context.Canceled || err == context.DeadlineExceeded:
+		return CauseCanceled
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return CauseConnection
+	case aws.IsErrorThrottle(err):
+		return CauseThrottled
+	case aws.IsErrorExpiredCreds(err):
+		return CauseCredentials
+	}
+
+	if err, ok := err.(net.Error); ok {
+		if err.Timeout() {
+			return CauseTimeout
+		}
+		return CauseConnection
+	}
+
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		if strings.Contains(err.Error(), "connection reset") {
+			return CauseConnection
+		}
+		return CauseOther
+	}
+
+	switch {
+	case aerr.Code() == aws.ErrCodeRequestCanceled:
+		return CauseCanceled
+	case timeoutCodes[aerr.Code()]:
+		return CauseTimeout
+	}
+
+	if failure, ok := aerr.(awserr.RequestFailure); ok {
+		switch {
+		case failure.StatusCode() >= 500:
+			return CauseServer
+		case failure.StatusCode() >= 400:
+			return CauseClient
+		}
+	}
+
+	// The errors of sending a request wrap the error of the connection.
+	if orig := aerr.OrigErr(); orig != nil {
+		return ErrorCause(orig)
+	}
+	return CauseOther
+}
+
+// MaxAttempts returns the number of the attempts the SDK makes to send the
+// request, or zero if it is not known.
+func MaxAttempts(r *aws.Request) int {
+	if r.Retryer == nil {
+		return 0
+	}
+	return r.MaxRetries() + 1
+}
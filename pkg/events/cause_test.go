@@ -0,0 +1,60 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+)
+
+// timeoutError is a network error timing out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorCause(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+
+	cases := map[string]struct {
+		err  error
+		want string
+	}{
+		"nil":          {nil, ""},
+		"canceled":     {awserr.New(aws.ErrCodeRequestCanceled, "canceled", context.Canceled), CauseCanceled},
+		"context":      {context.Canceled, CauseCanceled},
+		"throttled":    {awserr.NewRequestFailure(awserr.New("ThrottlingException", "slow down", nil), 400, "id"), CauseThrottled},
+		"expired":      {awserr.New("ExpiredTokenException", "expired", nil), CauseCredentials},
+		"timeout code": {awserr.NewRequestFailure(awserr.New("RequestTimeoutException", "timeout", nil), 408, "id"), CauseTimeout},
+		"net timeout":  {awserr.New("RequestError", "send request failed", timeoutError{}), CauseTimeout},
+		"reset":        {awserr.New("RequestError", "send request failed", reset), CauseConnection},
+		"eof":          {io.ErrUnexpectedEOF, CauseConnection},
+		"server":       {awserr.NewRequestFailure(awserr.New("ServiceUnavailableException", "unavailable", nil), 503, "id"), CauseServer},
+		"client":       {awserr.NewRequestFailure(awserr.New("InvalidParameterValueException", "invalid", nil), 400, "id"), CauseClient},
+		"other":        {errors.New("test"), CauseOther},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := ErrorCause(test.err); got != test.want {
+				t.Fatalf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMaxAttempts(t *testing.T) {
+	if got := MaxAttempts(&aws.Request{}); got != 0 {
+		t.Fatalf("got %#v, want 0", got)
+	}
+
+	request := &aws.Request{Retryer: aws.DefaultRetryer{NumMaxRetries: 4}}
+	if got := MaxAttempts(request); got != 5 {
+		t.Fatalf("got %#v, want 5", got)
+	}
+}
@@ -0,0 +1,264 @@
+// Package events defines the events reported during Amazon Glacier transfers
+// and the loggers that output them.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Type identifies the kind of an event.
+type Type string
+
+// Event types.
+const (
+	Initiated     Type = "initiated"
+	CheckStarted  Type = "check_started"
+	CheckFinished Type = "check_finished"
+	PartStarted   Type = "part_started"
+	PartFinished  Type = "part_finished"
+	PartFailed    Type = "part_failed"
+	PartVerified  Type = "part_verified"
+	PartMismatch  Type = "part_mismatch"
+	Retry         Type = "retry"
+	Verification  Type = "verification"
+	Completed     Type = "completed"
+	Summary       Type = "summary"
+	Progress      Type = "progress"
+	Incomplete    Type = "incomplete"
+	Hashing       Type = "hashing"
+	Tuned         Type = "tuned"
+	Warning       Type = "warning"
+	Error         Type = "error"
+	Debug         Type = "debug"
+)
+
+// Event describes something that happened during a transfer.
+// Only the fields relevant to the event type are set.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    Type      `json:"event"`
+	Message string    `json:"message"`
+
+	// The operation the event belongs to, either upload or download.
+	Operation string `json:"operation,omitempty"`
+
+	UploadId  string `json:"upload_id,omitempty"`
+	JobId     string `json:"job_id,omitempty"`
+	ArchiveId string `json:"archive_id,omitempty"`
+	Location  string `json:"location,omitempty"`
+
+	// The hex encoded tree hash of the archive, or of the part for the
+	// finished and failed parts.
+	Checksum string `json:"checksum,omitempty"`
+
+	// The part the event refers to, and its number counting from 1 if known.
+	Range *utils.Range `json:"range,omitempty"`
+	Part  int          `json:"part,omitempty"`
+
+	// The AWS request ID of the last attempt to transfer the part.
+	RequestId string `json:"request_id,omitempty"`
+
+	// The number of the retry attempt, or the number of the attempts it took
+	// to finish or fail the part.
+	Attempt int `json:"attempt,omitempty"`
+
+	// The number of the attempts the request is sent at most, and the cause
+	// of the error, reported with the retries.
+	MaxAttempts int    `json:"max_attempts,omitempty"`
+	Cause       string `json:"cause,omitempty"`
+
+	Error string `json:"error,omitempty"`
+
+	// The verification result.
+	Verified *bool `json:"verified,omitempty"`
+
+	// The transfer totals reported by the summary, or the totals to transfer
+	// reported once the transfer is initiated. The elapsed time is reported
+	// for the finished and failed parts as well.
+	Parts   int64   `json:"parts,omitempty"`
+	Bytes   int64   `json:"bytes,omitempty"`
+	Elapsed float64 `json:"elapsed_seconds,omitempty"`
+
+	// The number of the retries and the highest rate of a single part in
+	// bytes per second, reported by the download summary.
+	Retries  int64 `json:"retries,omitempty"`
+	PeakRate int64 `json:"peak_bytes_per_second,omitempty"`
+
+	// The distribution of the part latencies, reported by the summary.
+	Latency *utils.LatencySummary `json:"latency,omitempty"`
+}
+
+// Logger outputs events. Implementations must be safe for concurrent use.
+type Logger interface {
+	Log(e *Event)
+}
+
+// TextLogger outputs human-readable event messages.
+type TextLogger struct {
+	// The logger printing the messages. If the value is nil then the standard
+	// logger is used.
+	Logger *log.Logger
+
+	// Color enables coloring the messages by the event status
+	// with ANSI escape sequences.
+	Color bool
+}
+
+// ANSI escape sequences used to color the messages.
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+)
+
+// color returns the escape sequence coloring the event or an empty string.
+func color(e *Event) string {
+	switch e.Type {
+	case PartVerified, Completed:
+		return colorGreen
+	case Retry, PartMismatch, Warning, Incomplete:
+		return colorYellow
+	case PartFailed, Error:
+		return colorRed
+	case Summary:
+		return colorBold
+	case Verification:
+		if e.Verified != nil && *e.Verified {
+			return colorGreen
+		}
+		return colorRed
+	}
+	return ""
+}
+
+// Log prints the event message.
+func (l TextLogger) Log(e *Event) {
+	message := e.Message
+	if c := color(e); l.Color && c != "" {
+		message = c + message + colorReset
+	}
+
+	if l.Logger != nil {
+		l.Logger.Print(message)
+	} else {
+		log.Print(message)
+	}
+}
+
+// JSONLogger outputs events as newline delimited JSON objects.
+type JSONLogger struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONLogger creates a logger that writes one JSON object per event to w.
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// Log writes the event as a single line of JSON.
+func (l *JSONLogger) Log(e *Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// There is nowhere to report a failure to write a log entry.
+	_ = l.encoder.Encode(e)
+}
+
+// teeLogger passes the events to several loggers.
+type teeLogger []Logger
+
+// Tee creates a logger that passes every event to all the loggers in order.
+func Tee(loggers ...Logger) Logger {
+	return teeLogger(loggers)
+}
+
+// Log passes the event to all the loggers.
+func (t teeLogger) Log(e *Event) {
+	for _, l := range t {
+		l.Log(e)
+	}
+}
+
+// Discard is a logger ignoring all the events.
+var Discard Logger = discardLogger{}
+
+type discardLogger struct{}
+
+func (discardLogger) Log(*Event) {}
+
+// PartName returns "part NUMBER (RANGE)", or "part (RANGE)" if the number
+// of the part is not known.
+func PartName(number int, r *utils.Range) string {
+	if number <= 0 {
+		return fmt.Sprintf("part (%v)", r)
+	}
+	return fmt.Sprintf("part %d (%v)", number, r)
+}
+
+// AttemptName returns "attempt N/MAX", or "attempt N" if the maximum number
+// of the attempts is not known.
+func AttemptName(attempt, max int) string {
+	if max <= 0 {
+		return fmt.Sprintf("attempt %d", attempt)
+	}
+	return fmt.Sprintf("attempt %d/%d", attempt, max)
+}
+
+// OnRetry makes fn get called whenever the request is about to be retried
+// by the SDK. It is passed the number of the next attempt and the error
+// that caused the retry.
+func OnRetry(r *aws.Request, fn func(attempt int, err error)) {
+	var err error
+
+	r.Handlers.AfterRetry.PushFront(func(r *aws.Request) {
+		err = r.Error
+	})
+	r.Handlers.AfterRetry.PushBack(func(r *aws.Request) {
+		if r.Error == nil && err != nil {
+			fn(r.RetryCount+1, err)
+		}
+	})
+}
+
+// hashingInterval is the least time between two hashing events.
+var hashingInterval = 10 * time.Second
+
+// OnHashed returns the progress function of utils.ComputeTreeHashContext
+// passing a Hashing event to log at most every 10 seconds, so that hashing
+// a large file of the size shows how far it got.
+func OnHashed(log func(*Event), name string, size int64) func(hashed int64) {
+	start := time.Now()
+	last := start
+
+	return func(hashed int64) {
+		now := time.Now()
+		if now.Sub(last) < hashingInterval {
+			return
+		}
+		last = now
+
+		log(&Event{
+			Type:    Hashing,
+			Message: fmt.Sprintf("hashing %s: %s of %s", name, utils.FormatSize(hashed), utils.FormatSize(size)),
+			Bytes:   hashed,
+			Elapsed: now.Sub(start).Seconds(),
+		})
+	}
+}
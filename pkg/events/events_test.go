@@ -0,0 +1,207 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewJSONLogger(&buffer)
+
+	logger.Log(&Event{
+		Time:      time.Date(2018, 5, 5, 19, 1, 52, 0, time.UTC),
+		Type:      PartFinished,
+		Message:   "finish uploading part (0-3)",
+		Operation: "upload",
+		Range:     &utils.Range{Offset: 0, Limit: 4},
+	})
+	logger.Log(&Event{
+		Type:    Summary,
+		Message: "test",
+		Parts:   1,
+		Bytes:   4,
+	})
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+
+	want := `{"time":"2018-05-05T19:01:52Z","event":"part_finished","message":"finish uploading part (0-3)","operation":"upload","range":{"offset":0,"limit":4}}`
+	if lines[0] != want {
+		t.Fatalf("got %s, want %s", lines[0], want)
+	}
+
+	var e Event
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Time.IsZero() {
+		t.Fatal("time must be set")
+	}
+
+	if e.Type != Summary || e.Parts != 1 || e.Bytes != 4 {
+		t.Fatalf("unexpected event: %#v", e)
+	}
+}
+
+func TestOnRetry(t *testing.T) {
+	cases := map[string]struct {
+		retry   bool
+		attempt int
+	}{
+		"retried":     {retry: true, attempt: 2},
+		"not retried": {retry: false, attempt: 0},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := errors.New("test")
+			request := &aws.Request{
+				Error: err,
+			}
+
+			// Simulate the SDK handler deciding whether to retry the request.
+			request.Handlers.AfterRetry.PushFront(func(r *aws.Request) {
+				if test.retry {
+					r.RetryCount++
+					r.Error = nil
+				}
+			})
+
+			var gotAttempt int
+			var gotErr error
+			OnRetry(request, func(attempt int, err error) {
+				gotAttempt = attempt
+				gotErr = err
+			})
+
+			request.Handlers.AfterRetry.Run(request)
+
+			if gotAttempt != test.attempt {
+				t.Fatalf("got attempt %d, want %d", gotAttempt, test.attempt)
+			}
+
+			if test.retry && gotErr != err {
+				t.Fatalf("got %#v, want %#v", gotErr, err)
+			}
+		})
+	}
+}
+
+func TestPartName(t *testing.T) {
+	r := &utils.Range{Offset: 4, Limit: 4}
+
+	if got, want := PartName(2, r), "part 2 (4-7)"; got != want {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+	if got, want := PartName(0, r), "part (4-7)"; got != want {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+	if got, want := AttemptName(3, 5), "attempt 3/5"; got != want {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+	if got, want := AttemptName(3, 0), "attempt 3"; got != want {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestTee(t *testing.T) {
+	var first, second recorder
+	Tee(&first, &second).Log(&Event{Type: Summary})
+
+	want := []Type{Summary}
+	if !reflect.DeepEqual(first.types, want) || !reflect.DeepEqual(second.types, want) {
+		t.Fatalf("got %v and %v, want %v", first.types, second.types, want)
+	}
+}
+
+func TestTextLogger(t *testing.T) {
+	cases := map[string]struct {
+		color bool
+		event *Event
+		want  string
+	}{
+		"plain": {
+			event: &Event{Type: PartFailed, Message: "test"},
+			want:  "test\n",
+		},
+		"failed": {
+			color: true,
+			event: &Event{Type: PartFailed, Message: "test"},
+			want:  "\x1b[31mtest\x1b[0m\n",
+		},
+		"verified": {
+			color: true,
+			event: &Event{Type: Verification, Message: "test", Verified: aws.Bool(true)},
+			want:  "\x1b[32mtest\x1b[0m\n",
+		},
+		"retried": {
+			color: true,
+			event: &Event{Type: Retry, Message: "test"},
+			want:  "\x1b[33mtest\x1b[0m\n",
+		},
+		"uncolored": {
+			color: true,
+			event: &Event{Type: PartStarted, Message: "test"},
+			want:  "test\n",
+		},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			logger := TextLogger{
+				Logger: log.New(&buffer, "", 0),
+				Color:  test.color,
+			}
+
+			logger.Log(test.event)
+
+			if got := buffer.String(); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOnHashed(t *testing.T) {
+	var logged []*Event
+	log := func(e *Event) { logged = append(logged, e) }
+
+	t.Run("throttled", func(t *testing.T) {
+		progress := OnHashed(log, "test", 3<<20)
+		progress(1 << 20)
+		progress(2 << 20)
+
+		if len(logged) != 0 {
+			t.Errorf("got %d event(s), want none", len(logged))
+		}
+	})
+
+	t.Run("logged", func(t *testing.T) {
+		defer func(interval time.Duration) { hashingInterval = interval }(hashingInterval)
+		hashingInterval = 0
+
+		progress := OnHashed(log, "test", 3<<20)
+		progress(1 << 20)
+
+		if len(logged) != 1 {
+			t.Fatalf("got %d event(s), want 1", len(logged))
+		}
+		if e := logged[0]; e.Type != Hashing || e.Bytes != 1<<20 || e.Message != "hashing test: 1 MiB of 3 MiB" {
+			t.Errorf("got %#v", e)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package events
+
+// Level is the verbosity of the output. Higher levels include more events.
+type Level int
+
+// Verbosity levels.
+const (
+	// LevelQuiet outputs only the errors and the final result.
+	LevelQuiet Level = iota
+
+	// LevelNormal outputs the milestones of a transfer.
+	LevelNormal
+
+	// LevelVerbose outputs the progress of every part.
+	LevelVerbose
+
+	// LevelDebug additionally outputs the AWS requests.
+	LevelDebug
+)
+
+// Level returns the minimum verbosity the events of the type are output at.
+func (t Type) Level() Level {
+	switch t {
+	case Error, Completed, Summary, Progress, Incomplete:
+		return LevelQuiet
+	case PartStarted, PartFinished, PartVerified, PartMismatch, Retry:
+		return LevelVerbose
+	case Debug:
+		return LevelDebug
+	}
+	return LevelNormal
+}
+
+// levelFilter passes the events up to a verbosity level to the next logger.
+type levelFilter struct {
+	next  Logger
+	level Level
+}
+
+// NewLevelFilter creates a logger that drops the events above the level
+// and passes the rest to next.
+func NewLevelFilter(next Logger, level Level) Logger {
+	return &levelFilter{next: next, level: level}
+}
+
+// Log passes the event to the next logger if its type is within the level.
+func (f *levelFilter) Log(e *Event) {
+	if e.Type.Level() <= f.level {
+		f.next.Log(e)
+	}
+}
@@ -0,0 +1,39 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+)
+
+// recorder is a logger remembering the types of the events.
+type recorder struct {
+	types []Type
+}
+
+func (r *recorder) Log(e *Event) {
+	r.types = append(r.types, e.Type)
+}
+
+func TestLevelFilter(t *testing.T) {
+	all := []Type{Initiated, PartStarted, PartFailed, Retry, Debug, Completed, Summary, Progress, Incomplete, Hashing, Warning, Error}
+
+	cases := map[Level][]Type{
+		LevelQuiet:   {Completed, Summary, Progress, Incomplete, Error},
+		LevelNormal:  {Initiated, PartFailed, Completed, Summary, Progress, Incomplete, Hashing, Warning, Error},
+		LevelVerbose: {Initiated, PartStarted, PartFailed, Retry, Completed, Summary, Progress, Incomplete, Hashing, Warning, Error},
+		LevelDebug:   all,
+	}
+
+	for level, want := range cases {
+		var r recorder
+		filter := NewLevelFilter(&r, level)
+
+		for _, typ := range all {
+			filter.Log(&Event{Type: typ})
+		}
+
+		if !reflect.DeepEqual(r.types, want) {
+			t.Errorf("level %d: got %v, want %v", level, r.types, want)
+		}
+	}
+}
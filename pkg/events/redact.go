@@ -0,0 +1,123 @@
+package events
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Mask hides all but the last 4 characters of a sensitive value, which are
+// kept to tell the values apart.
+func Mask(s string) string {
+	if len(s) <= 4 {
+		return "***"
+	}
+	return "***" + s[len(s)-4:]
+}
+
+// signatures match the signing material of the requests dumped by the SDK:
+// the Authorization header, the session token and the query parameters of
+// a pre-signed URL.
+var signatures = []*regexp.Regexp{
+	regexp.MustCompile(`(?im)^(Authorization:[ \t]*)[^\r\n]*`),
+	regexp.MustCompile(`(?im)^(X-Amz-Security-Token:[ \t]*)[^\r\n]*`),
+	regexp.MustCompile(`(?i)(X-Amz-(?:Signature|Credential|Security-Token)=)[^&\s]+`),
+}
+
+// RedactSignatures replaces the signing material in the message, such as a
+// dumped request, so that it cannot be replayed by the readers of the log.
+func RedactSignatures(message string) string {
+	for _, re := range signatures {
+		message = re.ReplaceAllString(message, "${1}REDACTED")
+	}
+	return message
+}
+
+// Redactor masks the IDs of the events passed to the next logger.
+type Redactor struct {
+	next Logger
+
+	mutex    sync.Mutex
+	values   []string          // the IDs seen so far
+	replacer *strings.Replacer // masks the values in the messages
+}
+
+// NewRedactor creates a logger masking the upload IDs, the job IDs and the
+// account IDs of the events, in their fields as well as in their messages and
+// errors, before passing them to next. The account IDs of the vaults are
+// learned from the locations of the archives, the other values to mask are
+// given upfront or added later.
+func NewRedactor(next Logger, values ...string) *Redactor {
+	r := &Redactor{next: next, replacer: strings.NewReplacer()}
+	r.Add(values...)
+	return r
+}
+
+// Add makes the values masked in the events logged from now on, for example
+// the account ID once it is known.
+func (r *Redactor) Add(values ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, v := range values {
+		r.learn(v)
+	}
+}
+
+// learn adds the value to the values to mask. The mutex must be held.
+func (r *Redactor) learn(value string) {
+	if value == "" || value == "-" {
+		return
+	}
+	for _, v := range r.values {
+		if v == value {
+			return
+		}
+	}
+	r.values = append(r.values, value)
+
+	pairs := make([]string, 0, 2*len(r.values))
+	for _, v := range r.values {
+		pairs = append(pairs, v, Mask(v))
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// Log passes a masked copy of the event to the next logger.
+func (r *Redactor) Log(e *Event) {
+	redacted := *e
+
+	r.mutex.Lock()
+	r.learn(e.UploadId)
+	r.learn(e.JobId)
+	if account := locationAccount(e.Location); account != "" {
+		r.learn(account)
+	}
+	replacer := r.replacer
+	r.mutex.Unlock()
+
+	redacted.UploadId = maskNonEmpty(e.UploadId)
+	redacted.JobId = maskNonEmpty(e.JobId)
+	redacted.Location = replacer.Replace(e.Location)
+	redacted.Message = replacer.Replace(e.Message)
+	redacted.Error = replacer.Replace(e.Error)
+
+	r.next.Log(&redacted)
+}
+
+// locationAccount returns the account ID of an archive location
+// of the form /ACCOUNT/vaults/VAULT/archives/ARCHIVE.
+func locationAccount(location string) string {
+	parts := strings.SplitN(location, "/", 4)
+	if len(parts) < 4 || parts[0] != "" || parts[2] != "vaults" {
+		return ""
+	}
+	return parts[1]
+}
+
+func maskNonEmpty(s string) string {
+	if s == "" {
+		return ""
+	}
+	return Mask(s)
+}
@@ -0,0 +1,110 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+)
+
+// eventRecorder is a logger remembering the events.
+type eventRecorder struct {
+	events []Event
+}
+
+func (r *eventRecorder) Log(e *Event) {
+	r.events = append(r.events, *e)
+}
+
+func TestMask(t *testing.T) {
+	cases := map[string]string{
+		"111111111234": "***1234",
+		"1234":         "***",
+		"":             "***",
+	}
+
+	for s, want := range cases {
+		if got := Mask(s); got != want {
+			t.Errorf("%q: got %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestRedactSignatures(t *testing.T) {
+	message := "---[ REQUEST POST-SIGN ]-----------------------------\n" +
+		"POST /-/vaults/test/multipart-uploads HTTP/1.1\n" +
+		"Authorization: AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20180415/us-east-1/glacier/aws4_request, Signature=abcdef\r\n" +
+		"X-Amz-Security-Token: token\r\n" +
+		"GET /?X-Amz-Credential=AKIDEXAMPLE%2F20180415&X-Amz-Signature=abcdef HTTP/1.1"
+
+	want := "---[ REQUEST POST-SIGN ]-----------------------------\n" +
+		"POST /-/vaults/test/multipart-uploads HTTP/1.1\n" +
+		"Authorization: REDACTED\r\n" +
+		"X-Amz-Security-Token: REDACTED\r\n" +
+		"GET /?X-Amz-Credential=REDACTED&X-Amz-Signature=REDACTED HTTP/1.1"
+
+	if got := RedactSignatures(message); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	var r eventRecorder
+	redactor := NewRedactor(&r)
+	redactor.Add("222222222222", "-")
+
+	redactor.Log(&Event{
+		Type:     Initiated,
+		Message:  "upload upload-id-0001 initiated",
+		UploadId: "upload-id-0001",
+	})
+	redactor.Log(&Event{
+		Type:      Completed,
+		Message:   "upload location is /111111111111/vaults/test/archives/archive-id",
+		UploadId:  "upload-id-0001",
+		ArchiveId: "archive-id",
+		Location:  "/111111111111/vaults/test/archives/archive-id",
+	})
+	redactor.Log(&Event{
+		Type:    Error,
+		Message: "could not abort upload-id-0001 of 111111111111 and 222222222222",
+		Error:   "could not abort upload-id-0001",
+	})
+
+	want := []Event{
+		{
+			Type:     Initiated,
+			Message:  "upload ***0001 initiated",
+			UploadId: "***0001",
+		},
+		{
+			Type:      Completed,
+			Message:   "upload location is /***1111/vaults/test/archives/archive-id",
+			UploadId:  "***0001",
+			ArchiveId: "archive-id",
+			Location:  "/***1111/vaults/test/archives/archive-id",
+		},
+		{
+			Type:    Error,
+			Message: "could not abort ***0001 of ***1111 and ***2222",
+			Error:   "could not abort ***0001",
+		},
+	}
+
+	if !reflect.DeepEqual(r.events, want) {
+		t.Fatalf("got %#v, want %#v", r.events, want)
+	}
+}
+
+func TestLocationAccount(t *testing.T) {
+	cases := map[string]string{
+		"/111111111111/vaults/test/archives/archive-id": "111111111111",
+		"/111111111111/vaults":                          "",
+		"111111111111/vaults/test/archives/archive-id":  "",
+		"": "",
+	}
+
+	for location, want := range cases {
+		if got := locationAccount(location); got != want {
+			t.Errorf("%q: got %q, want %q", location, got, want)
+		}
+	}
+}
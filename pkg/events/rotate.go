@@ -0,0 +1,104 @@
+package events
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a log file that is rotated when it grows over the maximum
+// size. The rotated files are named after the file with a numeric suffix,
+// the most recent being .1. The oldest ones over the number of backups are removed.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// OpenRotatingFile opens the log file for appending, creating it if necessary.
+// If the maximum size is not positive the file is never rotated.
+func OpenRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		backups: backups,
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+func (f *RotatingFile) backupName(n int) string {
+	return fmt.Sprint(f.path, ".", n)
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	if f.backups > 0 {
+		os.Remove(f.backupName(f.backups))
+		for n := f.backups - 1; n > 0; n-- {
+			os.Rename(f.backupName(n), f.backupName(n+1))
+		}
+		if err := os.Rename(f.path, f.backupName(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+// Write appends p to the file, rotating it first if p doesn't fit.
+// A single write is never split across the files.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the file.
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.file.Close()
+}
@@ -0,0 +1,57 @@
+package events
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "surge.log")
+	if err := ioutil.WriteFile(filename, []byte("0000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenRotatingFile(filename, 10, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	for _, line := range []string{"1111\n", "2222\n", "3333\n", "4444\n", "5555\n", "6666\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	want := map[string]string{
+		filename:        "6666\n",
+		filename + ".1": "4444\n5555\n",
+		filename + ".2": "2222\n3333\n",
+	}
+
+	for name, content := range want {
+		got, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if string(got) != content {
+			t.Errorf("%s: got %q, want %q", name, got, content)
+		}
+	}
+
+	if _, err := os.Stat(filename + ".3"); !os.IsNotExist(err) {
+		t.Errorf("the oldest backup must be removed, got %#v", err)
+	}
+}
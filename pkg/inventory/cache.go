@@ -0,0 +1,91 @@
+package inventory
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/31z4/surge/pkg/sealed"
+)
+
+// DefaultCacheTTL is how long a cached inventory is used for by default.
+// Amazon Glacier takes an inventory of a vault about once a day, so that an
+// inventory retrieved again sooner is unlikely to be any newer.
+const DefaultCacheTTL = 24 * time.Hour
+
+// Cache stores the last inventory retrieved of every vault, so that it is not
+// retrieved again while it is fresh.
+type Cache struct {
+	// The directory holding the cached inventories.
+	Dir string
+
+	// The region of the vaults.
+	Region string
+
+	// How long an inventory is used for once it is retrieved.
+	TTL time.Duration
+
+	// The passphrase encrypting the cached inventories, which list the
+	// descriptions of the archives. An empty passphrase disables the encryption.
+	Passphrase []byte
+}
+
+// cachedInventory is the inventory of a vault along with when it was retrieved.
+type cachedInventory struct {
+	RetrievedAt time.Time  `json:"retrieved_at"`
+	JobId       string     `json:"job_id"`
+	Inventory   *Inventory `json:"inventory"`
+}
+
+// DefaultCacheDir returns ~/.surge/inventory.
+func DefaultCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".surge", "inventory")
+}
+
+func (c *Cache) path(accountId, vaultName string) string {
+	return filepath.Join(c.Dir, c.Region, accountId, vaultName+".json")
+}
+
+// load reads the cached inventory of the vault. It returns nil if there is none.
+func (c *Cache) load(accountId, vaultName string) (*cachedInventory, error) {
+	data, err := sealed.ReadFile(c.path(accountId, vaultName), c.Passphrase)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cached cachedInventory
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, err
+	}
+	if cached.Inventory == nil {
+		return nil, nil
+	}
+	return &cached, nil
+}
+
+// fresh reports whether the cached inventory is used rather than retrieved again.
+func (c *Cache) fresh(cached *cachedInventory, now time.Time) bool {
+	return now.Sub(cached.RetrievedAt) < c.TTL
+}
+
+// store replaces the cached inventory of the vault.
+func (c *Cache) store(accountId, vaultName string, cached *cachedInventory) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+
+	path := c.path(accountId, vaultName)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return sealed.WriteFile(path, data, 0600, c.Passphrase)
+}
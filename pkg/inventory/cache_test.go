@@ -0,0 +1,217 @@
+package inventory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/31z4/surge/internal/mocks"
+	"github.com/31z4/surge/pkg/sealed"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+func newTestCache(t *testing.T) (*Cache, func()) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := &Cache{
+		Dir:    dir,
+		Region: "eu-central-1",
+		TTL:    time.Hour,
+	}
+	return cache, func() { os.RemoveAll(dir) }
+}
+
+func newInitiateJobRequestMock(jobId string) func() glacier.InitiateJobRequest {
+	return func() glacier.InitiateJobRequest {
+		return glacier.InitiateJobRequest{
+			Request: &aws.Request{
+				Data: &glacier.InitiateJobOutput{JobId: aws.String(jobId)},
+			},
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	latest := newJob("latest", "Succeeded", "2018-05-05T00:00:00.000Z")
+
+	t.Run("fresh", func(t *testing.T) {
+		cache, cleanup := newTestCache(t)
+		defer cleanup()
+
+		mock := &mocks.Glacier{
+			ListJobsRequestMock:     newListJobsRequestMock(latest),
+			GetJobOutputRequestMock: newGetJobOutputRequestMock(testInventory),
+		}
+
+		input := newTestInput()
+		input.Cache = cache
+		if _, err := New(mock, input).Retrieve(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		// The cached inventory is used without any request.
+		input = newTestInput()
+		input.Cache = cache
+		inventory, err := New(&mocks.Glacier{}, input).Retrieve()
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if input.JobId != "latest" {
+			t.Fatalf("got %q, want %q", input.JobId, "latest")
+		}
+		if len(inventory.ArchiveList) != 2 {
+			t.Fatalf("got %d archives, want 2", len(inventory.ArchiveList))
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		cache, cleanup := newTestCache(t)
+		defer cleanup()
+
+		err := cache.store("-", "test", &cachedInventory{
+			RetrievedAt: time.Now().Add(-2 * time.Hour),
+			JobId:       "old",
+			Inventory:   &Inventory{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mock := &mocks.Glacier{
+			ListJobsRequestMock:     newListJobsRequestMock(latest),
+			GetJobOutputRequestMock: newGetJobOutputRequestMock(testInventory),
+		}
+
+		input := newTestInput()
+		input.Cache = cache
+		if _, err := New(mock, input).Retrieve(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if input.JobId != "latest" {
+			t.Fatalf("got %q, want %q", input.JobId, "latest")
+		}
+
+		cached, err := cache.load("-", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached.JobId != "latest" || !cache.fresh(cached, time.Now()) {
+			t.Fatalf("got %#v, want the latest job cached", cached)
+		}
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		cache, cleanup := newTestCache(t)
+		defer cleanup()
+
+		err := cache.store("-", "test", &cachedInventory{
+			RetrievedAt: time.Date(2018, 5, 5, 12, 0, 0, 0, time.UTC),
+			JobId:       "latest",
+			Inventory:   &Inventory{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The latest job is as old as the cache, a new one is initiated.
+		mock := &mocks.Glacier{
+			ListJobsRequestMock:    newListJobsRequestMock(latest),
+			InitiateJobRequestMock: newInitiateJobRequestMock("new"),
+		}
+
+		input := newTestInput()
+		input.Cache = cache
+		input.Refresh = true
+		if _, err := New(mock, input).Retrieve(); err != ErrJobNotReady {
+			t.Fatalf("got %#v, want %#v", err, ErrJobNotReady)
+		}
+		if input.JobId != "new" {
+			t.Fatalf("got %q, want %q", input.JobId, "new")
+		}
+
+		// Once the new job completes, its output is used.
+		mock = &mocks.Glacier{
+			ListJobsRequestMock: newListJobsRequestMock(
+				latest,
+				newJob("new", "Succeeded", "2018-05-05T16:00:00.000Z"),
+			),
+			GetJobOutputRequestMock: newGetJobOutputRequestMock(testInventory),
+		}
+
+		input = newTestInput()
+		input.Cache = cache
+		input.Refresh = true
+		if _, err := New(mock, input).Retrieve(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if input.JobId != "new" {
+			t.Fatalf("got %q, want %q", input.JobId, "new")
+		}
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		cache, cleanup := newTestCache(t)
+		defer cleanup()
+		cache.Passphrase = []byte("test")
+
+		err := cache.store("-", "test", &cachedInventory{
+			RetrievedAt: time.Now(),
+			JobId:       "latest",
+			Inventory:   &Inventory{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(cache.path("-", "test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sealed.IsSealed(data) {
+			t.Fatal("the cached inventory must be encrypted")
+		}
+
+		cached, err := cache.load("-", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached.JobId != "latest" {
+			t.Fatalf("got %q, want %q", cached.JobId, "latest")
+		}
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		cache, cleanup := newTestCache(t)
+		defer cleanup()
+
+		path := cache.path("-", "test")
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		mock := &mocks.Glacier{
+			ListJobsRequestMock:     newListJobsRequestMock(latest),
+			GetJobOutputRequestMock: newGetJobOutputRequestMock(testInventory),
+		}
+
+		input := newTestInput()
+		input.Cache = cache
+		if _, err := New(mock, input).Retrieve(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if cached, err := cache.load("-", "test"); err != nil || cached.JobId != "latest" {
+			t.Fatalf("got %#v, %#v, want the corrupted cache replaced", cached, err)
+		}
+	})
+}
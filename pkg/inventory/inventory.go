@@ -0,0 +1,377 @@
+// Package inventory retrieves Amazon Glacier vault inventories and compares
+// them against the local catalog.
+//
+// For information about vault inventories, see
+// https://docs.aws.amazon.com/amazonglacier/latest/dev/vault-inventory.html.
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/31z4/surge/pkg/catalog"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+	"github.com/aws/aws-sdk-go-v2/service/glacier/glacieriface"
+)
+
+// ErrJobNotReady is returned when the inventory retrieval job has not succeeded yet.
+var ErrJobNotReady = errors.New("the inventory job is not succeeded yet")
+
+const inventoryRetrieval = "InventoryRetrieval"
+
+// Archive describes an archive listed in the inventory.
+type Archive struct {
+	ArchiveId    string    `json:"ArchiveId"`
+	Description  string    `json:"ArchiveDescription"`
+	CreationDate time.Time `json:"CreationDate"`
+	Size         int64     `json:"Size"`
+	TreeHash     string    `json:"SHA256TreeHash"`
+}
+
+// Inventory is the list of the archives in a vault as of the inventory date.
+type Inventory struct {
+	VaultARN      string    `json:"VaultARN"`
+	InventoryDate time.Time `json:"InventoryDate"`
+	ArchiveList   []Archive `json:"ArchiveList"`
+}
+
+// Parse reads an inventory in the JSON format.
+func Parse(r io.Reader) (*Inventory, error) {
+	var inventory Inventory
+	if err := json.NewDecoder(r).Decode(&inventory); err != nil {
+		return nil, fmt.Errorf("invalid inventory: %v", err)
+	}
+	return &inventory, nil
+}
+
+// Input provides options for retrieving the inventory of an Amazon Glacier vault.
+type Input struct {
+	// The AccountId value is the AWS account ID of the account that owns the vault.
+	// You can either specify an AWS account ID or optionally a single '-' (hyphen),
+	// in which case Amazon Glacier uses the AWS account ID associated with the
+	// credentials used to sign the request.
+	AccountId string
+
+	// The name of the vault.
+	VaultName string
+
+	// The ID of the inventory retrieval job whose output is retrieved.
+	// If the value is empty then the most recent succeeded job is used,
+	// or a new job is initiated if there is none.
+	JobId string
+
+	// Cache stores the retrieved inventory and provides it instead of the
+	// job output while it is fresh. If the value is nil then the inventory
+	// is always retrieved.
+	Cache *Cache
+
+	// Refresh ignores the cached inventory. A job completed after it was
+	// retrieved is used, or a new job is initiated if there is none.
+	Refresh bool
+
+	// Logger receives the retrieval events.
+	// If the value is nil then the events are printed using the standard logger.
+	Logger events.Logger
+}
+
+// Retriever holds internal inventory retriever state.
+type Retriever struct {
+	service glacieriface.GlacierAPI
+	input   *Input
+}
+
+// New creates a new instance of the retriever with a service and input.
+func New(service glacieriface.GlacierAPI, input *Input) *Retriever {
+	return &Retriever{
+		service: service,
+		input:   input,
+	}
+}
+
+func (r *Retriever) log(e *events.Event) {
+	e.Operation = "inventory"
+	e.JobId = r.input.JobId
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	logger := r.input.Logger
+	if logger == nil {
+		logger = events.TextLogger{}
+	}
+	logger.Log(e)
+}
+
+// findJob returns the most recently completed succeeded inventory job, or a job
+// in progress if there is none. The succeeded jobs completed before the time are
+// ignored. It returns nil if there are no such jobs.
+func (r *Retriever) findJob(completedAfter time.Time) (*glacier.DescribeJobOutput, error) {
+	input := &glacier.ListJobsInput{
+		AccountId: &r.input.AccountId,
+		VaultName: &r.input.VaultName,
+	}
+
+	request := r.service.ListJobsRequest(input)
+	pager := request.Paginate()
+
+	var succeeded, inProgress *glacier.DescribeJobOutput
+
+	for pager.Next() {
+		for _, job := range pager.CurrentPage().JobList {
+			job := job
+			if string(job.Action) != inventoryRetrieval {
+				continue
+			}
+
+			switch string(job.StatusCode) {
+			case "Succeeded":
+				if completed, err := time.Parse(time.RFC3339, aws.StringValue(job.CompletionDate)); err == nil && completed.Before(completedAfter) {
+					continue
+				}
+				if succeeded == nil || aws.StringValue(job.CompletionDate) > aws.StringValue(succeeded.CompletionDate) {
+					succeeded = &job
+				}
+			case "InProgress":
+				inProgress = &job
+			}
+		}
+	}
+
+	if err := pager.Err(); err != nil {
+		return nil, err
+	}
+
+	if succeeded != nil {
+		return succeeded, nil
+	}
+	return inProgress, nil
+}
+
+func (r *Retriever) initiateJob() error {
+	jobType := "inventory-retrieval"
+	format := "JSON"
+	input := &glacier.InitiateJobInput{
+		AccountId: &r.input.AccountId,
+		VaultName: &r.input.VaultName,
+		JobParameters: &glacier.JobParameters{
+			Type:   &jobType,
+			Format: &format,
+		},
+	}
+
+	request := r.service.InitiateJobRequest(input)
+	result, err := request.Send()
+	if err != nil {
+		return err
+	}
+
+	r.input.JobId = aws.StringValue(result.JobId)
+	return nil
+}
+
+func (r *Retriever) checkJob() error {
+	input := &glacier.DescribeJobInput{
+		AccountId: &r.input.AccountId,
+		JobId:     &r.input.JobId,
+		VaultName: &r.input.VaultName,
+	}
+
+	request := r.service.DescribeJobRequest(input)
+	result, err := request.Send()
+	if err != nil {
+		return err
+	}
+
+	return checkStatus(result)
+}
+
+func checkStatus(job *glacier.DescribeJobOutput) error {
+	if action := string(job.Action); action != inventoryRetrieval {
+		return errors.New(action + " action is not supported")
+	}
+
+	switch status := string(job.StatusCode); status {
+	case "Succeeded":
+		return nil
+	case "InProgress":
+		return ErrJobNotReady
+	case "Failed":
+		return errors.New("the job is failed: " + aws.StringValue(job.StatusMessage))
+	default:
+		return errors.New("job status is unexpected: " + status)
+	}
+}
+
+func (r *Retriever) getOutput() (*Inventory, error) {
+	input := &glacier.GetJobOutputInput{
+		AccountId: &r.input.AccountId,
+		JobId:     &r.input.JobId,
+		VaultName: &r.input.VaultName,
+	}
+
+	request := r.service.GetJobOutputRequest(input)
+	result, err := request.Send()
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+
+	return Parse(result.Body)
+}
+
+// Retrieve returns the output of the inventory retrieval job. If the job has
+// not succeeded yet ErrJobNotReady is returned, the job ID is set in the input
+// so that the inventory can be retrieved later.
+func (r *Retriever) Retrieve() (*Inventory, error) {
+	accountId, err := utils.NormalizeAccountId(r.input.AccountId)
+	if err != nil {
+		return nil, err
+	}
+	r.input.AccountId = accountId
+
+	var cached *cachedInventory
+	if r.input.Cache != nil {
+		cached, err = r.input.Cache.load(accountId, r.input.VaultName)
+		if err != nil {
+			r.log(&events.Event{
+				Type:    events.Warning,
+				Message: fmt.Sprint("ignoring the cached inventory: ", err),
+			})
+		}
+	}
+
+	if cached != nil && r.input.JobId == "" && !r.input.Refresh && r.input.Cache.fresh(cached, time.Now()) {
+		r.input.JobId = cached.JobId
+		r.log(&events.Event{
+			Type: events.Completed,
+			Message: fmt.Sprintf("using the inventory of %s with %d archive(s) cached at %s",
+				cached.Inventory.InventoryDate.Format(time.RFC3339), len(cached.Inventory.ArchiveList), cached.RetrievedAt.Format(time.RFC3339)),
+		})
+		return cached.Inventory, nil
+	}
+
+	// Refreshing skips the jobs whose output is no newer than the cache.
+	var completedAfter time.Time
+	if cached != nil && r.input.Refresh {
+		completedAfter = cached.RetrievedAt
+	}
+
+	if r.input.JobId != "" {
+		if err := r.checkJob(); err != nil {
+			return nil, err
+		}
+	} else {
+		job, err := r.findJob(completedAfter)
+		if err != nil {
+			return nil, err
+		}
+
+		if job == nil {
+			if err := r.initiateJob(); err != nil {
+				return nil, err
+			}
+
+			r.log(&events.Event{
+				Type:    events.Initiated,
+				Message: fmt.Sprint("inventory job ", r.input.JobId, " initiated, it takes several hours to complete"),
+			})
+			return nil, ErrJobNotReady
+		}
+
+		r.input.JobId = aws.StringValue(job.JobId)
+		if err := checkStatus(job); err != nil {
+			return nil, err
+		}
+	}
+
+	inventory, err := r.getOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	r.log(&events.Event{
+		Type: events.Completed,
+		Message: fmt.Sprintf("retrieved the inventory of %s with %d archive(s)",
+			inventory.InventoryDate.Format(time.RFC3339), len(inventory.ArchiveList)),
+	})
+
+	if r.input.Cache != nil {
+		err := r.input.Cache.store(accountId, r.input.VaultName, &cachedInventory{
+			RetrievedAt: time.Now().UTC(),
+			JobId:       r.input.JobId,
+			Inventory:   inventory,
+		})
+		if err != nil {
+			r.log(&events.Event{
+				Type:    events.Warning,
+				Message: fmt.Sprint("could not cache the inventory: ", err),
+			})
+		}
+	}
+
+	return inventory, nil
+}
+
+// Diff is the difference between the inventory and the catalog.
+type Diff struct {
+	InventoryDate time.Time `json:"inventory_date"`
+
+	// The archives in the inventory that are unknown to the catalog.
+	RemoteOnly []Archive `json:"remote_only"`
+
+	// The archives in the catalog that are missing from the inventory
+	// although uploaded before it was taken.
+	LocalOnly []*catalog.Entry `json:"local_only"`
+
+	// The archives in the catalog uploaded after the inventory was taken.
+	Pending []*catalog.Entry `json:"pending"`
+}
+
+// Empty reports whether the inventory and the catalog agree.
+func (d *Diff) Empty() bool {
+	return len(d.RemoteOnly) == 0 && len(d.LocalOnly) == 0
+}
+
+// Compare compares the inventory against the catalog entries of its vault.
+func Compare(inventory *Inventory, entries []*catalog.Entry) *Diff {
+	diff := &Diff{
+		InventoryDate: inventory.InventoryDate,
+		RemoteOnly:    []Archive{},
+		LocalOnly:     []*catalog.Entry{},
+		Pending:       []*catalog.Entry{},
+	}
+
+	known := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		known[e.ArchiveId] = struct{}{}
+	}
+
+	listed := make(map[string]struct{}, len(inventory.ArchiveList))
+	for _, a := range inventory.ArchiveList {
+		listed[a.ArchiveId] = struct{}{}
+
+		if _, ok := known[a.ArchiveId]; !ok {
+			diff.RemoteOnly = append(diff.RemoteOnly, a)
+		}
+	}
+
+	for _, e := range entries {
+		if _, ok := listed[e.ArchiveId]; ok {
+			continue
+		}
+
+		if e.UploadedAt.After(inventory.InventoryDate) {
+			diff.Pending = append(diff.Pending, e)
+		} else {
+			diff.LocalOnly = append(diff.LocalOnly, e)
+		}
+	}
+
+	return diff
+}
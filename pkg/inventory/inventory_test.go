@@ -0,0 +1,246 @@
+package inventory
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/31z4/surge/internal/mocks"
+	"github.com/31z4/surge/pkg/catalog"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+const testInventory = `{
+	"VaultARN": "arn:aws:glacier:eu-central-1:111111111111:vaults/test",
+	"InventoryDate": "2018-05-05T00:00:00Z",
+	"ArchiveList": [
+		{
+			"ArchiveId": "first",
+			"ArchiveDescription": "first.tar",
+			"CreationDate": "2018-04-15T20:19:53Z",
+			"Size": 2621440,
+			"SHA256TreeHash": "hash"
+		},
+		{
+			"ArchiveId": "second",
+			"ArchiveDescription": "",
+			"CreationDate": "2018-04-16T10:00:00Z",
+			"Size": 4,
+			"SHA256TreeHash": "hash"
+		}
+	]
+}`
+
+type discard struct{}
+
+func (discard) Log(*events.Event) {}
+
+func newTestInput() *Input {
+	return &Input{
+		AccountId: "-",
+		VaultName: "test",
+		Logger:    discard{},
+	}
+}
+
+func newListJobsRequestMock(jobs ...glacier.DescribeJobOutput) func() glacier.ListJobsRequest {
+	return func() glacier.ListJobsRequest {
+		return glacier.ListJobsRequest{
+			Copy: func(*glacier.ListJobsInput) glacier.ListJobsRequest {
+				return glacier.ListJobsRequest{
+					Request: &aws.Request{
+						Data:      &glacier.ListJobsOutput{JobList: jobs},
+						Operation: &aws.Operation{},
+					},
+				}
+			},
+		}
+	}
+}
+
+func newGetJobOutputRequestMock(body string) func() glacier.GetJobOutputRequest {
+	return func() glacier.GetJobOutputRequest {
+		return glacier.GetJobOutputRequest{
+			Request: &aws.Request{
+				Data: &glacier.GetJobOutputOutput{
+					Body: ioutil.NopCloser(strings.NewReader(body)),
+				},
+			},
+		}
+	}
+}
+
+func newJob(id, status, completed string) glacier.DescribeJobOutput {
+	return glacier.DescribeJobOutput{
+		Action:         glacier.ActionCode(inventoryRetrieval),
+		JobId:          aws.String(id),
+		StatusCode:     glacier.StatusCode(status),
+		CompletionDate: aws.String(completed),
+	}
+}
+
+func TestParse(t *testing.T) {
+	inventory, err := Parse(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	if want := time.Date(2018, 5, 5, 0, 0, 0, 0, time.UTC); !inventory.InventoryDate.Equal(want) {
+		t.Fatalf("got %v, want %v", inventory.InventoryDate, want)
+	}
+
+	want := Archive{
+		ArchiveId:    "first",
+		Description:  "first.tar",
+		CreationDate: time.Date(2018, 4, 15, 20, 19, 53, 0, time.UTC),
+		Size:         2621440,
+		TreeHash:     "hash",
+	}
+	if len(inventory.ArchiveList) != 2 || !reflect.DeepEqual(inventory.ArchiveList[0], want) {
+		t.Fatalf("got %#v, want %#v first", inventory.ArchiveList, want)
+	}
+
+	if _, err := Parse(strings.NewReader("not json")); err == nil {
+		t.Fatal("got nil, want error")
+	}
+}
+
+func TestRetrieve(t *testing.T) {
+	t.Run("no jobs", func(t *testing.T) {
+		mock := &mocks.Glacier{
+			ListJobsRequestMock: newListJobsRequestMock(),
+			InitiateJobRequestMock: func() glacier.InitiateJobRequest {
+				return glacier.InitiateJobRequest{
+					Request: &aws.Request{
+						Data: &glacier.InitiateJobOutput{JobId: aws.String("new")},
+					},
+				}
+			},
+		}
+
+		input := newTestInput()
+		if _, err := New(mock, input).Retrieve(); err != ErrJobNotReady {
+			t.Fatalf("got %#v, want %#v", err, ErrJobNotReady)
+		}
+
+		if input.JobId != "new" {
+			t.Fatalf("got %q, want %q", input.JobId, "new")
+		}
+
+		sent := mock.InitiateJobInputs()
+		if len(sent) != 1 {
+			t.Fatalf("got %d inputs, want 1", len(sent))
+		}
+		if got := sent[0].JobParameters; got == nil || aws.StringValue(got.Type) != "inventory-retrieval" {
+			t.Fatalf("got %#v, want an inventory retrieval", got)
+		}
+		if got := aws.StringValue(sent[0].VaultName); got != input.VaultName {
+			t.Fatalf("got %q, want %q", got, input.VaultName)
+		}
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		mock := &mocks.Glacier{
+			ListJobsRequestMock: newListJobsRequestMock(newJob("running", "InProgress", "")),
+		}
+
+		input := newTestInput()
+		if _, err := New(mock, input).Retrieve(); err != ErrJobNotReady {
+			t.Fatalf("got %#v, want %#v", err, ErrJobNotReady)
+		}
+
+		if input.JobId != "running" {
+			t.Fatalf("got %q, want %q", input.JobId, "running")
+		}
+	})
+
+	t.Run("latest job", func(t *testing.T) {
+		mock := &mocks.Glacier{
+			ListJobsRequestMock: newListJobsRequestMock(
+				newJob("old", "Succeeded", "2018-05-04T00:00:00.000Z"),
+				newJob("latest", "Succeeded", "2018-05-05T00:00:00.000Z"),
+				newJob("running", "InProgress", ""),
+			),
+			GetJobOutputRequestMock: newGetJobOutputRequestMock(testInventory),
+		}
+
+		input := newTestInput()
+		inventory, err := New(mock, input).Retrieve()
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if input.JobId != "latest" {
+			t.Fatalf("got %q, want %q", input.JobId, "latest")
+		}
+
+		if len(inventory.ArchiveList) != 2 {
+			t.Fatalf("got %d archives, want 2", len(inventory.ArchiveList))
+		}
+	})
+
+	t.Run("job ID", func(t *testing.T) {
+		mock := &mocks.Glacier{
+			DescribeJobRequestMock: func() glacier.DescribeJobRequest {
+				job := newJob("test", "Failed", "")
+				job.StatusMessage = aws.String("test")
+				return glacier.DescribeJobRequest{
+					Request: &aws.Request{Data: &job},
+				}
+			},
+		}
+
+		input := newTestInput()
+		input.JobId = "test"
+
+		_, err := New(mock, input).Retrieve()
+		if want := "the job is failed: test"; err == nil || err.Error() != want {
+			t.Fatalf("got %#v, want %#v", err, want)
+		}
+	})
+}
+
+func TestCompare(t *testing.T) {
+	inventory, err := Parse(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := &catalog.Entry{
+		Vault:      "test",
+		ArchiveId:  "first",
+		UploadedAt: time.Date(2018, 4, 15, 20, 19, 53, 0, time.UTC),
+	}
+	lost := &catalog.Entry{
+		Vault:      "test",
+		ArchiveId:  "lost",
+		UploadedAt: time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+	recent := &catalog.Entry{
+		Vault:      "test",
+		ArchiveId:  "recent",
+		UploadedAt: time.Date(2018, 5, 5, 12, 0, 0, 0, time.UTC),
+	}
+
+	diff := Compare(inventory, []*catalog.Entry{first, lost, recent})
+
+	if len(diff.RemoteOnly) != 1 || diff.RemoteOnly[0].ArchiveId != "second" {
+		t.Fatalf("got %#v, want the second archive only in the vault", diff.RemoteOnly)
+	}
+
+	if got, want := diff.LocalOnly, []*catalog.Entry{lost}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	if got, want := diff.Pending, []*catalog.Entry{recent}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	if diff.Empty() {
+		t.Fatal("the diff must not be empty")
+	}
+}
@@ -0,0 +1,50 @@
+// Package keychain reads secrets stored in the keychain of the operating
+// system: the macOS Keychain, the Secret Service on Linux and the other Unix
+// systems, and the Windows Credential Manager.
+//
+// A secret is stored under a service and an account, which keeps it off the
+// command line and the environment of the process.
+package keychain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// ErrNotFound is returned when the keychain has no secret for the service
+// and the account.
+var ErrNotFound = errors.New("the secret is not found in the keychain")
+
+// Lookup returns the secret of the service and the account.
+func Lookup(service, account string) ([]byte, error) {
+	if service == "" || account == "" {
+		return nil, errors.New("the service and the account of the secret must not be empty")
+	}
+	return lookup(service, account)
+}
+
+// run runs the keychain tool and returns its output without the trailing
+// newline. The failure that notFound tells from its exit code and its error
+// output is reported as ErrNotFound, any other with the error output.
+func run(cmd *exec.Cmd, notFound func(code int, stderr []byte) bool) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err, ok := err.(*exec.ExitError); ok {
+		message := bytes.TrimSpace(stderr.Bytes())
+		if notFound(err.ExitCode(), message) {
+			return nil, ErrNotFound
+		}
+		if len(message) > 0 {
+			return nil, fmt.Errorf("%s: %s", cmd.Args[0], message)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", cmd.Args[0], err)
+	}
+
+	return bytes.TrimRight(output, "\r\n"), nil
+}
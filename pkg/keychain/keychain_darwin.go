@@ -0,0 +1,17 @@
+package keychain
+
+import (
+	"os/exec"
+)
+
+// errSecItemNotFound is the exit code of security when there is no such item.
+const errSecItemNotFound = 44
+
+// lookup finds the generic password of the service and the account in the
+// default keychain search list.
+func lookup(service, account string) ([]byte, error) {
+	cmd := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	return run(cmd, func(code int, stderr []byte) bool {
+		return code == errSecItemNotFound
+	})
+}
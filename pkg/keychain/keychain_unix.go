@@ -0,0 +1,22 @@
+//go:build !darwin && !windows
+
+package keychain
+
+import (
+	"os/exec"
+)
+
+// lookup finds the secret with the service and account attributes over the
+// Secret Service API with secret-tool, which is part of libsecret. The secret
+// is stored with:
+//
+//	secret-tool store --label surge service SERVICE account ACCOUNT
+func lookup(service, account string) ([]byte, error) {
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+
+	// secret-tool exits with 1 and prints nothing if there is no such secret,
+	// the errors of the Secret Service are printed with the same exit code.
+	return run(cmd, func(code int, stderr []byte) bool {
+		return code == 1 && len(stderr) == 0
+	})
+}
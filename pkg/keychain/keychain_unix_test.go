@@ -0,0 +1,70 @@
+//go:build !darwin && !windows
+
+package keychain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeSecretTool puts a secret-tool running the script first in the PATH
+// and returns the function restoring the PATH.
+func fakeSecretTool(t *testing.T, script string) func() {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret-tool"), []byte("#!/bin/sh\n"+script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	t.Run("found", func(t *testing.T) {
+		defer fakeSecretTool(t, `[ "$*" = "lookup service surge account catalog" ] && printf 'test passphrase\n'`)()
+
+		secret, err := Lookup("surge", "catalog")
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if string(secret) != "test passphrase" {
+			t.Fatalf("got %q, want %q", secret, "test passphrase")
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		defer fakeSecretTool(t, "exit 1")()
+
+		if _, got := Lookup("surge", "catalog"); got != ErrNotFound {
+			t.Fatalf("got %#v, want %#v", got, ErrNotFound)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		defer fakeSecretTool(t, "echo 'Cannot autolaunch D-Bus without X11 $DISPLAY' >&2; exit 1")()
+
+		errString := "secret-tool: Cannot autolaunch D-Bus without X11 $DISPLAY"
+		if _, got := Lookup("surge", "catalog"); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	t.Run("empty account", func(t *testing.T) {
+		errString := "the service and the account of the secret must not be empty"
+		if _, got := Lookup("surge", ""); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+}
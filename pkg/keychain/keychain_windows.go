@@ -0,0 +1,78 @@
+package keychain
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32     = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead = advapi32.NewProc("CredReadW")
+	procCredFree = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric = 1
+	errorNotFound   = syscall.Errno(1168)
+)
+
+// credential is the CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// lookup reads the generic credential with the SERVICE:ACCOUNT target name
+// from the Credential Manager. The credential is stored with:
+//
+//	cmdkey /generic:SERVICE:ACCOUNT /user:ACCOUNT /pass
+func lookup(service, account string) ([]byte, error) {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *credential
+	r, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if err == errorNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	return decodeBlob(blob), nil
+}
+
+// decodeBlob returns a copy of the secret of a credential blob, which is freed
+// with the credential. The Control Panel and cmdkey store the secret in UTF-16,
+// which is told from the UTF-8 stored by the other tools by the zero high
+// bytes of the ASCII characters.
+func decodeBlob(blob []byte) []byte {
+	if len(blob)%2 != 0 {
+		return append([]byte(nil), blob...)
+	}
+	for i := 1; i < len(blob); i += 2 {
+		if blob[i] != 0 {
+			return append([]byte(nil), blob...)
+		}
+	}
+
+	secret := make([]byte, len(blob)/2)
+	for i := range secret {
+		secret[i] = blob[2*i]
+	}
+	return secret
+}
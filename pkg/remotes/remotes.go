@@ -0,0 +1,182 @@
+// Package remotes resolves the named remotes defined in the surge config file.
+//
+// A remote saves repeating the account, region and vault on every invocation:
+//
+//	[remote backup]
+//	profile = glacier
+//	region = eu-central-1
+//	account_id = 111111111111
+//	vault = my-vault
+//	keep_last = 7
+//	keep_monthly = 12
+//	pre_cmd = zfs snapshot tank/data@surge
+//	post_cmd = zfs destroy tank/data@surge
+//
+// The keep_last and keep_monthly keys define the retention rules of the vault,
+// and the role_arn and external_id keys the role assumed to access it.
+// The pre_cmd and post_cmd keys define the commands run around the transfers.
+// It is referred to as "backup:" in place of the vault name, or as
+// "backup:other-vault" to use the remote settings with another vault.
+package remotes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+const (
+	configFileEnvVar = "SURGE_CONFIG_FILE"
+	sectionPrefix    = "remote "
+
+	profileKey     = "profile"
+	regionKey      = "region"
+	endpointURLKey = "endpoint_url"
+	accountIdKey   = "account_id"
+	roleARNKey     = "role_arn"
+	externalIdKey  = "external_id"
+	vaultKey       = "vault"
+	keepLastKey    = "keep_last"
+	keepMonthlyKey = "keep_monthly"
+	preCmdKey      = "pre_cmd"
+	postCmdKey     = "post_cmd"
+)
+
+// Remote is a named set of settings identifying a vault.
+type Remote struct {
+	Name        string
+	Profile     string
+	Region      string
+	EndpointURL string
+	AccountId   string
+	VaultName   string
+
+	// The role to assume in the account owning the vault, and the external ID
+	// its trust policy requires, empty if not defined.
+	RoleARN    string
+	ExternalId string
+
+	// The retention rules, zero if not defined.
+	KeepLast    int
+	KeepMonthly int
+
+	// The shell commands run before and after a transfer, empty if not defined.
+	PreCmd  string
+	PostCmd string
+}
+
+// DefaultConfigFile returns the SURGE_CONFIG_FILE environment variable
+// or ~/.surge/config if it is not set.
+func DefaultConfigFile() string {
+	if filename := os.Getenv(configFileEnvVar); filename != "" {
+		return filename
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".surge", "config")
+}
+
+// ParseTarget splits a target of the form "name:" or "name:vault".
+// It returns false if the target is a plain vault name.
+func ParseTarget(target string) (name, vaultName string, ok bool) {
+	i := strings.IndexByte(target, ':')
+	if i < 0 {
+		return "", "", false
+	}
+	return target[:i], target[i+1:], true
+}
+
+// Load reads the remotes defined in the config file. A missing file
+// defines no remotes.
+func Load(filename string) (map[string]*Remote, error) {
+	remotes := make(map[string]*Remote)
+
+	file, err := ini.Load(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return remotes, nil
+		}
+		return nil, err
+	}
+
+	for _, section := range file.Sections() {
+		if !strings.HasPrefix(section.Name(), sectionPrefix) {
+			continue
+		}
+
+		name := strings.TrimSpace(strings.TrimPrefix(section.Name(), sectionPrefix))
+		remote := &Remote{
+			Name:        name,
+			Profile:     section.Key(profileKey).String(),
+			Region:      section.Key(regionKey).String(),
+			EndpointURL: section.Key(endpointURLKey).String(),
+			AccountId:   section.Key(accountIdKey).String(),
+			VaultName:   section.Key(vaultKey).String(),
+			RoleARN:     section.Key(roleARNKey).String(),
+			ExternalId:  section.Key(externalIdKey).String(),
+			PreCmd:      section.Key(preCmdKey).String(),
+			PostCmd:     section.Key(postCmdKey).String(),
+		}
+
+		if remote.KeepLast, err = intKey(section, keepLastKey); err != nil {
+			return nil, fmt.Errorf("remote %q: %v", name, err)
+		}
+		if remote.KeepMonthly, err = intKey(section, keepMonthlyKey); err != nil {
+			return nil, fmt.Errorf("remote %q: %v", name, err)
+		}
+
+		remotes[name] = remote
+	}
+
+	return remotes, nil
+}
+
+// intKey returns the non-negative integer value of the key, zero if it is not set.
+func intKey(section *ini.Section, key string) (int, error) {
+	if !section.HasKey(key) {
+		return 0, nil
+	}
+
+	n, err := section.Key(key).Int()
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, section.Key(key).String())
+	}
+	return n, nil
+}
+
+// Resolve returns the remote the target refers to, with the vault name
+// overridden if the target specifies one. It returns nil if the target is
+// a plain vault name.
+func Resolve(filename, target string) (*Remote, error) {
+	name, vaultName, ok := ParseTarget(target)
+	if !ok {
+		return nil, nil
+	}
+
+	remotes, err := Load(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, ok := remotes[name]
+	if !ok {
+		return nil, fmt.Errorf("remote %q is not defined in %s", name, filename)
+	}
+
+	resolved := *remote
+	if vaultName != "" {
+		resolved.VaultName = vaultName
+	}
+
+	if resolved.VaultName == "" {
+		return nil, fmt.Errorf("remote %q has no vault, specify it as %s:VAULT", name, name)
+	}
+
+	return &resolved, nil
+}
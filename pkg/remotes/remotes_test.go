@@ -0,0 +1,141 @@
+package remotes
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	cases := map[string]struct {
+		name  string
+		vault string
+		ok    bool
+	}{
+		"my-vault":        {},
+		"backup:":         {name: "backup", ok: true},
+		"backup:my-vault": {name: "backup", vault: "my-vault", ok: true},
+	}
+
+	for target, want := range cases {
+		name, vault, ok := ParseTarget(target)
+		if name != want.name || vault != want.vault || ok != want.ok {
+			t.Fatalf("%s: got %q, %q, %v, want %q, %q, %v", target, name, vault, ok, want.name, want.vault, want.ok)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "config")
+	err = ioutil.WriteFile(filename, []byte(`
+[remote backup]
+profile = glacier
+region = eu-central-1
+account_id = 1111-1111-1111
+vault = my-vault
+role_arn = arn:aws:iam::111111111111:role/backup
+external_id = customer-1
+keep_last = 7
+keep_monthly = 12
+pre_cmd = zfs snapshot tank/data@surge
+post_cmd = echo "$SURGE_STATUS" | mail -s surge admin
+
+[remote novault]
+region = us-east-1
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup := Remote{
+		Name:        "backup",
+		Profile:     "glacier",
+		Region:      "eu-central-1",
+		AccountId:   "1111-1111-1111",
+		VaultName:   "my-vault",
+		RoleARN:     "arn:aws:iam::111111111111:role/backup",
+		ExternalId:  "customer-1",
+		KeepLast:    7,
+		KeepMonthly: 12,
+		PreCmd:      "zfs snapshot tank/data@surge",
+		PostCmd:     `echo "$SURGE_STATUS" | mail -s surge admin`,
+	}
+
+	t.Run("plain vault", func(t *testing.T) {
+		remote, err := Resolve(filename, "my-vault")
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if remote != nil {
+			t.Fatalf("got %#v, want nil", remote)
+		}
+	})
+
+	t.Run("remote", func(t *testing.T) {
+		remote, err := Resolve(filename, "backup:")
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if !reflect.DeepEqual(*remote, backup) {
+			t.Fatalf("got %#v, want %#v", *remote, backup)
+		}
+	})
+
+	t.Run("vault override", func(t *testing.T) {
+		remote, err := Resolve(filename, "backup:other-vault")
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		want := backup
+		want.VaultName = "other-vault"
+		if !reflect.DeepEqual(*remote, want) {
+			t.Fatalf("got %#v, want %#v", *remote, want)
+		}
+	})
+
+	errorCases := map[string]string{
+		"undefined": `remote "undefined" is not defined in ` + filename,
+		"novault":   `remote "novault" has no vault, specify it as novault:VAULT`,
+	}
+
+	for name, want := range errorCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Resolve(filename, name+":")
+			if err == nil || err.Error() != want {
+				t.Fatalf("got %#v, want %#v", err, want)
+			}
+		})
+	}
+
+	t.Run("invalid retention", func(t *testing.T) {
+		filename := path.Join(dir, "invalid")
+		if err := ioutil.WriteFile(filename, []byte("[remote backup]\nkeep_last = -1\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		want := `remote "backup": invalid keep_last "-1"`
+		if _, err := Load(filename); err == nil || err.Error() != want {
+			t.Fatalf("got %#v, want %#v", err, want)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		remotes, err := Load(path.Join(dir, "nonexistent"))
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if len(remotes) != 0 {
+			t.Fatalf("got %#v, want no remotes", remotes)
+		}
+	})
+}
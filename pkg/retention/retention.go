@@ -0,0 +1,70 @@
+// Package retention decides which archives expire according to the retention rules.
+//
+// The archives are grouped by the uploaded file, so that the rules apply to the
+// successive uploads of every file separately. An archive is kept if any of the
+// rules keeps it.
+package retention
+
+import (
+	"sort"
+
+	"github.com/31z4/surge/pkg/catalog"
+)
+
+// Policy holds the retention rules of a vault.
+type Policy struct {
+	// KeepLast is the number of the latest uploads of every file to keep.
+	KeepLast int
+
+	// KeepMonthly is the number of the latest months for which the last
+	// upload of every file is kept, e.g. 12 to keep a monthly upload for a year.
+	KeepMonthly int
+}
+
+// Empty reports whether the policy has no rules.
+func (p Policy) Empty() bool {
+	return p.KeepLast <= 0 && p.KeepMonthly <= 0
+}
+
+// Apply splits the archives into the kept and the expired ones, the latest
+// first within every file. An empty policy keeps every archive.
+func (p Policy) Apply(entries []*catalog.Entry) (keep, expire []*catalog.Entry) {
+	if p.Empty() {
+		return entries, nil
+	}
+
+	var files []string
+	groups := make(map[string][]*catalog.Entry)
+	for _, e := range entries {
+		if _, ok := groups[e.FileName]; !ok {
+			files = append(files, e.FileName)
+		}
+		groups[e.FileName] = append(groups[e.FileName], e)
+	}
+
+	for _, file := range files {
+		group := groups[file]
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].UploadedAt.After(group[j].UploadedAt)
+		})
+
+		months := make(map[string]struct{})
+		for i, e := range group {
+			kept := i < p.KeepLast
+
+			month := e.UploadedAt.UTC().Format("2006-01")
+			if _, ok := months[month]; !ok && len(months) < p.KeepMonthly {
+				months[month] = struct{}{}
+				kept = true
+			}
+
+			if kept {
+				keep = append(keep, e)
+			} else {
+				expire = append(expire, e)
+			}
+		}
+	}
+
+	return keep, expire
+}
@@ -0,0 +1,79 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/31z4/surge/pkg/catalog"
+)
+
+func TestApply(t *testing.T) {
+	upload := func(id, file string, year int, month time.Month, day int) *catalog.Entry {
+		return &catalog.Entry{
+			ArchiveId:  id,
+			FileName:   file,
+			UploadedAt: time.Date(year, month, day, 3, 0, 0, 0, time.UTC),
+		}
+	}
+
+	entries := []*catalog.Entry{
+		upload("a1", "a.tar", 2018, 3, 1),
+		upload("a2", "a.tar", 2018, 3, 15),
+		upload("a3", "a.tar", 2018, 4, 1),
+		upload("a4", "a.tar", 2018, 5, 1),
+		upload("a5", "a.tar", 2018, 5, 2),
+		upload("b1", "b.tar", 2018, 1, 1),
+	}
+
+	ids := func(entries []*catalog.Entry) []string {
+		var ids []string
+		for _, e := range entries {
+			ids = append(ids, e.ArchiveId)
+		}
+		return ids
+	}
+
+	cases := []struct {
+		name   string
+		policy Policy
+		keep   []string
+		expire []string
+	}{
+		{
+			name:   "empty",
+			policy: Policy{},
+			keep:   []string{"a1", "a2", "a3", "a4", "a5", "b1"},
+		},
+		{
+			name:   "keep last",
+			policy: Policy{KeepLast: 2},
+			keep:   []string{"a5", "a4", "b1"},
+			expire: []string{"a3", "a2", "a1"},
+		},
+		{
+			name:   "keep monthly",
+			policy: Policy{KeepMonthly: 2},
+			keep:   []string{"a5", "a3", "b1"},
+			expire: []string{"a4", "a2", "a1"},
+		},
+		{
+			name:   "keep last and monthly",
+			policy: Policy{KeepLast: 2, KeepMonthly: 12},
+			keep:   []string{"a5", "a4", "a3", "a2", "b1"},
+			expire: []string{"a1"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			keep, expire := c.policy.Apply(append([]*catalog.Entry(nil), entries...))
+			if got := ids(keep); !reflect.DeepEqual(got, c.keep) {
+				t.Fatalf("got %#v, want %#v", got, c.keep)
+			}
+			if got := ids(expire); !reflect.DeepEqual(got, c.expire) {
+				t.Fatalf("got %#v, want %#v", got, c.expire)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+// Package retrieval initiates the Amazon Glacier jobs retrieving archives and
+// waits for them to complete.
+//
+// For information about retrieving archives, see
+// https://docs.aws.amazon.com/amazonglacier/latest/dev/downloading-an-archive-two-steps.html.
+package retrieval
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/31z4/surge/pkg/events"
+	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+	"github.com/aws/aws-sdk-go-v2/service/glacier/glacieriface"
+)
+
+const archiveRetrieval = "ArchiveRetrieval"
+
+// outputLifetime is how long the output of a succeeded job can be downloaded.
+const outputLifetime = 24 * time.Hour
+
+// minRemaining is the least time left to download the output of a succeeded
+// job for the job to be reused rather than a new one initiated.
+const minRemaining = time.Hour
+
+// Tiers are the retrieval options, from the fastest and most expensive.
+var Tiers = []string{"Expedited", "Standard", "Bulk"}
+
+// Job is the retrieval job of an archive.
+type Job struct {
+	ArchiveId string
+	JobId     string
+
+	// Err is set if the job failed.
+	Err error
+}
+
+// Input provides options for retrieving archives from an Amazon Glacier vault.
+type Input struct {
+	// The AccountId value is the AWS account ID of the account that owns the vault.
+	// You can either specify an AWS account ID or optionally a single '-' (hyphen),
+	// in which case Amazon Glacier uses the AWS account ID associated with the
+	// credentials used to sign the request.
+	AccountId string
+
+	// The name of the vault.
+	VaultName string
+
+	// The IDs of the archives to retrieve.
+	ArchiveIds []string
+
+	// The retrieval option, one of Tiers. If the value is empty then the
+	// Standard tier is used.
+	Tier string
+
+	// Downgrade retrieves with the Standard tier the archives whose Expedited
+	// retrieval is rejected for insufficient capacity. Otherwise the rejection
+	// fails the retrieval.
+	Downgrade bool
+
+	// PollInterval is the time between the checks of the jobs in progress.
+	PollInterval time.Duration
+
+	// Logger receives the retrieval events.
+	// If the value is nil then the events are printed using the standard logger.
+	Logger events.Logger
+}
+
+// Retriever holds internal archive retriever state.
+type Retriever struct {
+	service glacieriface.GlacierAPI
+	input   *Input
+}
+
+// New creates a new instance of the retriever with a service and input.
+func New(service glacieriface.GlacierAPI, input *Input) *Retriever {
+	return &Retriever{
+		service: service,
+		input:   input,
+	}
+}
+
+func (r *Retriever) log(e *events.Event) {
+	e.Operation = "retrieval"
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	logger := r.input.Logger
+	if logger == nil {
+		logger = events.TextLogger{}
+	}
+	logger.Log(e)
+}
+
+// listJobs returns the archive retrieval jobs of the vault.
+func (r *Retriever) listJobs() ([]glacier.DescribeJobOutput, error) {
+	input := &glacier.ListJobsInput{
+		AccountId: &r.input.AccountId,
+		VaultName: &r.input.VaultName,
+	}
+
+	request := r.service.ListJobsRequest(input)
+	pager := request.Paginate()
+
+	var jobs []glacier.DescribeJobOutput
+	for pager.Next() {
+		for _, job := range pager.CurrentPage().JobList {
+			if string(job.Action) == archiveRetrieval {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+
+	if err := pager.Err(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// wholeArchive reports whether the job retrieves the whole archive.
+func wholeArchive(job *glacier.DescribeJobOutput) bool {
+	byteRange := aws.StringValue(job.RetrievalByteRange)
+	return byteRange == "" || byteRange == fmt.Sprint("0-", aws.Int64Value(job.ArchiveSizeInBytes)-1)
+}
+
+// findJobs returns the jobs retrieving the whole archives by the archive IDs,
+// preferring the most recently completed succeeded jobs to the ones in progress.
+// A succeeded job whose output expires within minRemaining of the time is not
+// reused, since the archive could not be downloaded in time.
+func (r *Retriever) findJobs(now time.Time) (map[string]string, error) {
+	jobs, err := r.listJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]string)
+	succeeded := make(map[string]time.Time)
+
+	for _, job := range jobs {
+		job := job
+		archiveId := aws.StringValue(job.ArchiveId)
+		if !wholeArchive(&job) {
+			continue
+		}
+
+		switch string(job.StatusCode) {
+		case "Succeeded":
+			completed, err := time.Parse(time.RFC3339, aws.StringValue(job.CompletionDate))
+			if err == nil && completed.Add(outputLifetime-minRemaining).Before(now) {
+				continue
+			}
+			if latest, ok := succeeded[archiveId]; ok && !completed.After(latest) {
+				continue
+			}
+			succeeded[archiveId] = completed
+			found[archiveId] = aws.StringValue(job.JobId)
+		case "InProgress":
+			if _, ok := succeeded[archiveId]; !ok {
+				found[archiveId] = aws.StringValue(job.JobId)
+			}
+		}
+	}
+
+	return found, nil
+}
+
+func (r *Retriever) initiateJob(archiveId string) (string, error) {
+	jobType := "archive-retrieval"
+	input := &glacier.InitiateJobInput{
+		AccountId: &r.input.AccountId,
+		VaultName: &r.input.VaultName,
+		JobParameters: &glacier.JobParameters{
+			Type:      &jobType,
+			ArchiveId: &archiveId,
+		},
+	}
+	if r.input.Tier != "" {
+		input.JobParameters.Tier = &r.input.Tier
+	}
+
+	request := r.service.InitiateJobRequest(input)
+	result, err := request.Send()
+	if err != nil && r.input.Downgrade && insufficientCapacity(err) {
+		r.log(&events.Event{
+			Type:      events.Warning,
+			Message:   fmt.Sprintf("Expedited retrieval of archive %s is rejected for insufficient capacity, retrieving it with the Standard tier", archiveId),
+			ArchiveId: archiveId,
+			Error:     err.Error(),
+		})
+
+		parameters := *input.JobParameters
+		parameters.Tier = aws.String("Standard")
+		downgraded := *input
+		downgraded.JobParameters = &parameters
+
+		request = r.service.InitiateJobRequest(&downgraded)
+		result, err = request.Send()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(result.JobId), nil
+}
+
+// insufficientCapacity reports whether the error rejects an Expedited retrieval
+// for insufficient capacity.
+func insufficientCapacity(err error) bool {
+	e, ok := err.(awserr.Error)
+	return ok && e.Code() == glacier.ErrCodeInsufficientCapacityException
+}
+
+// ProvisionedCapacity returns the number of the provisioned capacity units of
+// the account that have not expired. Without any, Expedited retrievals are
+// only accepted while Amazon Glacier has on-demand capacity to spare.
+func (r *Retriever) ProvisionedCapacity() (int, error) {
+	accountId, err := utils.NormalizeAccountId(r.input.AccountId)
+	if err != nil {
+		return 0, err
+	}
+
+	request := r.service.ListProvisionedCapacityRequest(&glacier.ListProvisionedCapacityInput{
+		AccountId: &accountId,
+	})
+	result, err := request.Send()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	units := 0
+	for _, unit := range result.ProvisionedCapacityList {
+		expiration, err := time.Parse(time.RFC3339, aws.StringValue(unit.ExpirationDate))
+		if err != nil {
+			return 0, fmt.Errorf("invalid expiration date of capacity unit %s: %v", aws.StringValue(unit.CapacityId), err)
+		}
+		if expiration.After(now) {
+			units++
+		}
+	}
+
+	return units, nil
+}
+
+// checkJobs sends the jobs that are no longer in progress to ready and
+// returns the rest.
+func (r *Retriever) checkJobs(pending []*Job, ready chan<- *Job) ([]*Job, error) {
+	jobs, err := r.listJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]*glacier.DescribeJobOutput)
+	for i := range jobs {
+		statuses[aws.StringValue(jobs[i].JobId)] = &jobs[i]
+	}
+
+	var inProgress []*Job
+	for _, job := range pending {
+		status, ok := statuses[job.JobId]
+		switch {
+		case !ok:
+			job.Err = errors.New("the job is not found, it might have expired")
+		case string(status.StatusCode) == "InProgress":
+			inProgress = append(inProgress, job)
+			continue
+		case string(status.StatusCode) == "Failed":
+			job.Err = errors.New("the job is failed: " + aws.StringValue(status.StatusMessage))
+		case string(status.StatusCode) != "Succeeded":
+			job.Err = errors.New("job status is unexpected: " + string(status.StatusCode))
+		}
+
+		if job.Err != nil {
+			r.log(&events.Event{
+				Type:      events.Error,
+				Message:   fmt.Sprintf("retrieval job %s of archive %s: %v", job.JobId, job.ArchiveId, job.Err),
+				JobId:     job.JobId,
+				ArchiveId: job.ArchiveId,
+				Error:     job.Err.Error(),
+			})
+		} else {
+			r.log(&events.Event{
+				Type:      events.CheckFinished,
+				Message:   fmt.Sprintf("retrieval job %s of archive %s succeeded", job.JobId, job.ArchiveId),
+				JobId:     job.JobId,
+				ArchiveId: job.ArchiveId,
+			})
+		}
+		ready <- job
+	}
+
+	return inProgress, nil
+}
+
+// Retrieve initiates the jobs retrieving the archives, reusing the jobs already
+// retrieving them or whose output is still available, and waits for the jobs
+// to complete. Every job is sent to ready once it is no longer in progress,
+// with Err set if it failed. The channel is closed once all the jobs are sent
+// or an error is returned.
+func (r *Retriever) Retrieve(ready chan<- *Job) error {
+	defer close(ready)
+
+	accountId, err := utils.NormalizeAccountId(r.input.AccountId)
+	if err != nil {
+		return err
+	}
+	r.input.AccountId = accountId
+
+	found, err := r.findJobs(time.Now())
+	if err != nil {
+		return err
+	}
+
+	var pending []*Job
+	for _, archiveId := range r.input.ArchiveIds {
+		job := &Job{ArchiveId: archiveId, JobId: found[archiveId]}
+
+		message := fmt.Sprintf("found retrieval job %s of archive %s", job.JobId, archiveId)
+		if job.JobId == "" {
+			if job.JobId, err = r.initiateJob(archiveId); err != nil {
+				return err
+			}
+			found[archiveId] = job.JobId
+			message = fmt.Sprintf("retrieval job %s of archive %s initiated", job.JobId, archiveId)
+		}
+
+		r.log(&events.Event{
+			Type:      events.CheckStarted,
+			Message:   message,
+			JobId:     job.JobId,
+			ArchiveId: archiveId,
+		})
+		pending = append(pending, job)
+	}
+
+	for {
+		if pending, err = r.checkJobs(pending, ready); err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		time.Sleep(r.input.PollInterval)
+	}
+}
@@ -0,0 +1,346 @@
+package retrieval
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/31z4/surge/internal/mocks"
+	"github.com/31z4/surge/pkg/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+func newTestInput(archiveIds ...string) *Input {
+	return &Input{
+		AccountId:  "-",
+		VaultName:  "test",
+		ArchiveIds: archiveIds,
+		Logger:     events.Discard,
+	}
+}
+
+func newJob(id, archiveId, status string) glacier.DescribeJobOutput {
+	return glacier.DescribeJobOutput{
+		Action:             glacier.ActionCode(archiveRetrieval),
+		JobId:              aws.String(id),
+		ArchiveId:          aws.String(archiveId),
+		ArchiveSizeInBytes: aws.Int64(4),
+		StatusCode:         glacier.StatusCode(status),
+	}
+}
+
+// newListJobsRequestMock lists the jobs of the next poll on every call,
+// the jobs of the last poll once all are listed.
+func newListJobsRequestMock(polls ...[]glacier.DescribeJobOutput) func() glacier.ListJobsRequest {
+	var mutex sync.Mutex
+	call := 0
+
+	return func() glacier.ListJobsRequest {
+		mutex.Lock()
+		jobs := polls[call]
+		if call < len(polls)-1 {
+			call++
+		}
+		mutex.Unlock()
+
+		return glacier.ListJobsRequest{
+			Copy: func(*glacier.ListJobsInput) glacier.ListJobsRequest {
+				return glacier.ListJobsRequest{
+					Request: &aws.Request{
+						Data:      &glacier.ListJobsOutput{JobList: jobs},
+						Operation: &aws.Operation{},
+					},
+				}
+			},
+		}
+	}
+}
+
+func newInitiateJobRequestMock() func() glacier.InitiateJobRequest {
+	var mutex sync.Mutex
+	jobs := 0
+
+	return func() glacier.InitiateJobRequest {
+		mutex.Lock()
+		jobs++
+		id := fmt.Sprint("new", jobs)
+		mutex.Unlock()
+
+		return glacier.InitiateJobRequest{
+			Request: &aws.Request{
+				Data: &glacier.InitiateJobOutput{JobId: aws.String(id)},
+			},
+		}
+	}
+}
+
+// retrieve returns the jobs sent to ready in the order and the error.
+func retrieve(r *Retriever) ([]Job, error) {
+	ready := make(chan *Job)
+	result := make(chan error, 1)
+	go func() {
+		result <- r.Retrieve(ready)
+	}()
+
+	var jobs []Job
+	for job := range ready {
+		jobs = append(jobs, *job)
+	}
+	return jobs, <-result
+}
+
+func TestRetrieve(t *testing.T) {
+	t.Run("initiates and reuses", func(t *testing.T) {
+		ranged := newJob("ranged", "first", "Succeeded")
+		ranged.RetrievalByteRange = aws.String("0-1")
+
+		existing := []glacier.DescribeJobOutput{
+			ranged,
+			newJob("running", "second", "InProgress"),
+			newJob("done", "second", "Succeeded"),
+			newJob("failed", "third", "Failed"),
+		}
+		mock := &mocks.Glacier{
+			ListJobsRequestMock: newListJobsRequestMock(existing, append(existing,
+				newJob("new1", "first", "Succeeded"),
+				newJob("new2", "third", "Succeeded"),
+			)),
+			InitiateJobRequestMock: newInitiateJobRequestMock(),
+		}
+
+		input := newTestInput("first", "second", "third")
+		input.Tier = "Bulk"
+
+		jobs, err := retrieve(New(mock, input))
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		want := []Job{
+			{ArchiveId: "first", JobId: "new1"},
+			{ArchiveId: "second", JobId: "done"},
+			{ArchiveId: "third", JobId: "new2"},
+		}
+		if !reflect.DeepEqual(jobs, want) {
+			t.Fatalf("got %#v, want %#v", jobs, want)
+		}
+
+		sent := mock.InitiateJobInputs()
+		if len(sent) != 2 {
+			t.Fatalf("got %d inputs, want 2", len(sent))
+		}
+		parameters := sent[0].JobParameters
+		if aws.StringValue(parameters.Type) != "archive-retrieval" || aws.StringValue(parameters.ArchiveId) != "first" || aws.StringValue(parameters.Tier) != "Bulk" {
+			t.Fatalf("got %#v", parameters)
+		}
+	})
+
+	t.Run("expiring output", func(t *testing.T) {
+		completedAgo := func(id, archiveId string, ago time.Duration) glacier.DescribeJobOutput {
+			job := newJob(id, archiveId, "Succeeded")
+			job.CompletionDate = aws.String(time.Now().Add(-ago).UTC().Format(time.RFC3339))
+			return job
+		}
+
+		existing := []glacier.DescribeJobOutput{
+			completedAgo("expiring", "first", 23*time.Hour+30*time.Minute),
+			newJob("running", "second", "InProgress"),
+			completedAgo("older", "second", 2*time.Hour),
+			completedAgo("newer", "second", time.Hour),
+			completedAgo("oldest", "second", 3*time.Hour),
+		}
+		mock := &mocks.Glacier{
+			ListJobsRequestMock: newListJobsRequestMock(existing, append(existing,
+				newJob("new1", "first", "Succeeded"),
+			)),
+			InitiateJobRequestMock: newInitiateJobRequestMock(),
+		}
+
+		jobs, err := retrieve(New(mock, newTestInput("first", "second")))
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		want := []Job{
+			{ArchiveId: "first", JobId: "new1"},
+			{ArchiveId: "second", JobId: "newer"},
+		}
+		if !reflect.DeepEqual(jobs, want) {
+			t.Fatalf("got %#v, want %#v", jobs, want)
+		}
+		if got := len(mock.InitiateJobInputs()); got != 1 {
+			t.Fatalf("got %d inputs, want 1", got)
+		}
+	})
+
+	t.Run("waits", func(t *testing.T) {
+		mock := &mocks.Glacier{
+			ListJobsRequestMock: newListJobsRequestMock(
+				nil,
+				[]glacier.DescribeJobOutput{newJob("new1", "first", "InProgress"), newJob("new2", "second", "InProgress")},
+				[]glacier.DescribeJobOutput{newJob("new1", "first", "InProgress"), newJob("new2", "second", "Succeeded")},
+				[]glacier.DescribeJobOutput{newJob("new1", "first", "Succeeded"), newJob("new2", "second", "Succeeded")},
+			),
+			InitiateJobRequestMock: newInitiateJobRequestMock(),
+		}
+
+		jobs, err := retrieve(New(mock, newTestInput("first", "second")))
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		want := []Job{
+			{ArchiveId: "second", JobId: "new2"},
+			{ArchiveId: "first", JobId: "new1"},
+		}
+		if !reflect.DeepEqual(jobs, want) {
+			t.Fatalf("got %#v, want %#v", jobs, want)
+		}
+		if got := len(mock.ListJobsInputs()); got != 4 {
+			t.Fatalf("got %d polls, want 4", got)
+		}
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		failed := newJob("new1", "first", "Failed")
+		failed.StatusMessage = aws.String("test")
+
+		mock := &mocks.Glacier{
+			ListJobsRequestMock:    newListJobsRequestMock(nil, []glacier.DescribeJobOutput{failed}),
+			InitiateJobRequestMock: newInitiateJobRequestMock(),
+		}
+
+		jobs, err := retrieve(New(mock, newTestInput("first", "second")))
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if len(jobs) != 2 {
+			t.Fatalf("got %#v", jobs)
+		}
+		if got, want := jobs[0].Err.Error(), "the job is failed: test"; got != want {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+		if got, want := jobs[1].Err.Error(), "the job is not found, it might have expired"; got != want {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("downgrade", func(t *testing.T) {
+		initiated := newInitiateJobRequestMock()
+		rejected := false
+
+		mock := &mocks.Glacier{
+			ListJobsRequestMock: newListJobsRequestMock(nil, []glacier.DescribeJobOutput{newJob("new1", "first", "Succeeded")}),
+			InitiateJobRequestMock: func() glacier.InitiateJobRequest {
+				if !rejected {
+					rejected = true
+					return glacier.InitiateJobRequest{
+						Request: &aws.Request{
+							Error: awserr.New(glacier.ErrCodeInsufficientCapacityException, "test", nil),
+						},
+					}
+				}
+				return initiated()
+			},
+		}
+
+		input := newTestInput("first")
+		input.Tier = "Expedited"
+		input.Downgrade = true
+
+		jobs, err := retrieve(New(mock, input))
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		want := []Job{{ArchiveId: "first", JobId: "new1"}}
+		if !reflect.DeepEqual(jobs, want) {
+			t.Fatalf("got %#v, want %#v", jobs, want)
+		}
+
+		var tiers []string
+		for _, input := range mock.InitiateJobInputs() {
+			tiers = append(tiers, aws.StringValue(input.JobParameters.Tier))
+		}
+		if want := []string{"Expedited", "Standard"}; !reflect.DeepEqual(tiers, want) {
+			t.Fatalf("got %#v, want %#v", tiers, want)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		err := awserr.New(glacier.ErrCodeInsufficientCapacityException, "test", nil)
+		mock := &mocks.Glacier{
+			ListJobsRequestMock: newListJobsRequestMock(nil),
+			InitiateJobRequestMock: func() glacier.InitiateJobRequest {
+				return glacier.InitiateJobRequest{Request: &aws.Request{Error: err}}
+			},
+		}
+
+		input := newTestInput("first")
+		input.Tier = "Expedited"
+
+		if _, got := retrieve(New(mock, input)); got != err {
+			t.Fatalf("got %#v, want %#v", got, err)
+		}
+	})
+
+	t.Run("invalid account ID", func(t *testing.T) {
+		mock := &mocks.Glacier{}
+		input := newTestInput("first")
+		input.AccountId = "test"
+
+		if _, err := retrieve(New(mock, input)); err == nil {
+			t.Fatal("got nil, want error")
+		}
+		if mock.CallCount != 0 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+	})
+}
+
+func TestProvisionedCapacity(t *testing.T) {
+	t.Run("send error", func(t *testing.T) {
+		err := errors.New("test")
+		mock := &mocks.Glacier{
+			ListProvisionedCapacityRequestMock: func() glacier.ListProvisionedCapacityRequest {
+				return glacier.ListProvisionedCapacityRequest{Request: &aws.Request{Error: err}}
+			},
+		}
+
+		if _, got := New(mock, newTestInput()).ProvisionedCapacity(); got != err {
+			t.Fatalf("got %#v, want %#v", got, err)
+		}
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		day := 24 * time.Hour
+		mock := &mocks.Glacier{
+			ListProvisionedCapacityRequestMock: func() glacier.ListProvisionedCapacityRequest {
+				return glacier.ListProvisionedCapacityRequest{
+					Request: &aws.Request{
+						Data: &glacier.ListProvisionedCapacityOutput{
+							ProvisionedCapacityList: []glacier.ProvisionedCapacityDescription{
+								{CapacityId: aws.String("expired"), ExpirationDate: aws.String(time.Now().Add(-day).Format(time.RFC3339))},
+								{CapacityId: aws.String("active"), ExpirationDate: aws.String(time.Now().Add(day).Format(time.RFC3339))},
+							},
+						},
+					},
+				}
+			},
+		}
+
+		units, err := New(mock, newTestInput()).ProvisionedCapacity()
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if units != 1 {
+			t.Fatalf("got %#v, want %#v", units, 1)
+		}
+	})
+}
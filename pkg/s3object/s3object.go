@@ -0,0 +1,259 @@
+// Package s3object reads an object stored in Amazon S3 with ranged requests,
+// so that it can be uploaded to Amazon Glacier without a local copy, and
+// writes one with a multipart upload, so that an archive can be downloaded
+// from Amazon Glacier without one.
+//
+// The object is referred to by an s3://bucket/key URL. The requests are sent
+// to the S3 endpoint of the region resolved from the AWS config, in the path
+// style, so that an emulator serving S3 at the endpoint URL works as well.
+package s3object
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	urlPrefix   = "s3://"
+	signingName = "s3"
+)
+
+// MaxRetries is the number of times a failed request is retried.
+var MaxRetries = 3
+
+// RetryDelay is the delay before the first retry, doubled on every next one.
+var RetryDelay = time.Second
+
+// Object is an object stored in Amazon S3, read at any offset.
+// It is safe for concurrent use.
+type Object struct {
+	Bucket string
+	Key    string
+
+	requester
+	size int64
+}
+
+// ParseURL splits an s3://bucket/key URL.
+// It returns false if the string is not an S3 URL.
+func ParseURL(s string) (bucket, key string, ok bool) {
+	if !strings.HasPrefix(s, urlPrefix) {
+		return "", "", false
+	}
+
+	split := strings.SplitN(strings.TrimPrefix(s, urlPrefix), "/", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return "", "", false
+	}
+	return split[0], split[1], true
+}
+
+// escapePath escapes every segment of the key, keeping the slashes.
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// newRequester creates the requester of the object in the bucket. The endpoint,
+// the region and the credentials are resolved from the config.
+func newRequester(config aws.Config, bucket, key string) (requester, error) {
+	endpoint, err := config.EndpointResolver.ResolveEndpoint(signingName, config.Region)
+	if err != nil {
+		return requester{}, err
+	}
+
+	region := endpoint.SigningRegion
+	if region == "" {
+		region = config.Region
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return requester{
+		name:   urlPrefix + bucket + "/" + key,
+		url:    strings.TrimSuffix(endpoint.URL, "/") + "/" + url.PathEscape(bucket) + "/" + escapePath(key),
+		region: region,
+		client: client,
+		signer: v4.NewSigner(config.Credentials, func(s *v4.Signer) {
+			s.DisableURIPathEscaping = true
+		}),
+	}, nil
+}
+
+// Open looks up the size of the object in the bucket. The endpoint, the
+// region and the credentials are resolved from the config.
+func Open(config aws.Config, bucket, key string) (*Object, error) {
+	requester, err := newRequester(config, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	requester.op = "read"
+
+	o := &Object{
+		Bucket:    bucket,
+		Key:       key,
+		requester: requester,
+	}
+
+	response, err := o.send("HEAD", "", nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+
+	if response.ContentLength < 0 {
+		return nil, fmt.Errorf("the size of %s is unknown", o)
+	}
+	o.size = response.ContentLength
+
+	return o, nil
+}
+
+// String returns the URL of the object.
+func (o *Object) String() string {
+	return o.name
+}
+
+// Size returns the size of the object in bytes.
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ReadAt reads len(p) bytes of the object starting at the offset with a
+// ranged request. It returns io.EOF if the object ends before p is filled.
+func (o *Object) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+
+	limit := int64(len(p))
+	if off+limit > o.size {
+		limit = o.size - off
+	}
+	if limit == 0 {
+		return 0, nil
+	}
+
+	// A ranged request not answered with the range would read the wrong data.
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, off+limit-1)}}
+	response, err := o.send("GET", "", header, nil, http.StatusPartialContent)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	n, err := io.ReadFull(response.Body, p[:limit])
+	if err != nil {
+		return n, fmt.Errorf("could not read %s: %v", o, err)
+	}
+
+	if limit < int64(len(p)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// requester sends the signed requests about an object.
+type requester struct {
+	name string // the URL of the object
+	op   string // what the failed requests could not do, read or write
+
+	url    string
+	region string
+
+	client *http.Client
+	signer *v4.Signer
+}
+
+// send sends the signed request with the query, the header and the body,
+// retrying the network errors and the server errors. The response must
+// have the wanted status.
+func (r *requester) send(method, query string, header http.Header, body []byte, want int) (*http.Response, error) {
+	delay := RetryDelay
+
+	for attempt := 0; ; attempt++ {
+		response, err := r.sendOnce(method, query, header, body, want)
+		if err == nil {
+			return response, nil
+		}
+		if e, ok := err.(*statusError); ok && e.code < http.StatusInternalServerError {
+			return nil, err
+		}
+		if attempt >= MaxRetries {
+			return nil, err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// statusError is returned when the request fails with an HTTP status.
+type statusError struct {
+	name   string
+	op     string
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("could not %s %s: %s", e.op, e.name, e.status)
+}
+
+// IsNotFound reports whether the error is returned because the object
+// or the bucket is not found.
+func IsNotFound(err error) bool {
+	e, ok := err.(*statusError)
+	return ok && e.code == http.StatusNotFound
+}
+
+func (r *requester) sendOnce(method, query string, header http.Header, body []byte, want int) (*http.Response, error) {
+	u := r.url
+	if query != "" {
+		u += "?" + query
+	}
+
+	request, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	// The signer sets the body it signs, of a length known to be sent.
+	var payload io.ReadSeeker
+	if body != nil {
+		payload = bytes.NewReader(body)
+	}
+	if _, err := r.signer.Sign(request, payload, signingName, r.region, time.Now()); err != nil {
+		return nil, err
+	}
+	request.ContentLength = int64(len(body))
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != want {
+		response.Body.Close()
+		return nil, &statusError{name: r.name, op: r.op, code: response.StatusCode, status: response.Status}
+	}
+
+	return response, nil
+}
@@ -0,0 +1,166 @@
+package s3object
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+)
+
+func TestParseURL(t *testing.T) {
+	cases := []struct {
+		s           string
+		bucket, key string
+		ok          bool
+	}{
+		{"s3://bucket/key", "bucket", "key", true},
+		{"s3://bucket/dir/key", "bucket", "dir/key", true},
+		{"s3://bucket/", "", "", false},
+		{"s3://bucket", "", "", false},
+		{"s3:///key", "", "", false},
+		{"bucket/key", "", "", false},
+		{"/tmp/s3://bucket/key", "", "", false},
+	}
+
+	for _, test := range cases {
+		bucket, key, ok := ParseURL(test.s)
+		if bucket != test.bucket || key != test.key || ok != test.ok {
+			t.Errorf("%s: got %#v, %#v, %#v, want %#v, %#v, %#v", test.s, bucket, key, ok, test.bucket, test.key, test.ok)
+		}
+	}
+}
+
+// fakeS3 serves the object at the path, failing the first requests.
+type fakeS3 struct {
+	path string
+	data []byte
+
+	mutex    sync.Mutex
+	failures int
+	requests []*http.Request
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.requests = append(s.requests, r)
+	fail := s.failures > 0
+	s.failures--
+	s.mutex.Unlock()
+
+	if fail {
+		http.Error(w, "injected failure", http.StatusInternalServerError)
+		return
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		http.Error(w, "not signed", http.StatusForbidden)
+		return
+	}
+	if r.URL.EscapedPath() != s.path {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.data))
+}
+
+func newConfig(url string) aws.Config {
+	config := defaults.Config()
+	config.Region = "eu-west-1"
+	config.Credentials = aws.NewStaticCredentialsProvider("test", "test", "")
+	config.EndpointResolver = aws.ResolveWithEndpointURL(url)
+	return config
+}
+
+func TestObject(t *testing.T) {
+	RetryDelay = time.Millisecond
+	defer func() { RetryDelay = time.Second }()
+
+	data := []byte("0123456789")
+
+	t.Run("reads", func(t *testing.T) {
+		s3 := &fakeS3{path: "/bucket/dir/a%20key", data: data}
+		server := httptest.NewServer(s3)
+		defer server.Close()
+
+		object, err := Open(newConfig(server.URL), "bucket", "dir/a key")
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if object.Size() != int64(len(data)) {
+			t.Fatalf("got %#v, want %#v", object.Size(), len(data))
+		}
+		if got := object.String(); got != "s3://bucket/dir/a key" {
+			t.Fatalf("got %#v, want %#v", got, "s3://bucket/dir/a key")
+		}
+
+		p := make([]byte, 4)
+		if n, err := object.ReadAt(p, 3); err != nil || n != 4 || string(p) != "3456" {
+			t.Fatalf("got %#v, %#v, %#v", n, err, string(p))
+		}
+		if got := s3.requests[1].Header.Get("Range"); got != "bytes=3-6" {
+			t.Fatalf("got %#v, want %#v", got, "bytes=3-6")
+		}
+
+		// The end of the object is reported like io.ReaderAt does.
+		if n, err := object.ReadAt(p, 8); err != io.EOF || n != 2 || string(p[:n]) != "89" {
+			t.Fatalf("got %#v, %#v, %#v", n, err, string(p[:n]))
+		}
+		if n, err := object.ReadAt(p, 10); err != io.EOF || n != 0 {
+			t.Fatalf("got %#v, %#v", n, err)
+		}
+
+		got, err := ioutil.ReadAll(io.NewSectionReader(object, 0, object.Size()))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("got %#v, %#v", string(got), err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		s3 := &fakeS3{path: "/bucket/key", data: data}
+		server := httptest.NewServer(s3)
+		defer server.Close()
+
+		_, err := Open(newConfig(server.URL), "bucket", "missing")
+		want := "could not read s3://bucket/missing: 404 Not Found"
+		if err == nil || err.Error() != want {
+			t.Fatalf("got %#v, want %#v", err, want)
+		}
+		if len(s3.requests) != 1 {
+			t.Fatalf("got %d requests, want 1", len(s3.requests))
+		}
+	})
+
+	t.Run("retries", func(t *testing.T) {
+		for _, failures := range []int{MaxRetries, MaxRetries + 1} {
+			t.Run(fmt.Sprint(failures, " failures"), func(t *testing.T) {
+				s3 := &fakeS3{path: "/bucket/key", data: data, failures: failures}
+				server := httptest.NewServer(s3)
+				defer server.Close()
+
+				_, err := Open(newConfig(server.URL), "bucket", "key")
+				if failures > MaxRetries {
+					want := "could not read s3://bucket/key: 500 Internal Server Error"
+					if err == nil || err.Error() != want {
+						t.Fatalf("got %#v, want %#v", err, want)
+					}
+				} else if err != nil {
+					t.Fatalf("unexpected error: %#v", err)
+				}
+
+				if len(s3.requests) != MaxRetries+1 {
+					t.Fatalf("got %d requests, want %d", len(s3.requests), MaxRetries+1)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,358 @@
+package s3object
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// The limits of an S3 multipart upload.
+const (
+	MinPartSize = 5 << 20
+	MaxParts    = 10000
+)
+
+// Writer writes an object stored in Amazon S3 with a multipart upload,
+// receiving the data at any offset and in any order. The data is buffered
+// until a whole part is written, then the part is uploaded. It is safe for
+// concurrent use.
+type Writer struct {
+	Bucket string
+	Key    string
+
+	requester
+	align int64
+
+	size     int64
+	partSize int64
+	uploadId string
+
+	mutex   sync.Mutex
+	pending map[int64]*pendingPart
+	etags   map[int64]string
+}
+
+// pendingPart is a part being written. It is kept until it is uploaded, so
+// that a part whose upload failed is uploaded again once a retried write
+// completes it.
+type pendingPart struct {
+	data []byte
+
+	// Whether every align block of the part is written, and the blocks
+	// left. The spans written of the blocks written piecewise by unaligned
+	// writes are kept until they cover the block, so that writing a range
+	// again never counts twice.
+	written []bool
+	left    int
+	pieces  map[int64][]span
+
+	uploading bool
+}
+
+// span is the range from start to end of the data of a part.
+type span struct {
+	start, end int64
+}
+
+func newPendingPart(size, align int64) *pendingPart {
+	blocks := int((size + align - 1) / align)
+	return &pendingPart{
+		data:    make([]byte, size),
+		written: make([]bool, blocks),
+		left:    blocks,
+		pieces:  make(map[int64][]span),
+	}
+}
+
+// cover records that the range from start to end of the part is written.
+func (p *pendingPart) cover(start, end, align int64) {
+	for block := start / align; block*align < end; block++ {
+		if p.written[block] {
+			continue
+		}
+
+		s, e := block*align, (block+1)*align
+		if e > int64(len(p.data)) {
+			e = int64(len(p.data))
+		}
+		whole := span{s, e}
+		if start > s {
+			s = start
+		}
+		if end < e {
+			e = end
+		}
+
+		pieces := []span{{s, e}}
+		if s != whole.start || e != whole.end {
+			pieces = mergeSpans(append(p.pieces[block], pieces...))
+			p.pieces[block] = pieces
+		}
+		if len(pieces) == 1 && pieces[0] == whole {
+			delete(p.pieces, block)
+			p.written[block] = true
+			p.left--
+		}
+	}
+}
+
+// mergeSpans returns the spans with the overlapping and adjacent ones joined.
+func mergeSpans(spans []span) []span {
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	merged := spans[:1]
+	for _, s := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if s.start > last.end {
+			merged = append(merged, s)
+		} else if s.end > last.end {
+			last.end = s.end
+		}
+	}
+	return merged
+}
+
+// Create creates the writer of the object in the bucket. The size of its parts
+// is a multiple of align, so that the writes of align bytes at the multiples
+// of align are never split between the parts. The endpoint, the region and
+// the credentials are resolved from the config. Nothing is sent to S3 until
+// Truncate is called.
+func Create(config aws.Config, bucket, key string, align int64) (*Writer, error) {
+	requester, err := newRequester(config, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	requester.op = "write"
+
+	return &Writer{
+		Bucket:    bucket,
+		Key:       key,
+		requester: requester,
+		align:     align,
+		pending:   make(map[int64]*pendingPart),
+		etags:     make(map[int64]string),
+	}, nil
+}
+
+// String returns the URL of the object.
+func (w *Writer) String() string {
+	return w.name
+}
+
+// partSizeFor returns the smallest multiple of align that is allowed as the
+// size of the parts of an object of the size.
+func partSizeFor(size, align int64) int64 {
+	partSize := align
+	for partSize < MinPartSize || (size+partSize-1)/partSize > MaxParts {
+		partSize += align
+	}
+	return partSize
+}
+
+// Truncate initiates the multipart upload of the object of the size.
+// It must be called once before any data is written.
+func (w *Writer) Truncate(size int64) error {
+	if size <= 0 {
+		return fmt.Errorf("could not write %s: the object is empty", w)
+	}
+
+	w.size = size
+	w.partSize = partSizeFor(size, w.align)
+
+	response, err := w.send("POST", "uploads", nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var result struct {
+		UploadId string
+	}
+	if err := xml.NewDecoder(response.Body).Decode(&result); err != nil {
+		return fmt.Errorf("could not write %s: %v", w, err)
+	}
+	if result.UploadId == "" {
+		return fmt.Errorf("could not write %s: no upload ID", w)
+	}
+	w.uploadId = result.UploadId
+
+	return nil
+}
+
+// WriteAt writes the data at the offset. The parts the data completes are
+// uploaded before WriteAt returns.
+func (w *Writer) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > w.size {
+		return 0, fmt.Errorf("could not write %s: the write at %d of %d bytes is out of range", w, off, len(p))
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// The data is split at the boundaries of the parts.
+		n := (off/w.partSize+1)*w.partSize - off
+		if n > int64(len(p)) {
+			n = int64(len(p))
+		}
+
+		if err := w.writePart(p[:n], off); err != nil {
+			return written, err
+		}
+
+		written += int(n)
+		p = p[n:]
+		off += n
+	}
+
+	return written, nil
+}
+
+// writePart writes the data within a part at the offset, and uploads the
+// part if the data completes it. If the upload fails, the part is kept, and
+// uploaded again once a write completes it again.
+func (w *Writer) writePart(p []byte, off int64) error {
+	number := off / w.partSize
+	start := number * w.partSize
+
+	w.mutex.Lock()
+	// The data of a part uploaded or being uploaded is already written.
+	if _, uploaded := w.etags[number]; uploaded {
+		w.mutex.Unlock()
+		return nil
+	}
+	part := w.pending[number]
+	if part == nil {
+		limit := w.partSize
+		if start+limit > w.size {
+			limit = w.size - start
+		}
+		part = newPendingPart(limit, w.align)
+		w.pending[number] = part
+	}
+	if part.uploading {
+		w.mutex.Unlock()
+		return nil
+	}
+
+	copy(part.data[off-start:], p)
+	part.cover(off-start, off-start+int64(len(p)), w.align)
+
+	complete := part.left == 0
+	part.uploading = complete
+	w.mutex.Unlock()
+
+	if !complete {
+		return nil
+	}
+
+	err := w.uploadPart(number, part.data)
+
+	w.mutex.Lock()
+	part.uploading = false
+	if err == nil {
+		delete(w.pending, number)
+	}
+	w.mutex.Unlock()
+
+	return err
+}
+
+// uploadPart uploads the data of the part with the zero-based number.
+func (w *Writer) uploadPart(number int64, data []byte) error {
+	query := fmt.Sprintf("partNumber=%d&uploadId=%s", number+1, url.QueryEscape(w.uploadId))
+	response, err := w.send("PUT", query, nil, data, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	etag := response.Header.Get("ETag")
+	if etag == "" {
+		return fmt.Errorf("could not write %s: no ETag of part %d", w, number+1)
+	}
+
+	w.mutex.Lock()
+	w.etags[number] = etag
+	w.mutex.Unlock()
+
+	return nil
+}
+
+// completedPart is a part listed in the request completing the upload.
+type completedPart struct {
+	PartNumber int64
+	ETag       string
+}
+
+// Commit completes the multipart upload, which makes the object available.
+// All the data must be written.
+func (w *Writer) Commit() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	parts := (w.size + w.partSize - 1) / w.partSize
+	if int64(len(w.etags)) != parts {
+		return fmt.Errorf("could not write %s: %d of %d parts are written", w, len(w.etags), parts)
+	}
+
+	request := struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{}
+	for number, etag := range w.etags {
+		request.Parts = append(request.Parts, completedPart{PartNumber: number + 1, ETag: etag})
+	}
+	sort.Slice(request.Parts, func(i, j int) bool {
+		return request.Parts[i].PartNumber < request.Parts[j].PartNumber
+	})
+
+	body, err := xml.Marshal(&request)
+	if err != nil {
+		return err
+	}
+
+	response, err := w.send("POST", "uploadId="+url.QueryEscape(w.uploadId), nil, body, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// The upload can still fail after the status is sent.
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("could not write %s: %v", w, err)
+	}
+
+	var failure struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.Unmarshal(result, &failure); err == nil && failure.XMLName.Local == "Error" {
+		return fmt.Errorf("could not write %s: %s: %s", w, failure.Code, failure.Message)
+	}
+
+	return nil
+}
+
+// Abort aborts the multipart upload, which discards the uploaded parts.
+func (w *Writer) Abort() error {
+	if w.uploadId == "" {
+		return nil
+	}
+
+	response, err := w.send("DELETE", "uploadId="+url.QueryEscape(w.uploadId), nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	return nil
+}
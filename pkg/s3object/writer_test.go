@@ -0,0 +1,266 @@
+package s3object
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPartSizeFor(t *testing.T) {
+	cases := []struct {
+		size, align, want int64
+	}{
+		{1, 1 << 20, 5 << 20},
+		{100 << 20, 1 << 20, 5 << 20},
+		{100 << 20, 4 << 20, 8 << 20},
+		{100 << 20, 16 << 20, 16 << 20},
+		{MaxParts * 5 << 20, 1 << 20, 5 << 20},
+		{MaxParts*5<<20 + 1, 1 << 20, 6 << 20},
+	}
+
+	for _, test := range cases {
+		if got := partSizeFor(test.size, test.align); got != test.want {
+			t.Errorf("%d, %d: got %#v, want %#v", test.size, test.align, got, test.want)
+		}
+	}
+}
+
+// fakeMultipart serves the multipart upload of the object at the path.
+type fakeMultipart struct {
+	path string
+
+	// failure is the error document completing the upload is answered with.
+	failure string
+
+	// The first failUploads uploads of part failPart fail.
+	failPart    int
+	failUploads int
+
+	mutex     sync.Mutex
+	parts     map[int][]byte
+	completed []completedPart
+	aborted   bool
+	object    []byte
+}
+
+func (s *fakeMultipart) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.EscapedPath() != s.path {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	query := r.URL.Query()
+	_, initiate := query["uploads"]
+
+	switch {
+	case r.Method == "POST" && initiate:
+		s.parts = make(map[int][]byte)
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>upload id</UploadId></InitiateMultipartUploadResult>")
+	case query.Get("uploadId") != "upload id":
+		http.Error(w, "no such upload", http.StatusNotFound)
+	case r.Method == "PUT":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if r.ContentLength < 0 {
+			http.Error(w, "missing content length", http.StatusLengthRequired)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		if number == s.failPart && s.failUploads > 0 {
+			s.failUploads--
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		s.parts[number] = data
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(data)))
+	case r.Method == "POST":
+		var request struct {
+			Parts []completedPart `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.completed = request.Parts
+
+		if s.failure != "" {
+			fmt.Fprint(w, s.failure)
+			return
+		}
+		for _, p := range request.Parts {
+			s.object = append(s.object, s.parts[int(p.PartNumber)]...)
+		}
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == "DELETE":
+		s.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	const align = 1 << 20
+
+	data := make([]byte, 11<<20+3)
+	rand.Read(data)
+
+	create := func(t *testing.T, s3 *fakeMultipart) (*Writer, func()) {
+		server := httptest.NewServer(s3)
+
+		w, err := Create(newConfig(server.URL), "bucket", "dir/a key", align)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if err := w.Truncate(int64(len(data))); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		return w, server.Close
+	}
+
+	// write writes the data in chunks of the size in any order.
+	write := func(t *testing.T, w *Writer, size int) {
+		var wg sync.WaitGroup
+		for _, i := range rand.Perm((len(data) + size - 1) / size) {
+			wg.Add(1)
+			go func(off int) {
+				defer wg.Done()
+
+				end := off + size
+				if end > len(data) {
+					end = len(data)
+				}
+				if n, err := w.WriteAt(data[off:end], int64(off)); err != nil || n != end-off {
+					t.Errorf("got %#v, %#v, want %#v", n, err, end-off)
+				}
+			}(i * size)
+		}
+		wg.Wait()
+	}
+
+	for name, size := range map[string]int{"writes": align, "unaligned writes": 3<<20 + 1} {
+		size := size
+		t.Run(name, func(t *testing.T) {
+			s3 := &fakeMultipart{path: "/bucket/dir/a%20key"}
+			w, stop := create(t, s3)
+			defer stop()
+
+			write(t, w, size)
+			if err := w.Commit(); err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+
+			if !bytes.Equal(s3.object, data) {
+				t.Fatalf("got %d bytes, want %d", len(s3.object), len(data))
+			}
+			if len(s3.completed) != 3 {
+				t.Fatalf("got %#v", s3.completed)
+			}
+			for i, p := range s3.completed {
+				if p.PartNumber != int64(i+1) || p.ETag != fmt.Sprintf(`"%x"`, md5.Sum(s3.parts[i+1])) {
+					t.Fatalf("got %#v", p)
+				}
+			}
+		})
+	}
+
+	t.Run("failed part upload", func(t *testing.T) {
+		RetryDelay = time.Millisecond
+		defer func() { RetryDelay = time.Second }()
+
+		// The second part fails once the writer gives up retrying it.
+		s3 := &fakeMultipart{path: "/bucket/dir/a%20key", failPart: 2, failUploads: MaxRetries + 1}
+		w, stop := create(t, s3)
+		defer stop()
+
+		// A range written twice counts once.
+		if _, err := w.WriteAt(data[:align], 0); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		var failed []int
+		for off := 0; off < len(data); off += align {
+			end := off + align
+			if end > len(data) {
+				end = len(data)
+			}
+			if _, err := w.WriteAt(data[off:end], int64(off)); err != nil {
+				failed = append(failed, off)
+			}
+		}
+		if len(failed) != 1 {
+			t.Fatalf("got %d failed write(s), want 1", len(failed))
+		}
+
+		// The range of the failed write completes the part again, since the
+		// other writes of the part are kept.
+		off := failed[0]
+		if _, err := w.WriteAt(data[off:off+align], int64(off)); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if err := w.Commit(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if !bytes.Equal(s3.object, data) {
+			t.Fatalf("got %d bytes, want %d", len(s3.object), len(data))
+		}
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		s3 := &fakeMultipart{path: "/bucket/dir/a%20key"}
+		w, stop := create(t, s3)
+		defer stop()
+
+		_, err := w.WriteAt(make([]byte, 4), int64(len(data))-3)
+		want := "could not write s3://bucket/dir/a key: the write at 11534336 of 4 bytes is out of range"
+		if err == nil || err.Error() != want {
+			t.Fatalf("got %#v, want %#v", err, want)
+		}
+	})
+
+	t.Run("incomplete", func(t *testing.T) {
+		s3 := &fakeMultipart{path: "/bucket/dir/a%20key"}
+		w, stop := create(t, s3)
+		defer stop()
+
+		if _, err := w.WriteAt(data[:align], 0); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		err := w.Commit()
+		want := "could not write s3://bucket/dir/a key: 0 of 3 parts are written"
+		if err == nil || err.Error() != want {
+			t.Fatalf("got %#v, want %#v", err, want)
+		}
+
+		if err := w.Abort(); err != nil || !s3.aborted {
+			t.Fatalf("got %#v, %#v", err, s3.aborted)
+		}
+	})
+
+	t.Run("failed completion", func(t *testing.T) {
+		s3 := &fakeMultipart{
+			path:    "/bucket/dir/a%20key",
+			failure: "<Error><Code>InternalError</Code><Message>We encountered an internal error.</Message></Error>",
+		}
+		w, stop := create(t, s3)
+		defer stop()
+
+		write(t, w, align)
+
+		err := w.Commit()
+		want := "could not write s3://bucket/dir/a key: InternalError: We encountered an internal error."
+		if err == nil || err.Error() != want {
+			t.Fatalf("got %#v, want %#v", err, want)
+		}
+	})
+}
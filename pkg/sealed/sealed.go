@@ -0,0 +1,152 @@
+// Package sealed encrypts the local files of surge at rest with a passphrase.
+//
+// A sealed file starts with a magic header followed by the key derivation
+// parameters, so that it is recognized when read and can be opened even if the
+// defaults change. The key is derived with PBKDF2-HMAC-SHA256 and the data is
+// encrypted and authenticated with AES-256-GCM.
+package sealed
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/31z4/surge/pkg/utils"
+)
+
+var (
+	// ErrPassphraseRequired is returned when reading a sealed file without a passphrase.
+	ErrPassphraseRequired = errors.New("the file is encrypted, a passphrase is required")
+
+	// ErrDecrypt is returned when a sealed file cannot be decrypted.
+	ErrDecrypt = errors.New("could not decrypt, the passphrase is wrong or the file is corrupted")
+)
+
+// Iterations is the number of PBKDF2 iterations used to seal new data.
+// Data sealed with more than ten times as many is not opened, so that a
+// corrupted or crafted header cannot make the key derivation take hours.
+var Iterations = 600000
+
+var magic = []byte("surge-sealed-v1\n")
+
+const (
+	saltSize   = 16
+	keySize    = 32
+	headerSize = len("surge-sealed-v1\n") + 4 + saltSize
+)
+
+// IsSealed reports whether the data is sealed.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+func newAEAD(passphrase, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, string(passphrase), salt, iterations, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts the data with the passphrase.
+func Seal(passphrase, data []byte) ([]byte, error) {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[len(magic):], uint32(Iterations))
+
+	salt := header[len(magic)+4:]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(passphrase, salt, Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := append(header, nonce...)
+	return aead.Seal(sealed, nonce, data, header), nil
+}
+
+// Open decrypts the sealed data with the passphrase.
+func Open(passphrase, sealed []byte) ([]byte, error) {
+	if !IsSealed(sealed) || len(sealed) < headerSize {
+		return nil, ErrDecrypt
+	}
+
+	header := sealed[:headerSize]
+	iterations := int(binary.BigEndian.Uint32(header[len(magic):]))
+	if iterations < 1 || iterations > 10*Iterations {
+		return nil, ErrDecrypt
+	}
+	salt := header[len(magic)+4:]
+
+	aead, err := newAEAD(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := sealed[headerSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	data, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return data, nil
+}
+
+// ReadFile reads the file, decrypting it with the passphrase if it is sealed.
+// Files that are not sealed are read as is, even if a passphrase is given.
+func ReadFile(path string, passphrase []byte) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsSealed(data) {
+		return data, nil
+	}
+
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+
+	return Open(passphrase, data)
+}
+
+// WriteFile writes the data to the file, sealing it with the passphrase
+// unless the passphrase is empty. The file is replaced at once, so that a
+// crash doesn't leave it truncated.
+func WriteFile(path string, data []byte, perm os.FileMode, passphrase []byte) error {
+	if len(passphrase) != 0 {
+		sealed, err := Seal(passphrase, data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
+
+	return utils.WriteFileAtomic(path, data, perm)
+}
@@ -0,0 +1,103 @@
+package sealed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func init() {
+	// Keep the tests fast, the iterations are stored in the sealed data.
+	Iterations = 1000
+}
+
+func TestSeal(t *testing.T) {
+	passphrase := []byte("test")
+	data := []byte(`{"archives":[]}`)
+
+	sealed, err := Seal(passphrase, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	if !IsSealed(sealed) || bytes.Contains(sealed, data) {
+		t.Fatalf("the data must be sealed, got %q", sealed)
+	}
+
+	got, err := Open(passphrase, sealed)
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("got %q, want %q", got, data)
+	}
+
+	if _, err := Open([]byte("wrong"), sealed); err != ErrDecrypt {
+		t.Fatalf("got %#v, want %#v", err, ErrDecrypt)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := Open(passphrase, sealed); err != ErrDecrypt {
+		t.Fatalf("got %#v, want %#v", err, ErrDecrypt)
+	}
+
+	if _, err := Open(passphrase, magic); err != ErrDecrypt {
+		t.Fatalf("got %#v, want %#v", err, ErrDecrypt)
+	}
+
+	for _, iterations := range []uint32{0, uint32(10*Iterations + 1)} {
+		tampered := append([]byte(nil), sealed...)
+		binary.BigEndian.PutUint32(tampered[len(magic):], iterations)
+		if _, err := Open(passphrase, tampered); err != ErrDecrypt {
+			t.Fatalf("%d iteration(s): got %#v, want %#v", iterations, err, ErrDecrypt)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "test")
+	data := []byte("test")
+
+	t.Run("plain", func(t *testing.T) {
+		if err := WriteFile(filename, data, 0600, nil); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		got, err := ReadFile(filename, []byte("ignored"))
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("got %q, want %q", got, data)
+		}
+	})
+
+	t.Run("sealed", func(t *testing.T) {
+		passphrase := []byte("test")
+		if err := WriteFile(filename, data, 0600, passphrase); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		if _, err := ReadFile(filename, nil); err != ErrPassphraseRequired {
+			t.Fatalf("got %#v, want %#v", err, ErrPassphraseRequired)
+		}
+
+		got, err := ReadFile(filename, passphrase)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("got %q, want %q", got, data)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+// Package split splits a file too large for a single Amazon Glacier archive
+// into segments uploaded as separate archives, and records them in an index
+// the file is reassembled and verified from.
+package split
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/31z4/surge/pkg/utils"
+)
+
+// The limits of a multipart upload to Amazon Glacier.
+const (
+	MaxParts       = 10000
+	MaxPartSize    = 4 << 30
+	MaxArchiveSize = MaxParts * MaxPartSize
+)
+
+// SegmentSize is the size of every segment except the last, the largest power
+// of two not exceeding MaxArchiveSize. Every segment is then a whole subtree
+// of the tree hash of the file, which is computed from the tree hashes of the
+// segments without reading the file once more.
+const SegmentSize = 1 << 45
+
+// IndexSuffix is appended to the name of the split file to name its index.
+const IndexSuffix = ".surge-index"
+
+// leafSize is the size of the data hashed by every leaf of a tree hash.
+const leafSize = 1 << 20
+
+// Segment is a range of the file uploaded as an archive.
+type Segment struct {
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+
+	// The archive and its hex encoded tree hash, set once the segment is uploaded.
+	ArchiveId string `json:"archive_id,omitempty"`
+	TreeHash  string `json:"tree_hash,omitempty"`
+
+	// The upload of the segment in progress, which resumes it.
+	UploadId string `json:"upload_id,omitempty"`
+}
+
+// Uploaded reports whether the archive of the segment is stored.
+func (s *Segment) Uploaded() bool {
+	return s.ArchiveId != ""
+}
+
+// Index lists the segments of a split file.
+type Index struct {
+	// The path of the split file and its size.
+	FileName string `json:"file"`
+	Size     int64  `json:"size"`
+
+	// The hex encoded tree hash of the whole file, set once every segment
+	// is uploaded.
+	TreeHash string `json:"tree_hash,omitempty"`
+
+	Segments []*Segment `json:"segments"`
+}
+
+// New creates the index of the file of the size split into the segments of
+// the segment size, which must be a power of two megabytes.
+func New(fileName string, size, segmentSize int64) *Index {
+	index := &Index{FileName: fileName, Size: size}
+
+	for offset := int64(0); offset < size; offset += segmentSize {
+		limit := segmentSize
+		if offset+limit > size {
+			limit = size - offset
+		}
+		index.Segments = append(index.Segments, &Segment{Offset: offset, Size: limit})
+	}
+
+	return index
+}
+
+// Read reads and validates the index from the file.
+func Read(name string) (*Index, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %v", name, err)
+	}
+	if err := index.validate(); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %v", name, err)
+	}
+
+	return &index, nil
+}
+
+// validate checks that the segments cover the file one after another, and
+// that all of them but the last are of the same power of two megabytes.
+func (x *Index) validate() error {
+	if len(x.Segments) == 0 {
+		return errors.New("no segments")
+	}
+
+	segmentSize := x.Segments[0].Size
+	if len(x.Segments) > 1 && (segmentSize < leafSize || segmentSize&(segmentSize-1) != 0) {
+		return fmt.Errorf("the segment size %d is not a power of two megabytes", segmentSize)
+	}
+
+	var offset int64
+	for i, s := range x.Segments {
+		if s.Offset != offset {
+			return fmt.Errorf("segment %d starts at %d instead of %d", i+1, s.Offset, offset)
+		}
+		last := i == len(x.Segments)-1
+		if s.Size <= 0 || s.Size > segmentSize || (!last && s.Size != segmentSize) {
+			return fmt.Errorf("segment %d has invalid size %d", i+1, s.Size)
+		}
+		offset += s.Size
+	}
+	if offset != x.Size {
+		return fmt.Errorf("the segments cover %d bytes of %d", offset, x.Size)
+	}
+
+	return nil
+}
+
+// Write writes the index to the file, replacing it at once so that an
+// interrupted write doesn't lose the uploaded segments.
+func (x *Index) Write(name string) error {
+	data, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(name, append(data, '\n'), 0644)
+}
+
+// Uploaded reports whether the archives of all the segments are stored.
+func (x *Index) Uploaded() bool {
+	for _, s := range x.Segments {
+		if !s.Uploaded() {
+			return false
+		}
+	}
+	return true
+}
+
+// Checksum returns the hex encoded tree hash of the file combined from the
+// tree hashes of the segments.
+func (x *Index) Checksum() (string, error) {
+	nodes := make([][sha256.Size]byte, len(x.Segments))
+	for i, s := range x.Segments {
+		hash, err := hex.DecodeString(s.TreeHash)
+		if err != nil || len(hash) != sha256.Size {
+			return "", fmt.Errorf("segment %d has invalid tree hash %q", i+1, s.TreeHash)
+		}
+		copy(nodes[i][:], hash)
+	}
+
+	// The segments are whole subtrees, so they combine like leaves.
+	return *utils.TreeHashOfLeaves(nodes), nil
+}
+
+// Verify checks the tree hashes of the segments against the tree hash of the file.
+func (x *Index) Verify() error {
+	checksum, err := x.Checksum()
+	if err != nil {
+		return err
+	}
+	if checksum != x.TreeHash {
+		return fmt.Errorf("the segments combine into tree hash %s instead of %s", checksum, x.TreeHash)
+	}
+	return nil
+}
+
+// PartSize returns the smallest power of two megabytes, but at least the part
+// size, that uploads the segment of the size in at most MaxParts parts.
+func PartSize(size, partSize int64) int64 {
+	p := int64(leafSize)
+	for p < partSize || (size+p-1)/p > MaxParts {
+		p *= 2
+	}
+	return p
+}
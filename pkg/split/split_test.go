@@ -0,0 +1,132 @@
+package split
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/31z4/surge/pkg/utils"
+)
+
+func TestNew(t *testing.T) {
+	index := New("file", 5<<20+1, 2<<20)
+
+	want := []*Segment{
+		{Offset: 0, Size: 2 << 20},
+		{Offset: 2 << 20, Size: 2 << 20},
+		{Offset: 4 << 20, Size: 1<<20 + 1},
+	}
+	if !reflect.DeepEqual(index.Segments, want) {
+		t.Fatalf("got %#v, want %#v", index.Segments, want)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	data := make([]byte, 9<<20+12345)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, segmentSize := range []int64{1 << 20, 2 << 20, 4 << 20, 16 << 20} {
+		index := New("file", int64(len(data)), segmentSize)
+		for _, s := range index.Segments {
+			s.ArchiveId = "archive"
+			s.TreeHash = *utils.TreeHashBytes(data[s.Offset : s.Offset+s.Size])
+		}
+
+		got, err := index.Checksum()
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if want := *utils.TreeHashBytes(data); got != want {
+			t.Fatalf("segment size %d: got %#v, want %#v", segmentSize, got, want)
+		}
+
+		index.TreeHash = got
+		if err := index.Verify(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	index := New("file", 3<<20, 2<<20)
+	index.Segments[0].TreeHash = *utils.TreeHashBytes(make([]byte, 2<<20))
+	index.Segments[1].TreeHash = *utils.TreeHashBytes(make([]byte, 1<<20))
+	index.TreeHash = *utils.TreeHashBytes(make([]byte, 2<<20))
+
+	if err := index.Verify(); err == nil {
+		t.Fatal("got no error, want a mismatch")
+	}
+
+	index.Segments[1].TreeHash = "invalid"
+	if _, err := index.Checksum(); err == nil || !strings.Contains(err.Error(), "segment 2") {
+		t.Fatalf("got %#v, want an invalid tree hash of segment 2", err)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "file"+IndexSuffix)
+
+	index := New("file", 3<<20, 2<<20)
+	index.Segments[0].ArchiveId = "first"
+	index.Segments[1].UploadId = "second"
+	if err := index.Write(name); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	got, err := Read(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if !reflect.DeepEqual(got, index) {
+		t.Fatalf("got %#v, want %#v", got, index)
+	}
+	if got.Uploaded() {
+		t.Fatal("got uploaded, want a segment left to upload")
+	}
+
+	cases := map[string]string{
+		"no segments":      `{"file": "file", "size": 1, "segments": []}`,
+		"not power of two": `{"file": "file", "size": 4194304, "segments": [{"offset": 0, "size": 3145728}, {"offset": 3145728, "size": 1048576}]}`,
+		"gap":              `{"file": "file", "size": 3145728, "segments": [{"offset": 0, "size": 1048576}, {"offset": 2097152, "size": 1048576}]}`,
+		"larger last":      `{"file": "file", "size": 3145728, "segments": [{"offset": 0, "size": 1048576}, {"offset": 1048576, "size": 2097152}]}`,
+		"short":            `{"file": "file", "size": 3145728, "segments": [{"offset": 0, "size": 2097152}]}`,
+	}
+	for name, content := range cases {
+		file := filepath.Join(dir, "invalid")
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Read(file); err == nil {
+			t.Fatalf("%s: got no error", name)
+		}
+	}
+}
+
+func TestPartSize(t *testing.T) {
+	cases := []struct {
+		size, partSize, want int64
+	}{
+		{3 << 20, 1 << 20, 1 << 20},
+		{3 << 20, 8 << 20, 8 << 20},
+		{10000 << 20, 1 << 20, 1 << 20},
+		{10000<<20 + 1, 1 << 20, 2 << 20},
+		{SegmentSize, 1 << 20, MaxPartSize},
+	}
+
+	for _, test := range cases {
+		if got := PartSize(test.size, test.partSize); got != test.want {
+			t.Fatalf("PartSize(%d, %d): got %d, want %d", test.size, test.partSize, got, test.want)
+		}
+	}
+}
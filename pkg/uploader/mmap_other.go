@@ -0,0 +1,16 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package uploader
+
+import (
+	"os"
+)
+
+// mmap is not supported on this platform, the file is read instead.
+func mmap(file *os.File, size int64) ([]byte, error) {
+	return nil, nil
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -4,19 +4,35 @@
 package uploader
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/31z4/surge/pkg/events"
 	"github.com/31z4/surge/pkg/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/glacier"
 	"github.com/aws/aws-sdk-go-v2/service/glacier/glacieriface"
 	"github.com/pkg/errors"
 )
 
+// ErrEmpty is returned for an empty file, source or stream, before any upload
+// is initiated, since an archive holds at least one byte.
+var ErrEmpty = errors.New("the archive is empty, Amazon Glacier does not store empty archives")
+
+// MaxSingleSize is the size of the largest archive Amazon Glacier accepts in
+// a single request.
+const MaxSingleSize = 4 << 30
+
 // Input provides options for multipart upload to an Amazon Glacier vault.
 type Input struct {
 	// The AccountId value is the AWS account ID of the account that owns the vault.
@@ -32,14 +48,246 @@ type Input struct {
 	// The file to upload.
 	FileName string
 
+	// Source is uploaded instead of the file if it is set, for example an
+	// object stored in Amazon S3. The file name then only names the archive.
+	Source Source
+
+	// Stream is uploaded instead of the file if it is set, for example the
+	// output of a command. Its size is only known once it ends, so an upload
+	// of a stream cannot be resumed.
+	Stream io.Reader
+
+	// The description of the archive, which is only set when a new upload
+	// is initiated.
+	Description string
+
 	// The upload ID of the multipart upload.
 	// If the value is empty then a new upload will be initiated.
 	// Specify the upload ID to resume an interrupted upload.
 	UploadId string
 
+	// UploadedParts are the parts of the resumed upload known to be uploaded,
+	// for example kept in a state store of the caller. They are checked against
+	// the file instead of the parts listed by Glacier, which saves listing the
+	// parts of a large upload page by page. If the value is nil then the
+	// uploaded parts are listed.
+	UploadedParts []UploadedPart
+
 	// The size of each part except the last, in bytes. The last part can be smaller
 	// than this part size.
 	PartSize int64
+
+	// MultipartThreshold is the size from which the file or the source is
+	// uploaded in parts. A smaller one is uploaded in a single request, which
+	// saves initiating and completing a multipart upload, but cannot be
+	// resumed. The stream and the resumed uploads are always multipart. If
+	// the value is zero then every upload is multipart.
+	MultipartThreshold int64
+
+	// The order the parts are uploaded in. A stream is always read and
+	// uploaded sequentially.
+	Order utils.Order
+
+	// The maximum transfer rate in bytes per second shared by all the parallel
+	// jobs. If the value is zero then the rate is not limited.
+	MaxRate int64
+
+	// MemoryMap maps the file into memory instead of reading every part.
+	// It is ignored on the platforms not supporting memory mapping.
+	MemoryMap bool
+
+	// MaxMemory bounds the part data buffered in memory, in bytes. Every job
+	// buffers the part it uploads, and the stream one more part read ahead.
+	// If the parts of the jobs would exceed the limit, fewer parts are
+	// uploaded at once, and a file larger than the limit is uploaded in
+	// parts. A mapped file is not buffered. If the value is zero then the
+	// memory is not limited.
+	MaxMemory int64
+
+	// DropCache advises the kernel to drop the cached pages of the file once
+	// they are transferred, so that a large transfer doesn't evict the page
+	// cache of other processes. It only has effect on Linux.
+	DropCache bool
+
+	// OnFailure is what the upload does with a part that fails once its
+	// requests are not retried anymore. By default the part is uploaded once
+	// more, then skipped, so that only the failed parts are left to upload
+	// when the upload is resumed.
+	OnFailure utils.FailurePolicy
+
+	// SlowPartFactor makes the upload warn about a part that takes more than
+	// the factor times the rolling median of the recent parts, which tells a
+	// degrading connection early. If the value is zero then no part is reported.
+	SlowPartFactor float64
+
+	// Context cancels every request of the upload, including listing the
+	// uploaded parts, and hashing the parts and the whole archive once it is
+	// done. If the value is nil then the upload is not canceled.
+	Context context.Context
+
+	// Logger receives the upload events.
+	// If the value is nil then the events are printed using the standard logger.
+	Logger events.Logger
+}
+
+// Result describes a completed upload.
+type Result struct {
+	// The ID of the multipart upload, empty for an archive uploaded in a
+	// single request.
+	UploadId string
+
+	// The ID of the archive, its location and its hex encoded tree hash.
+	ArchiveId string
+	Location  string
+	Checksum  string
+
+	// The parts uploaded, and the parts found already uploaded when the
+	// upload was resumed, which were not uploaded again.
+	PartsUploaded int64
+	PartsSkipped  int64
+
+	// The bytes uploaded, excluding the parts skipped.
+	BytesTransferred int64
+
+	Elapsed time.Duration
+}
+
+// UploadedPart is a part of a multipart upload already uploaded.
+type UploadedPart struct {
+	Range utils.Range
+
+	// The hex encoded tree hash of the part.
+	TreeHash string
+}
+
+// Source is the content of an archive read at any offset.
+type Source interface {
+	io.ReaderAt
+
+	// Size returns the size of the content in bytes.
+	Size() int64
+}
+
+// Client uploads archives to Amazon Glacier. It keeps no state of an upload,
+// so it runs any number of uploads, one after another or concurrently, each
+// with its own input.
+type Client struct {
+	service glacieriface.GlacierAPI
+
+	// Limiter limits the transfer rate of all the uploads of the client
+	// together if it is set. Otherwise the rate of every upload is limited
+	// by the MaxRate of its input.
+	Limiter *utils.Limiter
+}
+
+// NewClient creates a new client uploading to the service.
+func NewClient(service glacieriface.GlacierAPI) *Client {
+	return &Client{service: service}
+}
+
+// Upload uploads the archive of the input and returns the result of the
+// completed upload. The maximum number of the parallel uploads of its parts
+// is limited by the jobs parameter. The input is not modified, the upload
+// keeps its state apart, so the input may be reused for another upload.
+// The logger of the input must be safe for concurrent use if the client
+// runs several uploads at once.
+func (c *Client) Upload(input *Input, jobs int) (*Result, error) {
+	limiter := c.Limiter
+	if limiter == nil {
+		limiter = utils.NewLimiter(input.MaxRate)
+	}
+
+	copied := *input
+	s := &Uploader{
+		service:  c.service,
+		input:    &copied,
+		uploaded: make(map[int64]struct{}),
+		limiter:  limiter,
+	}
+	return s.upload(jobs)
+}
+
+// Verification is the outcome of the comparison of the parts of a multipart
+// upload against the file.
+type Verification struct {
+	UploadId string
+
+	// The part size of the upload.
+	PartSize int64
+
+	// The ranges of the parts uploaded with the content of the file, the
+	// ones uploaded with another content, and the ones not uploaded yet,
+	// every one in the order of the offsets.
+	Matched    []utils.Range
+	Mismatched []utils.Range
+	Missing    []utils.Range
+}
+
+// Verify compares the parts of the multipart upload of the input against the
+// file without uploading anything, so that a stalled upload can be audited
+// before it is resumed. The upload ID must be set. The parts are listed, or
+// taken from the uploaded parts of the input, and the part size of the upload
+// is used whatever the part size of the input.
+func (c *Client) Verify(input *Input) (*Verification, error) {
+	if input.UploadId == "" {
+		return nil, errors.New("the upload ID of the verified upload is not set")
+	}
+	if input.Stream != nil {
+		return nil, errors.New("an upload of a stream cannot be verified")
+	}
+
+	accountId, err := utils.NormalizeAccountId(input.AccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *input
+	copied.AccountId = accountId
+	s := &Uploader{
+		service:   c.service,
+		input:     &copied,
+		uploaded:  make(map[int64]struct{}),
+		verifying: input.UploadedParts == nil,
+	}
+
+	if err := s.openFile(); err != nil {
+		return nil, err
+	}
+	defer s.closeFile()
+
+	if err := s.checkUploadedParts(); err != nil {
+		return nil, err
+	}
+
+	v := &Verification{
+		UploadId:   s.input.UploadId,
+		PartSize:   s.input.PartSize,
+		Mismatched: s.mismatched,
+	}
+	sort.Slice(v.Mismatched, func(i, j int) bool {
+		return v.Mismatched[i].Offset < v.Mismatched[j].Offset
+	})
+
+	mismatched := make(map[int64]struct{}, len(s.mismatched))
+	for _, r := range s.mismatched {
+		mismatched[r.Offset] = struct{}{}
+	}
+	for r := s.getNextRange(); r != nil; r = s.getNextRange() {
+		if _, ok := mismatched[r.Offset]; !ok {
+			v.Missing = append(v.Missing, *r)
+		}
+	}
+	for offset := int64(0); offset < s.size; offset += s.input.PartSize {
+		if _, ok := s.uploaded[offset]; ok {
+			limit := s.input.PartSize
+			if offset+limit > s.size {
+				limit = s.size - offset
+			}
+			v.Matched = append(v.Matched, utils.Range{Offset: offset, Limit: limit})
+		}
+	}
+
+	return v, nil
 }
 
 // Uploader holds internal uploader state.
@@ -49,8 +297,41 @@ type Uploader struct {
 	uploaded map[int64]struct{}
 
 	file   *os.File
+	source Source
 	size   int64
 	offset int64
+
+	// The stream read ahead to tell an empty one before the upload is initiated.
+	stream io.Reader
+
+	// The content of the file if it is mapped into memory.
+	mapping []byte
+
+	// The tree hash of the stream, computed as it is read.
+	treeHash *string
+
+	// Whether the content is uploaded in a single request instead of a
+	// multipart upload, and the output of the request.
+	single  bool
+	archive *glacier.UploadArchiveOutput
+
+	limiter *utils.Limiter
+
+	// The context of the parts, canceled by the first failure if the policy
+	// stops the upload, and the failed parts.
+	context  context.Context
+	failures *utils.PartFailures
+
+	partsUploaded int64
+	bytesUploaded int64
+
+	// The latencies of the uploaded parts.
+	latencies *utils.PartLatencies
+
+	// Whether the uploaded parts are only verified, which takes the part
+	// size of the upload, and the ranges of the parts that do not match.
+	verifying  bool
+	mismatched []utils.Range
 }
 
 // New creates a new instance of the uploader with a service and input.
@@ -59,7 +340,54 @@ func New(service glacieriface.GlacierAPI, input *Input) *Uploader {
 		service:  service,
 		input:    input,
 		uploaded: make(map[int64]struct{}),
+		limiter:  utils.NewLimiter(input.MaxRate),
+	}
+}
+
+// NewReaderAt creates a new instance of the uploader uploading the size bytes
+// read from r instead of the file, for example a block device, data in memory
+// or a file of a virtual filesystem. The file name then only names the archive.
+// The input is copied, so it is left unchanged.
+func NewReaderAt(service glacieriface.GlacierAPI, input *Input, r io.ReaderAt, size int64) *Uploader {
+	in := *input
+	in.Source = io.NewSectionReader(r, 0, size)
+	return New(service, &in)
+}
+
+// ctx returns the context of the upload, the context of the input or the
+// background context.
+func (s *Uploader) ctx() context.Context {
+	if s.context != nil {
+		return s.context
+	}
+	if s.input.Context != nil {
+		return s.input.Context
+	}
+	return context.Background()
+}
+
+// canceled returns the error of the failed parts, or the error of the
+// context once it is done.
+func (s *Uploader) canceled() error {
+	if s.failures != nil {
+		if err := s.failures.Err(); err != nil {
+			return err
+		}
 	}
+	return s.ctx().Err()
+}
+
+func (s *Uploader) log(e *events.Event) {
+	e.Operation = "upload"
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	logger := s.input.Logger
+	if logger == nil {
+		logger = events.TextLogger{}
+	}
+	logger.Log(e)
 }
 
 func (s *Uploader) initiateUpload() error {
@@ -73,8 +401,12 @@ func (s *Uploader) initiateUpload() error {
 		PartSize:  &partSize,
 		VaultName: &s.input.VaultName,
 	}
+	if s.input.Description != "" {
+		input.ArchiveDescription = &s.input.Description
+	}
 
 	request := s.service.InitiateMultipartUploadRequest(input)
+	request.SetContext(s.ctx())
 	result, err := request.Send()
 	if err != nil {
 		return err
@@ -112,6 +444,12 @@ func (s *Uploader) getNextRange() *utils.Range {
 }
 
 func (s *Uploader) openFile() error {
+	if s.input.Source != nil {
+		s.source = s.input.Source
+		s.size = s.source.Size()
+		return nil
+	}
+
 	file, err := os.Open(s.input.FileName)
 	if err != nil {
 		return err
@@ -131,37 +469,176 @@ func (s *Uploader) openFile() error {
 	s.file = file
 	s.size = info.Size()
 
+	if s.input.MemoryMap && s.size > 0 {
+		mapping, err := mmap(file, s.size)
+		if err != nil {
+			file.Close()
+			return errors.Wrap(err, "could not map the file into memory")
+		}
+		s.mapping = mapping
+	}
+
 	return nil
 }
 
-func (s *Uploader) uploadPart(r *utils.Range) error {
-	body := io.NewSectionReader(s.file, r.Offset, r.Limit)
-	treeHash := utils.ComputeTreeHash(body)
+func (s *Uploader) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+
+	if s.input.DropCache {
+		utils.DropCache(s.file, 0, s.size)
+	}
+	if s.mapping != nil {
+		munmap(s.mapping)
+		s.mapping = nil
+	}
+	return s.file.Close()
+}
+
+// content returns the reader of the uploaded content, either the source or the file.
+func (s *Uploader) content() io.ReaderAt {
+	if s.source != nil {
+		return s.source
+	}
+	return s.file
+}
+
+// section returns a reader of the file range, backed by the memory mapping if there is one.
+func (s *Uploader) section(offset, limit int64) io.ReadSeeker {
+	if s.mapping != nil {
+		return bytes.NewReader(s.mapping[offset : offset+limit])
+	}
+	return io.NewSectionReader(s.content(), offset, limit)
+}
+
+// readPart returns the content of the part. A mapped part is not copied.
+func (s *Uploader) readPart(r *utils.Range) ([]byte, error) {
+	if s.mapping != nil {
+		return s.mapping[r.Offset : r.Offset+r.Limit], nil
+	}
+
+	data := make([]byte, r.Limit)
+	if _, err := io.ReadFull(io.NewSectionReader(s.content(), r.Offset, r.Limit), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// uploadPart uploads the part. The report receives the checksum of the part,
+// the ID of its request and the number of the attempts.
+func (s *Uploader) uploadPart(r *utils.Range, report *events.Event) error {
+	// The part is read from the file once and both hashed and sent from memory.
+	data, err := s.readPart(r)
+	if err != nil {
+		return err
+	}
+	return s.sendPart(r, data, report)
+}
+
+// sendPart uploads the content of the part, reporting like uploadPart.
+func (s *Uploader) sendPart(r *utils.Range, data []byte, report *events.Event) error {
+	treeHash := utils.TreeHashBytes(data)
 	if treeHash == nil {
 		return errors.New("could not compute hashes")
 	}
+	report.Checksum = *treeHash
+
+	request, send := s.partRequest(r, data, treeHash)
+	request.SetContext(s.ctx())
+	maxAttempts := events.MaxAttempts(request)
+	events.OnRetry(request, func(attempt int, err error) {
+		part, cause := utils.PartNumber(r, s.input.PartSize), events.ErrorCause(err)
+		s.log(&events.Event{
+			Type: events.Retry,
+			Message: fmt.Sprintf("retry uploading %s, %s (%s): %v",
+				events.PartName(part, r), events.AttemptName(attempt, maxAttempts), cause, err),
+			Range:       r,
+			Part:        part,
+			Attempt:     attempt,
+			MaxAttempts: maxAttempts,
+			Cause:       cause,
+			Error:       err.Error(),
+		})
+	})
+
+	s.limiter.Wait(r.Limit)
+
+	err := send()
+	report.RequestId = request.RequestID
+	report.Attempt = request.RetryCount + 1
+	if err != nil {
+		return err
+	}
+
+	if s.input.DropCache && s.file != nil {
+		utils.DropCache(s.file, r.Offset, r.Limit)
+	}
+
+	atomic.AddInt64(&s.partsUploaded, 1)
+	atomic.AddInt64(&s.bytesUploaded, r.Limit)
+
+	return nil
+}
+
+// partRequest returns the request uploading the content of the part and the
+// function sending it. The content uploaded in a single request is sent as
+// a whole archive, whose output is kept.
+func (s *Uploader) partRequest(r *utils.Range, data []byte, treeHash *string) (*aws.Request, func() error) {
+	if s.single {
+		input := &glacier.UploadArchiveInput{
+			AccountId: &s.input.AccountId,
+			VaultName: &s.input.VaultName,
+			Body:      bytes.NewReader(data),
+			Checksum:  treeHash,
+		}
+		if s.input.Description != "" {
+			input.ArchiveDescription = &s.input.Description
+		}
+
+		request := s.service.UploadArchiveRequest(input)
+		return request.Request, func() error {
+			output, err := request.Send()
+			s.archive = output
+			return err
+		}
+	}
 
 	rangeString := fmt.Sprint("bytes ", r, "/*")
 	input := &glacier.UploadMultipartPartInput{
 		AccountId: &s.input.AccountId,
 		UploadId:  &s.input.UploadId,
 		VaultName: &s.input.VaultName,
-		Body:      body,
+		Body:      bytes.NewReader(data),
 		Checksum:  treeHash,
 		Range:     &rangeString,
 	}
 
 	request := s.service.UploadMultipartPartRequest(input)
-	if _, err := request.Send(); err != nil {
+	return request.Request, func() error {
+		_, err := request.Send()
 		return err
 	}
+}
 
-	return nil
+// part is a part to upload. Its content is read when it is uploaded,
+// unless it is already read from a stream.
+type part struct {
+	r    *utils.Range
+	data []byte
 }
 
-func (s *Uploader) multipartUpload(jobs int) {
-	parts := make(chan *utils.Range)
+// transferPart uploads the part, either read from the stream or from the file.
+func (s *Uploader) transferPart(p *part, report *events.Event) error {
+	if p.data != nil {
+		return s.sendPart(p.r, p.data, report)
+	}
+	return s.uploadPart(p.r, report)
+}
 
+// startWorkers starts the jobs uploading the parts received from the channel
+// until it is closed. The returned wait group is done once they all return.
+func (s *Uploader) startWorkers(jobs int, parts <-chan *part) *sync.WaitGroup {
 	var wg sync.WaitGroup
 	wg.Add(jobs)
 
@@ -170,26 +647,148 @@ func (s *Uploader) multipartUpload(jobs int) {
 			defer wg.Done()
 
 			for p := range parts {
-				log.Printf("start uploading part (%v)", p)
-				if err := s.uploadPart(p); err != nil {
-					log.Printf("error uploading part (%v): %v", p, err)
+				if s.ctx().Err() != nil {
+					continue
+				}
+
+				s.log(&events.Event{
+					Type:    events.PartStarted,
+					Message: fmt.Sprintf("start uploading part (%v)", p.r),
+					Range:   p.r,
+					Part:    utils.PartNumber(p.r, s.input.PartSize),
+				})
+
+				report := &events.Event{
+					UploadId: s.input.UploadId,
+					Range:    p.r,
+				}
+				start := time.Now()
+
+				err := s.transferPart(p, report)
+				for retry := 0; err != nil && retry < s.input.OnFailure.Retries() && s.ctx().Err() == nil; retry++ {
+					part, cause := utils.PartNumber(p.r, s.input.PartSize), events.ErrorCause(err)
+					s.log(&events.Event{
+						Type:    events.Retry,
+						Message: fmt.Sprintf("upload %s once more after it failed (%s): %v", events.PartName(part, p.r), cause, err),
+						Range:   p.r,
+						Part:    part,
+						Cause:   cause,
+						Error:   err.Error(),
+					})
+					err = s.transferPart(p, report)
+				}
+				report.Elapsed = time.Since(start).Seconds()
+
+				if err != nil {
+					report.Type = events.PartFailed
+					report.Message = fmt.Sprintf("error uploading part (%v): %v", p.r, err)
+					report.Error = err.Error()
+					if s.failures != nil {
+						s.failures.Record(utils.PartError{Range: p.r, Err: err})
+					}
 				} else {
-					log.Printf("finish uploading part (%v)", p)
+					report.Type = events.PartFinished
+					report.Message = fmt.Sprintf("finish uploading part (%v)", p.r)
+				}
+				s.log(report)
+
+				if err == nil {
+					s.checkSlowPart(report)
+					s.latencies.Record(p.r, report.Elapsed)
 				}
 			}
 		}()
 	}
 
-	for {
-		if p := s.getNextRange(); p != nil {
-			parts <- p
-		} else {
+	return &wg
+}
+
+// multipartUpload uploads the parts left to upload with the jobs. It returns
+// the failed parts, listing their ranges, so that the upload is not completed
+// without them.
+func (s *Uploader) multipartUpload(jobs int) error {
+	var ranges []*utils.Range
+	for r := s.getNextRange(); r != nil; r = s.getNextRange() {
+		ranges = append(ranges, r)
+	}
+	s.input.Order.Schedule(ranges)
+
+	return s.uploadRanges(jobs, ranges)
+}
+
+// uploadRanges uploads the parts of the ranges in their order with the jobs,
+// and returns the failed parts or the error of the canceled upload.
+func (s *Uploader) uploadRanges(jobs int, ranges []*utils.Range) error {
+	// The failures are collected even if the upload was not initiated by
+	// Upload, so that a failed part is never only logged.
+	if s.failures == nil {
+		s.context, s.failures = utils.WithPartFailures(s.ctx(), s.input.OnFailure)
+	}
+
+	parts := make(chan *part)
+	wg := s.startWorkers(jobs, parts)
+
+	done := s.ctx().Done()
+feed:
+	for _, r := range ranges {
+		select {
+		case parts <- &part{r: r}:
+		case <-done:
+			break feed
+		}
+	}
+
+	close(parts)
+	wg.Wait()
+
+	return s.canceled()
+}
+
+// streamUpload reads the stream part by part and uploads the parts in
+// parallel, computing the tree hash of the stream on the way. A part read
+// short is the last one, since only the last part may be smaller than the
+// part size, so the size of the stream needs not be known in advance.
+func (s *Uploader) streamUpload(jobs int) error {
+	parts := make(chan *part)
+	wg := s.startWorkers(jobs, parts)
+
+	hasher := utils.NewTreeHasher()
+
+	var err error
+	for s.ctx().Err() == nil {
+		data := make([]byte, s.input.PartSize)
+		n, readErr := io.ReadFull(s.stream, data)
+		if n > 0 {
+			hasher.Write(data[:n])
+			parts <- &part{
+				r:    &utils.Range{Offset: s.size, Limit: int64(n)},
+				data: data[:n],
+			}
+			s.size += int64(n)
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			err = errors.Wrap(readErr, "could not read the stream")
 			break
 		}
 	}
 
 	close(parts)
 	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+	if err := s.canceled(); err != nil {
+		return err
+	}
+	treeHash := hex.EncodeToString(hasher.Sum(nil))
+	s.treeHash = &treeHash
+
+	return nil
 }
 
 func (s *Uploader) checkPart(part *glacier.PartListElement) (bool, error) {
@@ -198,12 +797,20 @@ func (s *Uploader) checkPart(part *glacier.PartListElement) (bool, error) {
 		return false, fmt.Errorf("part (%v) range is invalid", *part.RangeInBytes)
 	}
 
-	if partRange.Offset >= s.size {
+	// A part past the end of a truncated or changed file doesn't match it,
+	// which a verification reports rather than giving up.
+	if partRange.Offset+partRange.Limit > s.size {
+		if s.verifying {
+			return false, nil
+		}
 		return false, errors.New("file size mismatch")
 	}
 
-	body := io.NewSectionReader(s.file, partRange.Offset, partRange.Limit)
-	treeHash := utils.ComputeTreeHash(body)
+	body := s.section(partRange.Offset, partRange.Limit)
+	treeHash, err := utils.ComputeTreeHashContext(s.ctx(), body, nil)
+	if err != nil && s.ctx().Err() != nil {
+		return false, err
+	}
 	if treeHash == nil {
 		return false, fmt.Errorf("could not compute hashes of part (%v)", *part.RangeInBytes)
 	}
@@ -215,46 +822,177 @@ func (s *Uploader) checkPart(part *glacier.PartListElement) (bool, error) {
 	return false, nil
 }
 
+// partsPage is a page of the uploaded parts or the error listing them.
+type partsPage struct {
+	output *glacier.ListPartsOutput
+	err    error
+}
+
+// listParts lists the uploaded parts in the background, requesting the next
+// page while the current one is being checked, so that the pagination latency
+// overlaps with the hashing. The listing stops once done is closed.
+func (s *Uploader) listParts(done <-chan struct{}) <-chan partsPage {
+	pages := make(chan partsPage, 1)
+
+	ctx := s.ctx()
+
+	go func() {
+		defer close(pages)
+
+		input := &glacier.ListPartsInput{
+			AccountId: &s.input.AccountId,
+			UploadId:  &s.input.UploadId,
+			VaultName: &s.input.VaultName,
+		}
+
+		// Every page is requested with a copy of the request, so the context
+		// is set by an option applied to each of them.
+		request := s.service.ListPartsRequest(input)
+		pager := request.Paginate(func(r *aws.Request) {
+			r.SetContext(ctx)
+		})
+
+		for pager.Next() {
+			// Drop the page fetched after the checking has finished.
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			select {
+			case pages <- partsPage{output: pager.CurrentPage()}:
+			case <-done:
+				return
+			}
+		}
+
+		if err := pager.Err(); err != nil {
+			select {
+			case pages <- partsPage{err: err}:
+			case <-done:
+			}
+		}
+	}()
+
+	return pages
+}
+
+// verifyPart checks the uploaded part and logs whether it is ok.
+func (s *Uploader) verifyPart(part *glacier.PartListElement) error {
+	ok, err := s.checkPart(part)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		s.log(&events.Event{
+			Type:     events.PartVerified,
+			Message:  fmt.Sprintf("part (%v) is ok", *part.RangeInBytes),
+			Range:    utils.RangeFromString(part.RangeInBytes),
+			Verified: aws.Bool(true),
+		})
+	} else {
+		s.mismatched = append(s.mismatched, *utils.RangeFromString(part.RangeInBytes))
+		s.log(&events.Event{
+			Type:     events.PartMismatch,
+			Message:  fmt.Sprintf("part (%v) hash mismatch", *part.RangeInBytes),
+			Range:    utils.RangeFromString(part.RangeInBytes),
+			Verified: aws.Bool(false),
+		})
+	}
+
+	return nil
+}
+
 func (s *Uploader) checkUploadedParts() error {
-	log.Println("start checking uploaded parts")
+	s.log(&events.Event{
+		Type:     events.CheckStarted,
+		Message:  "start checking uploaded parts",
+		UploadId: s.input.UploadId,
+	})
 
-	input := &glacier.ListPartsInput{
-		AccountId: &s.input.AccountId,
-		UploadId:  &s.input.UploadId,
-		VaultName: &s.input.VaultName,
+	var err error
+	if s.input.UploadedParts != nil {
+		err = s.checkGivenParts()
+	} else {
+		err = s.checkListedParts()
+	}
+	if err != nil {
+		return err
+	}
+
+	s.log(&events.Event{
+		Type:     events.CheckFinished,
+		Message:  "finish checking uploaded parts",
+		UploadId: s.input.UploadId,
+	})
+
+	return nil
+}
+
+// checkGivenParts checks the uploaded parts given in the input. Unlike the
+// listed parts, they are not known to match the part size of the upload.
+func (s *Uploader) checkGivenParts() error {
+	for _, p := range s.input.UploadedParts {
+		if p.Range.Offset%s.input.PartSize != 0 {
+			return errors.New("part size mismatch")
+		}
+		if p.Range.Limit != s.input.PartSize && p.Range.Offset+p.Range.Limit != s.size {
+			return errors.New("part size mismatch")
+		}
+
+		part := &glacier.PartListElement{
+			RangeInBytes:   aws.String(p.Range.String()),
+			SHA256TreeHash: aws.String(p.TreeHash),
+		}
+		if err := s.verifyPart(part); err != nil {
+			return err
+		}
 	}
 
-	request := s.service.ListPartsRequest(input)
-	pager := request.Paginate()
+	return nil
+}
+
+// checkListedParts checks the uploaded parts listed by Glacier.
+func (s *Uploader) checkListedParts() error {
+	done := make(chan struct{})
+	defer close(done)
+
+	for page := range s.listParts(done) {
+		if page.err != nil {
+			return page.err
+		}
 
-	for pager.Next() {
-		result := pager.CurrentPage()
+		result := page.output
+		if s.verifying {
+			s.input.PartSize = *result.PartSizeInBytes
+		}
 		if *result.PartSizeInBytes != s.input.PartSize {
 			return errors.New("part size mismatch")
 		}
 
 		for _, part := range result.Parts {
-			if ok, err := s.checkPart(&part); err != nil {
+			if err := s.verifyPart(&part); err != nil {
 				return err
-			} else if ok {
-				log.Printf("part (%v) is ok", *part.RangeInBytes)
-			} else {
-				log.Printf("part (%v) hash mismatch", *part.RangeInBytes)
 			}
 		}
 	}
 
-	if err := pager.Err(); err != nil {
-		return err
-	}
-
-	log.Println("finish checking uploaded parts")
-
 	return nil
 }
 
-func (s *Uploader) completeUpload() (*string, error) {
-	treeHash := utils.ComputeTreeHash(s.file)
+func (s *Uploader) completeUpload() (*glacier.UploadArchiveOutput, error) {
+	treeHash := s.treeHash
+	if treeHash == nil && s.size > 0 {
+		progress := events.OnHashed(s.log, s.input.FileName, s.size)
+
+		var err error
+		treeHash, err = utils.ComputeTreeHashContext(s.ctx(), s.section(0, s.size), progress)
+		if err != nil && s.ctx().Err() != nil {
+			return nil, err
+		}
+	}
 	if treeHash == nil {
 		return nil, errors.New("could not compute hashes")
 	}
@@ -269,40 +1007,212 @@ func (s *Uploader) completeUpload() (*string, error) {
 	}
 
 	request := s.service.CompleteMultipartUploadRequest(input)
+	request.SetContext(s.ctx())
 	result, err := request.Send()
 	if err != nil {
 		return nil, err
 	}
 
-	return result.Location, nil
+	return result, nil
 }
 
-// Upload performs parallel multipart upload.
+// Upload performs parallel multipart upload, or uploads the content below the
+// multipart threshold in a single request, and returns the result of the
+// completed upload.
 // The maximum number of the parallel uploads is limited by the jobs parameter.
-func (s Uploader) Upload(jobs int) error {
-	if err := s.openFile(); err != nil {
-		return err
+// Every call is a new upload of the input, like Client.Upload, so the upload
+// ID of the result is to be set in the input to resume it.
+func (s Uploader) Upload(jobs int) (*Result, error) {
+	client := &Client{service: s.service, Limiter: s.limiter}
+	return client.Upload(s.input, jobs)
+}
+
+// upload runs the upload of the input.
+func (s *Uploader) upload(jobs int) (*Result, error) {
+	start := time.Now()
+
+	accountId, err := utils.NormalizeAccountId(s.input.AccountId)
+	if err != nil {
+		return nil, err
 	}
-	defer s.file.Close()
+	s.input.AccountId = accountId
 
-	if err := s.initiateUpload(); err != nil {
-		return err
+	if s.input.UploadedParts != nil && s.input.UploadId == "" {
+		return nil, errors.New("the uploaded parts are given without the upload ID")
 	}
 
-	log.Println("upload", s.input.UploadId, "initiated")
+	if s.input.Stream != nil {
+		if s.input.UploadId != "" {
+			return nil, errors.New("an upload of a stream cannot be resumed")
+		}
 
-	if err := s.checkUploadedParts(); err != nil {
-		return err
+		stream := bufio.NewReader(s.input.Stream)
+		if _, err := stream.Peek(1); err == io.EOF {
+			return nil, ErrEmpty
+		} else if err != nil {
+			return nil, errors.Wrap(err, "could not read the stream")
+		}
+		s.stream = stream
+	} else {
+		if err := s.openFile(); err != nil {
+			return nil, err
+		}
+		defer s.closeFile()
+
+		if s.size == 0 {
+			return nil, ErrEmpty
+		}
 	}
 
-	s.multipartUpload(jobs)
+	// A resumed upload is multipart, whatever the size of the file.
+	s.single = s.input.Stream == nil && s.input.UploadId == "" &&
+		s.size < s.input.MultipartThreshold && s.size <= MaxSingleSize &&
+		(s.input.MaxMemory == 0 || s.mapping != nil || s.size <= s.input.MaxMemory)
 
-	location, err := s.completeUpload()
+	if !s.single && s.mapping == nil {
+		if jobs, err = s.limitJobs(jobs); err != nil {
+			return nil, err
+		}
+	}
+
+	if !s.single {
+		if err := s.initiateUpload(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.context, s.failures = utils.WithPartFailures(s.ctx(), s.input.OnFailure)
+	s.latencies = &utils.PartLatencies{}
+
+	initiated := &events.Event{
+		Type:     events.Initiated,
+		Message:  fmt.Sprint("upload ", s.input.UploadId, " initiated"),
+		UploadId: s.input.UploadId,
+	}
+	if s.single {
+		initiated.Message = fmt.Sprintf("upload of %s in a single request started", utils.FormatSize(s.size))
+		initiated.Parts = 1
+		initiated.Bytes = s.size
+	} else if s.input.Stream == nil {
+		initiated.Parts = (s.size + s.input.PartSize - 1) / s.input.PartSize
+		initiated.Bytes = s.size
+	}
+	s.log(initiated)
+
+	if s.input.Stream != nil {
+		err = s.streamUpload(jobs)
+	} else if s.single {
+		err = s.uploadRanges(1, []*utils.Range{{Offset: 0, Limit: s.size}})
+	} else {
+		if err := s.checkUploadedParts(); err != nil {
+			return nil, err
+		}
+
+		err = s.multipartUpload(jobs)
+	}
 	if err != nil {
-		return err
+		if s.failures.Len() > 0 {
+			s.summarize(time.Since(start), "")
+		}
+		return nil, err
 	}
 
-	log.Println("upload location is", *location)
+	output := s.archive
+	if !s.single {
+		output, err = s.completeUpload()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return nil
+	result := &Result{
+		UploadId:         s.input.UploadId,
+		ArchiveId:        aws.StringValue(output.ArchiveId),
+		Location:         aws.StringValue(output.Location),
+		Checksum:         aws.StringValue(output.Checksum),
+		PartsUploaded:    s.partsUploaded,
+		PartsSkipped:     int64(len(s.uploaded)),
+		BytesTransferred: s.bytesUploaded,
+		Elapsed:          time.Since(start),
+	}
+
+	s.log(&events.Event{
+		Type:      events.Completed,
+		Message:   fmt.Sprint("upload location is ", result.Location),
+		UploadId:  s.input.UploadId,
+		ArchiveId: result.ArchiveId,
+		Checksum:  result.Checksum,
+		Location:  result.Location,
+	})
+
+	s.summarize(result.Elapsed, result.Location)
+
+	return result, nil
+}
+
+// limitJobs reduces the jobs so that the parts they buffer fit in the memory
+// limit, along with the part of the stream read ahead.
+func (s *Uploader) limitJobs(jobs int) (int, error) {
+	var readAhead int64
+	if s.input.Stream != nil {
+		readAhead = s.input.PartSize
+	}
+
+	limited, err := utils.LimitJobs(jobs, s.input.PartSize, readAhead, s.input.MaxMemory)
+	if err != nil {
+		return 0, err
+	}
+	if limited < jobs {
+		s.log(&events.Event{
+			Type: events.Warning,
+			Message: fmt.Sprintf("uploading %d part(s) at once instead of %d to buffer at most %s",
+				limited, jobs, utils.FormatSize(s.input.MaxMemory)),
+			UploadId: s.input.UploadId,
+		})
+	}
+	return limited, nil
+}
+
+// checkSlowPart warns if the finished part took much longer than the recent parts.
+func (s *Uploader) checkSlowPart(report *events.Event) {
+	median, slow := s.latencies.Slow(report.Elapsed, s.input.SlowPartFactor)
+	if !slow {
+		return
+	}
+
+	s.log(&events.Event{
+		Type: events.Warning,
+		Message: fmt.Sprintf("part (%v) took %v on attempt %d, %.1f times the median of the recent parts",
+			report.Range, time.Duration(report.Elapsed*float64(time.Second)).Round(time.Millisecond), report.Attempt, report.Elapsed/median),
+		UploadId: s.input.UploadId,
+		Range:    report.Range,
+		Attempt:  report.Attempt,
+		Elapsed:  report.Elapsed,
+	})
+}
+
+// summarize logs the summary of the upload which took the elapsed time. The
+// failed parts are described by the failure policy.
+func (s *Uploader) summarize(elapsed time.Duration, location string) {
+	message := fmt.Sprintf("uploaded %d part(s), %s in %v (%s)",
+		s.partsUploaded, utils.FormatSize(s.bytesUploaded), elapsed.Round(time.Millisecond),
+		utils.FormatRate(s.bytesUploaded, elapsed.Seconds()))
+	if failed := s.failures.Len(); failed > 0 {
+		message += ", " + s.input.OnFailure.Summary(failed)
+	}
+	latency := s.latencies.Summary()
+	if latency != nil {
+		message += ", " + latency.String()
+	}
+
+	s.log(&events.Event{
+		Type:     events.Summary,
+		Message:  message,
+		UploadId: s.input.UploadId,
+		Location: location,
+		Parts:    s.partsUploaded,
+		Bytes:    s.bytesUploaded,
+		Elapsed:  elapsed.Seconds(),
+		Latency:  latency,
+	})
 }
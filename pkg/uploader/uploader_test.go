@@ -1,11 +1,25 @@
 package uploader
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"testing/quick"
+	"testing/synctest"
+	"time"
 
 	"github.com/31z4/surge/internal/mocks"
+	"github.com/31z4/surge/pkg/events"
 	"github.com/31z4/surge/pkg/utils"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -86,6 +100,47 @@ func TestOpenFile(t *testing.T) {
 			t.Fatalf("unexpected size: %#v", uploader.size)
 		}
 	})
+
+	t.Run("memory map", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		if _, err := file.WriteString("test"); err != nil {
+			t.Fatal(err)
+		}
+
+		input := newTestInput()
+		input.FileName = file.Name()
+		input.MemoryMap = true
+
+		uploader := Uploader{
+			input: input,
+		}
+
+		if err := uploader.openFile(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		data, err := uploader.readPart(&utils.Range{Offset: 1, Limit: 2})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if string(data) != "es" {
+			t.Fatalf("got %q, want %q", data, "es")
+		}
+
+		if err := uploader.closeFile(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if uploader.mapping != nil {
+			t.Fatal("the mapping must be released")
+		}
+	})
 }
 
 func TestInitiateUpload(t *testing.T) {
@@ -146,6 +201,40 @@ func TestInitiateUpload(t *testing.T) {
 		if uploader.input.UploadId != uploadId {
 			t.Fatalf("got %#v, want %#v", uploader.input.UploadId, uploadId)
 		}
+
+		inputs := mock.InitiateMultipartUploadInputs()
+		if len(inputs) != 1 || inputs[0].ArchiveDescription != nil {
+			t.Fatalf("got %#v, want an input without description", inputs)
+		}
+	})
+
+	t.Run("sends description", func(t *testing.T) {
+		requestMock := func() glacier.InitiateMultipartUploadRequest {
+			return glacier.InitiateMultipartUploadRequest{
+				Request: &aws.Request{
+					Data: &glacier.InitiateMultipartUploadOutput{
+						UploadId: aws.String("test_id"),
+					},
+				},
+			}
+		}
+		mock := &mocks.Glacier{
+			InitiateMultipartUploadRequestMock: requestMock,
+		}
+
+		input := newTestInput()
+		input.UploadId = ""
+		input.Description = "test description"
+		uploader := New(mock, input)
+
+		if err := uploader.initiateUpload(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		inputs := mock.InitiateMultipartUploadInputs()
+		if len(inputs) != 1 || aws.StringValue(inputs[0].ArchiveDescription) != input.Description {
+			t.Fatalf("got %#v, want %#v", inputs, input.Description)
+		}
 	})
 }
 
@@ -182,7 +271,8 @@ func TestCheckPart(t *testing.T) {
 
 	t.Run("hashing error", func(t *testing.T) {
 		uploader := Uploader{
-			size: 1,
+			input: &Input{},
+			size:  1,
 		}
 		errString := "could not compute hashes of part (0-0)"
 		part := &glacier.PartListElement{
@@ -210,6 +300,7 @@ func TestCheckPart(t *testing.T) {
 		}
 
 		uploader := Uploader{
+			input:    &Input{},
 			file:     file,
 			size:     4,
 			uploaded: make(map[int64]struct{}),
@@ -230,6 +321,39 @@ func TestCheckPart(t *testing.T) {
 		}
 	})
 
+	t.Run("canceled", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		if _, err := file.WriteString("test"); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		uploader := Uploader{
+			input: &Input{Context: ctx},
+			file:  file,
+			size:  4,
+		}
+		part := &glacier.PartListElement{
+			RangeInBytes:   aws.String("0-3"),
+			SHA256TreeHash: aws.String("9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"),
+		}
+
+		if ok, got := uploader.checkPart(part); got != context.Canceled {
+			t.Fatalf("got %#v, want %#v", got, context.Canceled)
+		} else if ok {
+			t.Fatalf("unexpected ok: %#v", ok)
+		}
+	})
+
 	t.Run("not ok", func(t *testing.T) {
 		file, err := ioutil.TempFile("", "surge")
 		if err != nil {
@@ -244,6 +368,7 @@ func TestCheckPart(t *testing.T) {
 		}
 
 		uploader := Uploader{
+			input:    &Input{},
 			file:     file,
 			size:     4,
 			uploaded: make(map[int64]struct{}),
@@ -365,20 +490,8 @@ func TestCheckUploadedParts(t *testing.T) {
 			t.Fatalf("got %#v, want %#v", got, errString)
 		}
 	})
-}
-
-func TestUploadPart(t *testing.T) {
-	t.Run("hashing error", func(t *testing.T) {
-		uploader := Uploader{}
-		r := &utils.Range{}
-		errString := "could not compute hashes"
-
-		if got := uploader.uploadPart(r); got.Error() != errString {
-			t.Fatalf("got %#v, want %#v", got, errString)
-		}
-	})
 
-	t.Run("upload error", func(t *testing.T) {
+	t.Run("several pages", func(t *testing.T) {
 		file, err := ioutil.TempFile("", "surge")
 		if err != nil {
 			t.Fatal(err)
@@ -387,173 +500,990 @@ func TestUploadPart(t *testing.T) {
 		defer os.Remove(file.Name())
 		defer file.Close()
 
-		if _, err := file.WriteString("test"); err != nil {
+		if _, err := file.WriteString("testtest"); err != nil {
 			t.Fatal(err)
 		}
 
-		err = errors.New("test")
-		requestMock := func() glacier.UploadMultipartPartRequest {
-			return glacier.UploadMultipartPartRequest{
-				Request: &aws.Request{
-					Error: err,
-				},
-			}
+		var partSize int64 = 4
+		hash := aws.String("9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08")
+		pages := []*glacier.ListPartsOutput{
+			{
+				Marker:          aws.String("4"),
+				PartSizeInBytes: &partSize,
+				Parts:           []glacier.PartListElement{{RangeInBytes: aws.String("0-3"), SHA256TreeHash: hash}},
+			},
+			{
+				PartSizeInBytes: &partSize,
+				Parts:           []glacier.PartListElement{{RangeInBytes: aws.String("4-7"), SHA256TreeHash: hash}},
+			},
 		}
+
+		requested := 0
 		mock := &mocks.Glacier{
-			UploadMultipartPartRequestMock: requestMock,
+			ListPartsRequestMock: func() glacier.ListPartsRequest {
+				return glacier.ListPartsRequest{
+					Copy: func(input *glacier.ListPartsInput) glacier.ListPartsRequest {
+						request := &aws.Request{
+							Data:   pages[requested],
+							Params: input,
+							Operation: &aws.Operation{
+								Paginator: &aws.Paginator{
+									InputTokens:  []string{"Marker"},
+									OutputTokens: []string{"Marker"},
+								},
+							},
+						}
+						requested++
+						return glacier.ListPartsRequest{Request: request}
+					},
+				}
+			},
 		}
 
 		input := newTestInput()
-		input.FileName = file.Name()
+		input.PartSize = partSize
 
-		uploader := &Uploader{
-			service: mock,
-			input:   input,
-			file:    file,
-			size:    4,
-		}
+		uploader := New(mock, input)
+		uploader.file = file
+		uploader.size = 8
+		uploader.uploaded = make(map[int64]struct{})
 
-		r := &utils.Range{
-			Offset: 0,
-			Limit:  uploader.size,
+		if err := uploader.checkUploadedParts(); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
 		}
 
-		if got := uploader.uploadPart(r); got != err {
-			t.Fatalf("got %#v, want %#v", got, err)
+		if requested != len(pages) {
+			t.Errorf("got %#v pages requested, want %#v", requested, len(pages))
+		}
+		for _, offset := range []int64{0, 4} {
+			if _, exists := uploader.uploaded[offset]; !exists {
+				t.Errorf("the part at %d was not added to uploaded", offset)
+			}
 		}
 	})
+}
 
-	t.Run("ok", func(t *testing.T) {
-		file, err := ioutil.TempFile("", "surge")
-		if err != nil {
-			t.Fatal(err)
-		}
+func TestCheckGivenParts(t *testing.T) {
+	file, err := ioutil.TempFile("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		defer os.Remove(file.Name())
-		defer file.Close()
+	defer os.Remove(file.Name())
+	defer file.Close()
 
-		if _, err := file.WriteString("test"); err != nil {
-			t.Fatal(err)
-		}
+	if _, err := file.WriteString("testtest"); err != nil {
+		t.Fatal(err)
+	}
 
-		requestMock := func() glacier.UploadMultipartPartRequest {
-			return glacier.UploadMultipartPartRequest{
-				Request: &aws.Request{
-					Data: &glacier.UploadMultipartPartOutput{},
-				},
-			}
-		}
-		mock := &mocks.Glacier{
-			UploadMultipartPartRequestMock: requestMock,
-		}
+	hash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
 
-		input := newTestInput()
-		input.FileName = file.Name()
+	t.Run("ok", func(t *testing.T) {
+		// The given parts are checked instead of listing the uploaded parts.
+		mock := &mocks.Glacier{}
 
-		uploader := &Uploader{
-			service: mock,
-			input:   input,
-			file:    file,
-			size:    4,
+		input := newTestInput()
+		input.PartSize = 4
+		input.Logger = events.Discard
+		input.UploadedParts = []UploadedPart{
+			{Range: utils.Range{Offset: 0, Limit: 4}, TreeHash: hash},
+			{Range: utils.Range{Offset: 4, Limit: 4}, TreeHash: "test_hash"},
 		}
 
-		r := &utils.Range{
-			Offset: 0,
-			Limit:  uploader.size,
-		}
+		uploader := New(mock, input)
+		uploader.file = file
+		uploader.size = 8
 
-		if err := uploader.uploadPart(r); err != nil {
+		if err := uploader.checkUploadedParts(); err != nil {
 			t.Fatalf("unexpected error: %#v", err)
 		}
+
+		if mock.CallCount != 0 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+		if _, exists := uploader.uploaded[0]; !exists {
+			t.Error("the part at 0 was not added to uploaded")
+		}
+		if _, exists := uploader.uploaded[4]; exists {
+			t.Error("the mismatched part at 4 was added to uploaded")
+		}
 	})
-}
 
-func TestMultipartUpload(t *testing.T) {
-	t.Run("upload error", func(t *testing.T) {
-		file, err := ioutil.TempFile("", "surge")
-		if err != nil {
-			t.Fatal(err)
+	t.Run("part size mismatch", func(t *testing.T) {
+		parts := [][]UploadedPart{
+			{{Range: utils.Range{Offset: 2, Limit: 4}, TreeHash: hash}},
+			{{Range: utils.Range{Offset: 0, Limit: 2}, TreeHash: hash}},
 		}
 
-		defer os.Remove(file.Name())
-		defer file.Close()
+		for _, given := range parts {
+			input := newTestInput()
+			input.PartSize = 4
+			input.Logger = events.Discard
+			input.UploadedParts = given
 
-		if _, err := file.WriteString("test_upload"); err != nil {
-			t.Fatal(err)
-		}
+			uploader := New(&mocks.Glacier{}, input)
+			uploader.file = file
+			uploader.size = 8
 
-		err = errors.New("test")
-		requestMock := func() glacier.UploadMultipartPartRequest {
-			return glacier.UploadMultipartPartRequest{
-				Request: &aws.Request{
-					Error: err,
-				},
+			errString := "part size mismatch"
+			if got := uploader.checkUploadedParts(); got == nil || got.Error() != errString {
+				t.Fatalf("got %#v, want %#v", got, errString)
 			}
 		}
-		mock := &mocks.Glacier{
-			UploadMultipartPartRequestMock: requestMock,
-		}
+	})
+
+	t.Run("no upload ID", func(t *testing.T) {
+		mock := &mocks.Glacier{}
 
 		input := newTestInput()
-		input.FileName = file.Name()
-		input.PartSize = 4
+		input.AccountId = "-"
+		input.UploadId = ""
+		input.UploadedParts = []UploadedPart{}
 
-		uploader := &Uploader{
-			service: mock,
-			input:   input,
-			file:    file,
-			size:    11,
+		errString := "the uploaded parts are given without the upload ID"
+		if _, got := New(mock, input).Upload(1); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
 		}
-
-		uploader.multipartUpload(2)
-
-		if mock.CallCount != 3 {
+		if mock.CallCount != 0 {
 			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
 		}
 	})
+}
+
+func TestVerify(t *testing.T) {
+	hash := aws.String("9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08")
 
 	t.Run("ok", func(t *testing.T) {
-		file, err := ioutil.TempFile("", "surge")
-		if err != nil {
-			t.Fatal(err)
+		// The part size of the upload is used rather than the one of the input.
+		mock := &mocks.Glacier{
+			ListPartsRequestMock: func() glacier.ListPartsRequest {
+				return newListPartsRequestMock(&aws.Request{
+					Data: &glacier.ListPartsOutput{
+						PartSizeInBytes: aws.Int64(4),
+						Parts: []glacier.PartListElement{
+							{RangeInBytes: aws.String("4-7"), SHA256TreeHash: aws.String("test_hash")},
+							{RangeInBytes: aws.String("0-3"), SHA256TreeHash: hash},
+						},
+					},
+					Operation: &aws.Operation{},
+				})
+			},
 		}
 
-		defer os.Remove(file.Name())
-		defer file.Close()
+		input := newTestInput()
+		input.AccountId = "-"
+		input.Source = bytes.NewReader([]byte("testtesttesttest!"))
+		input.Logger = events.Discard
 
-		if _, err := file.WriteString("test_upload"); err != nil {
-			t.Fatal(err)
+		got, err := NewClient(mock).Verify(input)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
 		}
 
-		requestMock := func() glacier.UploadMultipartPartRequest {
-			return glacier.UploadMultipartPartRequest{
-				Request: &aws.Request{
-					Data: &glacier.UploadMultipartPartOutput{},
-				},
-			}
+		want := &Verification{
+			UploadId:   "test_id",
+			PartSize:   4,
+			Matched:    []utils.Range{{Offset: 0, Limit: 4}},
+			Mismatched: []utils.Range{{Offset: 4, Limit: 4}},
+			Missing: []utils.Range{
+				{Offset: 8, Limit: 4},
+				{Offset: 12, Limit: 4},
+				{Offset: 16, Limit: 1},
+			},
 		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+		if input.PartSize != 123 {
+			t.Fatalf("the part size of the input was changed to %d", input.PartSize)
+		}
+		if mock.CallCount != 1 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+	})
+
+	t.Run("truncated file", func(t *testing.T) {
 		mock := &mocks.Glacier{
-			UploadMultipartPartRequestMock: requestMock,
+			ListPartsRequestMock: func() glacier.ListPartsRequest {
+				return newListPartsRequestMock(&aws.Request{
+					Data: &glacier.ListPartsOutput{
+						PartSizeInBytes: aws.Int64(4),
+						Parts: []glacier.PartListElement{
+							{RangeInBytes: aws.String("0-3"), SHA256TreeHash: hash},
+							{RangeInBytes: aws.String("8-11"), SHA256TreeHash: aws.String("test_hash")},
+							{RangeInBytes: aws.String("12-15"), SHA256TreeHash: aws.String("test_hash")},
+						},
+					},
+					Operation: &aws.Operation{},
+				})
+			},
 		}
 
 		input := newTestInput()
-		input.FileName = file.Name()
-		input.PartSize = 2
+		input.AccountId = "-"
+		input.Source = bytes.NewReader([]byte("testtest!"))
+		input.Logger = events.Discard
 
-		uploader := &Uploader{
-			service: mock,
-			input:   input,
-			file:    file,
-			size:    11,
+		got, err := NewClient(mock).Verify(input)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
 		}
 
-		uploader.multipartUpload(2)
-
-		if mock.CallCount != 6 {
-			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		// The parts past the end of the file are mismatched.
+		want := &Verification{
+			UploadId:   "test_id",
+			PartSize:   4,
+			Matched:    []utils.Range{{Offset: 0, Limit: 4}},
+			Mismatched: []utils.Range{{Offset: 8, Limit: 4}, {Offset: 12, Limit: 4}},
+			Missing:    []utils.Range{{Offset: 4, Limit: 4}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v, want %#v", got, want)
 		}
 	})
-}
+
+	t.Run("no upload ID", func(t *testing.T) {
+		mock := &mocks.Glacier{}
+
+		input := newTestInput()
+		input.AccountId = "-"
+		input.UploadId = ""
+
+		errString := "the upload ID of the verified upload is not set"
+		if _, got := NewClient(mock).Verify(input); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+		if mock.CallCount != 0 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+	})
+
+	t.Run("list parts error", func(t *testing.T) {
+		err := errors.New("test")
+		mock := &mocks.Glacier{
+			ListPartsRequestMock: func() glacier.ListPartsRequest {
+				return newListPartsRequestMock(&aws.Request{Error: err})
+			},
+		}
+
+		input := newTestInput()
+		input.AccountId = "-"
+		input.Source = bytes.NewReader([]byte("test"))
+		input.Logger = events.Discard
+
+		if _, got := NewClient(mock).Verify(input); got != err {
+			t.Fatalf("got %#v, want %#v", got, err)
+		}
+	})
+}
+
+func TestCheckUploadedPartsContext(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "test")
+
+	input := newTestInput()
+	input.Context = ctx
+	input.Logger = events.Discard
+
+	var got []interface{}
+	mock := &mocks.Glacier{
+		ListPartsRequestMock: func() glacier.ListPartsRequest {
+			return glacier.ListPartsRequest{
+				Copy: func(in *glacier.ListPartsInput) glacier.ListPartsRequest {
+					request := &aws.Request{
+						Data:      &glacier.ListPartsOutput{PartSizeInBytes: &input.PartSize},
+						Params:    in,
+						Operation: &aws.Operation{},
+					}
+					request.Handlers.Send.PushBack(func(r *aws.Request) {
+						got = append(got, r.Context().Value(key{}))
+					})
+					return glacier.ListPartsRequest{Request: request}
+				},
+			}
+		},
+	}
+
+	if err := New(mock, input).checkUploadedParts(); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	if want := []interface{}{"test"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+// TestCheckUploadedPartsStop checks in a synctest bubble that the parts stop
+// being listed once the checking fails, since a leaked goroutine listing the
+// endless pages would remain blocked when the bubble exits.
+func TestCheckUploadedPartsStop(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		input := newTestInput()
+		input.Logger = events.Discard
+
+		requested := 0
+		mock := &mocks.Glacier{
+			ListPartsRequestMock: func() glacier.ListPartsRequest {
+				return glacier.ListPartsRequest{
+					Copy: func(in *glacier.ListPartsInput) glacier.ListPartsRequest {
+						requested++
+						request := &aws.Request{
+							Data: &glacier.ListPartsOutput{
+								Marker:          aws.String(fmt.Sprint(requested)),
+								PartSizeInBytes: &input.PartSize,
+								Parts:           []glacier.PartListElement{{RangeInBytes: aws.String("test")}},
+							},
+							Params: in,
+							Operation: &aws.Operation{
+								Paginator: &aws.Paginator{
+									InputTokens:  []string{"Marker"},
+									OutputTokens: []string{"Marker"},
+								},
+							},
+						}
+						return glacier.ListPartsRequest{Request: request}
+					},
+				}
+			},
+			Faults: &mocks.Faults{Latency: time.Second},
+		}
+
+		uploader := New(mock, input)
+		errString := "part (test) range is invalid"
+
+		if got := uploader.checkUploadedParts(); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+
+		// The second page is already being fetched while the first is checked.
+		synctest.Wait()
+		time.Sleep(time.Second)
+		synctest.Wait()
+
+		if requested != 2 {
+			t.Fatalf("got %d pages requested, want 2", requested)
+		}
+	})
+}
+
+// treeHashAligned reports whether the range of the archive is tree-hash
+// aligned, that is its tree hash is a node of the tree hash of the archive.
+func treeHashAligned(r *utils.Range, size int64) bool {
+	for chunk := int64(1 << 20); r.Offset%chunk == 0; chunk <<= 1 {
+		end := r.Offset + chunk
+		if end >= size {
+			return r.Offset+r.Limit == size
+		}
+		if r.Offset+r.Limit == end {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGetNextRange(t *testing.T) {
+	config := &quick.Config{MaxCount: 1000}
+
+	t.Run("partition", func(t *testing.T) {
+		// Every byte is covered exactly once by the parts in order.
+		partition := func(size uint16, partSize uint8) bool {
+			uploader := New(nil, &Input{PartSize: int64(partSize) + 1})
+			uploader.size = int64(size)
+
+			var offset int64
+			for r := uploader.getNextRange(); r != nil; r = uploader.getNextRange() {
+				if r.Offset != offset || r.Limit <= 0 || r.Limit > uploader.input.PartSize {
+					return false
+				}
+				offset += r.Limit
+			}
+			return offset == uploader.size
+		}
+		if err := quick.Check(partition, config); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		// The parts already uploaded are skipped, and only them.
+		resume := func(size uint16, partSize uint8, uploaded uint64) bool {
+			uploader := New(nil, &Input{PartSize: int64(partSize) + 1})
+			uploader.size = int64(size)
+
+			skipped := make(map[int64]bool)
+			for i, offset := 0, int64(0); offset < uploader.size; i, offset = i+1, offset+uploader.input.PartSize {
+				if uploaded&(1<<uint(i%64)) != 0 {
+					uploader.uploaded[offset] = struct{}{}
+					skipped[offset] = true
+				}
+			}
+
+			var offset int64
+			for r := uploader.getNextRange(); r != nil; r = uploader.getNextRange() {
+				for skipped[offset] {
+					offset += uploader.input.PartSize
+				}
+				if r.Offset != offset || r.Limit <= 0 || r.Offset+r.Limit > uploader.size {
+					return false
+				}
+				offset += uploader.input.PartSize
+			}
+			for skipped[offset] {
+				offset += uploader.input.PartSize
+			}
+			return offset >= uploader.size
+		}
+		if err := quick.Check(resume, config); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("tree-hash aligned", func(t *testing.T) {
+		// The parts of a power of two megabytes are valid Glacier ranges.
+		aligned := func(size uint32, shift uint8) bool {
+			uploader := New(nil, &Input{PartSize: 1 << 20 << (shift % 8)})
+			uploader.size = int64(size)
+
+			for r := uploader.getNextRange(); r != nil; r = uploader.getNextRange() {
+				s := r.String()
+				if parsed := utils.RangeFromString(&s); parsed == nil || *parsed != *r {
+					return false
+				}
+				if !treeHashAligned(r, uploader.size) {
+					return false
+				}
+			}
+			return true
+		}
+		if err := quick.Check(aligned, config); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestUploadPart(t *testing.T) {
+	t.Run("hashing error", func(t *testing.T) {
+		uploader := Uploader{}
+		r := &utils.Range{}
+		errString := "could not compute hashes"
+
+		if got := uploader.uploadPart(r, &events.Event{}); got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		if _, err := file.WriteString("test"); err != nil {
+			t.Fatal(err)
+		}
+
+		uploader := &Uploader{
+			input: newTestInput(),
+			file:  file,
+			size:  8,
+		}
+
+		r := &utils.Range{
+			Offset: 0,
+			Limit:  uploader.size,
+		}
+
+		if got := uploader.uploadPart(r, &events.Event{}); got != io.ErrUnexpectedEOF {
+			t.Fatalf("got %#v, want %#v", got, io.ErrUnexpectedEOF)
+		}
+	})
+
+	t.Run("upload error", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		if _, err := file.WriteString("test"); err != nil {
+			t.Fatal(err)
+		}
+
+		err = errors.New("test")
+		requestMock := func() glacier.UploadMultipartPartRequest {
+			return glacier.UploadMultipartPartRequest{
+				Request: &aws.Request{
+					Error: err,
+				},
+			}
+		}
+		mock := &mocks.Glacier{
+			UploadMultipartPartRequestMock: requestMock,
+		}
+
+		input := newTestInput()
+		input.FileName = file.Name()
+
+		uploader := &Uploader{
+			service: mock,
+			input:   input,
+			file:    file,
+			size:    4,
+		}
+
+		r := &utils.Range{
+			Offset: 0,
+			Limit:  uploader.size,
+		}
+
+		if got := uploader.uploadPart(r, &events.Event{}); got != err {
+			t.Fatalf("got %#v, want %#v", got, err)
+		}
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		if _, err := file.WriteString("test"); err != nil {
+			t.Fatal(err)
+		}
+
+		requestMock := func() glacier.UploadMultipartPartRequest {
+			return glacier.UploadMultipartPartRequest{
+				Request: &aws.Request{
+					Data:      &glacier.UploadMultipartPartOutput{},
+					RequestID: "test_request",
+				},
+			}
+		}
+		mock := &mocks.Glacier{
+			UploadMultipartPartRequestMock: requestMock,
+		}
+
+		input := newTestInput()
+		input.FileName = file.Name()
+
+		uploader := &Uploader{
+			service: mock,
+			input:   input,
+			file:    file,
+			size:    4,
+		}
+
+		r := &utils.Range{
+			Offset: 0,
+			Limit:  uploader.size,
+		}
+
+		report := &events.Event{}
+		if err := uploader.uploadPart(r, report); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		want := &events.Event{
+			Checksum:  *utils.TreeHashBytes([]byte("test")),
+			RequestId: "test_request",
+			Attempt:   1,
+		}
+		if !reflect.DeepEqual(report, want) {
+			t.Fatalf("got %#v, want %#v", report, want)
+		}
+	})
+}
+
+func TestMultipartUpload(t *testing.T) {
+	t.Run("upload error", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		if _, err := file.WriteString("test_upload"); err != nil {
+			t.Fatal(err)
+		}
+
+		err = errors.New("test")
+		requestMock := func() glacier.UploadMultipartPartRequest {
+			return glacier.UploadMultipartPartRequest{
+				Request: &aws.Request{
+					Error: err,
+				},
+			}
+		}
+		mock := &mocks.Glacier{
+			UploadMultipartPartRequestMock: requestMock,
+		}
+
+		input := newTestInput()
+		input.FileName = file.Name()
+		input.PartSize = 4
+		input.Logger = events.Discard
+
+		uploader := &Uploader{
+			service: mock,
+			input:   input,
+			file:    file,
+			size:    11,
+		}
+
+		got := uploader.multipartUpload(2)
+
+		// Every part is uploaded once more after it fails.
+		if mock.CallCount != 6 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+
+		errString := "could not transfer 3 part(s): 0-3: test; 4-7: test; 8-10: test"
+		if got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	t.Run("retry then skip", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		if _, err := file.WriteString("test_upload"); err != nil {
+			t.Fatal(err)
+		}
+
+		// The first part fails twice, the second part only once.
+		var calls int32
+		requestMock := func() glacier.UploadMultipartPartRequest {
+			if call := atomic.AddInt32(&calls, 1); call <= 3 {
+				return glacier.UploadMultipartPartRequest{
+					Request: &aws.Request{
+						Error: errors.New("test"),
+					},
+				}
+			}
+			return glacier.UploadMultipartPartRequest{
+				Request: &aws.Request{
+					Data: &glacier.UploadMultipartPartOutput{},
+				},
+			}
+		}
+		mock := &mocks.Glacier{
+			UploadMultipartPartRequestMock: requestMock,
+		}
+
+		input := newTestInput()
+		input.FileName = file.Name()
+		input.PartSize = 8
+		input.Logger = events.Discard
+
+		uploader := &Uploader{
+			service: mock,
+			input:   input,
+			file:    file,
+			size:    11,
+		}
+		uploader.context, uploader.failures = utils.WithPartFailures(context.Background(), input.OnFailure)
+
+		got := uploader.multipartUpload(1)
+
+		if mock.CallCount != 4 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+
+		errString := "could not transfer 1 part(s): 0-7: test"
+		if got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	t.Run("fail fast", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		if _, err := file.WriteString("test_upload"); err != nil {
+			t.Fatal(err)
+		}
+
+		requestMock := func() glacier.UploadMultipartPartRequest {
+			return glacier.UploadMultipartPartRequest{
+				Request: &aws.Request{
+					Error: errors.New("test"),
+				},
+			}
+		}
+		mock := &mocks.Glacier{
+			UploadMultipartPartRequestMock: requestMock,
+		}
+
+		input := newTestInput()
+		input.FileName = file.Name()
+		input.PartSize = 4
+		input.OnFailure = utils.FailFast
+		input.Logger = events.Discard
+
+		uploader := &Uploader{
+			service: mock,
+			input:   input,
+			file:    file,
+			size:    11,
+		}
+		uploader.context, uploader.failures = utils.WithPartFailures(context.Background(), input.OnFailure)
+
+		got := uploader.multipartUpload(1)
+
+		if mock.CallCount != 1 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+
+		errString := "could not transfer 1 part(s): 0-3: test"
+		if got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		if _, err := file.WriteString("test_upload"); err != nil {
+			t.Fatal(err)
+		}
+
+		requestMock := func() glacier.UploadMultipartPartRequest {
+			return glacier.UploadMultipartPartRequest{
+				Request: &aws.Request{
+					Data: &glacier.UploadMultipartPartOutput{},
+				},
+			}
+		}
+		mock := &mocks.Glacier{
+			UploadMultipartPartRequestMock: requestMock,
+		}
+
+		input := newTestInput()
+		input.FileName = file.Name()
+		input.PartSize = 2
+
+		uploader := &Uploader{
+			service: mock,
+			input:   input,
+			file:    file,
+			size:    11,
+		}
+
+		uploader.multipartUpload(2)
+
+		if mock.CallCount != 6 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+
+		// The parts are uploaded in parallel, so the order of the inputs varies.
+		sent := make(map[string]string)
+		for _, input := range mock.UploadMultipartPartInputs() {
+			sent[*input.Range] = *input.Checksum
+		}
+
+		want := make(map[string]string)
+		for offset := 0; offset < 11; offset += 2 {
+			end := offset + 2
+			if end > 11 {
+				end = 11
+			}
+			want[fmt.Sprintf("bytes %d-%d/*", offset, end-1)] = *utils.TreeHashBytes([]byte("test_upload"[offset:end]))
+		}
+
+		if !reflect.DeepEqual(sent, want) {
+			t.Fatalf("got %#v, want %#v", sent, want)
+		}
+	})
+
+	for name, test := range map[string]struct {
+		maxRetries int
+		uploaded   int64
+		requests   int
+	}{
+		"failures retried":            {maxRetries: 1, uploaded: 6, requests: 8},
+		"failures uploaded once more": {maxRetries: 0, uploaded: 6, requests: 8},
+	} {
+		t.Run(name, func(t *testing.T) {
+			file, err := ioutil.TempFile("", "surge")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.Remove(file.Name())
+			defer file.Close()
+
+			if _, err := file.WriteString("test_upload"); err != nil {
+				t.Fatal(err)
+			}
+
+			requestMock := func() glacier.UploadMultipartPartRequest {
+				return glacier.UploadMultipartPartRequest{
+					Request: &aws.Request{
+						Data: &glacier.UploadMultipartPartOutput{},
+					},
+				}
+			}
+			faults := &mocks.Faults{
+				FailRequests: []int{1, 3},
+				MaxRetries:   test.maxRetries,
+			}
+			mock := &mocks.Glacier{
+				UploadMultipartPartRequestMock: requestMock,
+				Faults:                         faults,
+			}
+
+			input := newTestInput()
+			input.FileName = file.Name()
+			input.PartSize = 2
+			input.Logger = events.Discard
+
+			uploader := &Uploader{
+				service: mock,
+				input:   input,
+				file:    file,
+				size:    11,
+			}
+
+			uploader.multipartUpload(1)
+
+			if uploader.partsUploaded != test.uploaded {
+				t.Errorf("got %#v parts uploaded, want %#v", uploader.partsUploaded, test.uploaded)
+			}
+			if got := faults.Requests(); got != test.requests {
+				t.Errorf("got %#v requests, want %#v", got, test.requests)
+			}
+		})
+	}
+}
+
+// TestMultipartUploadSchedule runs the workers in a synctest bubble, where
+// the requests take exactly their latency of fake time, so that the elapsed
+// time shows how the parts were scheduled.
+func TestMultipartUploadSchedule(t *testing.T) {
+	for name, test := range map[string]struct {
+		jobs    int
+		maxRate int64
+		elapsed time.Duration
+	}{
+		"sequential":   {jobs: 1, elapsed: 6 * time.Second},
+		"parallel":     {jobs: 2, elapsed: 3 * time.Second},
+		"all parallel": {jobs: 8, elapsed: time.Second},
+		"rate limited": {jobs: 8, maxRate: 2, elapsed: 6 * time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			file, err := ioutil.TempFile("", "surge")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.Remove(file.Name())
+			defer file.Close()
+
+			// The parts are of the same size, so that the rate limited ones
+			// take as long whichever starts first.
+			if _, err := file.WriteString("test_upload!"); err != nil {
+				t.Fatal(err)
+			}
+
+			synctest.Test(t, func(t *testing.T) {
+				requestMock := func() glacier.UploadMultipartPartRequest {
+					return glacier.UploadMultipartPartRequest{
+						Request: &aws.Request{
+							Data: &glacier.UploadMultipartPartOutput{},
+						},
+					}
+				}
+				mock := &mocks.Glacier{
+					UploadMultipartPartRequestMock: requestMock,
+					Faults:                         &mocks.Faults{Latency: time.Second},
+				}
+
+				input := newTestInput()
+				input.FileName = file.Name()
+				input.PartSize = 2
+				input.MaxRate = test.maxRate
+				input.Logger = events.Discard
+
+				uploader := New(mock, input)
+				uploader.file = file
+				uploader.size = 12
+
+				start := time.Now()
+				uploader.multipartUpload(test.jobs)
+
+				if elapsed := time.Since(start); elapsed != test.elapsed {
+					t.Errorf("got %v elapsed, want %v", elapsed, test.elapsed)
+				}
+				if uploader.partsUploaded != 6 {
+					t.Errorf("got %#v parts uploaded, want 6", uploader.partsUploaded)
+				}
+			})
+		})
+	}
+}
+
+// eventRecorder records the logged events.
+type eventRecorder []*events.Event
+
+func (r *eventRecorder) Log(e *events.Event) {
+	*r = append(*r, e)
+}
+
+func TestCheckSlowPart(t *testing.T) {
+	var recorder eventRecorder
+
+	input := newTestInput()
+	input.Logger = &recorder
+	input.SlowPartFactor = 3
+
+	uploader := New(&mocks.Glacier{}, input)
+	uploader.latencies = &utils.PartLatencies{}
+	for i := int64(0); i < 5; i++ {
+		uploader.latencies.Record(&utils.Range{Offset: i * 4, Limit: 4}, 1)
+	}
+
+	uploader.checkSlowPart(&events.Event{Range: &utils.Range{Offset: 20, Limit: 4}, Attempt: 1, Elapsed: 2})
+	if len(recorder) != 0 {
+		t.Fatalf("unexpected events: %#v", recorder)
+	}
+
+	uploader.checkSlowPart(&events.Event{Range: &utils.Range{Offset: 24, Limit: 4}, Attempt: 2, Elapsed: 4.5})
+	if len(recorder) != 1 {
+		t.Fatalf("got %d event(s), want 1", len(recorder))
+	}
+
+	want := "part (24-27) took 4.5s on attempt 2, 4.5 times the median of the recent parts"
+	if e := recorder[0]; e.Type != events.Warning || e.Message != want || e.Attempt != 2 {
+		t.Fatalf("got %#v, want a warning %#v", e, want)
+	}
+}
 
 func TestCompleteUpload(t *testing.T) {
 	t.Run("hashing error", func(t *testing.T) {
@@ -646,10 +1576,604 @@ func TestCompleteUpload(t *testing.T) {
 			size:    11,
 		}
 
-		if got, err := uploader.completeUpload(); *got != location {
-			t.Fatalf("got %#v, want %#v", *got, location)
+		if got, err := uploader.completeUpload(); *got.Location != location {
+			t.Fatalf("got %#v, want %#v", *got.Location, location)
 		} else if err != nil {
 			t.Fatalf("unexpected error: %#v", err)
 		}
+
+		sent := mock.CompleteMultipartUploadInputs()
+		if len(sent) != 1 {
+			t.Fatalf("got %d inputs, want 1", len(sent))
+		}
+
+		want := glacier.CompleteMultipartUploadInput{
+			AccountId:   &input.AccountId,
+			ArchiveSize: aws.String("11"),
+			Checksum:    utils.TreeHashBytes([]byte("test_upload")),
+			UploadId:    &input.UploadId,
+			VaultName:   &input.VaultName,
+		}
+		if !reflect.DeepEqual(*sent[0], want) {
+			t.Fatalf("got %#v, want %#v", *sent[0], want)
+		}
+	})
+}
+
+func TestUpload(t *testing.T) {
+	t.Run("invalid account ID", func(t *testing.T) {
+		mock := &mocks.Glacier{}
+		input := newTestInput()
+		uploader := New(mock, input)
+		errString := `invalid account ID "test_account": must be '-' or a 12-digit AWS account ID`
+
+		if _, got := uploader.Upload(1); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+
+		if mock.CallCount != 0 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+	})
+
+	t.Run("source", func(t *testing.T) {
+		data := []byte("test_upload")
+		mock := newUploadMock()
+
+		// The file does not exist, the source is uploaded instead.
+		input := newTestInput()
+		input.AccountId = "-"
+		input.UploadId = ""
+		input.FileName = "s3://bucket/key"
+		input.PartSize = 4
+		input.Source = bytes.NewReader(data)
+		input.Logger = events.Discard
+
+		result, err := New(mock, input).Upload(2)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if result.PartsUploaded != 3 || result.PartsSkipped != 0 || result.BytesTransferred != 11 {
+			t.Fatalf("got %#v, want 3 parts and 11 bytes uploaded", result)
+		}
+
+		parts := mock.UploadMultipartPartInputs()
+		if len(parts) != 3 {
+			t.Fatalf("got %d parts, want 3", len(parts))
+		}
+
+		completed := mock.CompleteMultipartUploadInputs()
+		want := utils.TreeHashBytes(data)
+		if len(completed) != 1 || *completed[0].Checksum != *want || *completed[0].ArchiveSize != "11" {
+			t.Fatalf("got %#v, want the checksum %#v", completed, *want)
+		}
+	})
+
+	t.Run("failed part", func(t *testing.T) {
+		mock := newUploadMock()
+
+		// The first part fails, once more after it is retried.
+		var calls int32
+		mock.UploadMultipartPartRequestMock = func() glacier.UploadMultipartPartRequest {
+			if atomic.AddInt32(&calls, 1) <= 2 {
+				return glacier.UploadMultipartPartRequest{
+					Request: &aws.Request{
+						Error: errors.New("test"),
+					},
+				}
+			}
+			return glacier.UploadMultipartPartRequest{
+				Request: &aws.Request{
+					Data: &glacier.UploadMultipartPartOutput{},
+				},
+			}
+		}
+
+		input := newTestInput()
+		input.AccountId = "-"
+		input.UploadId = ""
+		input.FileName = "s3://bucket/key"
+		input.PartSize = 4
+		input.Source = bytes.NewReader([]byte("test_upload"))
+		input.Logger = events.Discard
+
+		_, err := New(mock, input).Upload(1)
+		if _, ok := err.(*utils.PartialTransferError); !ok {
+			t.Fatalf("got %#v, want a partial transfer error", err)
+		}
+		if errString := "could not transfer 1 part(s): 0-3: test"; err.Error() != errString {
+			t.Fatalf("got %#v, want %#v", err.Error(), errString)
+		}
+		if len(mock.CompleteMultipartUploadInputs()) != 0 {
+			t.Fatal("the upload with a failed part was completed")
+		}
+	})
+
+	t.Run("reader at", func(t *testing.T) {
+		data := []byte("test_upload with a tail")
+		mock := newUploadMock()
+
+		input := newTestInput()
+		input.AccountId = "-"
+		input.UploadId = ""
+		input.FileName = "device"
+		input.PartSize = 4
+		input.Logger = events.Discard
+
+		if _, err := NewReaderAt(mock, input, bytes.NewReader(data), 11).Upload(2); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		completed := mock.CompleteMultipartUploadInputs()
+		want := utils.TreeHashBytes(data[:11])
+		if len(completed) != 1 || *completed[0].Checksum != *want || *completed[0].ArchiveSize != "11" {
+			t.Fatalf("got %#v, want the checksum %#v", completed, *want)
+		}
+		if input.Source != nil {
+			t.Fatalf("got %#v, want the input left unchanged", input.Source)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		for _, size := range []int{3, 4, 11, 12} {
+			t.Run(fmt.Sprint(size), func(t *testing.T) {
+				data := make([]byte, size)
+				for i := range data {
+					data[i] = byte(i)
+				}
+				mock := newUploadMock()
+
+				input := newTestInput()
+				input.AccountId = "-"
+				input.UploadId = ""
+				input.FileName = "stream"
+				input.PartSize = 4
+				input.Stream = iotest.HalfReader(bytes.NewReader(data))
+				input.Logger = events.Discard
+
+				if _, err := New(mock, input).Upload(2); err != nil {
+					t.Fatalf("unexpected error: %#v", err)
+				}
+
+				// Every part but the last is of the part size.
+				var uploaded []byte
+				parts := mock.UploadMultipartPartInputs()
+				offset := func(p *glacier.UploadMultipartPartInput) (o int64) {
+					fmt.Sscanf(*p.Range, "bytes %d-", &o)
+					return o
+				}
+				sort.Slice(parts, func(i, j int) bool { return offset(parts[i]) < offset(parts[j]) })
+				for i, p := range parts {
+					body, _ := ioutil.ReadAll(p.Body)
+					if i < len(parts)-1 && len(body) != 4 {
+						t.Fatalf("got part %d of %d bytes, want 4", i, len(body))
+					}
+					uploaded = append(uploaded, body...)
+				}
+				if !bytes.Equal(uploaded, data) {
+					t.Fatalf("got %#v, want %#v", uploaded, data)
+				}
+
+				completed := mock.CompleteMultipartUploadInputs()
+				want := utils.TreeHashBytes(data)
+				if len(completed) != 1 || *completed[0].Checksum != *want || *completed[0].ArchiveSize != fmt.Sprint(size) {
+					t.Fatalf("got %#v, want the checksum %#v", completed, *want)
+				}
+				if len(mock.ListPartsInputs()) != 0 {
+					t.Fatal("the parts of a stream upload were listed")
+				}
+			})
+		}
+	})
+
+	t.Run("stream error", func(t *testing.T) {
+		mock := newUploadMock()
+
+		input := newTestInput()
+		input.AccountId = "-"
+		input.UploadId = ""
+		input.PartSize = 4
+		input.Stream = iotest.TimeoutReader(bytes.NewReader([]byte("test_upload")))
+		input.Logger = events.Discard
+
+		errString := "could not read the stream: timeout"
+		if _, got := New(mock, input).Upload(1); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+		if len(mock.CompleteMultipartUploadInputs()) != 0 {
+			t.Fatal("the upload of a failed stream was completed")
+		}
+	})
+
+	t.Run("empty stream", func(t *testing.T) {
+		mock := newUploadMock()
+
+		input := newTestInput()
+		input.AccountId = "-"
+		input.UploadId = ""
+		input.Stream = bytes.NewReader(nil)
+		input.Logger = events.Discard
+
+		if _, got := New(mock, input).Upload(1); got != ErrEmpty {
+			t.Fatalf("got %#v, want %#v", got, ErrEmpty)
+		}
+		if mock.CallCount != 0 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		file, err := ioutil.TempFile("", "surge")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.Remove(file.Name())
+		defer file.Close()
+
+		mock := newUploadMock()
+
+		input := newTestInput()
+		input.AccountId = "-"
+		input.UploadId = ""
+		input.FileName = file.Name()
+		input.Logger = events.Discard
+
+		if _, got := New(mock, input).Upload(1); got != ErrEmpty {
+			t.Fatalf("got %#v, want %#v", got, ErrEmpty)
+		}
+		if mock.CallCount != 0 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+	})
+
+	// A file smaller than a part and than a tree hash leaf is uploaded in a
+	// single part, and its tree hash is the SHA-256 of the content.
+	for _, size := range []int{1, 1000, 1<<20 - 1} {
+		t.Run(fmt.Sprintf("%d bytes", size), func(t *testing.T) {
+			data := bytes.Repeat([]byte{'x'}, size)
+			mock := newUploadMock()
+			mock.ListPartsRequestMock = func() glacier.ListPartsRequest {
+				return newListPartsRequestMock(&aws.Request{
+					Data:      &glacier.ListPartsOutput{PartSizeInBytes: aws.Int64(1 << 20)},
+					Operation: &aws.Operation{},
+				})
+			}
+
+			input := newTestInput()
+			input.AccountId = "-"
+			input.UploadId = ""
+			input.PartSize = 1 << 20
+			input.Source = bytes.NewReader(data)
+			input.Logger = events.Discard
+
+			result, err := New(mock, input).Upload(2)
+			if err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			if result.PartsUploaded != 1 || result.BytesTransferred != int64(size) {
+				t.Fatalf("got %#v, want a single part of %d bytes", result, size)
+			}
+
+			parts := mock.UploadMultipartPartInputs()
+			if len(parts) != 1 || *parts[0].Range != fmt.Sprintf("bytes 0-%d/*", size-1) {
+				t.Fatalf("got %#v, want a single part of %d bytes", parts, size)
+			}
+
+			completed := mock.CompleteMultipartUploadInputs()
+			want := fmt.Sprintf("%x", sha256.Sum256(data))
+			if len(completed) != 1 || *completed[0].Checksum != want || *completed[0].ArchiveSize != fmt.Sprint(size) {
+				t.Fatalf("got %#v, want the checksum %#v", completed, want)
+			}
+		})
+	}
+
+	t.Run("single request", func(t *testing.T) {
+		data := []byte("test_upload")
+		mock := newUploadMock()
+
+		input := newTestInput()
+		input.AccountId = "-"
+		input.UploadId = ""
+		input.PartSize = 4
+		input.MultipartThreshold = 12
+		input.Description = "test_description"
+		input.Source = bytes.NewReader(data)
+		input.Logger = events.Discard
+
+		result, err := New(mock, input).Upload(2)
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if result.ArchiveId != "test_archive" || result.PartsUploaded != 1 || result.BytesTransferred != 11 {
+			t.Fatalf("got %#v, want archive test_archive uploaded in a single part of 11 bytes", result)
+		}
+
+		archives := mock.UploadArchiveInputs()
+		want := utils.TreeHashBytes(data)
+		if len(archives) != 1 || *archives[0].Checksum != *want || *archives[0].ArchiveDescription != input.Description {
+			t.Fatalf("got %#v, want the checksum %#v", archives, *want)
+		}
+		if body, _ := ioutil.ReadAll(archives[0].Body); !bytes.Equal(body, data) {
+			t.Fatalf("got %#v, want %#v", body, data)
+		}
+		if mock.CallCount != 1 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+	})
+
+	// The content of the threshold size, a resumed upload and a stream are
+	// uploaded in parts.
+	multipart := map[string]func(input *Input){
+		"threshold": func(input *Input) {
+			input.UploadId = ""
+			input.Source = bytes.NewReader([]byte("test_upload"))
+			input.MultipartThreshold = 11
+		},
+		"resumed": func(input *Input) {
+			input.Source = bytes.NewReader([]byte("test_upload"))
+			input.MultipartThreshold = 12
+		},
+		"stream": func(input *Input) {
+			input.UploadId = ""
+			input.Stream = bytes.NewReader([]byte("test_upload"))
+			input.MultipartThreshold = 12
+		},
+		"larger than the memory limit": func(input *Input) {
+			input.UploadId = ""
+			input.Source = bytes.NewReader([]byte("test_upload"))
+			input.MultipartThreshold = 12
+			input.MaxMemory = 8
+		},
+	}
+	for name, setup := range multipart {
+		t.Run("multipart "+name, func(t *testing.T) {
+			mock := newUploadMock()
+
+			input := newTestInput()
+			input.AccountId = "-"
+			input.PartSize = 4
+			input.Logger = events.Discard
+			setup(input)
+
+			if _, err := New(mock, input).Upload(2); err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			if len(mock.UploadArchiveInputs()) != 0 || len(mock.CompleteMultipartUploadInputs()) != 1 {
+				t.Fatalf("got %#v, want a multipart upload", mock.Inputs())
+			}
+		})
+	}
+
+	t.Run("stream resumed", func(t *testing.T) {
+		mock := &mocks.Glacier{}
+
+		input := newTestInput()
+		input.AccountId = "-"
+		input.Stream = bytes.NewReader(nil)
+
+		errString := "an upload of a stream cannot be resumed"
+		if _, got := New(mock, input).Upload(1); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+		if mock.CallCount != 0 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+	})
+}
+
+func TestUploadMemoryLimit(t *testing.T) {
+	t.Run("reduced", func(t *testing.T) {
+		var recorder eventRecorder
+		mock := newUploadMock()
+
+		// The stream holds a part read ahead along with the parts of the jobs.
+		input := newTestInput()
+		input.AccountId = "-"
+		input.UploadId = ""
+		input.PartSize = 4
+		input.Stream = bytes.NewReader([]byte("test_upload"))
+		input.MaxMemory = 10
+		input.Logger = &recorder
+
+		if _, err := New(mock, input).Upload(8); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		want := "uploading 1 part(s) at once instead of 8 to buffer at most 10 B"
+		for _, e := range recorder {
+			if e.Type == events.Warning {
+				if e.Message != want {
+					t.Fatalf("got %#v, want %#v", e.Message, want)
+				}
+				return
+			}
+		}
+		t.Fatalf("no warning, want %#v", want)
+	})
+
+	t.Run("too small", func(t *testing.T) {
+		mock := &mocks.Glacier{}
+
+		input := newTestInput()
+		input.AccountId = "-"
+		input.UploadId = ""
+		input.PartSize = 4
+		input.Source = bytes.NewReader([]byte("test_upload"))
+		input.MaxMemory = 3
+		input.Logger = events.Discard
+
+		errString := "the memory limit of 3 B is too small for a part of 4 B"
+		if _, got := New(mock, input).Upload(2); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+		if mock.CallCount != 0 {
+			t.Fatalf("unexpected mock call count: %d", mock.CallCount)
+		}
+	})
+}
+
+func TestClient(t *testing.T) {
+	t.Run("reused", func(t *testing.T) {
+		mock := newUploadMock()
+
+		input := newTestInput()
+		input.AccountId = "-"
+		input.UploadId = ""
+		input.PartSize = 4
+		input.Source = bytes.NewReader([]byte("test_upload"))
+		input.Logger = events.Discard
+
+		// Every upload is a new one, the parts of the first are not skipped.
+		uploader := New(mock, input)
+		for i := 0; i < 2; i++ {
+			result, err := uploader.Upload(2)
+			if err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			if result.UploadId != "test_id" || result.PartsUploaded != 3 || result.PartsSkipped != 0 {
+				t.Fatalf("got %#v, want 3 parts of upload test_id uploaded", result)
+			}
+		}
+
+		if input.UploadId != "" {
+			t.Fatalf("got upload ID %#v, want the input unchanged", input.UploadId)
+		}
+		if len(mock.InitiateMultipartUploadInputs()) != 2 || len(mock.UploadMultipartPartInputs()) != 6 {
+			t.Fatalf("got %#v, want 2 new uploads", mock.Inputs())
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		mock := newUploadMock()
+		client := NewClient(mock)
+
+		contents := []string{"first", "the second", "the third one", "and the fourth one"}
+
+		var wg sync.WaitGroup
+		errs := make([]error, len(contents))
+		for i, content := range contents {
+			wg.Add(1)
+			go func(i int, content string) {
+				defer wg.Done()
+
+				input := newTestInput()
+				input.AccountId = "-"
+				input.UploadId = ""
+				input.PartSize = 4
+				input.FileName = content
+				input.Source = bytes.NewReader([]byte(content))
+				input.Logger = events.Discard
+
+				result, err := client.Upload(input, 2)
+				if err == nil && result.BytesTransferred != int64(len(content)) {
+					err = fmt.Errorf("got %d bytes transferred, want %d", result.BytesTransferred, len(content))
+				}
+				errs[i] = err
+			}(i, content)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+		}
+
+		var got, want []string
+		for _, input := range mock.CompleteMultipartUploadInputs() {
+			got = append(got, *input.ArchiveSize+" "+*input.Checksum)
+		}
+		for _, content := range contents {
+			want = append(want, fmt.Sprint(len(content), " ", *utils.TreeHashBytes([]byte(content))))
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
 	})
 }
+
+// newUploadMock returns a mock accepting a new upload of any parts.
+func newUploadMock() *mocks.Glacier {
+	return &mocks.Glacier{
+		InitiateMultipartUploadRequestMock: func() glacier.InitiateMultipartUploadRequest {
+			return glacier.InitiateMultipartUploadRequest{
+				Request: &aws.Request{
+					Data: &glacier.InitiateMultipartUploadOutput{UploadId: aws.String("test_id")},
+				},
+			}
+		},
+		ListPartsRequestMock: func() glacier.ListPartsRequest {
+			return newListPartsRequestMock(&aws.Request{
+				Data:      &glacier.ListPartsOutput{PartSizeInBytes: aws.Int64(4)},
+				Operation: &aws.Operation{},
+			})
+		},
+		UploadMultipartPartRequestMock: func() glacier.UploadMultipartPartRequest {
+			return glacier.UploadMultipartPartRequest{
+				Request: &aws.Request{
+					Data: &glacier.UploadMultipartPartOutput{},
+				},
+			}
+		},
+		CompleteMultipartUploadRequestMock: func() glacier.CompleteMultipartUploadRequest {
+			return glacier.CompleteMultipartUploadRequest{
+				Request: &aws.Request{
+					Data: &glacier.UploadArchiveOutput{},
+				},
+			}
+		},
+		UploadArchiveRequestMock: func() glacier.UploadArchiveRequest {
+			return glacier.UploadArchiveRequest{
+				Request: &aws.Request{
+					Data: &glacier.UploadArchiveOutput{ArchiveId: aws.String("test_archive")},
+				},
+			}
+		},
+	}
+}
+
+func BenchmarkMultipartUpload(b *testing.B) {
+	const size = 16 << 20
+
+	file, err := ioutil.TempFile("", "surge")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err := file.Write(make([]byte, size)); err != nil {
+		b.Fatal(err)
+	}
+
+	requestMock := func() glacier.UploadMultipartPartRequest {
+		return glacier.UploadMultipartPartRequest{
+			Request: &aws.Request{
+				Data: &glacier.UploadMultipartPartOutput{},
+			},
+		}
+	}
+	mock := &mocks.Glacier{
+		UploadMultipartPartRequestMock: requestMock,
+	}
+
+	input := newTestInput()
+	input.FileName = file.Name()
+	input.PartSize = 1 << 20
+	input.Logger = events.Discard
+
+	b.SetBytes(size)
+	for i := 0; i < b.N; i++ {
+		uploader := New(mock, input)
+		if err := uploader.openFile(); err != nil {
+			b.Fatal(err)
+		}
+
+		uploader.multipartUpload(4)
+		uploader.closeFile()
+	}
+}
@@ -0,0 +1,22 @@
+//go:build linux && (amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64)
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// POSIX_FADV_DONTNEED on the architectures above.
+const fadvDontNeed = 4
+
+// DropCache advises the kernel to drop the cached pages of the file range.
+// Dirty pages are written back first, so they are dropped once written.
+func DropCache(file *os.File, offset, length int64) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, file.Fd(),
+		uintptr(offset), uintptr(length), fadvDontNeed, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux || !(amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64)
+
+package utils
+
+import (
+	"os"
+)
+
+// DropCache does nothing on this platform.
+func DropCache(file *os.File, offset, length int64) error {
+	return nil
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDropCache(t *testing.T) {
+	file, err := ioutil.TempFile("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err := file.WriteString("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DropCache(file, 0, 4); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+}
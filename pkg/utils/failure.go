@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FailurePolicy is what a transfer does with a part that still fails once
+// its requests are not retried anymore.
+type FailurePolicy int
+
+// Failure policies.
+const (
+	// RetryThenSkip transfers the part once more, then leaves it out and
+	// goes on with the other parts. The transfer fails in the end with the
+	// parts left out, which are the only ones left to resume it.
+	RetryThenSkip FailurePolicy = iota
+
+	// RetryThenFail transfers the part once more, then stops the transfer
+	// like FailFast.
+	RetryThenFail
+
+	// FailFast stops the transfer at once: the parts in flight are canceled,
+	// the rest are not started and the transfer fails with the part.
+	FailFast
+)
+
+var failurePolicyNames = []string{"retry-then-skip", "retry-then-fail", "fail-fast"}
+
+// String returns the name of the policy.
+func (p FailurePolicy) String() string {
+	if p < 0 || int(p) >= len(failurePolicyNames) {
+		return fmt.Sprintf("FailurePolicy(%d)", int(p))
+	}
+	return failurePolicyNames[p]
+}
+
+// ParseFailurePolicy returns the policy of the name.
+func ParseFailurePolicy(name string) (FailurePolicy, error) {
+	for i, n := range failurePolicyNames {
+		if n == name {
+			return FailurePolicy(i), nil
+		}
+	}
+	return RetryThenSkip, fmt.Errorf("unknown failure policy %q", name)
+}
+
+// Retries returns the number of the times a failed part is transferred again.
+func (p FailurePolicy) Retries() int {
+	if p == FailFast {
+		return 0
+	}
+	return 1
+}
+
+// Stops reports whether a failed part stops the transfer.
+func (p FailurePolicy) Stops() bool {
+	return p != RetryThenSkip
+}
+
+// PartFailures collects the parts failing in a transfer. If the policy stops
+// the transfer, only the first failure is kept and it cancels the context of
+// the transfer, so that the other parts stop at once. It is safe for
+// concurrent use.
+type PartFailures struct {
+	mutex  sync.Mutex
+	failed []PartError
+	cancel context.CancelFunc
+}
+
+// WithPartFailures returns a copy of the parent context and the failures of
+// the parts of a transfer with the policy, which cancel the context if the
+// policy stops the transfer.
+func WithPartFailures(parent context.Context, policy FailurePolicy) (context.Context, *PartFailures) {
+	if !policy.Stops() {
+		return parent, &PartFailures{}
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	return ctx, &PartFailures{cancel: cancel}
+}
+
+// Record adds the failure of the part.
+func (f *PartFailures) Record(failed PartError) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.cancel == nil {
+		f.failed = append(f.failed, failed)
+	} else if len(f.failed) == 0 {
+		f.failed = append(f.failed, failed)
+		f.cancel()
+	}
+}
+
+// Len returns the number of the failed parts.
+func (f *PartFailures) Len() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return len(f.failed)
+}
+
+// Err returns the failures as a PartialTransferError, or nil if no part
+// failed.
+func (f *PartFailures) Err() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(f.failed) == 0 {
+		return nil
+	}
+	return NewPartialTransferError(append([]PartError(nil), f.failed...))
+}
+
+// Summary describes the outcome of the failed parts of a transfer with the
+// policy for its summary.
+func (p FailurePolicy) Summary(failed int) string {
+	if failed == 0 {
+		return "no part failed"
+	}
+	switch p {
+	case RetryThenSkip:
+		return fmt.Sprintf("skipped %d part(s) failing twice", failed)
+	case RetryThenFail:
+		return "stopped at a part failing twice"
+	}
+	return "stopped at the first failed part"
+}
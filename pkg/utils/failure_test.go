@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseFailurePolicy(t *testing.T) {
+	for _, want := range []FailurePolicy{RetryThenSkip, RetryThenFail, FailFast} {
+		got, err := ParseFailurePolicy(want.String())
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	errString := `unknown failure policy "test"`
+	if _, err := ParseFailurePolicy("test"); err == nil || err.Error() != errString {
+		t.Errorf("got %#v, want %#v", err, errString)
+	}
+}
+
+func TestPartFailures(t *testing.T) {
+	first := PartError{Range: &Range{Offset: 4, Limit: 4}, Err: errors.New("first")}
+	second := PartError{Range: &Range{Offset: 0, Limit: 4}, Err: errors.New("second")}
+
+	t.Run("skip", func(t *testing.T) {
+		ctx, failures := WithPartFailures(context.Background(), RetryThenSkip)
+
+		if err := failures.Err(); err != nil {
+			t.Errorf("unexpected error: %#v", err)
+		}
+
+		failures.Record(first)
+		failures.Record(second)
+
+		if ctx.Err() != nil {
+			t.Errorf("unexpected error: %#v", ctx.Err())
+		}
+
+		errString := "could not transfer 2 part(s): 0-3: second; 4-7: first"
+		if got := failures.Err(); got == nil || got.Error() != errString {
+			t.Errorf("got %#v, want %q", got, errString)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		ctx, failures := WithPartFailures(context.Background(), FailFast)
+
+		failures.Record(first)
+		failures.Record(second)
+
+		if ctx.Err() != context.Canceled {
+			t.Errorf("got %#v, want %#v", ctx.Err(), context.Canceled)
+		}
+
+		errString := "could not transfer 1 part(s): 4-7: first"
+		if got := failures.Err(); got == nil || got.Error() != errString {
+			t.Errorf("got %#v, want %q", got, errString)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes the data to the file like ioutil.WriteFile, but to
+// a temporary file in the same directory first, which is synced to disk and
+// renamed over the file. A crash then leaves either the previous content of
+// the file or the new one, never a part of it. A symbolic link is followed,
+// so that the file it points to is replaced rather than the link.
+func WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	if resolved, err := filepath.EvalSymlinks(name); err == nil {
+		name = resolved
+	}
+	dir := filepath.Dir(name)
+
+	temp, err := ioutil.TempFile(dir, "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	// The temporary file is gone once it is renamed.
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Chmod(perm); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp.Name(), name); err != nil {
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// syncDir syncs the directory to disk, so that a file renamed into it stays
+// renamed after a crash. Some platforms don't sync directories, which is
+// ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
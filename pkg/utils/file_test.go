@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "file")
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFileAtomic(name, []byte(content), 0600); err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		got, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Fatalf("got %#v, want %#v", string(got), content)
+		}
+	}
+
+	// No temporary file is left behind.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "file" {
+		t.Fatalf("got %d file(s), want the written file only", len(files))
+	}
+	if runtime.GOOS != "windows" && files[0].Mode().Perm() != 0600 {
+		t.Fatalf("got mode %v, want %v", files[0].Mode().Perm(), os.FileMode(0600))
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// The file a link points to is replaced, the link is kept.
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(name, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(link, []byte("third"), 0600); err != nil {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("got %#v, %#v, want the link kept", info, err)
+	}
+	if got, err := ioutil.ReadFile(name); err != nil || string(got) != "third" {
+		t.Fatalf("got %#v, %#v, want %#v", string(got), err, "third")
+	}
+}
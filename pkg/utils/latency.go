@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SlowestParts is the number of the slowest parts reported by the latency summary.
+const SlowestParts = 3
+
+// The rolling median a slow part is compared with is computed over the latencies
+// of the recent parts, once there are enough of them.
+const (
+	recentParts    = 32
+	minRecentParts = 5
+)
+
+// PartLatency is the time it took to transfer a part, retries included.
+type PartLatency struct {
+	Range   *Range  `json:"range"`
+	Elapsed float64 `json:"elapsed_seconds"`
+}
+
+// LatencySummary describes the distribution of the part latencies of a transfer.
+type LatencySummary struct {
+	P50 float64 `json:"p50_seconds"`
+	P90 float64 `json:"p90_seconds"`
+	P99 float64 `json:"p99_seconds"`
+
+	// The slowest parts, the slowest first.
+	Slowest []PartLatency `json:"slowest"`
+}
+
+// String returns the percentiles and the slowest parts, e.g.
+// "part latency p50 1.2s, p90 2.5s, p99 4.1s, slowest 0-1048575 (4.2s)".
+func (s *LatencySummary) String() string {
+	slowest := make([]string, len(s.Slowest))
+	for i, p := range s.Slowest {
+		slowest[i] = fmt.Sprintf("%v (%v)", p.Range, seconds(p.Elapsed))
+	}
+
+	return fmt.Sprintf("part latency p50 %v, p90 %v, p99 %v, slowest %s",
+		seconds(s.P50), seconds(s.P90), seconds(s.P99), strings.Join(slowest, ", "))
+}
+
+// seconds returns the duration of the seconds rounded to milliseconds.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+}
+
+// PartLatencies collects the latencies of the parts transferred.
+// It is safe for concurrent use. A nil value records nothing.
+type PartLatencies struct {
+	mutex sync.Mutex
+	parts []PartLatency
+
+	// The latencies of the recent parts in a ring buffer.
+	recent []float64
+	next   int
+}
+
+// Record adds the time in seconds it took to transfer the part.
+func (l *PartLatencies) Record(r *Range, elapsed float64) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.parts = append(l.parts, PartLatency{Range: r, Elapsed: elapsed})
+
+	if len(l.recent) < recentParts {
+		l.recent = append(l.recent, elapsed)
+	} else {
+		l.recent[l.next] = elapsed
+		l.next = (l.next + 1) % recentParts
+	}
+}
+
+// Slow reports whether a part that took the elapsed time in seconds is slower
+// than the factor times the rolling median of the recent parts, and returns
+// the median. No part is slow until enough parts are recorded.
+func (l *PartLatencies) Slow(elapsed, factor float64) (median float64, slow bool) {
+	if l == nil || factor <= 0 {
+		return 0, false
+	}
+
+	l.mutex.Lock()
+	recent := make([]float64, len(l.recent))
+	copy(recent, l.recent)
+	l.mutex.Unlock()
+
+	if len(recent) < minRecentParts {
+		return 0, false
+	}
+
+	sort.Float64s(recent)
+	median = recent[len(recent)/2]
+	if len(recent)%2 == 0 {
+		median = (median + recent[len(recent)/2-1]) / 2
+	}
+
+	return median, median > 0 && elapsed > factor*median
+}
+
+// Summary returns the distribution of the latencies, nil if no part was recorded.
+func (l *PartLatencies) Summary() *LatencySummary {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	parts := make([]PartLatency, len(l.parts))
+	copy(parts, l.parts)
+	l.mutex.Unlock()
+
+	if len(parts) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(parts, func(i, j int) bool {
+		return parts[i].Elapsed > parts[j].Elapsed
+	})
+
+	// The nearest-rank percentile of the parts sorted the slowest first.
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(parts))))
+		return parts[len(parts)-rank].Elapsed
+	}
+
+	slowest := SlowestParts
+	if slowest > len(parts) {
+		slowest = len(parts)
+	}
+
+	return &LatencySummary{
+		P50:     percentile(50),
+		P90:     percentile(90),
+		P99:     percentile(99),
+		Slowest: parts[:slowest],
+	}
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPartLatencies(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		var latencies PartLatencies
+
+		if got := latencies.Summary(); got != nil {
+			t.Fatalf("got %#v, want nil", got)
+		}
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var latencies *PartLatencies
+		latencies.Record(&Range{Offset: 0, Limit: 4}, 1)
+
+		if got := latencies.Summary(); got != nil {
+			t.Fatalf("got %#v, want nil", got)
+		}
+	})
+
+	t.Run("summary", func(t *testing.T) {
+		var latencies PartLatencies
+		for i := 0; i < 10; i++ {
+			latencies.Record(&Range{Offset: int64(i) * 4, Limit: 4}, float64(i+1))
+		}
+
+		want := &LatencySummary{
+			P50: 5,
+			P90: 9,
+			P99: 10,
+			Slowest: []PartLatency{
+				{Range: &Range{Offset: 36, Limit: 4}, Elapsed: 10},
+				{Range: &Range{Offset: 32, Limit: 4}, Elapsed: 9},
+				{Range: &Range{Offset: 28, Limit: 4}, Elapsed: 8},
+			},
+		}
+
+		got := latencies.Summary()
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+
+		wantString := "part latency p50 5s, p90 9s, p99 10s, slowest 36-39 (10s), 32-35 (9s), 28-31 (8s)"
+		if got.String() != wantString {
+			t.Fatalf("got %#v, want %#v", got.String(), wantString)
+		}
+	})
+
+	t.Run("slow", func(t *testing.T) {
+		var latencies PartLatencies
+		for i := 0; i < minRecentParts-1; i++ {
+			latencies.Record(&Range{Offset: int64(i) * 4, Limit: 4}, 1)
+		}
+
+		if _, slow := latencies.Slow(10, 3); slow {
+			t.Fatal("got a slow part before enough parts are recorded")
+		}
+
+		latencies.Record(&Range{Offset: 16, Limit: 4}, 2)
+
+		if median, slow := latencies.Slow(3, 3); slow || median != 1 {
+			t.Fatalf("got %v, %v, want 1, false", median, slow)
+		}
+		if median, slow := latencies.Slow(3.5, 3); !slow || median != 1 {
+			t.Fatalf("got %v, %v, want 1, true", median, slow)
+		}
+		if _, slow := latencies.Slow(10, 0); slow {
+			t.Fatal("got a slow part with the detection disabled")
+		}
+	})
+
+	t.Run("rolling median", func(t *testing.T) {
+		var latencies PartLatencies
+		for i := 0; i < recentParts; i++ {
+			latencies.Record(&Range{Offset: int64(i) * 4, Limit: 4}, 1)
+		}
+		for i := 0; i < recentParts/2+1; i++ {
+			latencies.Record(&Range{Offset: int64(recentParts+i) * 4, Limit: 4}, 4)
+		}
+
+		if median, slow := latencies.Slow(10, 3); slow || median != 4 {
+			t.Fatalf("got %v, %v, want 4, false", median, slow)
+		}
+	})
+
+	t.Run("single part", func(t *testing.T) {
+		var latencies PartLatencies
+		latencies.Record(&Range{Offset: 0, Limit: 4}, 0.25)
+
+		want := &LatencySummary{
+			P50:     0.25,
+			P90:     0.25,
+			P99:     0.25,
+			Slowest: []PartLatency{{Range: &Range{Offset: 0, Limit: 4}, Elapsed: 0.25}},
+		}
+
+		if got := latencies.Summary(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter limits the rate of bytes transferred by concurrent parts.
+// A nil Limiter doesn't limit the rate.
+type Limiter struct {
+	rate int64 // bytes per second
+
+	mutex sync.Mutex
+	next  time.Time // when the next part may start
+	sleep func(time.Duration)
+}
+
+// NewLimiter constructs a Limiter allowing rate bytes per second on average.
+// If the rate is not positive nil is returned.
+func NewLimiter(rate int64) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &Limiter{
+		rate:  rate,
+		sleep: time.Sleep,
+	}
+}
+
+// Wait blocks until n bytes may be transferred without exceeding the rate.
+// Each call reserves the time it takes to transfer n bytes at the rate,
+// so that concurrent callers are spread evenly.
+func (l *Limiter) Wait(n int64) {
+	if l == nil {
+		return
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	start := l.next
+	l.next = start.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	l.mutex.Unlock()
+
+	if delay := start.Sub(now); delay > 0 {
+		l.sleep(delay)
+	}
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	if l := NewLimiter(0); l != nil {
+		t.Fatalf("got %#v, want nil", l)
+	}
+
+	// A nil limiter must not block.
+	var unlimited *Limiter
+	unlimited.Wait(1 << 30)
+
+	l := NewLimiter(1024)
+
+	var slept time.Duration
+	l.sleep = func(d time.Duration) {
+		slept += d
+	}
+
+	l.Wait(1024)
+	if slept != 0 {
+		t.Fatalf("got %v, want no delay for the first part", slept)
+	}
+
+	l.Wait(2048)
+	l.Wait(1024)
+
+	// The second part waits 1s for the first one, the third one 3s for both.
+	if want := 4 * time.Second; slept < want-100*time.Millisecond || slept > want {
+		t.Fatalf("got %v, want about %v", slept, want)
+	}
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// LimitJobs returns the number of the parallel jobs, at most jobs, whose
+// parts of the part size fit in the memory limit along with the reserved
+// bytes buffered whatever the number of the jobs, e.g. a write buffer. Every
+// job buffers one part. If the limit is zero then the jobs are not limited.
+// An error is returned if not even a single part fits in the limit.
+func LimitJobs(jobs int, partSize, reserved, limit int64) (int, error) {
+	if limit <= 0 || partSize <= 0 {
+		return jobs, nil
+	}
+
+	fit := (limit - reserved) / partSize
+	if fit < 1 {
+		message := fmt.Sprintf("the memory limit of %s is too small for a part of %s", FormatSize(limit), FormatSize(partSize))
+		if reserved > 0 {
+			message += fmt.Sprintf(" along with the %s buffered", FormatSize(reserved))
+		}
+		return 0, errors.New(message)
+	}
+	if fit < int64(jobs) {
+		return int(fit), nil
+	}
+	return jobs, nil
+}
+
+// parseMemAvailable returns the MemAvailable field of /proc/meminfo in bytes,
+// or zero if it is missing.
+func parseMemAvailable(r io.Reader) int64 {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kib, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kib << 10
+	}
+	return 0
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package utils
+
+import "os"
+
+// AvailableMemory returns the memory available to start new applications
+// without swapping, as estimated by the kernel, or zero if it is not known.
+func AvailableMemory() int64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	return parseMemAvailable(file)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package utils
+
+// AvailableMemory returns zero, the available memory is not known on this platform.
+func AvailableMemory() int64 {
+	return 0
+}
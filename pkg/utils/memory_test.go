@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLimitJobs(t *testing.T) {
+	cases := []struct {
+		name                      string
+		jobs                      int
+		partSize, reserved, limit int64
+		want                      int
+	}{
+		{"no limit", 8, 16 << 20, 0, 0, 8},
+		{"fits", 8, 1 << 20, 0, 8 << 20, 8},
+		{"reduced", 8, 16 << 20, 0, 100 << 20, 6},
+		{"reserved", 8, 16 << 20, 64 << 20, 100 << 20, 2},
+		{"single", 8, 16 << 20, 0, 16 << 20, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := LimitJobs(c.jobs, c.partSize, c.reserved, c.limit)
+			if err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			if got != c.want {
+				t.Fatalf("got %d, want %d", got, c.want)
+			}
+		})
+	}
+
+	t.Run("too small", func(t *testing.T) {
+		errString := "the memory limit of 10 MiB is too small for a part of 16 MiB"
+		if _, got := LimitJobs(8, 16<<20, 0, 10<<20); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+
+		errString = "the memory limit of 20 MiB is too small for a part of 16 MiB along with the 8 MiB buffered"
+		if _, got := LimitJobs(8, 16<<20, 8<<20, 20<<20); got == nil || got.Error() != errString {
+			t.Fatalf("got %#v, want %#v", got, errString)
+		}
+	})
+}
+
+func TestParseMemAvailable(t *testing.T) {
+	meminfo := "MemTotal:       16303716 kB\n" +
+		"MemFree:         1000504 kB\n" +
+		"MemAvailable:    8151856 kB\n" +
+		"Buffers:          523076 kB\n"
+	if got, want := parseMemAvailable(strings.NewReader(meminfo)), int64(8151856<<10); got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if got := parseMemAvailable(strings.NewReader("MemTotal: 16303716 kB\n")); got != 0 {
+		t.Fatalf("got %d, want 0", got)
+	}
+}
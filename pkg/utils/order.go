@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Order is the order the parts of an archive are transferred in.
+type Order int
+
+// Part orders.
+const (
+	// Sequential transfers the parts from the start of the archive to the end.
+	Sequential Order = iota
+
+	// LargestRemainingFirst transfers the longest run of the adjacent parts
+	// left to transfer first, which closes the largest gaps of a resumed
+	// transfer first.
+	LargestRemainingFirst
+
+	// Random transfers the parts in a random order, which spreads the
+	// requests over the archive, for example away from a hot range.
+	Random
+)
+
+var orderNames = []string{"sequential", "largest-remaining-first", "random"}
+
+// String returns the name of the order.
+func (o Order) String() string {
+	if o < 0 || int(o) >= len(orderNames) {
+		return fmt.Sprintf("Order(%d)", int(o))
+	}
+	return orderNames[o]
+}
+
+// ParseOrder returns the order of the name.
+func ParseOrder(name string) (Order, error) {
+	for i, n := range orderNames {
+		if n == name {
+			return Order(i), nil
+		}
+	}
+	return Sequential, fmt.Errorf("unknown part order %q", name)
+}
+
+// Schedule sorts the parts, given by increasing offsets, in the order.
+func (o Order) Schedule(parts []*Range) {
+	switch o {
+	case LargestRemainingFirst:
+		// The runs of the adjacent parts, by the index of their first part.
+		var runs [][]*Range
+		for i, p := range parts {
+			if i > 0 && parts[i-1].Offset+parts[i-1].Limit == p.Offset {
+				runs[len(runs)-1] = append(runs[len(runs)-1], p)
+			} else {
+				runs = append(runs, []*Range{p})
+			}
+		}
+
+		size := func(run []*Range) int64 {
+			last := run[len(run)-1]
+			return last.Offset + last.Limit - run[0].Offset
+		}
+		sort.SliceStable(runs, func(i, j int) bool {
+			return size(runs[i]) > size(runs[j])
+		})
+
+		scheduled := make([]*Range, 0, len(parts))
+		for _, run := range runs {
+			scheduled = append(scheduled, run...)
+		}
+		copy(parts, scheduled)
+	case Random:
+		rand.Shuffle(len(parts), func(i, j int) {
+			parts[i], parts[j] = parts[j], parts[i]
+		})
+	}
+}
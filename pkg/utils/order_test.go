@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseOrder(t *testing.T) {
+	for _, o := range []Order{Sequential, LargestRemainingFirst, Random} {
+		got, err := ParseOrder(o.String())
+		if err != nil || got != o {
+			t.Fatalf("got %#v, %#v, want %#v", got, err, o)
+		}
+	}
+
+	if _, err := ParseOrder("backwards"); err == nil || err.Error() != `unknown part order "backwards"` {
+		t.Fatalf("got %#v", err)
+	}
+}
+
+func TestOrderSchedule(t *testing.T) {
+	// The parts left of an archive of 10 parts of 10 bytes.
+	parts := func(offsets ...int64) []*Range {
+		var ranges []*Range
+		for _, o := range offsets {
+			ranges = append(ranges, &Range{Offset: o, Limit: 10})
+		}
+		return ranges
+	}
+	offsets := func(ranges []*Range) []int64 {
+		var o []int64
+		for _, r := range ranges {
+			o = append(o, r.Offset)
+		}
+		return o
+	}
+
+	t.Run("sequential", func(t *testing.T) {
+		got := parts(0, 20, 30)
+		Sequential.Schedule(got)
+		if want := []int64{0, 20, 30}; !reflect.DeepEqual(offsets(got), want) {
+			t.Fatalf("got %#v, want %#v", offsets(got), want)
+		}
+	})
+
+	t.Run("largest remaining first", func(t *testing.T) {
+		got := parts(0, 20, 30, 50, 60, 70, 90)
+		LargestRemainingFirst.Schedule(got)
+		if want := []int64{50, 60, 70, 20, 30, 0, 90}; !reflect.DeepEqual(offsets(got), want) {
+			t.Fatalf("got %#v, want %#v", offsets(got), want)
+		}
+	})
+
+	t.Run("random", func(t *testing.T) {
+		got := parts(0, 10, 20, 30, 40, 50, 60, 70, 80, 90)
+		Random.Schedule(got)
+
+		o := offsets(got)
+		sort.Slice(o, func(i, j int) bool { return o[i] < o[j] })
+		if want := offsets(parts(0, 10, 20, 30, 40, 50, 60, 70, 80, 90)); !reflect.DeepEqual(o, want) {
+			t.Fatalf("got %#v, want %#v", o, want)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var binaryUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+// sizeMultipliers maps the lower case size units to the number of bytes.
+// Single letters are binary units like in most command line tools.
+var sizeMultipliers = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kib": 1 << 10,
+	"kb":  1e3,
+	"m":   1 << 20,
+	"mib": 1 << 20,
+	"mb":  1e6,
+	"g":   1 << 30,
+	"gib": 1 << 30,
+	"gb":  1e9,
+	"t":   1 << 40,
+	"tib": 1 << 40,
+	"tb":  1e12,
+}
+
+// ParseSize parses a human-readable size such as 16MiB, 1.5GB or 1048576.
+// Units are case insensitive, KiB/MiB/GiB/TiB and K/M/G/T are powers of 1024,
+// while KB/MB/GB/TB are powers of 1000.
+func ParseSize(s string) (int64, error) {
+	trimmed := strings.TrimSpace(s)
+
+	i := strings.IndexFunc(trimmed, func(c rune) bool {
+		return (c < '0' || c > '9') && c != '.'
+	})
+	if i < 0 {
+		i = len(trimmed)
+	}
+
+	number, unit := trimmed[:i], strings.ToLower(strings.TrimSpace(trimmed[i:]))
+
+	multiplier, ok := sizeMultipliers[unit]
+	if !ok || number == "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	if value, err := strconv.ParseInt(number, 10, 64); err == nil && multiplier == 1 {
+		return value, nil
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	size := math.Floor(value * multiplier)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+
+	return int64(size), nil
+}
+
+// ParseRate parses a human-readable transfer rate in bytes per second
+// such as 20MB/s. The "/s" suffix is optional.
+func ParseRate(s string) (int64, error) {
+	rate, err := ParseSize(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return rate, nil
+}
+
+// FormatSize returns the size in the largest binary unit it amounts to,
+// with at most one decimal digit, e.g. 2.5 MiB.
+func FormatSize(size int64) string {
+	value := float64(size)
+	unit := 0
+
+	for math.Abs(value) >= 1024 && unit < len(binaryUnits)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprint(size, " ", binaryUnits[0])
+	}
+
+	formatted := strings.TrimSuffix(strconv.FormatFloat(value, 'f', 1, 64), ".0")
+	return formatted + " " + binaryUnits[unit]
+}
+
+// FormatRate returns the rate of transferring the size in the number of
+// seconds, e.g. 2.5 MiB/s.
+func FormatRate(size int64, seconds float64) string {
+	if seconds <= 0 {
+		return "- B/s"
+	}
+	return FormatSize(int64(float64(size)/seconds)) + "/s"
+}
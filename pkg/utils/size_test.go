@@ -0,0 +1,102 @@
+package utils
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]struct {
+		size int64
+		err  string
+	}{
+		"1048576":    {size: 1048576},
+		"512B":       {size: 512},
+		"16MiB":      {size: 16 << 20},
+		"16 MiB":     {size: 16 << 20},
+		"16mib":      {size: 16 << 20},
+		"16M":        {size: 16 << 20},
+		"16MB":       {size: 16000000},
+		"1.5GiB":     {size: 3 << 29},
+		"2TB":        {size: 2e12},
+		"":           {err: `invalid size ""`},
+		"MiB":        {err: `invalid size "MiB"`},
+		"16XB":       {err: `invalid size "16XB"`},
+		"-1":         {err: `invalid size "-1"`},
+		"1.2.3MiB":   {err: `invalid size "1.2.3MiB"`},
+		"9000000TiB": {err: `size "9000000TiB" is too large`},
+	}
+
+	for input, test := range cases {
+		t.Run(input, func(t *testing.T) {
+			got, err := ParseSize(input)
+
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got %#v, want %#v", err, test.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			if got != test.size {
+				t.Errorf("got %d, want %d", got, test.size)
+			}
+		})
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	cases := map[string]struct {
+		rate int64
+		err  string
+	}{
+		"20MB/s":  {rate: 20000000},
+		"1MiB/s":  {rate: 1 << 20},
+		"1024":    {rate: 1024},
+		"fast/s":  {err: `invalid rate "fast/s"`},
+		"20MB/ms": {err: `invalid rate "20MB/ms"`},
+	}
+
+	for input, test := range cases {
+		t.Run(input, func(t *testing.T) {
+			got, err := ParseRate(input)
+
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got %#v, want %#v", err, test.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			if got != test.rate {
+				t.Errorf("got %d, want %d", got, test.rate)
+			}
+		})
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	cases := map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1024:          "1 KiB",
+		1048576:       "1 MiB",
+		2621440:       "2.5 MiB",
+		10485760:      "10 MiB",
+		3 << 29:       "1.5 GiB",
+		5 * (1 << 40): "5 TiB",
+	}
+
+	for size, want := range cases {
+		if got := FormatSize(size); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	if got, want := FormatRate(2621440, 2), "1.2 MiB/s"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
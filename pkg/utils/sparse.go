@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"os"
+)
+
+// minSparseSize is the size of the smallest file reported sparse, since
+// the filesystems may store a small file without allocating any block.
+const minSparseSize = 1 << 20
+
+// Sparse reports whether the regular file allocates less than half of its
+// size on disk, as a sparse file does, and returns the allocated size. A
+// file compressed by the filesystem is reported as well. No file is reported
+// on the platforms not telling the allocated size.
+func Sparse(info os.FileInfo) (allocated int64, sparse bool) {
+	if !info.Mode().IsRegular() || info.Size() < minSparseSize {
+		return 0, false
+	}
+
+	allocated, ok := allocatedSize(info)
+	if !ok {
+		return 0, false
+	}
+	return allocated, allocated < info.Size()/2
+}
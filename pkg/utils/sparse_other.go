@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package utils
+
+import (
+	"os"
+)
+
+// allocatedSize does not tell the allocated size on this platform.
+func allocatedSize(info os.FileInfo) (int64, bool) {
+	return 0, false
+}
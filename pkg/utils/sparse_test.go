@@ -0,0 +1,44 @@
+//go:build !windows && !plan9
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSparse(t *testing.T) {
+	file, err := ioutil.TempFile("", "surge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// A hole of 64 MiB followed by a single byte.
+	if _, err := file.WriteAt([]byte{1}, 64<<20); err != nil {
+		t.Fatal(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated, sparse := Sparse(info); !sparse || allocated >= 1<<20 {
+		t.Fatalf("got %d allocated and sparse %#v, want a sparse file", allocated, sparse)
+	}
+
+	if _, err := file.WriteAt(make([]byte, 64<<20), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = file.Stat(); err != nil {
+		t.Fatal(err)
+	}
+	if allocated, sparse := Sparse(info); sparse {
+		t.Fatalf("got %d allocated and sparse %#v, want a file written in full", allocated, sparse)
+	}
+}
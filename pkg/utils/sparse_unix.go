@@ -0,0 +1,18 @@
+//go:build !windows && !plan9
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// allocatedSize returns the size the file allocates on disk, counted in the
+// 512-byte blocks of stat(2).
+func allocatedSize(info os.FileInfo) (int64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int64(stat.Blocks) * 512, true
+}
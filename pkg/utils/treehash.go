@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"sync"
+)
+
+// NewSHA256 creates the SHA-256 state the tree hashes are computed with.
+// It may be replaced with an accelerated implementation before any hashing is
+// done, e.g. by a program embedding the package. A TreeHasher keeps the state
+// it was created with, so the hashers created before, including the pooled
+// ones, ignore the replacement.
+var NewSHA256 = sha256.New
+
+// treeHashChunk is the size of the data hashed by every leaf of a tree hash.
+const treeHashChunk = 1 << 20
+
+// TreeHasher computes the Amazon Glacier tree hash of the data written to it.
+// It implements hash.Hash and reuses its buffers and hash state, so that
+// hashing into a caller provided buffer doesn't allocate once it is warmed up.
+// It is not safe for concurrent use.
+//
+// See https://docs.aws.amazon.com/amazonglacier/latest/dev/checksum-calculations.html.
+type TreeHasher struct {
+	digest hash.Hash
+	filled int // the bytes written to the digest of the current leaf
+
+	node hash.Hash // hashes the complete leaves and the pairs of nodes
+
+	leaves  [][sha256.Size]byte
+	scratch [][sha256.Size]byte
+	leaf    [sha256.Size]byte
+	pair    [2 * sha256.Size]byte
+
+	buf []byte // the read buffer of ComputeTreeHash
+}
+
+// NewTreeHasher creates a new tree hasher.
+func NewTreeHasher() *TreeHasher {
+	return &TreeHasher{digest: NewSHA256(), node: NewSHA256()}
+}
+
+// Write adds the data to the hash. It never returns an error.
+func (h *TreeHasher) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		if h.filled == 0 && len(p) >= treeHashChunk {
+			h.leaves = append(h.leaves, h.sum(p[:treeHashChunk]))
+			p = p[treeHashChunk:]
+			continue
+		}
+
+		k := treeHashChunk - h.filled
+		if k > len(p) {
+			k = len(p)
+		}
+
+		h.digest.Write(p[:k])
+		h.filled += k
+		p = p[k:]
+
+		if h.filled == treeHashChunk {
+			h.digest.Sum(h.leaf[:0])
+			h.leaves = append(h.leaves, h.leaf)
+			h.digest.Reset()
+			h.filled = 0
+		}
+	}
+
+	return n, nil
+}
+
+// Sum appends the tree hash of the data written so far to b and returns the
+// resulting slice. It does not change the underlying hash state. The tree hash
+// of no data is the SHA-256 of no data.
+func (h *TreeHasher) Sum(b []byte) []byte {
+	nodes := append(h.scratch[:0], h.leaves...)
+	if h.filled > 0 || len(nodes) == 0 {
+		h.digest.Sum(h.leaf[:0])
+		nodes = append(nodes, h.leaf)
+	}
+
+	for len(nodes) > 1 {
+		n := 0
+		for i := 0; i < len(nodes); i += 2 {
+			if i+1 < len(nodes) {
+				copy(h.pair[:sha256.Size], nodes[i][:])
+				copy(h.pair[sha256.Size:], nodes[i+1][:])
+				nodes[n] = h.sum(h.pair[:])
+			} else {
+				nodes[n] = nodes[i]
+			}
+			n++
+		}
+		nodes = nodes[:n]
+	}
+
+	h.scratch = nodes[:0]
+	return append(b, nodes[0][:]...)
+}
+
+// sum returns the SHA-256 of the data.
+func (h *TreeHasher) sum(data []byte) [sha256.Size]byte {
+	h.node.Reset()
+	h.node.Write(data)
+	h.node.Sum(h.leaf[:0])
+	return h.leaf
+}
+
+// Reset resets the hash to its initial state.
+func (h *TreeHasher) Reset() {
+	h.digest.Reset()
+	h.filled = 0
+	h.leaves = h.leaves[:0]
+}
+
+// Size returns the number of bytes Sum will return.
+func (h *TreeHasher) Size() int {
+	return sha256.Size
+}
+
+// BlockSize returns the block size of the underlying SHA-256.
+func (h *TreeHasher) BlockSize() int {
+	return sha256.BlockSize
+}
+
+// treeHashers keeps the tree hashers for reuse by ComputeTreeHash.
+var treeHashers = sync.Pool{
+	New: func() interface{} {
+		return NewTreeHasher()
+	},
+}
+
+// ComputeTreeHash computes the hex encoded tree-hash of a seekable reader r.
+// The whole stream is read and rewound at the end.
+// If there was an error computing the hash or the stream is empty nil is returned.
+func ComputeTreeHash(r io.ReadSeeker) *string {
+	treeHash, err := ComputeTreeHashContext(context.Background(), r, nil)
+	if err != nil {
+		return nil
+	}
+	return treeHash
+}
+
+// ComputeTreeHashContext computes the hex encoded tree-hash of a seekable
+// reader r like ComputeTreeHash, calling progress, if it is not nil, with
+// the number of the bytes hashed so far after every 1 MiB. It stops with
+// the error of the context once the context is done. If the stream is
+// empty nil is returned without an error.
+func ComputeTreeHashContext(ctx context.Context, r io.ReadSeeker, progress func(hashed int64)) (*string, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	defer r.Seek(0, io.SeekStart)
+
+	h := treeHashers.Get().(*TreeHasher)
+	defer treeHashers.Put(h)
+
+	h.Reset()
+	if h.buf == nil {
+		h.buf = make([]byte, treeHashChunk)
+	}
+
+	var hashed int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		n, err := io.ReadFull(r, h.buf)
+		h.Write(h.buf[:n])
+		hashed += int64(n)
+
+		if n > 0 && progress != nil {
+			progress(hashed)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if hashed == 0 {
+		return nil, nil
+	}
+
+	var sum [sha256.Size]byte
+	encoded := hex.EncodeToString(h.Sum(sum[:0]))
+	return &encoded, nil
+}
+
+// TreeHashBytes computes the hex encoded tree-hash of the data in memory.
+// If the data is empty nil is returned, like by ComputeTreeHash.
+func TreeHashBytes(data []byte) *string {
+	if len(data) == 0 {
+		return nil
+	}
+
+	h := treeHashers.Get().(*TreeHasher)
+	defer treeHashers.Put(h)
+
+	h.Reset()
+	h.Write(data)
+
+	var sum [sha256.Size]byte
+	encoded := hex.EncodeToString(h.Sum(sum[:0]))
+	return &encoded
+}
+
+// TreeHashLeaves returns the SHA-256 of every 1 MiB chunk of the data, the
+// leaves of its tree hash. The leaves of the consecutive 1 MiB aligned parts
+// of an archive make up the leaves of the archive.
+func TreeHashLeaves(data []byte) [][sha256.Size]byte {
+	h := NewTreeHasher()
+	h.Write(data)
+
+	leaves := h.leaves
+	if h.filled > 0 {
+		leaves = append(leaves, h.sum(data[len(data)-h.filled:]))
+	}
+	return leaves
+}
+
+// TreeHashOfLeaves computes the hex encoded tree-hash of the data with the
+// leaves returned by TreeHashLeaves. If there are no leaves nil is returned.
+func TreeHashOfLeaves(leaves [][sha256.Size]byte) *string {
+	if len(leaves) == 0 {
+		return nil
+	}
+
+	h := NewTreeHasher()
+	h.leaves = leaves
+
+	var sum [sha256.Size]byte
+	encoded := hex.EncodeToString(h.Sum(sum[:0]))
+	return &encoded
+}
@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/glacier"
+)
+
+// sizes covers the data sizes around the boundaries of the tree hash leaves.
+var sizes = []int{1, 1023, treeHashChunk - 1, treeHashChunk, treeHashChunk + 1, 3 * treeHashChunk, 5*treeHashChunk + 7}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+// sdkTreeHash computes the tree hash with the SDK implementation.
+func sdkTreeHash(data []byte) string {
+	hashes := glacier.ComputeHashes(bytes.NewReader(data))
+	return hex.EncodeToString(hashes.TreeHash)
+}
+
+func TestComputeTreeHash(t *testing.T) {
+	t.Run("nil result", func(t *testing.T) {
+		var data []byte
+		reader := bytes.NewReader(data)
+
+		if got := ComputeTreeHash(reader); got != nil {
+			t.Errorf("got %#v, want nil", got)
+		}
+	})
+
+	t.Run("sha256 result", func(t *testing.T) {
+		data := []byte{'t', 'e', 's', 't'}
+		reader := bytes.NewReader(data)
+		want := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+		if got := ComputeTreeHash(reader); *got != want {
+			t.Errorf("got %q, want %q", *got, want)
+		}
+	})
+}
+
+func TestComputeTreeHashContext(t *testing.T) {
+	data := testData(5*treeHashChunk + 7)
+
+	t.Run("progress", func(t *testing.T) {
+		var got []int64
+		treeHash, err := ComputeTreeHashContext(context.Background(), bytes.NewReader(data), func(hashed int64) {
+			got = append(got, hashed)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+		if want := sdkTreeHash(data); *treeHash != want {
+			t.Errorf("got %q, want %q", *treeHash, want)
+		}
+
+		want := []int64{treeHashChunk, 2 * treeHashChunk, 3 * treeHashChunk, 4 * treeHashChunk, 5 * treeHashChunk, int64(len(data))}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		treeHash, err := ComputeTreeHashContext(ctx, bytes.NewReader(data), func(hashed int64) {
+			if hashed == 2*treeHashChunk {
+				cancel()
+			}
+		})
+		if err != context.Canceled {
+			t.Errorf("got %#v, want %#v", err, context.Canceled)
+		}
+		if treeHash != nil {
+			t.Errorf("got %#v, want nil", treeHash)
+		}
+	})
+}
+
+func BenchmarkComputeTreeHash(b *testing.B) {
+	for _, size := range []int{1 << 10, 1 << 20, 16 << 20, 64 << 20} {
+		data := make([]byte, size)
+
+		b.Run(FormatSize(int64(size)), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if ComputeTreeHash(bytes.NewReader(data)) == nil {
+					b.Fatal("could not compute hash")
+				}
+			}
+		})
+	}
+}
+
+func TestTreeHasher(t *testing.T) {
+	t.Run("sdk equality", func(t *testing.T) {
+		for _, size := range sizes {
+			data := testData(size)
+			want := sdkTreeHash(data)
+
+			if got := ComputeTreeHash(bytes.NewReader(data)); got == nil || *got != want {
+				t.Errorf("size %d: got %#v, want %#v", size, got, want)
+			}
+			if got := TreeHashBytes(data); got == nil || *got != want {
+				t.Errorf("size %d: got %#v, want %#v", size, got, want)
+			}
+		}
+	})
+
+	t.Run("small writes", func(t *testing.T) {
+		data := testData(3*treeHashChunk + 5)
+		want := sdkTreeHash(data)
+
+		h := NewTreeHasher()
+		for p := data; len(p) > 0; {
+			n := 4093
+			if n > len(p) {
+				n = len(p)
+			}
+			h.Write(p[:n])
+			p = p[n:]
+		}
+
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("sum keeps state", func(t *testing.T) {
+		data := testData(2*treeHashChunk + 1)
+		want := sdkTreeHash(data)
+
+		h := NewTreeHasher()
+		h.Write(data[:treeHashChunk+1])
+		h.Sum(nil)
+		h.Write(data[treeHashChunk+1:])
+
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		h := NewTreeHasher()
+		h.Write(testData(treeHashChunk + 1))
+		h.Reset()
+		h.Write([]byte("test"))
+
+		want := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("no allocations", func(t *testing.T) {
+		data := testData(4*treeHashChunk + 1)
+		h := NewTreeHasher()
+		sum := make([]byte, 0, h.Size())
+		allocs := testing.AllocsPerRun(10, func() {
+			h.Reset()
+			h.Write(data)
+			sum = h.Sum(sum[:0])
+		})
+		if allocs != 0 {
+			t.Errorf("got %v allocations, want 0", allocs)
+		}
+	})
+}
+
+func BenchmarkTreeHasher(b *testing.B) {
+	data := make([]byte, 16<<20)
+	h := NewTreeHasher()
+	sum := make([]byte, 0, h.Size())
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.Reset()
+		h.Write(data)
+		sum = h.Sum(sum[:0])
+	}
+}
+
+// countingHash counts the bytes written to a SHA-256 state.
+type countingHash struct {
+	hash.Hash
+	written *int
+}
+
+func (c countingHash) Write(p []byte) (int, error) {
+	*c.written += len(p)
+	return c.Hash.Write(p)
+}
+
+func TestTreeHashLeaves(t *testing.T) {
+	if got := TreeHashOfLeaves(nil); got != nil {
+		t.Errorf("got %#v, want nil", got)
+	}
+
+	for _, size := range sizes {
+		data := testData(size)
+		want := sdkTreeHash(data)
+
+		// The leaves of the 2 MiB parts make up the leaves of the data.
+		var leaves [][sha256.Size]byte
+		for offset := 0; offset < size; offset += 2 * treeHashChunk {
+			end := offset + 2*treeHashChunk
+			if end > size {
+				end = size
+			}
+			leaves = append(leaves, TreeHashLeaves(data[offset:end])...)
+		}
+
+		if got := TreeHashOfLeaves(leaves); got == nil || *got != want {
+			t.Errorf("%d bytes: got %#v, want %#v", size, got, want)
+		}
+	}
+}
+
+func TestNewSHA256(t *testing.T) {
+	written := 0
+	defer func(f func() hash.Hash) { NewSHA256 = f }(NewSHA256)
+	NewSHA256 = func() hash.Hash {
+		return countingHash{Hash: sha256.New(), written: &written}
+	}
+
+	data := testData(2*treeHashChunk + 1)
+	want := sdkTreeHash(data)
+
+	h := NewTreeHasher()
+	h.Write(data)
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	// The data and the two pairs of nodes.
+	if want := len(data) + 2*2*sha256.Size; written != want {
+		t.Errorf("got %#v bytes hashed, want %#v", written, want)
+	}
+}
+
+func FuzzTreeHasher(f *testing.F) {
+	f.Add(uint32(0), uint32(0), byte(0))
+	f.Add(uint32(treeHashChunk), uint32(4093), byte(1))
+	f.Add(uint32(3*treeHashChunk+5), uint32(treeHashChunk+1), byte(7))
+
+	f.Fuzz(func(t *testing.T, size, step uint32, fill byte) {
+		// Up to five leaves keep every execution fast while covering
+		// the reduction of both the even and the odd number of nodes.
+		size %= 5*treeHashChunk + 1
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = fill + byte(i)
+		}
+
+		h := NewTreeHasher()
+		for p, n := data, int(step%(2*treeHashChunk))+1; len(p) > 0; {
+			if n > len(p) {
+				n = len(p)
+			}
+			h.Write(p[:n])
+			p = p[n:]
+		}
+
+		var want string
+		if size == 0 {
+			want = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+		} else {
+			want = sdkTreeHash(data)
+		}
+
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			t.Fatalf("size %d, step %d: got %#v, want %#v", size, step, got, want)
+		}
+	})
+}
@@ -2,19 +2,44 @@
 package utils
 
 import (
-	"encoding/hex"
 	"fmt"
-	"io"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/aws/aws-sdk-go-v2/service/glacier"
 )
 
+// NormalizeAccountId checks that id is either a single '-' (hyphen) or a 12-digit
+// AWS account ID and returns it in the form Amazon Glacier accepts. Since the ID
+// must not include any hyphens, an ID formatted as 1234-5678-9012 is accepted
+// and returned with the hyphens stripped.
+func NormalizeAccountId(id string) (string, error) {
+	if id == "-" {
+		return id, nil
+	}
+
+	stripped := strings.Replace(id, "-", "", -1)
+	if stripped != id && (len(id) != 14 || id[4] != '-' || id[9] != '-') {
+		return "", fmt.Errorf("invalid account ID %q: hyphens are only allowed as in 1234-5678-9012", id)
+	}
+
+	if len(stripped) != 12 {
+		return "", fmt.Errorf("invalid account ID %q: must be '-' or a 12-digit AWS account ID", id)
+	}
+
+	for _, c := range stripped {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("invalid account ID %q: must be '-' or a 12-digit AWS account ID", id)
+		}
+	}
+
+	return stripped, nil
+}
+
 // Range represents a range of bytes that is used for multipart archive upload and download.
 type Range struct {
-	Offset int64
-	Limit  int64
+	Offset int64 `json:"offset"`
+	Limit  int64 `json:"limit"`
 }
 
 // String returns the string representation.
@@ -22,6 +47,45 @@ func (r *Range) String() string {
 	return fmt.Sprint(r.Offset, "-", r.Offset+r.Limit-1)
 }
 
+// PartNumber returns the number of the part of the range counting from 1,
+// or zero if the part size is not known.
+func PartNumber(r *Range, partSize int64) int {
+	if partSize <= 0 {
+		return 0
+	}
+	return int(r.Offset/partSize) + 1
+}
+
+// PartError describes a part that could not be transferred.
+type PartError struct {
+	Range *Range
+	Err   error
+}
+
+// PartialTransferError is returned when some of the parts could not be transferred.
+type PartialTransferError struct {
+	Parts []PartError
+}
+
+// NewPartialTransferError constructs a PartialTransferError with parts sorted by offset.
+func NewPartialTransferError(parts []PartError) *PartialTransferError {
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].Range.Offset < parts[j].Range.Offset
+	})
+
+	return &PartialTransferError{Parts: parts}
+}
+
+// Error returns the string representation listing the failed parts.
+func (e *PartialTransferError) Error() string {
+	failed := make([]string, len(e.Parts))
+	for i, p := range e.Parts {
+		failed[i] = fmt.Sprint(p.Range, ": ", p.Err)
+	}
+
+	return fmt.Sprintf("could not transfer %d part(s): %s", len(e.Parts), strings.Join(failed, "; "))
+}
+
 // RangeFromString constructs a Range from a string s.
 // If the string doesn't represent a valid byte range nil is returned.
 func RangeFromString(s *string) *Range {
@@ -30,35 +94,39 @@ func RangeFromString(s *string) *Range {
 		return nil
 	}
 
-	var result Range
-
-	if begin, err := strconv.ParseInt(split[0], 10, 64); err == nil {
-		result.Offset = begin
-	} else {
+	begin, ok := parseOffset(split[0])
+	if !ok {
 		return nil
 	}
 
-	if end, err := strconv.ParseInt(split[1], 10, 64); err == nil {
-		result.Limit = end - result.Offset + 1
-	} else {
+	end, ok := parseOffset(split[1])
+	if !ok {
 		return nil
 	}
 
-	if result.Limit <= 0 {
+	// The end must leave room for the offset following the range.
+	if end < begin || end == math.MaxInt64 {
 		return nil
 	}
 
-	return &result
+	return &Range{
+		Offset: begin,
+		Limit:  end - begin + 1,
+	}
 }
 
-// ComputeTreeHash computes the hex encoded tree-hash of a seekable reader r.
-// If there was an error computing the hash nil is returned.
-func ComputeTreeHash(r io.ReadSeeker) *string {
-	treeHash := glacier.ComputeHashes(r).TreeHash
-	if treeHash == nil {
-		return nil
+// parseOffset parses a byte offset of a range, which consists of decimal
+// digits only, unlike the integers accepted by strconv.
+func parseOffset(s string) (int64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
 	}
 
-	encoded := hex.EncodeToString(treeHash)
-	return &encoded
+	offset, err := strconv.ParseInt(s, 10, 64)
+	return offset, err == nil
 }
@@ -1,10 +1,71 @@
 package utils
 
 import (
-	"bytes"
+	"errors"
 	"testing"
 )
 
+func TestNormalizeAccountId(t *testing.T) {
+	cases := map[string]struct {
+		input  string
+		output string
+		err    string
+	}{
+		"hyphen": {
+			input:  "-",
+			output: "-",
+		},
+		"digits": {
+			input:  "111122223333",
+			output: "111122223333",
+		},
+		"console format": {
+			input:  "1111-2222-3333",
+			output: "111122223333",
+		},
+		"empty": {
+			input: "",
+			err:   `invalid account ID "": must be '-' or a 12-digit AWS account ID`,
+		},
+		"too short": {
+			input: "11112222333",
+			err:   `invalid account ID "11112222333": must be '-' or a 12-digit AWS account ID`,
+		},
+		"not digits": {
+			input: "11112222333a",
+			err:   `invalid account ID "11112222333a": must be '-' or a 12-digit AWS account ID`,
+		},
+		"misplaced hyphens": {
+			input: "-111122223333",
+			err:   `invalid account ID "-111122223333": hyphens are only allowed as in 1234-5678-9012`,
+		},
+		"alias": {
+			input: "my-account",
+			err:   `invalid account ID "my-account": hyphens are only allowed as in 1234-5678-9012`,
+		},
+	}
+
+	for name, test := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := NormalizeAccountId(test.input)
+
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got %#v, want %#v", err, test.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %#v", err)
+			}
+			if got != test.output {
+				t.Errorf("got %q, want %q", got, test.output)
+			}
+		})
+	}
+}
+
 func TestRangeFromString(t *testing.T) {
 	cases := map[string]struct {
 		input  string
@@ -34,6 +95,14 @@ func TestRangeFromString(t *testing.T) {
 			input:  "1-0",
 			output: nil,
 		},
+		"signed": {
+			input:  "+1-+3",
+			output: nil,
+		},
+		"end overflow": {
+			input:  "9223372036854775807-9223372036854775807",
+			output: nil,
+		},
 
 		"begin is equal to end": {
 			input:  "0-0",
@@ -67,23 +136,36 @@ func TestRangeFromString(t *testing.T) {
 	}
 }
 
-func TestComputeTreeHash(t *testing.T) {
-	t.Run("nil result", func(t *testing.T) {
-		var data []byte
-		reader := bytes.NewReader(data)
+func TestPartialTransferError(t *testing.T) {
+	err := NewPartialTransferError([]PartError{
+		{Range: &Range{Offset: 4, Limit: 4}, Err: errors.New("second")},
+		{Range: &Range{Offset: 0, Limit: 4}, Err: errors.New("first")},
+	})
+	errString := "could not transfer 2 part(s): 0-3: first; 4-7: second"
+
+	if got := err.Error(); got != errString {
+		t.Errorf("got %q, want %q", got, errString)
+	}
+}
 
-		if got := ComputeTreeHash(reader); got != nil {
-			t.Errorf("got %#v, want nil", got)
+func FuzzRangeFromString(f *testing.F) {
+	for _, seed := range []string{"", "test", "0-0", "0-1", "1-0", "0-1-test", "1048576-2097151", "9223372036854775807-9223372036854775807"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		got := RangeFromString(&s)
+		if got == nil {
+			return
 		}
-	})
 
-	t.Run("sha256 result", func(t *testing.T) {
-		data := []byte{'t', 'e', 's', 't'}
-		reader := bytes.NewReader(data)
-		want := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+		if got.Offset < 0 || got.Limit <= 0 || got.Offset+got.Limit < got.Offset {
+			t.Fatalf("invalid range %#v parsed from %q", got, s)
+		}
 
-		if got := ComputeTreeHash(reader); *got != want {
-			t.Errorf("got %q, want %q", *got, want)
+		formatted := got.String()
+		if again := RangeFromString(&formatted); again == nil || *again != *got {
+			t.Fatalf("got %#v, want %#v parsing %q formatted from %q", again, got, formatted, s)
 		}
 	})
 }